@@ -3,7 +3,9 @@
 package ac
 
 import (
+	"context"
 	"fmt"
+	"math"
 )
 
 // ErrDecodeInsufficientBits is returned when there are insufficient bits sent to Decode to reconstruct the original data.
@@ -18,3 +20,78 @@ type Model interface {
 	// Observe informs the Model that a bit is observed from the sequence.
 	Observe(bit int)
 }
+
+// A Coder is a finite precision realization of the arithmetic coding algorithm, such as those in the subpackages witten and eidma.
+type Coder interface {
+	// EncodeStats performs arithmetic coding on the bits read from src given model, and writes the encoded bits to dst.
+	// It returns the statistics of the encoding, and stops early with ctx.Err() if ctx is cancelled.
+	EncodeStats(ctx context.Context, dst BitSink, src BitSource, model Model) (Stats, error)
+
+	// DecodeStats decodes originalSize bits from the encoded bits read from src, and writes them to dst.
+	// The model should be the exact same probabilistic model used in the encoding.
+	// It returns the statistics of the decoding, and stops early with ctx.Err() if ctx is cancelled.
+	DecodeStats(ctx context.Context, dst BitSink, src BitSource, model Model, originalSize int64) (Stats, error)
+}
+
+// ProbBits is the number of bits of the quantized probabilities used by the arithmetic coders.
+const ProbBits = 16
+
+// ProbScale is the quantized probability that represents certainty.
+const ProbScale = 1 << ProbBits
+
+// An IntModel is a Model which can also give its probabilities quantized to integers.
+// The arithmetic coders use quantized probabilities only, so that an encoding made on one machine can always be decoded on another,
+// whereas the floating point probabilities of a Model might be rounded differently on different platforms.
+// Models whose predictions are computed in integer arithmetic should implement IntModel to avoid floating point math altogether.
+type IntModel interface {
+	Model
+
+	// Prob0Int returns the probability that the next bit will be zero, multiplied by ProbScale.
+	// The returned value must be within [1, ProbScale-1].
+	Prob0Int() uint32
+}
+
+// TerminationProb0 is the quantized probability of zero of the flags that precede each bit of a self-terminating stream.
+// A flag of zero means that a bit follows, and a flag of one marks the end of the stream.
+// Since the end is coded only once, the flags cost a negligible 1/ProbScale/ln(2) bits each.
+const TerminationProb0 uint32 = ProbScale - 1
+
+// Quantize returns the probability prob0 multiplied by ProbScale, clamped to [1, ProbScale-1] so that neither bit is ever impossible.
+func Quantize(prob0 float64) uint32 {
+	q := prob0*ProbScale + 0.5
+	if !(q >= 1) {
+		return 1
+	}
+	if q > ProbScale-1 {
+		return ProbScale - 1
+	}
+	return uint32(q)
+}
+
+// Prob0Int returns the quantized probability that the next bit will be zero according to model.
+// The quantized probability is given by the model itself if it is an IntModel, and by Quantize otherwise.
+func Prob0Int(model Model) uint32 {
+	if im, ok := model.(IntModel); ok {
+		return im.Prob0Int()
+	}
+	return Quantize(model.Prob0())
+}
+
+// Prob1 returns the probability that the next bit will be one according to model.
+func Prob1(model Model) float64 {
+	return 1 - model.Prob0()
+}
+
+// Entropy returns the entropy in bits of the prediction of model for the next bit.
+// It is zero when the model is certain about the next bit, and one when the model considers both bits equally likely.
+func Entropy(model Model) float64 {
+	return BinaryEntropy(model.Prob0())
+}
+
+// BinaryEntropy returns the entropy in bits of a bit whose probability of being zero is p0.
+func BinaryEntropy(p0 float64) float64 {
+	if p0 <= 0 || p0 >= 1 {
+		return 0
+	}
+	return -p0*math.Log2(p0) - (1-p0)*math.Log2(1-p0)
+}
@@ -0,0 +1,63 @@
+package ac
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		p0      float64
+		entropy float64
+	}{
+		{p0: 0.5, entropy: 1},
+		{p0: 0, entropy: 0},
+		{p0: 1, entropy: 0},
+		{p0: 0.25, entropy: -0.25*math.Log2(0.25) - 0.75*math.Log2(0.75)},
+	}
+	for _, test := range tests {
+		model := &constModel{p0: test.p0}
+		if e := Entropy(model); math.Abs(e-test.entropy) > 1e-12 {
+			t.Errorf("%f %f %f", test.p0, e, test.entropy)
+		}
+		if p1 := Prob1(model); p1 != 1-test.p0 {
+			t.Errorf("%f %f", test.p0, p1)
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	tests := []struct {
+		p0 float64
+		q  uint32
+	}{
+		{p0: 0.5, q: ProbScale / 2},
+		{p0: 0, q: 1},
+		{p0: 1, q: ProbScale - 1},
+		{p0: math.NaN(), q: 1},
+		{p0: 0.25, q: ProbScale / 4},
+	}
+	for _, test := range tests {
+		if q := Quantize(test.p0); q != test.q {
+			t.Errorf("%f %d %d", test.p0, q, test.q)
+		}
+	}
+
+	if q := Prob0Int(&constIntModel{q: 7}); q != 7 {
+		t.Errorf("%d", q)
+	}
+}
+
+type constIntModel struct {
+	q uint32
+}
+
+func (m *constIntModel) Prob0() float64 {
+	return float64(m.q) / ProbScale
+}
+
+func (m *constIntModel) Prob0Int() uint32 {
+	return m.q
+}
+
+func (m *constIntModel) Observe(bit int) {}
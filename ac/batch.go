@@ -0,0 +1,88 @@
+package ac
+
+import (
+	"io"
+)
+
+// BatchSize is the number of bits transferred at a time between the coders and BitSources or BitSinks.
+const BatchSize = 4096
+
+// A BatchWriter buffers the bits written by an encoder, and writes them to a BitSink in batches.
+type BatchWriter struct {
+	dst  BitSink
+	bits []int
+
+	// Count is the number of bits written.
+	Count int64
+}
+
+// NewBatchWriter returns a BatchWriter writing to dst.
+func NewBatchWriter(dst BitSink) *BatchWriter {
+	return &BatchWriter{dst: dst, bits: make([]int, 0, BatchSize)}
+}
+
+// Write buffers bit, and writes the buffered bits to the BitSink when a batch is full.
+func (bw *BatchWriter) Write(bit int) error {
+	bw.bits = append(bw.bits, bit)
+	bw.Count++
+	if len(bw.bits) < cap(bw.bits) {
+		return nil
+	}
+	return bw.Flush()
+}
+
+// Flush writes the buffered bits to the BitSink.
+func (bw *BatchWriter) Flush() error {
+	err := bw.dst.WriteBits(bw.bits)
+	bw.bits = bw.bits[:0]
+	return err
+}
+
+// A BatchReader reads bits from a BitSource in batches, and hands them to a decoder one at a time.
+type BatchReader struct {
+	src  BitSource
+	bits []int
+	pos  int
+	err  error
+
+	// Count is the number of bits read.
+	Count int64
+}
+
+// NewBatchReader returns a BatchReader reading from src.
+func NewBatchReader(src BitSource) *BatchReader {
+	return &BatchReader{src: src, bits: make([]int, 0, BatchSize)}
+}
+
+// Read returns the next bit.
+func (br *BatchReader) Read() (int, error) {
+	if err := br.fill(); err != nil {
+		return 0, err
+	}
+	bit := br.bits[br.pos]
+	br.pos++
+	br.Count++
+	return bit, nil
+}
+
+// EOF reports whether there are no more bits to be read.
+func (br *BatchReader) EOF() bool {
+	return br.fill() == io.EOF
+}
+
+// fill reads the next batch from src if all bits of the current batch have been read.
+func (br *BatchReader) fill() error {
+	for br.pos == len(br.bits) {
+		if br.err != nil {
+			return br.err
+		}
+		var n int
+		n, br.err = br.src.ReadBits(br.bits[:cap(br.bits)])
+		br.bits = br.bits[:n]
+		br.pos = 0
+		if n == 0 && br.err == nil {
+			br.err = io.ErrNoProgress
+		}
+	}
+	return nil
+}
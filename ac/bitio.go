@@ -0,0 +1,136 @@
+package ac
+
+import (
+	"bufio"
+	"io"
+)
+
+// A BitSource supplies a stream of bits to be coded in batches.
+// Batches avoid the cost of transferring each bit through a channel.
+type BitSource interface {
+	// ReadBits reads up to len(bits) bits into bits, and returns the number of bits read.
+	// As with io.Reader, ReadBits returns io.EOF when there are no more bits.
+	ReadBits(bits []int) (int, error)
+}
+
+// A BitSink receives a stream of coded bits in batches.
+type BitSink interface {
+	// WriteBits writes all of bits, returning an error if it could not.
+	WriteBits(bits []int) error
+}
+
+// A BitReader reads the bits of the bytes of an io.Reader, from the least significant bit of each byte to the most significant one.
+// This is the same order in which Compress feeds bytes to the arithmetic coders.
+type BitReader struct {
+	r    *bufio.Reader
+	bt   byte
+	nbit uint
+}
+
+// NewBitReader returns a BitReader reading from r.
+func NewBitReader(r io.Reader) *BitReader {
+	return &BitReader{r: bufio.NewReader(r)}
+}
+
+// ReadBit returns the next bit.
+// At the end of the input, ReadBit returns io.EOF.
+func (br *BitReader) ReadBit() (int, error) {
+	if br.nbit == 0 {
+		bt, err := br.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		br.bt = bt
+		br.nbit = 8
+	}
+	bit := int(br.bt & 1)
+	br.bt >>= 1
+	br.nbit--
+	return bit, nil
+}
+
+// ReadBits reads up to len(bits) bits into bits, and returns the number of bits read.
+// ReadBits returns io.EOF only when no bits are read.
+func (br *BitReader) ReadBits(bits []int) (int, error) {
+	for i := range bits {
+		b, err := br.ReadBit()
+		if err != nil {
+			if i > 0 && err == io.EOF {
+				return i, nil
+			}
+			return i, err
+		}
+		bits[i] = b
+	}
+	return len(bits), nil
+}
+
+// A BitWriter packs bits into bytes and writes them to an io.Writer, filling each byte from its least significant bit to the most significant one.
+// Writes are buffered, and callers must call Flush after the last bit.
+type BitWriter struct {
+	w    *bufio.Writer
+	bt   byte
+	nbit uint
+}
+
+// NewBitWriter returns a BitWriter writing to w.
+func NewBitWriter(w io.Writer) *BitWriter {
+	return &BitWriter{w: bufio.NewWriter(w)}
+}
+
+// WriteBit writes bit, which should be either zero or one.
+func (bw *BitWriter) WriteBit(bit int) error {
+	bw.bt |= byte(bit&1) << bw.nbit
+	bw.nbit++
+	if bw.nbit < 8 {
+		return nil
+	}
+	err := bw.w.WriteByte(bw.bt)
+	bw.bt = 0
+	bw.nbit = 0
+	return err
+}
+
+// WriteBits writes bits.
+func (bw *BitWriter) WriteBits(bits []int) error {
+	for _, b := range bits {
+		if err := bw.WriteBit(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+// If the number of bits written is not a multiple of 8, the last byte is padded with zeros.
+func (bw *BitWriter) Flush() error {
+	if bw.nbit > 0 {
+		if err := bw.w.WriteByte(bw.bt); err != nil {
+			return err
+		}
+		bw.bt = 0
+		bw.nbit = 0
+	}
+	return bw.w.Flush()
+}
+
+// Bits returns the bits of p, in the same order as they are read by a BitReader.
+func Bits(p []byte) []int {
+	bits := make([]int, 0, len(p)*8)
+	for _, bt := range p {
+		for i := uint(0); i < 8; i++ {
+			bits = append(bits, int(bt>>i)&1)
+		}
+	}
+	return bits
+}
+
+// Bytes packs bits into bytes, in the same order as they are written by a BitWriter.
+// If the number of bits is not a multiple of 8, the last byte is padded with zeros.
+func Bytes(bits []int) []byte {
+	p := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		p[i/8] |= byte(b&1) << uint(i%8)
+	}
+	return p
+}
@@ -0,0 +1,64 @@
+package ac
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBitIO(t *testing.T) {
+	bits := []int{1, 0, 1, 1, 0, 0, 0, 0, 1, 1}
+	buf := bytes.NewBuffer(nil)
+	bw := NewBitWriter(buf)
+	for _, b := range bits {
+		if err := bw.WriteBit(b); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{0x0d, 0x03}) {
+		t.Fatalf("%x", buf.Bytes())
+	}
+
+	br := NewBitReader(buf)
+	for i := 0; i < 16; i++ {
+		b, err := br.ReadBit()
+		if err != nil {
+			t.Fatalf("%d %v", i, err)
+		}
+		expected := 0
+		if i < len(bits) {
+			expected = bits[i]
+		}
+		if b != expected {
+			t.Fatalf("%d %d %d", i, b, expected)
+		}
+	}
+	if _, err := br.ReadBit(); err != io.EOF {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestBitsBytes(t *testing.T) {
+	bits := []int{1, 0, 1, 1, 0, 0, 0, 0, 1, 1}
+	p := Bytes(bits)
+	if !bytes.Equal(p, []byte{0x0d, 0x03}) {
+		t.Fatalf("%x", p)
+	}
+
+	unpacked := Bits(p)
+	if len(unpacked) != 16 {
+		t.Fatalf("%d", len(unpacked))
+	}
+	for i, b := range unpacked {
+		expected := 0
+		if i < len(bits) {
+			expected = bits[i]
+		}
+		if b != expected {
+			t.Fatalf("%d %d %d", i, b, expected)
+		}
+	}
+}
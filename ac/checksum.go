@@ -0,0 +1,43 @@
+package ac
+
+import (
+	"fmt"
+)
+
+// ChecksumBits is the number of bits of the checksums embedded in streams.
+const ChecksumBits = 16
+
+// A Checksum is a running checksum of the bits of a stream.
+// Coders can periodically embed the checksum of the original data into the encoded stream, so that a decoder whose model diverged from that of the encoder detects it,
+// instead of silently producing garbage.
+type Checksum uint32
+
+// Update updates the checksum with bit.
+func (c *Checksum) Update(bit int) {
+	// FNV-1a over bits.
+	h := uint32(*c)
+	if h == 0 {
+		h = 2166136261
+	}
+	h ^= uint32(bit) + 1
+	h *= 16777619
+	*c = Checksum(h)
+}
+
+// Sum returns the ChecksumBits bits of the checksum.
+func (c Checksum) Sum() uint32 {
+	h := uint32(c)
+	return (h ^ (h >> ChecksumBits)) & (1<<ChecksumBits - 1)
+}
+
+// A ChecksumError is returned by decoders when a checksum embedded in a stream does not match the decoded data.
+// This means that the model of the decoder diverged from that of the encoder, or that the stream is corrupted.
+type ChecksumError struct {
+	// Offset is the number of bits decoded when the mismatch was detected.
+	// The divergence happened after the previous checksum.
+	Offset int64
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch at bit %d", e.Offset)
+}
@@ -0,0 +1,70 @@
+package ac
+
+import (
+	"math"
+)
+
+// A Comparison summarizes how the predictions of two models P and Q differ over a sequence of bits.
+// All quantities are measured in bits.
+type Comparison struct {
+	// N is the number of bits in the sequence.
+	N int
+
+	// CodeLengthP and CodeLengthQ are the code lengths of the sequence under P and Q respectively,
+	// that is the negative log probabilities the models assign to the sequence.
+	CodeLengthP float64
+	CodeLengthQ float64
+
+	// CrossEntropy is the average over the sequence of the cross entropy of the predictions of Q relative to those of P.
+	CrossEntropy float64
+
+	// KL is the average over the sequence of the Kullback-Leibler divergence from the predictions of Q to those of P.
+	// It is zero only if the two models always make the same predictions.
+	KL float64
+
+	// MaxKL is the largest Kullback-Leibler divergence between the predictions of the two models at a single bit.
+	MaxKL float64
+}
+
+// Compare runs the models p and q over bits, and compares their predictions at each bit.
+// Both models observe bits, and are hence updated by Compare.
+func Compare(p, q Model, bits []int) Comparison {
+	c := Comparison{N: len(bits)}
+	for _, bit := range bits {
+		p0 := p.Prob0()
+		q0 := q.Prob0()
+
+		if bit == 0 {
+			c.CodeLengthP -= math.Log2(p0)
+			c.CodeLengthQ -= math.Log2(q0)
+		} else {
+			c.CodeLengthP -= math.Log2(1 - p0)
+			c.CodeLengthQ -= math.Log2(1 - q0)
+		}
+
+		ce := -xlog2y(p0, q0) - xlog2y(1-p0, 1-q0)
+		kl := ce + xlog2y(p0, p0) + xlog2y(1-p0, 1-p0)
+		c.CrossEntropy += ce
+		c.KL += kl
+		if kl > c.MaxKL {
+			c.MaxKL = kl
+		}
+
+		p.Observe(bit)
+		q.Observe(bit)
+	}
+
+	if c.N > 0 {
+		c.CrossEntropy /= float64(c.N)
+		c.KL /= float64(c.N)
+	}
+	return c
+}
+
+// xlog2y returns x*log2(y), with the convention that it is zero when x is zero.
+func xlog2y(x, y float64) float64 {
+	if x == 0 {
+		return 0
+	}
+	return x * math.Log2(y)
+}
@@ -0,0 +1,42 @@
+package ac
+
+import (
+	"math"
+	"testing"
+)
+
+type constModel struct {
+	p0 float64
+}
+
+func (m *constModel) Prob0() float64 {
+	return m.p0
+}
+
+func (m *constModel) Observe(bit int) {}
+
+func TestCompare(t *testing.T) {
+	bits := []int{0, 0, 0, 1}
+
+	same := Compare(&constModel{p0: 0.75}, &constModel{p0: 0.75}, bits)
+	if same.KL != 0 || same.MaxKL != 0 || same.CodeLengthP != same.CodeLengthQ {
+		t.Fatalf("%+v", same)
+	}
+	entropy := -0.75*math.Log2(0.75) - 0.25*math.Log2(0.25)
+	if math.Abs(same.CrossEntropy-entropy) > 1e-12 {
+		t.Fatalf("%+v %f", same, entropy)
+	}
+
+	diff := Compare(&constModel{p0: 0.75}, &constModel{p0: 0.5}, bits)
+	kl := 0.75*math.Log2(0.75/0.5) + 0.25*math.Log2(0.25/0.5)
+	if math.Abs(diff.KL-kl) > 1e-12 || math.Abs(diff.MaxKL-kl) > 1e-12 {
+		t.Fatalf("%+v %f", diff, kl)
+	}
+	if math.Abs(diff.CodeLengthQ-4) > 1e-12 {
+		t.Fatalf("%+v", diff)
+	}
+	codeLengthP := -3*math.Log2(0.75) - math.Log2(0.25)
+	if math.Abs(diff.CodeLengthP-codeLengthP) > 1e-12 {
+		t.Fatalf("%+v %f", diff, codeLengthP)
+	}
+}
@@ -0,0 +1,754 @@
+// Package eidma implements the arithmetic coding algorithm described in
+// Chapter 6. Arithmetic Encoding and Decoding,
+// F.M.J. Willems and Tj. J. Tjalkens,
+// Complexity Reduction of the Context-Tree Weighting Algorithm: A Study for KPN Research, Technical University of Eindhoven, EIDMA Report RS.97.01.
+package eidma
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/fumin/ctw/ac"
+)
+
+const (
+	// d is the width of the delay register in bits.
+	// Here, since we are using an uint64 for the delay register, d is set to 64.
+	d uint = 64
+)
+
+// An encoder carries the state required by an encoder.
+// The encoded bits are sent to write, and the first error returned by write is kept in err, after which no more bits are written.
+type encoder struct {
+	dlreg uint64
+	accum uint64
+	v     uint64
+	t     *tables
+
+	write func(bit int) error
+	err   error
+
+	// stats, if not nil, accumulates the statistics of the encoding.
+	stats *ac.Stats
+}
+
+func newEncoder(t *tables, write func(bit int) error) *encoder {
+	e := &encoder{}
+	e.v = 1
+	e.t = t
+	e.write = write
+	return e
+}
+
+func (e *encoder) output(bit int) {
+	if e.err != nil {
+		return
+	}
+	e.err = e.write(bit)
+	if e.stats != nil {
+		e.stats.EncodedBits++
+	}
+}
+
+// encode encodes x, whose quantized probability of being zero is prob0.
+func (e *encoder) encode(prob0 uint32, x int) error {
+	// Prepare v_0 and xt
+	f, A, B := e.t.f, e.t.A, e.t.B
+	v_0, relabel := e.t.stepSize(prob0)
+	xt := x
+	if relabel {
+		xt = 1 - x
+	}
+
+	// Scaling and pushing
+	for e.v > (1 << f) {
+		if e.stats != nil {
+			e.stats.Renormalizations++
+		}
+		if e.dlreg >= (1 << (d - 1)) {
+			e.output(1)
+			e.dlreg = 2 * (e.dlreg - (1 << (d - 1)))
+		} else {
+			e.output(0)
+			e.dlreg = 2 * e.dlreg
+		}
+
+		if e.accum >= (1 << f) {
+			e.dlreg = e.dlreg + 1
+			e.accum = 2 * (e.accum - (1 << f))
+		} else {
+			e.accum = 2 * e.accum
+		}
+
+		e.v = e.v - (1 << f)
+	}
+
+	// Creating zeros in delay register
+	for e.dlreg == ((1 << d) - 1) {
+		if e.stats != nil {
+			e.stats.Renormalizations++
+		}
+		e.output(1)
+		e.dlreg = 2 * (e.dlreg - (1 << (d - 1)))
+
+		if e.accum >= (1 << f) {
+			e.dlreg = e.dlreg + 1
+			e.accum = 2 * (e.accum - (1 << f))
+		} else {
+			e.accum = 2 * e.accum
+		}
+	}
+
+	v0 := e.v + v_0
+	if xt == 1 {
+		if v0 <= (1 << f) {
+			e.accum = e.accum + 2*A[v0]
+			if e.accum >= (1 << (f + 1)) {
+				e.dlreg = e.dlreg + 1
+				e.accum = e.accum - (1 << (f + 1))
+			}
+			e.v = B[A[e.v]-A[v0]]
+		} else {
+			e.accum = e.accum + A[v0-(1<<f)]
+			if e.accum >= (1 << (f + 1)) {
+				e.dlreg = e.dlreg + 1
+				e.accum = e.accum - (1 << (f + 1))
+			}
+			e.v = B[2*A[e.v]-A[v0-(1<<f)]] + (1 << f)
+		}
+	} else {
+		e.v = v0
+	}
+	return e.err
+}
+
+// finish writes the bits that terminate the encoding.
+func (e *encoder) finish() error {
+	f := e.t.f
+	for i := 1; i <= int(d); i++ {
+		if e.dlreg < (1 << (d - 1)) {
+			e.output(0)
+			e.dlreg = e.dlreg * 2
+		} else {
+			e.output(1)
+			e.dlreg = (e.dlreg - (1 << (d - 1))) * 2
+		}
+	}
+	for i := 1; i <= int(f+1); i++ {
+		if e.accum < (1 << f) {
+			e.output(0)
+			e.accum = e.accum * 2
+		} else {
+			e.output(1)
+			e.accum = (e.accum - (1 << f)) * 2
+		}
+	}
+	return e.err
+}
+
+// EncoderOptions are the options of a Coder.
+type EncoderOptions struct {
+	// Precision is the precision f in bits of the exp-tables of the coder, which should be within [MinPrecision, MaxPrecision].
+	// A higher precision codes probabilities more accurately at the cost of larger tables.
+	// Zero means DefaultPrecision.
+	Precision uint
+}
+
+// A Coder is an arithmetic coder of a given precision.
+// Its exp-tables are shared with all other Coders of the same precision, so creating a Coder is cheap after the first one.
+// The encoder and decoder of a stream must use the same precision.
+type Coder struct {
+	tables *tables
+}
+
+// NewCoder returns a Coder with the given options.
+func NewCoder(opts EncoderOptions) (*Coder, error) {
+	f := opts.Precision
+	if f == 0 {
+		f = DefaultPrecision
+	}
+	if f < MinPrecision || f > MaxPrecision {
+		return nil, fmt.Errorf("precision %d not within [%d, %d]", f, MinPrecision, MaxPrecision)
+	}
+	return &Coder{tables: getTables(f)}, nil
+}
+
+// Precision returns the precision f in bits of the exp-tables of the coder.
+func (c *Coder) Precision() uint {
+	return c.tables.f
+}
+
+// defaultCoder is the Coder of DefaultPrecision used by the package level functions.
+var defaultCoder = &Coder{tables: getTables(DefaultPrecision)}
+
+// Encode is Coder.Encode with the default precision.
+func Encode(dst chan<- int, src <-chan int, model ac.Model) {
+	defaultCoder.Encode(dst, src, model)
+}
+
+// EncodeBits is Coder.EncodeBits with the default precision.
+func EncodeBits(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return defaultCoder.EncodeBits(dst, src, model)
+}
+
+// EncodeContext is Coder.EncodeContext with the default precision.
+func EncodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return defaultCoder.EncodeContext(ctx, dst, src, model)
+}
+
+// EncodeStream is Coder.EncodeStream with the default precision.
+func EncodeStream(w io.Writer, r io.Reader, model ac.Model) error {
+	return defaultCoder.EncodeStream(w, r, model)
+}
+
+// EncodeBytes is Coder.EncodeBytes with the default precision.
+func EncodeBytes(src []byte, model ac.Model) []byte {
+	return defaultCoder.EncodeBytes(src, model)
+}
+
+// EncodeChecksum is Coder.EncodeChecksum with the default precision.
+func EncodeChecksum(dst ac.BitSink, src ac.BitSource, model ac.Model, period int64) error {
+	return defaultCoder.EncodeChecksum(dst, src, model, period)
+}
+
+// EncodeStats is Coder.EncodeStats with the default precision.
+func EncodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	return defaultCoder.EncodeStats(ctx, dst, src, model)
+}
+
+// EncodeTerminated is Coder.EncodeTerminated with the default precision.
+func EncodeTerminated(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return defaultCoder.EncodeTerminated(dst, src, model)
+}
+
+// Decode is Coder.Decode with the default precision.
+func Decode(dst chan<- int, src <-chan int, model ac.Model, originalSize int64) error {
+	return defaultCoder.Decode(dst, src, model, originalSize)
+}
+
+// DecodeBits is Coder.DecodeBits with the default precision.
+func DecodeBits(dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) error {
+	return defaultCoder.DecodeBits(dst, src, model, originalSize)
+}
+
+// DecodeContext is Coder.DecodeContext with the default precision.
+func DecodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) error {
+	return defaultCoder.DecodeContext(ctx, dst, src, model, originalSize)
+}
+
+// DecodeChecksum is Coder.DecodeChecksum with the default precision.
+func DecodeChecksum(dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize, period int64) error {
+	return defaultCoder.DecodeChecksum(dst, src, model, originalSize, period)
+}
+
+// DecodeStats is Coder.DecodeStats with the default precision.
+func DecodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	return defaultCoder.DecodeStats(ctx, dst, src, model, originalSize)
+}
+
+// DecodeTerminated is Coder.DecodeTerminated with the default precision.
+func DecodeTerminated(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return defaultCoder.DecodeTerminated(dst, src, model)
+}
+
+// DecodeStream is Coder.DecodeStream with the default precision.
+func DecodeStream(w io.Writer, r io.Reader, model ac.Model, n int64) error {
+	return defaultCoder.DecodeStream(w, r, model, n)
+}
+
+// DecodeBytes is Coder.DecodeBytes with the default precision.
+func DecodeBytes(src []byte, model ac.Model, n int) ([]byte, error) {
+	return defaultCoder.DecodeBytes(src, model, n)
+}
+
+// Encode performs arithmetic coding on a stream of bits given a binary probabilistic model.
+// The input bits should be sent through src, which Encode consumes until it is closed.
+// The output bits can be received from dst. Encode will block when dst if full and is not read from.
+// Encode closes dst when the encoding is complete and there are no more bits to be sent to it.
+func (c *Coder) Encode(dst chan<- int, src <-chan int, model ac.Model) {
+	defer close(dst)
+	e := newEncoder(c.tables, func(bit int) error {
+		dst <- bit
+		return nil
+	})
+	for x := range src {
+		prob0 := ac.Prob0Int(model)
+		model.Observe(x)
+		e.encode(prob0, x)
+	}
+	e.finish()
+}
+
+// EncodeBits performs arithmetic coding on the bits read from src given a binary probabilistic model, and writes the encoded bits to dst.
+// Bits are transferred in batches, which is much faster than the channels of Encode.
+func (c *Coder) EncodeBits(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return c.EncodeContext(context.Background(), dst, src, model)
+}
+
+// EncodeContext is like EncodeBits, but stops early and returns ctx.Err() if ctx is cancelled.
+// The context is checked between batches of bits.
+func (c *Coder) EncodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return c.encodeContext(ctx, dst, src, model, options{})
+}
+
+// EncodeStats is like EncodeContext, but also returns the statistics of the encoding.
+func (c *Coder) EncodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	var stats ac.Stats
+	err := c.encodeContext(ctx, dst, src, model, options{stats: &stats})
+	return stats, err
+}
+
+// EncodeTerminated is like EncodeBits, but encodes an end of stream marker after the last bit, so that DecodeTerminated needs not know the length of the original data.
+// This allows coding streams whose length is unknown upfront, such as those from pipes.
+func (c *Coder) EncodeTerminated(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return c.encodeContext(context.Background(), dst, src, model, options{terminated: true})
+}
+
+// EncodeChecksum is like EncodeBits, but embeds a checksum of the original data after every period bits.
+// DecodeChecksum verifies the checksums, and returns an *ac.ChecksumError if the decoded data does not match them.
+// Each checksum takes ac.ChecksumBits bits, so a period of a few thousand bits or more adds a negligible overhead.
+func (c *Coder) EncodeChecksum(dst ac.BitSink, src ac.BitSource, model ac.Model, period int64) error {
+	if period <= 0 {
+		return fmt.Errorf("non-positive checksum period %d", period)
+	}
+	return c.encodeContext(context.Background(), dst, src, model, options{checksumPeriod: period})
+}
+
+// options are the options of the encoding and decoding of a stream.
+type options struct {
+	// terminated is whether each bit is preceded by an end of stream flag.
+	terminated bool
+
+	// stats, if not nil, accumulates the statistics of the coding.
+	stats *ac.Stats
+
+	// checksumPeriod, if positive, is the number of bits after which a checksum is embedded.
+	checksumPeriod int64
+}
+
+// encodeContext performs arithmetic coding on the bits read from src with the given options.
+func (c *Coder) encodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, opts options) error {
+	bw := ac.NewBatchWriter(dst)
+	e := newEncoder(c.tables, bw.Write)
+	e.stats = opts.stats
+	var checksum ac.Checksum
+	var count int64
+	bits := make([]int, ac.BatchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := src.ReadBits(bits)
+		for _, x := range bits[:n] {
+			if opts.terminated {
+				if err := e.encode(ac.TerminationProb0, 0); err != nil {
+					return err
+				}
+			}
+			prob0 := ac.Prob0Int(model)
+			model.Observe(x)
+			if opts.stats != nil {
+				opts.stats.Observe(prob0, x)
+			}
+			if err := e.encode(prob0, x); err != nil {
+				return err
+			}
+
+			count++
+			if opts.checksumPeriod > 0 {
+				checksum.Update(x)
+				if count%opts.checksumPeriod == 0 {
+					if err := encodeChecksum(e, checksum); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if opts.terminated {
+		if err := e.encode(ac.TerminationProb0, 1); err != nil {
+			return err
+		}
+	}
+	if err := e.finish(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// encodeChecksum encodes the bits of checksum, each with probability one half.
+func encodeChecksum(e *encoder, checksum ac.Checksum) error {
+	sum := checksum.Sum()
+	for i := uint(0); i < ac.ChecksumBits; i++ {
+		if err := e.encode(ac.ProbScale/2, int((sum>>i)&1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeStream performs arithmetic coding on the bytes read from r given a binary probabilistic model, and writes the encoded bytes to w.
+// The bits of each byte are coded from the least significant one to the most significant one, and the encoded bits are packed into bytes in the same order.
+// It is the io counterpart of Encode, and produces the same bits as Encode does, padded with zeros to a whole number of bytes.
+func (c *Coder) EncodeStream(w io.Writer, r io.Reader, model ac.Model) error {
+	bw := ac.NewBitWriter(w)
+	if err := c.EncodeBits(bw, ac.NewBitReader(r), model); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// EncodeBytes performs arithmetic coding on src given a binary probabilistic model, and returns the encoded bytes.
+// It is a convenience wrapper of EncodeStream for small payloads held in memory.
+func (c *Coder) EncodeBytes(src []byte, model ac.Model) []byte {
+	buf := bytes.NewBuffer(nil)
+	// Neither reading from nor writing to memory buffers fail.
+	c.EncodeStream(buf, bytes.NewReader(src), model)
+	return buf.Bytes()
+}
+
+// A decoder carries the state required by a decoder.
+// The encoded bits are obtained from read, which returns io.EOF when there are no more bits.
+type decoder struct {
+	dlreg  uint64
+	accum  uint64
+	v      uint64
+	cdlreg uint64
+	caccum uint64
+	t      *tables
+
+	read func() (int, error)
+
+	// stats, if not nil, accumulates the statistics of the decoding.
+	stats *ac.Stats
+}
+
+func newDecoder(t *tables, read func() (int, error), stats *ac.Stats) (*decoder, error) {
+	dc := &decoder{}
+	dc.v = 1
+	dc.t = t
+	dc.read = read
+	dc.stats = stats
+	for i := 1; i <= int(d); i++ {
+		pull, err := dc.pull()
+		if err != nil {
+			return nil, err
+		}
+		dc.cdlreg = dc.cdlreg*2 + pull
+	}
+	for i := 1; i <= int(t.f+1); i++ {
+		pull, err := dc.pull()
+		if err != nil {
+			return nil, err
+		}
+		dc.caccum = dc.caccum*2 + pull
+	}
+	return dc, nil
+}
+
+// pull reads the next encoded bit.
+func (dc *decoder) pull() (uint64, error) {
+	if dc.stats != nil {
+		dc.stats.EncodedBits++
+	}
+	b, err := dc.read()
+	if err == io.EOF {
+		return 0, ac.ErrDecodeInsufficientBits
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint64(b), nil
+}
+
+// shift shifts the next encoded bit into the code registers.
+func (dc *decoder) shift() error {
+	f := dc.t.f
+	if dc.cdlreg >= (1 << (d - 1)) {
+		dc.cdlreg = 2 * (dc.cdlreg - (1 << (d - 1)))
+	} else {
+		dc.cdlreg = 2 * dc.cdlreg
+	}
+
+	pl, err := dc.pull()
+	if err != nil {
+		return err
+	}
+	if dc.caccum >= (1 << f) {
+		dc.cdlreg = dc.cdlreg + 1
+		dc.caccum = 2*(dc.caccum-(1<<f)) + pl
+	} else {
+		dc.caccum = 2*dc.caccum + pl
+	}
+	return nil
+}
+
+// decode decodes the next bit, whose quantized probability of being zero is prob0.
+func (dc *decoder) decode(prob0 uint32) (int, error) {
+	// Prepare v_0
+	f, A, B := dc.t.f, dc.t.A, dc.t.B
+	v_0, relabel := dc.t.stepSize(prob0)
+
+	// Scaling and pulling
+	for dc.v > (1 << f) {
+		if dc.stats != nil {
+			dc.stats.Renormalizations++
+		}
+		if dc.dlreg >= (1 << (d - 1)) {
+			dc.dlreg = 2 * (dc.dlreg - (1 << (d - 1)))
+		} else {
+			dc.dlreg = 2 * dc.dlreg
+		}
+		if dc.accum >= (1 << f) {
+			dc.dlreg = dc.dlreg + 1
+			dc.accum = 2 * (dc.accum - (1 << f))
+		} else {
+			dc.accum = 2 * dc.accum
+		}
+		dc.v = dc.v - (1 << f)
+		if err := dc.shift(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Creating zeros in delay register
+	for dc.dlreg == ((1 << d) - 1) {
+		if dc.stats != nil {
+			dc.stats.Renormalizations++
+		}
+		dc.dlreg = 2 * (dc.dlreg - (1 << (d - 1)))
+		if dc.accum >= (1 << f) {
+			dc.dlreg = dc.dlreg + 1
+			dc.accum = 2 * (dc.accum - (1 << f))
+		} else {
+			dc.accum = 2 * dc.accum
+		}
+		if err := dc.shift(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Adding A[v0] to the accumulator (or not) and computing v.
+	// At the same time, decode the next bit xt.
+	var xt int
+	v0 := dc.v + v_0
+	var taccum uint64
+	if v0 <= (1 << f) {
+		taccum = dc.accum + 2*A[v0]
+	} else {
+		taccum = dc.accum + A[v0-(1<<f)]
+	}
+	tdlreg := dc.dlreg
+	if taccum >= (1 << (f + 1)) {
+		tdlreg = tdlreg + 1
+		taccum = taccum - (1 << (f + 1))
+	}
+	if (dc.cdlreg == tdlreg && dc.caccum < taccum) || (dc.cdlreg < tdlreg) {
+		xt = 0
+	} else {
+		xt = 1
+	}
+	if xt == 1 {
+		dc.accum = taccum
+		dc.dlreg = tdlreg
+		if v0 <= (1 << f) {
+			dc.v = B[A[dc.v]-A[v0]]
+		} else {
+			dc.v = B[2*A[dc.v]-A[v0-(1<<f)]] + (1 << f)
+		}
+	} else {
+		dc.v = v0
+	}
+
+	// Handle relabeling.
+	if relabel {
+		xt = 1 - xt
+	}
+	return xt, nil
+}
+
+// Decode decodes a stream of bits encoded by Encode using arithmetic coding.
+//
+// Completion of the decoding is determined by originalSize, which is the number of bits of the original data before encoding.
+// Decode consumes bits from src until either it is closed, or when it has decoded originalSize number of bits.
+// Therefore, it is important that callers close src when there are no more bits to be sent to Decode, so that Decode will not block indefinitely on receiving from src.
+// Equally important is that callers do not block indefinitely when sending to src, since Decode can return early and stop consuming src anytime.
+// A typical pattern is for callers to multiplex on src and a separate channel that is signaled when the decoding completes or errors.
+//
+// The output decoded bits can be received from dst. Decode will block when dst is full and is not read from.
+// Decode closes dst when the decoding is complete.
+// Decode expects that model is the exact same probabilistic model used in Encode.
+// ErrDecodeInsufficientBits is returned if src is closed before originalSize number of bits have been decoded.
+func (c *Coder) Decode(dst chan<- int, src <-chan int, model ac.Model, originalSize int64) error {
+	defer close(dst)
+	dc, err := newDecoder(c.tables, func() (int, error) {
+		b, ok := <-src
+		if !ok {
+			return 0, io.EOF
+		}
+		return b, nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	for i := int64(0); i < originalSize; i++ {
+		prob0 := ac.Prob0Int(model)
+		xt, err := dc.decode(prob0)
+		if err != nil {
+			return err
+		}
+		model.Observe(xt)
+		dst <- xt
+	}
+
+	return nil
+}
+
+// DecodeBits decodes the bits read from src, which were encoded by EncodeBits, and writes the decoded bits to dst.
+// Completion of the decoding is determined by originalSize, which is the number of bits of the original data before encoding.
+// Bits are transferred in batches, which is much faster than the channels of Decode.
+// DecodeBits expects that model is the exact same probabilistic model used in EncodeBits.
+func (c *Coder) DecodeBits(dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) error {
+	return c.DecodeContext(context.Background(), dst, src, model, originalSize)
+}
+
+// DecodeContext is like DecodeBits, but stops early and returns ctx.Err() if ctx is cancelled.
+// The context is checked between batches of bits.
+func (c *Coder) DecodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) error {
+	if originalSize < 0 {
+		return fmt.Errorf("negative original size %d", originalSize)
+	}
+	return c.decodeContext(ctx, dst, src, model, originalSize, options{})
+}
+
+// DecodeStats is like DecodeContext, but also returns the statistics of the decoding.
+func (c *Coder) DecodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	var stats ac.Stats
+	if originalSize < 0 {
+		return stats, fmt.Errorf("negative original size %d", originalSize)
+	}
+	err := c.decodeContext(ctx, dst, src, model, originalSize, options{stats: &stats})
+	return stats, err
+}
+
+// DecodeTerminated decodes the bits read from src, which were encoded by EncodeTerminated, and writes the decoded bits to dst.
+// Decoding stops at the end of stream marker, and bits of src after the encoded stream are not consumed unless they are within the read buffer.
+// DecodeTerminated expects that model is the exact same probabilistic model used in EncodeTerminated.
+func (c *Coder) DecodeTerminated(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return c.decodeContext(context.Background(), dst, src, model, -1, options{terminated: true})
+}
+
+// DecodeChecksum decodes the bits read from src, which were encoded by EncodeChecksum with the same period, and writes the decoded bits to dst.
+// If an embedded checksum does not match the decoded data, DecodeChecksum returns an *ac.ChecksumError with the offset of the mismatch.
+func (c *Coder) DecodeChecksum(dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize, period int64) error {
+	if originalSize < 0 {
+		return fmt.Errorf("negative original size %d", originalSize)
+	}
+	if period <= 0 {
+		return fmt.Errorf("non-positive checksum period %d", period)
+	}
+	return c.decodeContext(context.Background(), dst, src, model, originalSize, options{checksumPeriod: period})
+}
+
+// decodeContext decodes originalSize bits from src with the given options.
+// If opts.terminated is true, originalSize should be negative, and the decoding continues until the end of stream marker.
+func (c *Coder) decodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64, opts options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dc, err := newDecoder(c.tables, ac.NewBatchReader(src).Read, opts.stats)
+	if err != nil {
+		return err
+	}
+
+	bw := ac.NewBatchWriter(dst)
+	var checksum ac.Checksum
+	for i := int64(0); opts.terminated || i < originalSize; i++ {
+		if i%ac.BatchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if opts.terminated {
+			end, err := dc.decode(ac.TerminationProb0)
+			if err != nil {
+				return err
+			}
+			if end == 1 {
+				break
+			}
+		}
+		prob0 := ac.Prob0Int(model)
+		xt, err := dc.decode(prob0)
+		if err != nil {
+			return err
+		}
+		model.Observe(xt)
+		if opts.stats != nil {
+			opts.stats.Observe(prob0, xt)
+		}
+		if err := bw.Write(xt); err != nil {
+			return err
+		}
+
+		if opts.checksumPeriod > 0 {
+			checksum.Update(xt)
+			if (i+1)%opts.checksumPeriod == 0 {
+				if err := decodeChecksum(dc, checksum, i+1); err != nil {
+					// Write out the bits decoded so far to help debugging.
+					bw.Flush()
+					return err
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// decodeChecksum decodes an embedded checksum, and returns an *ac.ChecksumError if it does not match checksum.
+// The offset is the number of bits decoded so far.
+func decodeChecksum(dc *decoder, checksum ac.Checksum, offset int64) error {
+	var sum uint32
+	for i := uint(0); i < ac.ChecksumBits; i++ {
+		b, err := dc.decode(ac.ProbScale / 2)
+		if err != nil {
+			return err
+		}
+		sum |= uint32(b) << i
+	}
+	if sum != checksum.Sum() {
+		return &ac.ChecksumError{Offset: offset}
+	}
+	return nil
+}
+
+// DecodeStream decodes the bytes read from r, which were encoded by EncodeStream, and writes the decoded bytes to w.
+// Completion of the decoding is determined by n, which is the number of bytes of the original data before encoding.
+// DecodeStream expects that model is the exact same probabilistic model used in EncodeStream.
+// ErrDecodeInsufficientBits is returned if r ends before n bytes have been decoded.
+func (c *Coder) DecodeStream(w io.Writer, r io.Reader, model ac.Model, n int64) error {
+	bw := ac.NewBitWriter(w)
+	if err := c.DecodeBits(bw, ac.NewBitReader(r), model, n*8); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// DecodeBytes decodes src, which was encoded by EncodeBytes, into the n bytes of the original data.
+// DecodeBytes expects that model is the exact same probabilistic model used in EncodeBytes.
+func (c *Coder) DecodeBytes(src []byte, model ac.Model, n int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, n))
+	if err := c.DecodeStream(buf, bytes.NewReader(src), model, int64(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,461 @@
+package eidma
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+)
+
+func TestEncodeConstModel(t *testing.T) {
+	model := func(p float64) func() ac.Model {
+		return func() ac.Model {
+			return &ConstModel{P0: p}
+		}
+	}
+
+	testEncode(t, model(0.75))
+
+	// Test the edge case where v_0 == (1 << (f+1))
+	testEncode(t, model(0.5))
+
+	// Test the case where the probability of zero is less than 0.5.
+	// The description of the algorithm assumes that the probability of zero is larger than 0.5,
+	// and explains that the opposite case can be handled by relabeling the zeros and ones.
+	// We check that the implementation conducts such relabelings correctly.
+	testEncode(t, model(0.25))
+
+	// Test that the "creating zeros in delay register" mechanism is working
+	testEncode(t, model(0.000000025))
+}
+
+func testEncode(t *testing.T, model func() ac.Model) {
+	// Prepare data
+	// x := []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1, 1, 1, 0, 1, 0, 1, 1, 1, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0}
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	x := ac.Bits(contents)
+
+	// Encode
+	src := make(chan int)
+	go func() {
+		for _, b := range x {
+			src <- b
+		}
+		close(src)
+	}()
+
+	encoded := []int{}
+	dst := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for b := range dst {
+			encoded = append(encoded, b)
+		}
+	}()
+
+	Encode(dst, src, model())
+	wg.Wait()
+	t.Logf("encoded bits: %d, original bits: %d", len(encoded), len(x))
+
+	// Decode
+	dsrc := make(chan int)
+	go func() {
+		for i := range encoded {
+			dsrc <- encoded[i]
+		}
+		close(dsrc)
+	}()
+
+	decoded := []int{}
+	ddst := make(chan int)
+	wg = sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for b := range ddst {
+			decoded = append(decoded, b)
+		}
+	}()
+
+	Decode(ddst, dsrc, model(), int64(len(x)))
+	wg.Wait()
+
+	// Check that the decoded result is correct.
+	if len(x) != len(decoded) {
+		t.Fatalf("%d != %d", len(x), len(decoded))
+	}
+	for i, b := range x {
+		if decoded[i] != b {
+			t.Errorf("%d: %d != %d", i, b, decoded[i])
+		}
+	}
+}
+
+type ConstModel struct {
+	P0 float64
+}
+
+func (m *ConstModel) Prob0() float64 {
+	return m.P0
+}
+
+func (m *ConstModel) Observe(b int) {}
+
+func TestEncodeStream(t *testing.T) {
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	encoded := bytes.NewBuffer(nil)
+	if err := EncodeStream(encoded, bytes.NewReader(contents), &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	t.Logf("encoded bytes: %d, original bytes: %d", encoded.Len(), len(contents))
+
+	decoded := bytes.NewBuffer(nil)
+	if err := DecodeStream(decoded, encoded, &ConstModel{P0: 0.25}, int64(len(contents))); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), contents) {
+		t.Fatalf("%s", decoded.Bytes())
+	}
+}
+
+func TestEncodeBytes(t *testing.T) {
+	msg := []byte("Four score and seven years ago")
+	encoded := EncodeBytes(msg, &ConstModel{P0: 0.75})
+	decoded, err := DecodeBytes(encoded, &ConstModel{P0: 0.75}, len(msg))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(decoded, msg) {
+		t.Fatalf("%s", decoded)
+	}
+}
+
+func TestEncodeBits(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	if err := EncodeBits(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// The encoded bits are the same as those of the channel based Encode.
+	src := make(chan int)
+	go func() {
+		for _, b := range x {
+			src <- b
+		}
+		close(src)
+	}()
+	dst := make(chan int)
+	go Encode(dst, src, &ConstModel{P0: 0.25})
+	i := 0
+	for b := range dst {
+		if i >= len(encoded.bits) || encoded.bits[i] != b {
+			t.Fatalf("%d", i)
+		}
+		i++
+	}
+	if i != len(encoded.bits) {
+		t.Fatalf("%d %d", i, len(encoded.bits))
+	}
+
+	decoded := &sliceSink{}
+	if err := DecodeBits(decoded, &sliceSource{bits: encoded.bits}, &ConstModel{P0: 0.25}, int64(len(x))); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(decoded.bits) != len(x) {
+		t.Fatalf("%d %d", len(decoded.bits), len(x))
+	}
+	for i, b := range x {
+		if decoded.bits[i] != b {
+			t.Fatalf("%d: %d != %d", i, b, decoded.bits[i])
+		}
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	x := gettysburgBits(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := EncodeContext(ctx, &sliceSink{}, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != context.Canceled {
+		t.Fatalf("%+v", err)
+	}
+
+	encoded := &sliceSink{}
+	if err := EncodeBits(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := DecodeContext(ctx, &sliceSink{}, &sliceSource{bits: encoded.bits}, &ConstModel{P0: 0.25}, int64(len(x))); err != context.Canceled {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestEncodeTerminated(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	if err := EncodeTerminated(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	plain := &sliceSink{}
+	if err := EncodeBits(plain, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	t.Logf("terminated bits: %d, plain bits: %d", len(encoded.bits), len(plain.bits))
+
+	// Trailing bits after the stream do not affect decoding.
+	src := &sliceSource{bits: append(encoded.bits, 1, 0, 1, 1, 0, 1)}
+	decoded := &sliceSink{}
+	if err := DecodeTerminated(decoded, src, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(decoded.bits) != len(x) {
+		t.Fatalf("%d %d", len(decoded.bits), len(x))
+	}
+	for i, b := range x {
+		if decoded.bits[i] != b {
+			t.Fatalf("%d: %d != %d", i, b, decoded.bits[i])
+		}
+	}
+}
+
+func TestEncodeStats(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	stats, err := EncodeStats(context.Background(), encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.5})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stats.Bits != int64(len(x)) || stats.EncodedBits != int64(len(encoded.bits)) || stats.Renormalizations == 0 {
+		t.Fatalf("%+v %d", stats, len(encoded.bits))
+	}
+	if math.Abs(stats.CrossEntropy-float64(len(x))) > 1e-6 {
+		t.Fatalf("%+v", stats)
+	}
+
+	dstats, err := DecodeStats(context.Background(), &sliceSink{}, &sliceSource{bits: encoded.bits}, &ConstModel{P0: 0.5}, int64(len(x)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dstats.Bits != stats.Bits || dstats.CrossEntropy != stats.CrossEntropy || dstats.Renormalizations != stats.Renormalizations {
+		t.Fatalf("%+v %+v", dstats, stats)
+	}
+}
+
+func TestEncodeChecksum(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	if err := EncodeChecksum(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.25}, 1024); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	decoded := &sliceSink{}
+	if err := DecodeChecksum(decoded, &sliceSource{bits: encoded.bits}, &ConstModel{P0: 0.25}, int64(len(x)), 1024); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i, b := range x {
+		if decoded.bits[i] != b {
+			t.Fatalf("%d: %d != %d", i, b, decoded.bits[i])
+		}
+	}
+
+	// A decoder whose model diverges from that of the encoder detects the divergence at the next checksum.
+	diverged := &divergingModel{ConstModel: ConstModel{P0: 0.25}, after: 5000}
+	err := DecodeChecksum(&sliceSink{}, &sliceSource{bits: encoded.bits}, diverged, int64(len(x)), 1024)
+	cerr, ok := err.(*ac.ChecksumError)
+	if !ok {
+		t.Fatalf("%+v", err)
+	}
+	if cerr.Offset != 5120 {
+		t.Fatalf("%d", cerr.Offset)
+	}
+}
+
+// A divergingModel changes its prediction after observing a number of bits.
+type divergingModel struct {
+	ConstModel
+	after int
+	n     int
+}
+
+func (m *divergingModel) Prob0() float64 {
+	if m.n >= m.after {
+		return 0.3
+	}
+	return m.P0
+}
+
+func (m *divergingModel) Observe(b int) {
+	m.n++
+}
+
+func BenchmarkEncode(b *testing.B) {
+	x := gettysburgBits(b)
+	b.SetBytes(int64(len(x) / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src := make(chan int)
+		go func() {
+			for _, b := range x {
+				src <- b
+			}
+			close(src)
+		}()
+		dst := make(chan int)
+		go Encode(dst, src, &ConstModel{P0: 0.25})
+		for range dst {
+		}
+	}
+}
+
+func BenchmarkEncodeBits(b *testing.B) {
+	x := gettysburgBits(b)
+	b.SetBytes(int64(len(x) / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := EncodeBits(&sliceSink{}, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+}
+
+func gettysburgBits(tb testing.TB) []int {
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		tb.Fatalf("%v", err)
+	}
+	x := ac.Bits(contents)
+	return x
+}
+
+type sliceSource struct {
+	bits []int
+}
+
+func (s *sliceSource) ReadBits(bits []int) (int, error) {
+	if len(s.bits) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(bits, s.bits)
+	s.bits = s.bits[n:]
+	return n, nil
+}
+
+type sliceSink struct {
+	bits []int
+}
+
+func (s *sliceSink) WriteBits(bits []int) error {
+	s.bits = append(s.bits, bits...)
+	return nil
+}
+
+func TestPrecision(t *testing.T) {
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, f := range []uint{MinPrecision, 8, 16, MaxPrecision} {
+		coder, err := NewCoder(EncoderOptions{Precision: f})
+		if err != nil {
+			t.Fatalf("%d %+v", f, err)
+		}
+		A := coder.tables.A
+		if A[1] != (1<<f)-1 {
+			t.Fatalf("%d %d", f, A[1])
+		}
+
+		encoded := coder.EncodeBytes(contents, &ConstModel{P0: 0.25})
+		decoded, err := coder.DecodeBytes(encoded, &ConstModel{P0: 0.25}, len(contents))
+		if err != nil {
+			t.Fatalf("%d %+v", f, err)
+		}
+		if !bytes.Equal(decoded, contents) {
+			t.Fatalf("%d %s", f, decoded)
+		}
+		t.Logf("precision %d, encoded bytes: %d", f, len(encoded))
+	}
+
+	if _, err := NewCoder(EncoderOptions{Precision: MaxPrecision + 1}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if c, _ := NewCoder(EncoderOptions{}); c.tables != defaultCoder.tables {
+		t.Fatalf("tables not cached")
+	}
+}
+
+func TestEncoderFlush(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	enc := NewEncoder(encoded, &ConstModel{P0: 0.25})
+	src := &flushedSource{sink: encoded}
+	dec := NewDecoder(src, &ConstModel{P0: 0.25})
+
+	// Each segment is decodable as soon as it is flushed, without reading the bits of subsequent segments.
+	segment := 1000
+	for start := 0; start < len(x); start += segment {
+		end := start + segment
+		if end > len(x) {
+			end = len(x)
+		}
+		for _, b := range x[start:end] {
+			if err := enc.Encode(b); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(encoded.bits)%8 != 0 {
+			t.Fatalf("%d", len(encoded.bits))
+		}
+
+		for i := start; i < end; i++ {
+			b, err := dec.Decode()
+			if err != nil {
+				t.Fatalf("%d %+v", i, err)
+			}
+			if b != x[i] {
+				t.Fatalf("%d: %d != %d", i, x[i], b)
+			}
+		}
+	}
+	src.closed = true
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// A flushedSource reads the bits that have been written to sink, and fails if there are none.
+type flushedSource struct {
+	sink   *sliceSink
+	pos    int
+	closed bool
+}
+
+func (s *flushedSource) ReadBits(bits []int) (int, error) {
+	if s.pos == len(s.sink.bits) {
+		if s.closed {
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("read beyond flushed bits %d", s.pos)
+	}
+	n := copy(bits, s.sink.bits[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+var _ ac.Coder = &Coder{}
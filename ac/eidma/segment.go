@@ -0,0 +1,155 @@
+package eidma
+
+import (
+	"io"
+
+	"github.com/fumin/ctw/ac"
+)
+
+// flusher is implemented by BitSinks that buffer bits, such as ac.BitWriter.
+type flusher interface {
+	Flush() error
+}
+
+// An Encoder encodes bits one at a time, and emits them in segments that can be decoded as soon as they are flushed.
+// This allows a long-lived connection to send decodable data without closing the stream.
+// The model is shared across segments, so segments must be decoded in order by a Decoder.
+type Encoder struct {
+	model  ac.Model
+	dst    ac.BitSink
+	bw     *ac.BatchWriter
+	tables *tables
+
+	// e is the encoder of the current segment, or nil if no bits have been encoded since the last flush.
+	e     *encoder
+	stats ac.Stats
+}
+
+// NewEncoder returns an Encoder of the precision of c, which writes the encoded bits to dst.
+func (c *Coder) NewEncoder(dst ac.BitSink, model ac.Model) *Encoder {
+	return &Encoder{model: model, dst: dst, bw: ac.NewBatchWriter(dst), tables: c.tables}
+}
+
+// NewEncoder is Coder.NewEncoder with the default precision.
+func NewEncoder(dst ac.BitSink, model ac.Model) *Encoder {
+	return defaultCoder.NewEncoder(dst, model)
+}
+
+// Encode encodes bit.
+// The encoded bit is only guaranteed to be written to dst after Flush.
+func (enc *Encoder) Encode(bit int) error {
+	if enc.e == nil {
+		enc.e = newEncoder(enc.tables, enc.bw.Write)
+		enc.e.stats = &enc.stats
+	}
+	if err := enc.e.encode(ac.TerminationProb0, 0); err != nil {
+		return err
+	}
+	prob0 := ac.Prob0Int(enc.model)
+	enc.model.Observe(bit)
+	enc.stats.Observe(prob0, bit)
+	return enc.e.encode(prob0, bit)
+}
+
+// Stats returns the statistics of the bits encoded so far.
+// Bits that have not been flushed are not included in the EncodedBits.
+func (enc *Encoder) Stats() ac.Stats {
+	return enc.stats
+}
+
+// Flush terminates the current segment, and writes it to dst.
+// If dst has a Flush method, as ac.BitWriter does, it is also called.
+// Segments are padded to a whole number of bytes.
+func (enc *Encoder) Flush() error {
+	if enc.e != nil {
+		if err := enc.e.encode(ac.TerminationProb0, 1); err != nil {
+			return err
+		}
+		if err := enc.e.finish(); err != nil {
+			return err
+		}
+		for enc.bw.Count%8 != 0 {
+			enc.e.output(0)
+		}
+		if enc.e.err != nil {
+			return enc.e.err
+		}
+		enc.e = nil
+	}
+	if err := enc.bw.Flush(); err != nil {
+		return err
+	}
+	if f, ok := enc.dst.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// A Decoder decodes the segments of an Encoder one bit at a time.
+type Decoder struct {
+	model  ac.Model
+	br     *ac.BatchReader
+	tables *tables
+
+	// dc is the decoder of the current segment, or nil if the next bit starts a new segment.
+	dc    *decoder
+	stats ac.Stats
+}
+
+// NewDecoder returns a Decoder of the precision of c, which reads the encoded bits from src.
+// The model should be the exact same probabilistic model used in the Encoder, whose precision should be the same as c.
+func (c *Coder) NewDecoder(src ac.BitSource, model ac.Model) *Decoder {
+	return &Decoder{model: model, br: ac.NewBatchReader(src), tables: c.tables}
+}
+
+// NewDecoder is Coder.NewDecoder with the default precision.
+func NewDecoder(src ac.BitSource, model ac.Model) *Decoder {
+	return defaultCoder.NewDecoder(src, model)
+}
+
+// Decode returns the next decoded bit.
+// Decode returns io.EOF when src ends after the last segment.
+func (dec *Decoder) Decode() (int, error) {
+	for {
+		if dec.dc == nil {
+			if dec.br.EOF() {
+				return 0, io.EOF
+			}
+			dc, err := newDecoder(dec.tables, dec.br.Read, &dec.stats)
+			if err != nil {
+				return 0, err
+			}
+			dec.dc = dc
+		}
+
+		end, err := dec.dc.decode(ac.TerminationProb0)
+		if err != nil {
+			return 0, err
+		}
+		if end == 0 {
+			break
+		}
+		// Skip the padding of the segment.
+		for dec.br.Count%8 != 0 {
+			if _, err := dec.br.Read(); err != nil {
+				return 0, err
+			}
+			dec.stats.EncodedBits++
+		}
+		dec.dc = nil
+	}
+
+	prob0 := ac.Prob0Int(dec.model)
+	bit, err := dec.dc.decode(prob0)
+	if err != nil {
+		return 0, err
+	}
+	dec.model.Observe(bit)
+	dec.stats.Observe(prob0, bit)
+	return bit, nil
+}
+
+// Stats returns the statistics of the bits decoded so far.
+func (dec *Decoder) Stats() ac.Stats {
+	return dec.stats
+}
@@ -0,0 +1,106 @@
+package eidma
+
+import (
+	"math"
+	"sync"
+
+	"github.com/fumin/ctw/ac"
+)
+
+const (
+	// DefaultPrecision is the default precision f in bits we assume our probability models are operating in.
+	// In particular, the accumulator is assumed to be f+1 binary digits wide.
+	//
+	// We need to be aware that it cannot be too big, for a larger f implies a higher floating point precision requirement when calculating the exp-tables A and B.
+	// In particular, a useful check is that A[1] should give ((1 << f) - 1), not (1 << f).
+	DefaultPrecision uint = 12
+
+	// MinPrecision is the minimum precision of a Coder.
+	MinPrecision uint = 4
+
+	// MaxPrecision is the maximum precision of a Coder, at which the exp-tables take 32MB.
+	MaxPrecision uint = 20
+)
+
+// tables holds the exp-tables and step sizes of a precision f.
+type tables struct {
+	f    uint
+	A, B []uint64
+
+	// stepSizes holds the initial step size v_0 of each quantized probability in [ProbScale/2, ProbScale).
+	stepSizes []uint64
+}
+
+// tablesCache caches the tables of each precision, since computing them is much more expensive than coding short sequences.
+var tablesCache = struct {
+	sync.Mutex
+	m map[uint]*tables
+}{m: make(map[uint]*tables)}
+
+// getTables returns the tables of precision f.
+func getTables(f uint) *tables {
+	tablesCache.Lock()
+	defer tablesCache.Unlock()
+	if t, ok := tablesCache.m[f]; ok {
+		return t
+	}
+	t := &tables{f: f}
+	t.A, t.B = expTables(f)
+	t.stepSizes = stepSizeTable(f)
+	tablesCache.m[f] = t
+	return t
+}
+
+// stepSize returns the initial step size v_0 for the quantized probability prob0, as well as whether the bits should be relabeled such that the more probable bit is zero.
+func (t *tables) stepSize(prob0 uint32) (uint64, bool) {
+	p := prob0
+	relabel := false
+	if prob0 <= ac.ProbScale/2 {
+		p = ac.ProbScale - prob0
+		relabel = true
+	}
+	return t.stepSizes[p-ac.ProbScale/2], relabel
+}
+
+// stepSizeTable computes the initial step sizes of quantized probabilities for precision f.
+// The initial step size for a predicted probability is given in Equation (118), Section 4 Exponential Tables and Stepsizes, Chapter 6.
+func stepSizeTable(f uint) []uint64 {
+	table := make([]uint64, ac.ProbScale/2)
+	for i := range table {
+		p := float64(ac.ProbScale/2+i) / ac.ProbScale
+		v_0 := uint64(math.Exp2(float64(f))*math.Log2(1/p) + 0.5)
+		if v_0 < 3 {
+			v_0 = 3
+		}
+		table[i] = v_0
+	}
+	return table
+}
+
+// expTables prepares the exp-tables of precision f described in section 6.4 of the EIDMA report by F.M.J. Willems and Tj. J. Tjalkens.
+// Complexity Reduction of the Context-Tree Weighting Algorithm: A Study for KPN Research, Technical University of Eindhoven, EIDMA Report RS.97.01
+func expTables(f uint) ([]uint64, []uint64) {
+	var pow2f float64 = math.Exp2(float64(f))
+	A := make([]uint64, int(pow2f)+1)
+	for i := 1; i <= int(pow2f); i++ {
+		A[i] = uint64(pow2f*math.Exp2(-float64(i)/pow2f) + 0.5)
+	}
+
+	// B entries for (1<<(f-1)), (1<<f)-1
+	B := make([]uint64, int(pow2f))
+	for j := 1 << (f - 1); j <= (1<<f)-1; j++ {
+		B[j] = uint64(-pow2f*math.Log2(float64(j)/pow2f) + 0.5)
+	}
+
+	// B entries for 1,(1<<(f-1))-1
+	for j := 1; j < (1 << (f - 1)); j++ {
+		k := math.Ceil(float64(f) - 1 - math.Log2(float64(j)))
+		b2kj := B[int(math.Exp2(k))*j]
+		if b2kj == 0 {
+			panic("")
+		}
+		B[j] = b2kj + uint64(k*pow2f)
+	}
+
+	return A, B
+}
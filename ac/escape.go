@@ -0,0 +1,159 @@
+package ac
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// EscapeChunkSize is the number of bytes over which EncodeEscaped decides between coding and copying.
+	EscapeChunkSize = 4096
+
+	// escapeMargin is the number of bits by which the code length of a chunk must undercut its raw size for it to be coded.
+	// It accounts for the termination of the coder, which costs a few bytes per chunk.
+	escapeMargin = 64
+
+	// chunkHeaderSize is the size of the mode, the original size, and the encoded size of a chunk.
+	chunkHeaderSize = 1 + 4 + 4
+)
+
+// The modes of a chunk of EncodeEscaped.
+const (
+	chunkCoded byte = 0
+	chunkRaw   byte = 1
+)
+
+// A replayModel replays the quantized probabilities recorded from another model.
+type replayModel struct {
+	probs []uint32
+	i     int
+}
+
+func (m *replayModel) Prob0() float64 {
+	return float64(m.probs[m.i]) / ProbScale
+}
+
+func (m *replayModel) Prob0Int() uint32 {
+	return m.probs[m.i]
+}
+
+func (m *replayModel) Observe(bit int) {
+	m.i++
+}
+
+// EncodeEscaped is like coding the bytes read from r with coder and model, except that stretches which the model predicts poorly are copied raw instead.
+// The bytes are split into chunks of EscapeChunkSize bytes, and each chunk whose code length under model would exceed its raw size is copied as is.
+// Since the model observes every chunk regardless of how it is stored, it keeps learning from the copied chunks,
+// and the worst case expansion over the raw data is bounded by the small header of each chunk, which is about a quarter of a percent.
+//
+// The result, which is written to w, is a sequence of chunks, each of which is a mode byte, the original and encoded sizes as big endian uint32s, and the encoded or raw bytes.
+// It is self delimiting, so that DecodeEscaped needs not know the size of the original data.
+func EncodeEscaped(w io.Writer, r io.Reader, coder Coder, model Model) error {
+	chunk := make([]byte, EscapeChunkSize)
+	replay := &replayModel{probs: make([]uint32, 0, EscapeChunkSize*8)}
+	encoded := bytes.NewBuffer(nil)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		// Run the model over the chunk, recording its predictions for the coder.
+		replay.probs = replay.probs[:0]
+		replay.i = 0
+		var stats Stats
+		for _, bit := range Bits(chunk[:n]) {
+			prob0 := Prob0Int(model)
+			model.Observe(bit)
+			stats.Observe(prob0, bit)
+			replay.probs = append(replay.probs, prob0)
+		}
+
+		mode := chunkRaw
+		payload := chunk[:n]
+		if stats.CrossEntropy+escapeMargin < float64(n*8) {
+			encoded.Reset()
+			bw := NewBitWriter(encoded)
+			if _, err := coder.EncodeStats(context.Background(), bw, NewBitReader(bytes.NewReader(chunk[:n])), replay); err != nil {
+				return err
+			}
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			if encoded.Len() < n {
+				mode = chunkCoded
+				payload = encoded.Bytes()
+			}
+		}
+
+		header := make([]byte, chunkHeaderSize)
+		header[0] = mode
+		binary.BigEndian.PutUint32(header[1:], uint32(n))
+		binary.BigEndian.PutUint32(header[5:], uint32(len(payload)))
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+
+		if n < EscapeChunkSize {
+			return nil
+		}
+	}
+}
+
+// DecodeEscaped decodes the bytes read from r, which were encoded by EncodeEscaped, and writes the decoded bytes to w.
+// DecodeEscaped expects that coder and model are the same as those used in EncodeEscaped.
+func DecodeEscaped(w io.Writer, r io.Reader, coder Coder, model Model) error {
+	header := make([]byte, chunkHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n := binary.BigEndian.Uint32(header[1:])
+		size := binary.BigEndian.Uint32(header[5:])
+		payload := io.LimitReader(r, int64(size))
+
+		switch header[0] {
+		case chunkCoded:
+			bw := NewBitWriter(w)
+			if _, err := coder.DecodeStats(context.Background(), bw, NewBitReader(payload), model, int64(n)*8); err != nil {
+				return err
+			}
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			// Skip the bits of the coder that were not read.
+			if _, err := io.Copy(ioutil.Discard, payload); err != nil {
+				return err
+			}
+		case chunkRaw:
+			if size != n {
+				return fmt.Errorf("raw chunk of %d bytes with size %d", n, size)
+			}
+			raw := make([]byte, n)
+			if _, err := io.ReadFull(payload, raw); err != nil {
+				return err
+			}
+			for _, bit := range Bits(raw) {
+				model.Observe(bit)
+			}
+			if _, err := w.Write(raw); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown chunk mode %d", header[0])
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package ac_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/ac/witten"
+	"github.com/fumin/ctw/order0"
+)
+
+func TestEncodeEscaped(t *testing.T) {
+	gettys, err := ioutil.ReadFile("../gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	noise := make([]byte, 3*ac.EscapeChunkSize)
+	rand.New(rand.NewSource(0)).Read(noise)
+	var text []byte
+	for len(text) < 2*ac.EscapeChunkSize {
+		text = append(text, gettys...)
+	}
+
+	for _, data := range [][]byte{nil, gettys, noise, append(append(append([]byte{}, text...), noise...), text...)} {
+		buf := bytes.NewBuffer(nil)
+		if err := ac.EncodeEscaped(buf, bytes.NewReader(data), witten.Coder{}, order0.NewModel(0)); err != nil {
+			t.Fatalf("%v", err)
+		}
+		t.Logf("original bytes: %d, encoded bytes: %d", len(data), buf.Len())
+		// Incompressible data should expand by at most the chunk headers.
+		if buf.Len() > len(data)+(len(data)/ac.EscapeChunkSize+1)*9 {
+			t.Fatalf("%d %d", buf.Len(), len(data))
+		}
+
+		decoded := bytes.NewBuffer(nil)
+		if err := ac.DecodeEscaped(decoded, buf, witten.Coder{}, order0.NewModel(0)); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Fatalf("%d %d", decoded.Len(), len(data))
+		}
+	}
+}
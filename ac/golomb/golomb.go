@@ -0,0 +1,205 @@
+// Package golomb implements a Golomb-Rice coder, which codes the lengths of runs of the likely bit instead of performing arithmetic coding.
+// A run of likely bits followed by an unlikely one is coded by the Rice code whose parameter is chosen from the probability the model predicts at the start of the run.
+// For geometric sources, where the model predicts nearly constant probabilities, the Rice code is nearly optimal,
+// and coding a long run takes a handful of bit operations and a single prediction of the model, which is much faster than arithmetic coding every bit.
+// For other sources, the coding is still lossless, but the compression may be much worse than that of arithmetic coding.
+//
+// Reference:
+// R.F. Rice, Some Practical Universal Noiseless Coding Techniques, JPL Publication 79-22, 1979.
+package golomb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/fumin/ctw/ac"
+)
+
+const (
+	// ln2Scaled is ln(2) multiplied by ac.ProbScale.
+	// The optimal Golomb parameter for a geometric source whose unlikely bit has probability p is close to ln(2)/p.
+	ln2Scaled = 45426
+
+	// maxParameter is the largest Rice parameter.
+	maxParameter = 30
+)
+
+// parameter returns the likely bit and the Rice parameter for runs whose quantized probability of zero at their start is prob0.
+func parameter(prob0 uint32) (int, uint) {
+	likely, unlikely := 0, ac.ProbScale-prob0
+	if prob0 < ac.ProbScale/2 {
+		likely, unlikely = 1, prob0
+	}
+	m := ln2Scaled / unlikely
+	if m == 0 {
+		return likely, 0
+	}
+	k := uint(bits.Len32(m) - 1)
+	if k > maxParameter {
+		k = maxParameter
+	}
+	return likely, k
+}
+
+// A run is a sequence of likely bits, which ends at an unlikely bit or at the end of the data.
+type run struct {
+	active bool
+	likely int
+	k      uint
+	length uint64
+
+	// prob0 is the quantized probability of zero predicted at the start of the run.
+	prob0 uint32
+}
+
+// A Coder is the ac.Coder of this package.
+type Coder struct{}
+
+// EncodeStats is the package level EncodeStats.
+func (Coder) EncodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	return EncodeStats(ctx, dst, src, model)
+}
+
+// DecodeStats is the package level DecodeStats.
+func (Coder) DecodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	return DecodeStats(ctx, dst, src, model, originalSize)
+}
+
+// encodeRun writes the Rice code of the length of r, which is the length divided by 2^k in unary followed by its k least significant bits.
+func encodeRun(bw *ac.BatchWriter, r run) error {
+	for q := r.length >> r.k; q > 0; q-- {
+		if err := bw.Write(1); err != nil {
+			return err
+		}
+	}
+	if err := bw.Write(0); err != nil {
+		return err
+	}
+	for i := int(r.k) - 1; i >= 0; i-- {
+		if err := bw.Write(int(r.length>>uint(i)) & 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeStats codes the bits read from src with the Rice codes of the runs of likely bits, and writes the encoded bits to dst.
+// The model is asked for a prediction only at the start of each run, although it observes every bit.
+// Accordingly, the cross entropy in the returned statistics is computed with the prediction at the start of each run for all bits of the run.
+func EncodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	var stats ac.Stats
+	bw := ac.NewBatchWriter(dst)
+	var r run
+	bits := make([]int, ac.BatchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		n, err := src.ReadBits(bits)
+		for _, bit := range bits[:n] {
+			if !r.active {
+				prob0 := ac.Prob0Int(model)
+				likely, k := parameter(prob0)
+				r = run{active: true, likely: likely, k: k, prob0: prob0}
+			}
+			stats.Observe(r.prob0, bit)
+			model.Observe(bit)
+
+			if bit == r.likely {
+				r.length++
+				continue
+			}
+			if err := encodeRun(bw, r); err != nil {
+				return stats, err
+			}
+			r = run{}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+	if r.active {
+		if err := encodeRun(bw, r); err != nil {
+			return stats, err
+		}
+	}
+	stats.EncodedBits = bw.Count
+	return stats, bw.Flush()
+}
+
+// DecodeStats decodes the bits read from src, which were encoded by EncodeStats, and writes the decoded bits to dst.
+// Completion of the decoding is determined by originalSize, which is the number of bits of the original data before encoding.
+// DecodeStats expects that model is the exact same probabilistic model used in EncodeStats.
+func DecodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	var stats ac.Stats
+	if originalSize < 0 {
+		return stats, fmt.Errorf("negative original size %d", originalSize)
+	}
+	br := ac.NewBatchReader(src)
+	read := func() (int, error) {
+		bit, err := br.Read()
+		if err == io.EOF {
+			return 0, ac.ErrDecodeInsufficientBits
+		}
+		return bit, err
+	}
+
+	bw := ac.NewBatchWriter(dst)
+	for i := int64(0); i < originalSize; {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		// Decode the length of the next run.
+		prob0 := ac.Prob0Int(model)
+		likely, k := parameter(prob0)
+		var length uint64
+		for {
+			bit, err := read()
+			if err != nil {
+				return stats, err
+			}
+			if bit == 0 {
+				break
+			}
+			length += 1 << k
+		}
+		for j := int(k) - 1; j >= 0; j-- {
+			bit, err := read()
+			if err != nil {
+				return stats, err
+			}
+			length |= uint64(bit) << uint(j)
+		}
+		if length > uint64(originalSize-i) {
+			return stats, fmt.Errorf("run of %d bits exceeds the remaining %d bits", length, originalSize-i)
+		}
+
+		// Output the run, followed by the unlikely bit unless the data ends.
+		for ; length > 0; length-- {
+			if err := decodeBit(bw, model, &stats, prob0, likely); err != nil {
+				return stats, err
+			}
+			i++
+		}
+		if i < originalSize {
+			if err := decodeBit(bw, model, &stats, prob0, 1-likely); err != nil {
+				return stats, err
+			}
+			i++
+		}
+	}
+	stats.EncodedBits = br.Count
+	return stats, bw.Flush()
+}
+
+func decodeBit(bw *ac.BatchWriter, model ac.Model, stats *ac.Stats, prob0 uint32, bit int) error {
+	model.Observe(bit)
+	stats.Observe(prob0, bit)
+	return bw.Write(bit)
+}
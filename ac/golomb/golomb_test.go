@@ -0,0 +1,131 @@
+package golomb
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/ac/witten"
+)
+
+var _ ac.Coder = Coder{}
+
+type constModel struct {
+	p0 float64
+}
+
+func (m *constModel) Prob0() float64 { return m.p0 }
+
+func (m *constModel) Observe(bit int) {}
+
+// geometricBits returns n bits in which the bit 1-likely occurs with probability p.
+func geometricBits(n int, p float64, likely int) []int {
+	rng := rand.New(rand.NewSource(0))
+	x := make([]int, n)
+	for i := range x {
+		x[i] = likely
+		if rng.Float64() < p {
+			x[i] = 1 - likely
+		}
+	}
+	return x
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		x  []int
+		p0 float64
+	}{
+		{x: geometricBits(100000, 0.001, 0), p0: 0.999},
+		{x: geometricBits(100000, 0.01, 1), p0: 0.01},
+		{x: geometricBits(1000, 0.5, 0), p0: 0.5},
+		{x: []int{0, 0, 1}, p0: 0.9},
+		{x: []int{0, 0, 1, 0}, p0: 0.9},
+		{x: []int{}, p0: 0.9},
+	}
+	for i, test := range tests {
+		encoded := &sliceSink{}
+		stats, err := EncodeStats(context.Background(), encoded, &sliceSource{bits: test.x}, &constModel{p0: test.p0})
+		if err != nil {
+			t.Fatalf("%d %v", i, err)
+		}
+		if stats.EncodedBits != int64(len(encoded.bits)) {
+			t.Fatalf("%d %+v %d", i, stats, len(encoded.bits))
+		}
+		t.Logf("%d: %v", i, stats)
+		// Rice codes are within a few percent of the entropy of geometric sources.
+		if len(test.x) > 10000 && float64(stats.EncodedBits) > 1.05*stats.CrossEntropy {
+			t.Fatalf("%d %+v", i, stats)
+		}
+
+		decoded := &sliceSink{}
+		dstats, err := DecodeStats(context.Background(), decoded, &sliceSource{bits: encoded.bits}, &constModel{p0: test.p0}, int64(len(test.x)))
+		if err != nil {
+			t.Fatalf("%d %v", i, err)
+		}
+		if dstats != stats {
+			t.Fatalf("%d %+v %+v", i, dstats, stats)
+		}
+		if len(decoded.bits) != len(test.x) {
+			t.Fatalf("%d %d %d", i, len(decoded.bits), len(test.x))
+		}
+		for j, b := range test.x {
+			if decoded.bits[j] != b {
+				t.Fatalf("%d %d: %d != %d", i, j, b, decoded.bits[j])
+			}
+		}
+	}
+}
+
+func TestDecodeInsufficientBits(t *testing.T) {
+	t.Parallel()
+	_, err := DecodeStats(context.Background(), &sliceSink{}, &sliceSource{bits: []int{1, 1}}, &constModel{p0: 0.999}, 100)
+	if err != ac.ErrDecodeInsufficientBits {
+		t.Fatalf("%v", err)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	x := geometricBits(1000000, 0.0001, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeStats(context.Background(), &sliceSink{}, &sliceSource{bits: x}, &constModel{p0: 0.9999}); err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+}
+
+func BenchmarkEncodeWitten(b *testing.B) {
+	x := geometricBits(1000000, 0.0001, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := witten.EncodeStats(context.Background(), &sliceSink{}, &sliceSource{bits: x}, &constModel{p0: 0.9999}); err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+}
+
+type sliceSource struct {
+	bits []int
+}
+
+func (s *sliceSource) ReadBits(bits []int) (int, error) {
+	if len(s.bits) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(bits, s.bits)
+	s.bits = s.bits[n:]
+	return n, nil
+}
+
+type sliceSink struct {
+	bits []int
+}
+
+func (s *sliceSink) WriteBits(bits []int) error {
+	s.bits = append(s.bits, bits...)
+	return nil
+}
@@ -0,0 +1,460 @@
+// Package mq implements the MQ-coder, the multiplication free binary arithmetic coder of JPEG 2000 and JBIG2.
+// The MQ-coder approximates the width of the coding interval by a constant, so that the interval is split with only a table lookup and a subtraction.
+// This makes it much faster than the coders in witten and eidma, at the cost of a few percent of compression ratio.
+//
+// Unlike the MQ-coder of JPEG 2000, the probabilities are not estimated by its own finite state machine, but are supplied by an ac.Model.
+//
+// Reference:
+// ITU-T Recommendation T.800, Information technology - JPEG 2000 image coding system: Core coding system, Annex C, 2002.
+package mq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/fumin/ctw/ac"
+)
+
+const (
+	// qeScale is the value of qe for a less probable symbol of probability one.
+	// The interval register a is kept within [0x8000, 0x10000), so that a probability of one half corresponds to the largest qe of the JPEG 2000 probability table, 0x5601.
+	qeScale = 0xAC02
+
+	// maxPadding is the number of bytes beyond the end of the encoded data that the decoder may read.
+	maxPadding = 3
+)
+
+// qeTable maps the quantized probability of the less probable symbol to the value qe that it is coded with.
+var qeTable = newQeTable()
+
+func newQeTable() []uint32 {
+	table := make([]uint32, ac.ProbScale/2+1)
+	for p := range table {
+		qe := (uint64(p)*qeScale + ac.ProbScale/2) >> ac.ProbBits
+		if qe == 0 {
+			qe = 1
+		}
+		table[p] = uint32(qe)
+	}
+	return table
+}
+
+// symbols returns the more probable symbol and the qe of the less probable symbol, given that prob0 is the quantized probability of zero.
+func symbols(prob0 uint32) (int, uint32) {
+	if prob0 >= ac.ProbScale/2 {
+		return 0, qeTable[ac.ProbScale-prob0]
+	}
+	return 1, qeTable[prob0]
+}
+
+// An encoder carries the state required by an encoder.
+// The encoded bytes are sent to write, and the first error returned by write is kept in err, after which no more bytes are written.
+type encoder struct {
+	a  uint32
+	c  uint32
+	ct uint
+
+	// b is the last byte output, which is held back as it may still be incremented by a carry.
+	// It is not written if hasB is false, in which case it is the dummy byte preceding the encoded data.
+	b    byte
+	hasB bool
+
+	write func(bit int) error
+	err   error
+
+	// stats, if not nil, accumulates the statistics of the encoding.
+	stats *ac.Stats
+}
+
+func newEncoder(write func(bit int) error) *encoder {
+	e := &encoder{}
+	e.a = 0x8000
+	e.ct = 12
+	e.write = write
+	return e
+}
+
+// emit writes the held back byte, and holds back b instead.
+func (e *encoder) emit(b byte) {
+	if e.hasB && e.err == nil {
+		for i := uint(0); i < 8; i++ {
+			if e.err = e.write(int(e.b>>i) & 1); e.err != nil {
+				break
+			}
+		}
+		if e.stats != nil {
+			e.stats.EncodedBits += 8
+		}
+	}
+	e.b = b
+	e.hasB = true
+}
+
+// byteOut outputs a byte from the code register, inserting a stuffed bit after each 0xFF so that carries never propagate beyond the held back byte.
+func (e *encoder) byteOut() {
+	if e.b != 0xFF && e.c >= 0x8000000 {
+		e.b++
+		e.c &= 0x7FFFFFF
+	}
+	if e.b == 0xFF {
+		e.emit(byte(e.c >> 20))
+		e.c &= 0xFFFFF
+		e.ct = 7
+	} else {
+		e.emit(byte(e.c >> 19))
+		e.c &= 0x7FFFF
+		e.ct = 8
+	}
+}
+
+func (e *encoder) renormalize() {
+	for {
+		e.a <<= 1
+		e.c <<= 1
+		e.ct--
+		if e.ct == 0 {
+			e.byteOut()
+		}
+		if e.stats != nil {
+			e.stats.Renormalizations++
+		}
+		if e.a&0x8000 != 0 {
+			break
+		}
+	}
+}
+
+// encode encodes bit, whose quantized probability of being zero is prob0.
+func (e *encoder) encode(prob0 uint32, bit int) error {
+	mps, qe := symbols(prob0)
+	e.a -= qe
+	if bit == mps {
+		if e.a&0x8000 != 0 {
+			e.c += qe
+			return e.err
+		}
+		// Conditional exchange: the more probable symbol takes the larger of the two subintervals.
+		if e.a < qe {
+			e.a = qe
+		} else {
+			e.c += qe
+		}
+	} else {
+		if e.a < qe {
+			e.c += qe
+		} else {
+			e.a = qe
+		}
+	}
+	e.renormalize()
+	return e.err
+}
+
+// finish writes the bytes that terminate the encoding.
+func (e *encoder) finish() error {
+	// Set as many trailing bits of the code register to one as possible while staying within the interval, so that fewer bytes need to follow.
+	upper := e.c + e.a
+	e.c |= 0xFFFF
+	if e.c >= upper {
+		e.c -= 0x8000
+	}
+	e.c <<= e.ct
+	e.byteOut()
+	e.c <<= e.ct
+	e.byteOut()
+
+	// A trailing 0xFF is implied by the decoder.
+	if e.b != 0xFF {
+		e.emit(0)
+	}
+	return e.err
+}
+
+// A Coder is the ac.Coder of this package.
+type Coder struct{}
+
+// EncodeStats is the package level EncodeStats.
+func (Coder) EncodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	return EncodeStats(ctx, dst, src, model)
+}
+
+// DecodeStats is the package level DecodeStats.
+func (Coder) DecodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	return DecodeStats(ctx, dst, src, model, originalSize)
+}
+
+// EncodeBits performs arithmetic coding on the bits read from src given a binary probabilistic model, and writes the encoded bits to dst.
+// The encoded data consists of whole bytes, whose bits are written from the least significant one to the most significant one.
+func EncodeBits(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return EncodeContext(context.Background(), dst, src, model)
+}
+
+// EncodeContext is like EncodeBits, but stops early and returns ctx.Err() if ctx is cancelled.
+// The context is checked between batches of bits.
+func EncodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return encodeContext(ctx, dst, src, model, nil)
+}
+
+// EncodeStats is like EncodeContext, but also returns the statistics of the encoding.
+func EncodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	var stats ac.Stats
+	err := encodeContext(ctx, dst, src, model, &stats)
+	return stats, err
+}
+
+// encodeContext performs arithmetic coding on the bits read from src, accumulating the statistics of the encoding in stats if it is not nil.
+func encodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, stats *ac.Stats) error {
+	bw := ac.NewBatchWriter(dst)
+	e := newEncoder(bw.Write)
+	e.stats = stats
+	bits := make([]int, ac.BatchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := src.ReadBits(bits)
+		for _, bit := range bits[:n] {
+			prob0 := ac.Prob0Int(model)
+			model.Observe(bit)
+			if stats != nil {
+				stats.Observe(prob0, bit)
+			}
+			if err := e.encode(prob0, bit); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := e.finish(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// EncodeBytes performs arithmetic coding on src given a binary probabilistic model, and returns the encoded bytes.
+// The bits of each byte are coded from the least significant one to the most significant one.
+func EncodeBytes(src []byte, model ac.Model) []byte {
+	buf := bytes.NewBuffer(nil)
+	bw := ac.NewBitWriter(buf)
+	// Neither reading from nor writing to memory buffers fail.
+	EncodeBits(bw, ac.NewBitReader(bytes.NewReader(src)), model)
+	bw.Flush()
+	return buf.Bytes()
+}
+
+// A decoder carries the state required by a decoder.
+// The encoded bits are obtained from read, which returns io.EOF when there are no more bits.
+type decoder struct {
+	a  uint32
+	c  uint32
+	ct uint
+
+	// b is the last byte read.
+	b byte
+
+	read    func() (int, error)
+	padding int
+
+	// stats, if not nil, accumulates the statistics of the decoding.
+	stats *ac.Stats
+}
+
+func newDecoder(read func() (int, error), stats *ac.Stats) (*decoder, error) {
+	dc := &decoder{}
+	dc.read = read
+	dc.stats = stats
+	b, eof, err := dc.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if eof {
+		b = 0xFF
+	}
+	dc.b = b
+	dc.c = uint32(b) << 16
+	if err := dc.byteIn(); err != nil {
+		return nil, err
+	}
+	dc.c <<= 7
+	dc.ct -= 7
+	dc.a = 0x8000
+	return dc, nil
+}
+
+// readByte reads the next byte, reporting whether the end of the encoded data has been reached.
+func (dc *decoder) readByte() (byte, bool, error) {
+	var b byte
+	for i := uint(0); i < 8; i++ {
+		bit, err := dc.read()
+		if err == io.EOF && i > 0 {
+			return 0, false, ac.ErrDecodeInsufficientBits
+		}
+		if err == io.EOF {
+			dc.padding++
+			if dc.padding > maxPadding {
+				return 0, true, ac.ErrDecodeInsufficientBits
+			}
+			return 0, true, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		b |= byte(bit&1) << i
+	}
+	if dc.stats != nil {
+		dc.stats.EncodedBits += 8
+	}
+	return b, false, nil
+}
+
+// byteIn reads the next byte into the code register, undoing the bit stuffing of the encoder.
+// Beyond the end of the encoded data, the decoder behaves as if it were followed by 0xFF bytes.
+func (dc *decoder) byteIn() error {
+	b, eof, err := dc.readByte()
+	if err != nil {
+		return err
+	}
+	if dc.b == 0xFF {
+		if eof || b > 0x8F {
+			dc.c += 0xFF00
+			dc.ct = 8
+			return nil
+		}
+		dc.b = b
+		dc.c += uint32(b) << 9
+		dc.ct = 7
+		return nil
+	}
+	if eof {
+		b = 0xFF
+	}
+	dc.b = b
+	dc.c += uint32(b) << 8
+	dc.ct = 8
+	return nil
+}
+
+func (dc *decoder) renormalize() error {
+	for {
+		if dc.ct == 0 {
+			if err := dc.byteIn(); err != nil {
+				return err
+			}
+		}
+		dc.a <<= 1
+		dc.c <<= 1
+		dc.ct--
+		if dc.stats != nil {
+			dc.stats.Renormalizations++
+		}
+		if dc.a&0x8000 != 0 {
+			return nil
+		}
+	}
+}
+
+// decode decodes the next bit, whose quantized probability of being zero is prob0.
+func (dc *decoder) decode(prob0 uint32) (int, error) {
+	mps, qe := symbols(prob0)
+	dc.a -= qe
+	var bit int
+	if dc.c>>16 < qe {
+		if dc.a < qe {
+			bit = mps
+		} else {
+			bit = 1 - mps
+		}
+		dc.a = qe
+	} else {
+		dc.c -= qe << 16
+		if dc.a&0x8000 != 0 {
+			return mps, nil
+		}
+		if dc.a < qe {
+			bit = 1 - mps
+		} else {
+			bit = mps
+		}
+	}
+	if err := dc.renormalize(); err != nil {
+		return 0, err
+	}
+	return bit, nil
+}
+
+// DecodeBits decodes the bits read from src, which were encoded by EncodeBits, and writes the decoded bits to dst.
+// Completion of the decoding is determined by originalSize, which is the number of bits of the original data before encoding.
+// DecodeBits expects that model is the exact same probabilistic model used in EncodeBits.
+func DecodeBits(dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) error {
+	return DecodeContext(context.Background(), dst, src, model, originalSize)
+}
+
+// DecodeContext is like DecodeBits, but stops early and returns ctx.Err() if ctx is cancelled.
+// The context is checked between batches of bits.
+func DecodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) error {
+	return decodeContext(ctx, dst, src, model, originalSize, nil)
+}
+
+// DecodeStats is like DecodeContext, but also returns the statistics of the decoding.
+func DecodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	var stats ac.Stats
+	err := decodeContext(ctx, dst, src, model, originalSize, &stats)
+	return stats, err
+}
+
+// decodeContext decodes originalSize bits from src, accumulating the statistics of the decoding in stats if it is not nil.
+func decodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64, stats *ac.Stats) error {
+	if originalSize < 0 {
+		return fmt.Errorf("negative original size %d", originalSize)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dc, err := newDecoder(ac.NewBatchReader(src).Read, stats)
+	if err != nil {
+		return err
+	}
+
+	bw := ac.NewBatchWriter(dst)
+	for i := int64(0); i < originalSize; i++ {
+		if i%ac.BatchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		prob0 := ac.Prob0Int(model)
+		bit, err := dc.decode(prob0)
+		if err != nil {
+			return err
+		}
+		model.Observe(bit)
+		if stats != nil {
+			stats.Observe(prob0, bit)
+		}
+		if err := bw.Write(bit); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// DecodeBytes decodes src, which was encoded by EncodeBytes, into the n bytes of the original data.
+// DecodeBytes expects that model is the exact same probabilistic model used in EncodeBytes.
+func DecodeBytes(src []byte, model ac.Model, n int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, n))
+	bw := ac.NewBitWriter(buf)
+	if err := DecodeBits(bw, ac.NewBitReader(bytes.NewReader(src)), model, int64(n)*8); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
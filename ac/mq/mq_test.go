@@ -0,0 +1,157 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+)
+
+var _ ac.Coder = Coder{}
+
+type constModel struct {
+	p0 float64
+}
+
+func (m *constModel) Prob0() float64 { return m.p0 }
+
+func (m *constModel) Observe(bit int) {}
+
+// randomModel predicts random probabilities, which are reproducible given the same seed.
+type randomModel struct {
+	rng *rand.Rand
+	p0  float64
+}
+
+func newRandomModel(seed int64) *randomModel {
+	m := &randomModel{rng: rand.New(rand.NewSource(seed))}
+	m.Observe(0)
+	return m
+}
+
+func (m *randomModel) Prob0() float64 { return m.p0 }
+
+func (m *randomModel) Observe(bit int) {
+	m.p0 = m.rng.Float64()
+}
+
+func TestEncodeBytes(t *testing.T) {
+	t.Parallel()
+	contents, err := ioutil.ReadFile("../../gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, p0 := range []float64{0.5, 0.75, 0.25, 0.999, 0.000000025} {
+		encoded := EncodeBytes(contents, &constModel{p0: p0})
+		decoded, err := DecodeBytes(encoded, &constModel{p0: p0}, len(contents))
+		if err != nil {
+			t.Fatalf("%f %v", p0, err)
+		}
+		if !bytes.Equal(decoded, contents) {
+			t.Fatalf("%f %s", p0, decoded)
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	t.Parallel()
+	rng := rand.New(rand.NewSource(0))
+	for seed := int64(0); seed < 200; seed++ {
+		x := make([]byte, rng.Intn(64))
+		for i := range x {
+			x[i] = byte(rng.Intn(4))
+		}
+		encoded := EncodeBytes(x, newRandomModel(seed))
+		decoded, err := DecodeBytes(encoded, newRandomModel(seed), len(x))
+		if err != nil {
+			t.Fatalf("%d %v", seed, err)
+		}
+		if !bytes.Equal(decoded, x) {
+			t.Fatalf("%d %v %v", seed, decoded, x)
+		}
+	}
+}
+
+func TestEncodeStats(t *testing.T) {
+	t.Parallel()
+	contents, err := ioutil.ReadFile("../../gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	x := ac.Bits(contents)
+
+	encoded := &sliceSink{}
+	stats, err := EncodeStats(context.Background(), encoded, &sliceSource{bits: x}, &constModel{p0: 0.75})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if stats.Bits != int64(len(x)) || stats.EncodedBits != int64(len(encoded.bits)) {
+		t.Fatalf("%+v %d %d", stats, len(x), len(encoded.bits))
+	}
+	// The MQ-coder should be within a few percent of the ideal code length.
+	if float64(stats.EncodedBits) > stats.CrossEntropy*1.05 {
+		t.Fatalf("%+v", stats)
+	}
+
+	decoded := &sliceSink{}
+	dstats, err := DecodeStats(context.Background(), decoded, &sliceSource{bits: encoded.bits}, &constModel{p0: 0.75}, int64(len(x)))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if dstats.Bits != stats.Bits || dstats.CrossEntropy != stats.CrossEntropy {
+		t.Fatalf("%+v %+v", dstats, stats)
+	}
+	for i, b := range x {
+		if decoded.bits[i] != b {
+			t.Fatalf("%d %d %d", i, decoded.bits[i], b)
+		}
+	}
+}
+
+func TestDecodeInsufficientBits(t *testing.T) {
+	t.Parallel()
+	err := DecodeBits(&sliceSink{}, &sliceSource{}, &constModel{p0: 0.5}, 1024)
+	if err != ac.ErrDecodeInsufficientBits {
+		t.Fatalf("%v", err)
+	}
+}
+
+func BenchmarkEncodeBits(b *testing.B) {
+	contents, err := ioutil.ReadFile("../../gettysburg.txt")
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	x := ac.Bits(contents)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := EncodeBits(&sliceSink{}, &sliceSource{bits: x}, &constModel{p0: 0.25}); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+}
+
+type sliceSource struct {
+	bits []int
+}
+
+func (s *sliceSource) ReadBits(bits []int) (int, error) {
+	if len(s.bits) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(bits, s.bits)
+	s.bits = s.bits[n:]
+	return n, nil
+}
+
+type sliceSink struct {
+	bits []int
+}
+
+func (s *sliceSink) WriteBits(bits []int) error {
+	s.bits = append(s.bits, bits...)
+	return nil
+}
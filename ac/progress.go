@@ -0,0 +1,94 @@
+package ac
+
+import (
+	"context"
+)
+
+// A ProgressFunc receives the progress of an encoding or a decoding.
+// The bits argument is the number of bits of the original data processed so far, and encodedBits is the number of bits of the encoded data.
+type ProgressFunc func(bits, encodedBits int64)
+
+// WithProgress returns a Coder which codes with coder, and calls progress whenever at least period more bits of the original data have been processed, as well as once at the end.
+// Since bits are transferred in batches, progress is called between batches, so periods shorter than a batch are effectively rounded up.
+// The encoded bits counted are those transferred so far, and may lag behind the bits processed by the amount buffered within coder.
+func WithProgress(coder Coder, period int64, progress ProgressFunc) Coder {
+	return &progressCoder{coder: coder, period: period, progress: progress}
+}
+
+type progressCoder struct {
+	coder    Coder
+	period   int64
+	progress ProgressFunc
+}
+
+// Unwrap returns the coder that c codes with.
+func (c *progressCoder) Unwrap() Coder {
+	return c.coder
+}
+
+// A progressTracker counts the bits transferred through a BitSource and a BitSink, and reports them every period bits of the original data.
+type progressTracker struct {
+	period   int64
+	progress ProgressFunc
+
+	bits        int64
+	encodedBits int64
+	reported    int64
+}
+
+func (t *progressTracker) addBits(n int) {
+	t.bits += int64(n)
+	if t.bits-t.reported >= t.period {
+		t.reported = t.bits
+		t.progress(t.bits, t.encodedBits)
+	}
+}
+
+type progressSource struct {
+	src     BitSource
+	tracker *progressTracker
+	encoded bool
+}
+
+func (s *progressSource) ReadBits(bits []int) (int, error) {
+	n, err := s.src.ReadBits(bits)
+	if s.encoded {
+		s.tracker.encodedBits += int64(n)
+	} else {
+		s.tracker.addBits(n)
+	}
+	return n, err
+}
+
+type progressSink struct {
+	dst     BitSink
+	tracker *progressTracker
+	encoded bool
+}
+
+func (s *progressSink) WriteBits(bits []int) error {
+	if s.encoded {
+		s.tracker.encodedBits += int64(len(bits))
+	} else {
+		s.tracker.addBits(len(bits))
+	}
+	return s.dst.WriteBits(bits)
+}
+
+func (c *progressCoder) EncodeStats(ctx context.Context, dst BitSink, src BitSource, model Model) (Stats, error) {
+	t := &progressTracker{period: c.period, progress: c.progress}
+	stats, err := c.coder.EncodeStats(ctx, &progressSink{dst: dst, tracker: t, encoded: true}, &progressSource{src: src, tracker: t}, model)
+	if err == nil {
+		c.progress(t.bits, t.encodedBits)
+	}
+	return stats, err
+}
+
+func (c *progressCoder) DecodeStats(ctx context.Context, dst BitSink, src BitSource, model Model, originalSize int64) (Stats, error) {
+	t := &progressTracker{period: c.period, progress: c.progress}
+	stats, err := c.coder.DecodeStats(ctx, &progressSink{dst: dst, tracker: t}, &progressSource{src: src, tracker: t, encoded: true}, model, originalSize)
+	if err == nil {
+		c.progress(t.bits, t.encodedBits)
+	}
+	return stats, err
+}
@@ -0,0 +1,69 @@
+package ac_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/ac/witten"
+	"github.com/fumin/ctw/order0"
+)
+
+func TestWithProgress(t *testing.T) {
+	gettys, err := ioutil.ReadFile("../gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var text []byte
+	for i := 0; i < 10; i++ {
+		text = append(text, gettys...)
+	}
+	const period = 10000
+
+	type report struct{ bits, encodedBits int64 }
+	var reports []report
+	coder := ac.WithProgress(witten.Coder{}, period, func(bits, encodedBits int64) {
+		reports = append(reports, report{bits: bits, encodedBits: encodedBits})
+	})
+	buf := bytes.NewBuffer(nil)
+	bw := ac.NewBitWriter(buf)
+	stats, err := coder.EncodeStats(context.Background(), bw, ac.NewBitReader(bytes.NewReader(text)), order0.NewModel(0))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Progress is reported between batches, so the period is rounded up to a multiple of the batch size.
+	if len(reports) < len(text)*8/(2*period) {
+		t.Fatalf("%d %v", len(reports), reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].bits < reports[i-1].bits || reports[i].encodedBits < reports[i-1].encodedBits {
+			t.Fatalf("%d %v", i, reports)
+		}
+	}
+	last := reports[len(reports)-1]
+	if last.bits != stats.Bits || last.encodedBits != stats.EncodedBits {
+		t.Fatalf("%+v %+v", last, stats)
+	}
+
+	reports = nil
+	decoded := bytes.NewBuffer(nil)
+	dbw := ac.NewBitWriter(decoded)
+	if _, err := coder.DecodeStats(context.Background(), dbw, ac.NewBitReader(buf), order0.NewModel(0), int64(len(text)*8)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := dbw.Flush(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), text) {
+		t.Fatalf("%s", decoded.Bytes())
+	}
+	if last := reports[len(reports)-1]; last.bits != int64(len(text)*8) {
+		t.Fatalf("%+v", last)
+	}
+}
@@ -0,0 +1,52 @@
+package ac
+
+import (
+	"fmt"
+	"math"
+)
+
+// Stats are the statistics of an encoding or a decoding.
+type Stats struct {
+	// Bits is the number of bits of the original data.
+	Bits int64
+
+	// EncodedBits is the number of bits of the encoded data.
+	EncodedBits int64
+
+	// CrossEntropy is the sum of -log2 of the probabilities that the model assigned to the bits of the original data.
+	// It is the length in bits that an ideal arithmetic coder would give, so the difference between EncodedBits and CrossEntropy is the overhead of the coder.
+	CrossEntropy float64
+
+	// Renormalizations is the number of times the coder rescaled its interval.
+	Renormalizations int64
+}
+
+// codeLengths holds the code length in bits of each quantized probability, since computing logarithms would otherwise dominate the cost of fast coders.
+var codeLengths = newCodeLengths()
+
+func newCodeLengths() []float64 {
+	lengths := make([]float64, ProbScale)
+	for p := 1; p < ProbScale; p++ {
+		lengths[p] = ProbBits - math.Log2(float64(p))
+	}
+	return lengths
+}
+
+// Observe records that bit, whose quantized probability of being zero is prob0, is coded.
+func (s *Stats) Observe(prob0 uint32, bit int) {
+	s.Bits++
+	p := prob0
+	if bit == 1 {
+		p = ProbScale - prob0
+	}
+	s.CrossEntropy += codeLengths[p]
+}
+
+// String returns a human readable summary of the statistics.
+func (s Stats) String() string {
+	var rate float64
+	if s.Bits > 0 {
+		rate = float64(s.EncodedBits) / float64(s.Bits)
+	}
+	return fmt.Sprintf("bits %d, encoded bits %d (%.4f bits per bit), cross entropy %.1f bits, renormalizations %d", s.Bits, s.EncodedBits, rate, s.CrossEntropy, s.Renormalizations)
+}
@@ -0,0 +1,95 @@
+package witten
+
+import (
+	"context"
+	"io"
+
+	"github.com/fumin/ctw/ac"
+)
+
+// A symbol is a bit together with its quantized probability of being zero.
+type symbol struct {
+	prob0 uint32
+	bit   int
+}
+
+// EncodePipelined is like EncodeStats, but overlaps the updates of model with the interval arithmetic of the encoder.
+// While the encoder codes a batch of bits in a separate goroutine, the model is updated with the next batch, which is double-buffered.
+// This is worthwhile when updating the model is expensive, as is the case for deep Context Tree Weighting models, and when more than one CPU is available.
+// The encoded bits are the same as those of EncodeStats.
+func EncodePipelined(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	// The model and the encoder keep separate statistics, which are combined after both are done.
+	var stats, coderStats ac.Stats
+	bw := ac.NewBatchWriter(dst)
+	e := newEncoder(bw.Write)
+	e.stats = &coderStats
+
+	// Two buffers circulate between the model and the encoder.
+	free := make(chan []symbol, 2)
+	for i := 0; i < cap(free); i++ {
+		free <- make([]symbol, 0, ac.BatchSize)
+	}
+	batches := make(chan []symbol, 1)
+	failed := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for batch := range batches {
+			for _, s := range batch {
+				if err != nil {
+					break
+				}
+				if err = e.encode(s.prob0, s.bit); err != nil {
+					close(failed)
+				}
+			}
+			free <- batch[:0]
+		}
+		done <- err
+	}()
+
+	err := func() error {
+		bits := make([]int, ac.BatchSize)
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			select {
+			case <-failed:
+				return nil
+			default:
+			}
+
+			n, err := src.ReadBits(bits)
+			batch := <-free
+			for _, bit := range bits[:n] {
+				prob0 := ac.Prob0Int(model)
+				model.Observe(bit)
+				stats.Observe(prob0, bit)
+				batch = append(batch, symbol{prob0: prob0, bit: bit})
+			}
+			batches <- batch
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}()
+	close(batches)
+	coderErr := <-done
+	if err != nil {
+		return stats, err
+	}
+	if coderErr != nil {
+		return stats, coderErr
+	}
+
+	if err := e.finish(); err != nil {
+		return stats, err
+	}
+	stats.EncodedBits = coderStats.EncodedBits
+	stats.Renormalizations = coderStats.Renormalizations
+	return stats, bw.Flush()
+}
@@ -0,0 +1,247 @@
+package witten
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/fumin/ctw/ac"
+)
+
+const (
+	// runThreshold is the quantized probability of the unlikely bit at or below which bits are coded in runs.
+	runThreshold = ac.ProbScale >> 10
+
+	// maxRunCount is the count at which the counters of the run lengths are halved, so that they adapt to changing statistics.
+	maxRunCount = 255
+)
+
+// likely returns the likely bit if the quantized probability of zero prob0 is extreme enough for bits to be coded in runs.
+func likely(prob0 uint32) (int, bool) {
+	if prob0 >= ac.ProbScale-runThreshold {
+		return 0, true
+	}
+	if prob0 <= runThreshold {
+		return 1, true
+	}
+	return 0, false
+}
+
+// A run is a sequence of likely bits.
+// It ends at an unlikely bit, at a bit which the model no longer predicts to be extremely likely, or at the end of the data.
+// Only the number of likely bits is coded, since the decoder can tell the end of a run by itself in the latter two cases,
+// and knows that the bit following the likely bits is the unlikely one in the first case.
+type run struct {
+	active bool
+	bit    int
+	length uint64
+}
+
+// A runCoder codes the lengths of runs with an adaptive Elias gamma code.
+// The number of binary digits of a length is coded in unary with adaptive probabilities, followed by the digits themselves, each with probability one half.
+type runCoder struct {
+	counts [65][2]uint32
+}
+
+// prob0 returns the quantized probability that the unary code of the number of digits ends at the k-th digit.
+func (rc *runCoder) prob0(k int) uint32 {
+	c := rc.counts[k]
+	prob0 := (uint64(2*c[0]+1) * ac.ProbScale) / uint64(2*(c[0]+c[1])+2)
+	if prob0 < 1 {
+		return 1
+	}
+	if prob0 > ac.ProbScale-1 {
+		return ac.ProbScale - 1
+	}
+	return uint32(prob0)
+}
+
+func (rc *runCoder) observe(k, bit int) {
+	c := &rc.counts[k]
+	c[bit]++
+	if c[0]+c[1] > maxRunCount {
+		c[0] /= 2
+		c[1] /= 2
+	}
+}
+
+func (rc *runCoder) encode(e *encoder, length uint64) error {
+	v := length + 1
+	digits := 0
+	for x := v; x > 0; x >>= 1 {
+		digits++
+	}
+	for k := 1; k <= digits; k++ {
+		bit := 1
+		if k == digits {
+			bit = 0
+		}
+		if err := e.encode(rc.prob0(k), bit); err != nil {
+			return err
+		}
+		rc.observe(k, bit)
+	}
+	for i := digits - 2; i >= 0; i-- {
+		if err := e.encode(ac.ProbScale/2, int(v>>uint(i))&1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rc *runCoder) decode(dc *decoder) (uint64, error) {
+	digits := 0
+	for {
+		digits++
+		if digits >= len(rc.counts) {
+			return 0, fmt.Errorf("run length of more than %d digits", len(rc.counts)-1)
+		}
+		bit, err := dc.decode(rc.prob0(digits))
+		if err != nil {
+			return 0, err
+		}
+		rc.observe(digits, bit)
+		if bit == 0 {
+			break
+		}
+	}
+	v := uint64(1)
+	for i := digits - 2; i >= 0; i-- {
+		bit, err := dc.decode(ac.ProbScale / 2)
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | uint64(bit)
+	}
+	return v - 1, nil
+}
+
+// EncodeRuns is like EncodeStats, but codes bits that the model predicts to be extremely likely in runs.
+// Instead of narrowing the interval for every bit, only the number of consecutive likely bits is coded, which makes coding sparse data such as bitmaps much faster.
+// Since the unlikely bit ending a run is implied, runs usually also take fewer bits than coding their bits one by one.
+// The encoded bits can only be decoded by DecodeRuns.
+func EncodeRuns(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	var stats ac.Stats
+	bw := ac.NewBatchWriter(dst)
+	e := newEncoder(bw.Write)
+	e.stats = &stats
+	var r run
+	var rc runCoder
+	bits := make([]int, ac.BatchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		n, err := src.ReadBits(bits)
+		for _, bit := range bits[:n] {
+			prob0 := ac.Prob0Int(model)
+			model.Observe(bit)
+			stats.Observe(prob0, bit)
+
+			lb, ok := likely(prob0)
+			if r.active && !(ok && lb == r.bit) {
+				if err := rc.encode(e, r.length); err != nil {
+					return stats, err
+				}
+				r = run{}
+			}
+			if !r.active && ok {
+				r = run{active: true, bit: lb}
+			}
+			if !r.active {
+				if err := e.encode(prob0, bit); err != nil {
+					return stats, err
+				}
+				continue
+			}
+			if bit == r.bit {
+				r.length++
+				continue
+			}
+			if err := rc.encode(e, r.length); err != nil {
+				return stats, err
+			}
+			r = run{}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+	if r.active {
+		if err := rc.encode(e, r.length); err != nil {
+			return stats, err
+		}
+	}
+	if err := e.finish(); err != nil {
+		return stats, err
+	}
+	return stats, bw.Flush()
+}
+
+// DecodeRuns decodes the bits read from src, which were encoded by EncodeRuns, and writes the decoded bits to dst.
+// Completion of the decoding is determined by originalSize, which is the number of bits of the original data before encoding.
+// DecodeRuns expects that model is the exact same probabilistic model used in EncodeRuns.
+func DecodeRuns(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	var stats ac.Stats
+	if originalSize < 0 {
+		return stats, fmt.Errorf("negative original size %d", originalSize)
+	}
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	dc, err := newDecoder(ac.NewBatchReader(src).Read, &stats, DecoderOptions{})
+	if err != nil {
+		return stats, err
+	}
+
+	bw := ac.NewBatchWriter(dst)
+	var r run
+	var rc runCoder
+	for i := int64(0); i < originalSize; i++ {
+		if i%ac.BatchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+		}
+		prob0 := ac.Prob0Int(model)
+		lb, ok := likely(prob0)
+		if r.active && !(ok && lb == r.bit) {
+			// The run ended because the model changed its prediction, so all of its likely bits must have been decoded.
+			if r.length != 0 {
+				return stats, fmt.Errorf("run with %d remaining bits ended at bit %d", r.length, i)
+			}
+			r = run{}
+		}
+		if !r.active && ok {
+			length, err := rc.decode(dc)
+			if err != nil {
+				return stats, err
+			}
+			r = run{active: true, bit: lb, length: length}
+		}
+
+		var bit int
+		switch {
+		case !r.active:
+			bit, err = dc.decode(prob0)
+			if err != nil {
+				return stats, err
+			}
+		case r.length > 0:
+			bit = r.bit
+			r.length--
+		default:
+			bit = 1 - r.bit
+			r = run{}
+		}
+		model.Observe(bit)
+		stats.Observe(prob0, bit)
+		if err := bw.Write(bit); err != nil {
+			return stats, err
+		}
+	}
+	return stats, bw.Flush()
+}
@@ -0,0 +1,109 @@
+package witten
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+)
+
+// sparseBits returns n bits which are mostly zeros, with a one occurring with probability p.
+func sparseBits(n int, p float64) []int {
+	rng := rand.New(rand.NewSource(0))
+	x := make([]int, n)
+	for i := range x {
+		if rng.Float64() < p {
+			x[i] = 1
+		}
+	}
+	return x
+}
+
+// switchingModel alternates between predicting extremely likely zeros, extremely likely ones, and uncertain bits.
+type switchingModel struct {
+	i int
+}
+
+func (m *switchingModel) Prob0() float64 {
+	switch (m.i / 100) % 3 {
+	case 0:
+		return 0.9999
+	case 1:
+		return 0.0001
+	default:
+		return 0.6
+	}
+}
+
+func (m *switchingModel) Observe(b int) {
+	m.i++
+}
+
+func TestEncodeRuns(t *testing.T) {
+	tests := []struct {
+		x     []int
+		model func() ac.Model
+	}{
+		{x: gettysburgBits(t), model: func() ac.Model { return &ConstModel{P0: 0.000000025} }},
+		{x: gettysburgBits(t), model: func() ac.Model { return &ConstModel{P0: 0.5} }},
+		{x: gettysburgBits(t), model: func() ac.Model { return &switchingModel{} }},
+		{x: sparseBits(100000, 0.0001), model: func() ac.Model { return &ConstModel{P0: 0.9999} }},
+		{x: []int{}, model: func() ac.Model { return &ConstModel{P0: 0.9999} }},
+	}
+	for i, test := range tests {
+		encoded := &sliceSink{}
+		stats, err := EncodeRuns(context.Background(), encoded, &sliceSource{bits: test.x}, test.model())
+		if err != nil {
+			t.Fatalf("%d %+v", i, err)
+		}
+		plain := &sliceSink{}
+		if err := EncodeBits(plain, &sliceSource{bits: test.x}, test.model()); err != nil {
+			t.Fatalf("%d %+v", i, err)
+		}
+		t.Logf("%d: run bits: %d, plain bits: %d, cross entropy: %f", i, len(encoded.bits), len(plain.bits), stats.CrossEntropy)
+
+		decoded := &sliceSink{}
+		if _, err := DecodeRuns(context.Background(), decoded, &sliceSource{bits: encoded.bits}, test.model(), int64(len(test.x))); err != nil {
+			t.Fatalf("%d %+v", i, err)
+		}
+		if len(decoded.bits) != len(test.x) {
+			t.Fatalf("%d %d %d", i, len(decoded.bits), len(test.x))
+		}
+		for j, b := range test.x {
+			if decoded.bits[j] != b {
+				t.Fatalf("%d %d: %d != %d", i, j, b, decoded.bits[j])
+			}
+		}
+	}
+}
+
+func BenchmarkEncodeSparse(b *testing.B) {
+	x := sparseBits(1000000, 0.0001)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := EncodeBits(&sliceSink{}, &sliceSource{bits: x}, &ConstModel{P0: 0.9999}); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+}
+
+func BenchmarkEncodeRunsSparse(b *testing.B) {
+	x := sparseBits(1000000, 0.0001)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeRuns(context.Background(), &sliceSink{}, &sliceSource{bits: x}, &ConstModel{P0: 0.9999}); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+}
+
+func BenchmarkEncodeStatsSparse(b *testing.B) {
+	x := sparseBits(1000000, 0.0001)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeStats(context.Background(), &sliceSink{}, &sliceSource{bits: x}, &ConstModel{P0: 0.9999}); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+}
@@ -3,6 +3,11 @@
 package witten
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
 	"github.com/fumin/ctw/ac"
 )
 
@@ -12,34 +17,129 @@ const (
 	firstQtr      = topValue/4 + 1
 	half          = 2 * firstQtr
 	thirdQtr      = 3 * firstQtr
-
-	topValueDbl = float64(topValue)
 )
 
-// An arithmeticEncoder carries the state required by an encoder.
-type arithmeticEncoder struct {
+// An encoder carries the state required by an encoder.
+// The encoded bits are sent to write, and the first error returned by write is kept in err, after which no more bits are written.
+type encoder struct {
 	low   uint64
 	high  uint64
 	fbits uint64
+
+	write func(bit int) error
+	err   error
+
+	// stats, if not nil, accumulates the statistics of the encoding.
+	stats *ac.Stats
 }
 
-func newAE() *arithmeticEncoder {
-	ae := &arithmeticEncoder{}
-	ae.high = topValue
-	return ae
+func newEncoder(write func(bit int) error) *encoder {
+	e := &encoder{}
+	e.high = topValue
+	e.write = write
+	return e
 }
 
-func bitPlusFollow(dst chan<- int, ae *arithmeticEncoder, bit int) {
+func (e *encoder) output(bit int) {
+	if e.err != nil {
+		return
+	}
+	e.err = e.write(bit)
+	if e.stats != nil {
+		e.stats.EncodedBits++
+	}
+}
+
+func (e *encoder) bitPlusFollow(bit int) {
 	negbit := 0
 	if bit == 0 {
 		negbit = 1
 	}
 
-	dst <- bit
-	for ae.fbits > 0 {
-		dst <- negbit
-		ae.fbits -= 1
+	e.output(bit)
+	for e.fbits > 0 {
+		e.output(negbit)
+		e.fbits -= 1
+	}
+}
+
+// encode encodes bit, whose quantized probability of being zero is prob0.
+func (e *encoder) encode(prob0 uint32, bit int) error {
+	arange := (e.high - e.low) + 1
+	split := e.low + (arange*uint64(prob0))>>ac.ProbBits
+
+	// narrow range
+	if bit == 1 {
+		e.low = split
+	} else {
+		e.high = split - 1
+	}
+
+	for {
+		if e.high < half {
+			e.bitPlusFollow(0)
+		} else if e.low >= half {
+			e.bitPlusFollow(1)
+			e.low -= half
+			e.high -= half
+		} else if e.low >= firstQtr && e.high < thirdQtr {
+			e.fbits += 1
+			e.low -= firstQtr
+			e.high -= firstQtr
+		} else {
+			break
+		}
+
+		e.low = 2 * e.low
+		e.high = 2*e.high + 1
+		if e.stats != nil {
+			e.stats.Renormalizations++
+		}
 	}
+	return e.err
+}
+
+// finish writes the bits that terminate the encoding.
+func (e *encoder) finish() error {
+	e.fbits += 1
+	if e.low < firstQtr {
+		e.bitPlusFollow(0)
+	} else {
+		e.bitPlusFollow(1)
+	}
+	return e.err
+}
+
+// A Coder is the ac.Coder of this package.
+type Coder struct {
+	// Pipelined makes EncodeStats overlap the updates of the model with the encoding, as in EncodePipelined.
+	Pipelined bool
+
+	// Runs makes EncodeStats and DecodeStats code extremely likely bits in runs, as in EncodeRuns and DecodeRuns.
+	// It takes precedence over Pipelined and DecoderOptions.
+	Runs bool
+
+	// DecoderOptions configure the decoding of DecodeStats.
+	DecoderOptions DecoderOptions
+}
+
+// EncodeStats is the package level EncodeStats, or EncodeRuns or EncodePipelined as configured by the Coder.
+func (c Coder) EncodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	if c.Runs {
+		return EncodeRuns(ctx, dst, src, model)
+	}
+	if c.Pipelined {
+		return EncodePipelined(ctx, dst, src, model)
+	}
+	return EncodeStats(ctx, dst, src, model)
+}
+
+// DecodeStats is the package level DecodeStats with the options of the Coder, or DecodeRuns if the Coder codes runs.
+func (c Coder) DecodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	if c.Runs {
+		return DecodeRuns(ctx, dst, src, model, originalSize)
+	}
+	return DecodeOptions(ctx, dst, src, model, originalSize, c.DecoderOptions)
 }
 
 // Encode performs arithmetic coding on a stream of bits given a binary probabilistic model.
@@ -48,132 +148,456 @@ func bitPlusFollow(dst chan<- int, ae *arithmeticEncoder, bit int) {
 // Encode closes dst when the encoding is complete and there are no more bits to be sent to it.
 func Encode(dst chan<- int, src <-chan int, model ac.Model) {
 	defer close(dst)
-	ae := newAE()
+	e := newEncoder(func(bit int) error {
+		dst <- bit
+		return nil
+	})
 	for bit := range src {
-		prob0 := model.Prob0()
+		prob0 := ac.Prob0Int(model)
 		model.Observe(bit)
+		e.encode(prob0, bit)
+	}
+	e.finish()
+}
+
+// EncodeBits performs arithmetic coding on the bits read from src given a binary probabilistic model, and writes the encoded bits to dst.
+// Bits are transferred in batches, which is much faster than the channels of Encode.
+func EncodeBits(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return EncodeContext(context.Background(), dst, src, model)
+}
 
-		arange := (ae.high - ae.low) + 1
-		split := ae.low + arange*uint64(prob0*topValueDbl)/topValue
+// EncodeContext is like EncodeBits, but stops early and returns ctx.Err() if ctx is cancelled.
+// The context is checked between batches of bits.
+func EncodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return encodeContext(ctx, dst, src, model, options{})
+}
 
-		// narrow range
-		if bit == 1 {
-			ae.low = split
-		} else {
-			ae.high = split - 1
-		}
-
-		for {
-			if ae.high < half {
-				bitPlusFollow(dst, ae, 0)
-			} else if ae.low >= half {
-				bitPlusFollow(dst, ae, 1)
-				ae.low -= half
-				ae.high -= half
-			} else if ae.low >= firstQtr && ae.high < thirdQtr {
-				ae.fbits += 1
-				ae.low -= firstQtr
-				ae.high -= firstQtr
-			} else {
-				break
+// EncodeStats is like EncodeContext, but also returns the statistics of the encoding.
+func EncodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model) (ac.Stats, error) {
+	var stats ac.Stats
+	err := encodeContext(ctx, dst, src, model, options{stats: &stats})
+	return stats, err
+}
+
+// EncodeTerminated is like EncodeBits, but encodes an end of stream marker after the last bit, so that DecodeTerminated needs not know the length of the original data.
+// This allows coding streams whose length is unknown upfront, such as those from pipes.
+func EncodeTerminated(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return encodeContext(context.Background(), dst, src, model, options{terminated: true})
+}
+
+// EncodeChecksum is like EncodeBits, but embeds a checksum of the original data after every period bits.
+// DecodeChecksum verifies the checksums, and returns an *ac.ChecksumError if the decoded data does not match them.
+// Each checksum takes ac.ChecksumBits bits, so a period of a few thousand bits or more adds a negligible overhead.
+func EncodeChecksum(dst ac.BitSink, src ac.BitSource, model ac.Model, period int64) error {
+	if period <= 0 {
+		return fmt.Errorf("non-positive checksum period %d", period)
+	}
+	return encodeContext(context.Background(), dst, src, model, options{checksumPeriod: period})
+}
+
+// options are the options of the encoding and decoding of a stream.
+type options struct {
+	// terminated is whether each bit is preceded by an end of stream flag.
+	terminated bool
+
+	// stats, if not nil, accumulates the statistics of the coding.
+	stats *ac.Stats
+
+	// checksumPeriod, if positive, is the number of bits after which a checksum is embedded.
+	checksumPeriod int64
+
+	// decoder configures the handling of the end of the encoded bits when decoding.
+	decoder DecoderOptions
+}
+
+// encodeContext performs arithmetic coding on the bits read from src with the given options.
+func encodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, opts options) error {
+	bw := ac.NewBatchWriter(dst)
+	e := newEncoder(bw.Write)
+	e.stats = opts.stats
+	var checksum ac.Checksum
+	var count int64
+	bits := make([]int, ac.BatchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := src.ReadBits(bits)
+		for _, bit := range bits[:n] {
+			if opts.terminated {
+				if err := e.encode(ac.TerminationProb0, 0); err != nil {
+					return err
+				}
+			}
+			prob0 := ac.Prob0Int(model)
+			model.Observe(bit)
+			if opts.stats != nil {
+				opts.stats.Observe(prob0, bit)
+			}
+			if err := e.encode(prob0, bit); err != nil {
+				return err
 			}
 
-			ae.low = 2 * ae.low
-			ae.high = 2*ae.high + 1
+			count++
+			if opts.checksumPeriod > 0 {
+				checksum.Update(bit)
+				if count%opts.checksumPeriod == 0 {
+					if err := encodeChecksum(e, checksum); err != nil {
+						return err
+					}
+				}
+			}
 		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if opts.terminated {
+		if err := e.encode(ac.TerminationProb0, 1); err != nil {
+			return err
+		}
+	}
+	if err := e.finish(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
 
+// encodeChecksum encodes the bits of checksum, each with probability one half.
+func encodeChecksum(e *encoder, checksum ac.Checksum) error {
+	sum := checksum.Sum()
+	for i := uint(0); i < ac.ChecksumBits; i++ {
+		if err := e.encode(ac.ProbScale/2, int((sum>>i)&1)); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	ae.fbits += 1
-	if ae.low < firstQtr {
-		bitPlusFollow(dst, ae, 0)
-	} else {
-		bitPlusFollow(dst, ae, 1)
+// EncodeStream performs arithmetic coding on the bytes read from r given a binary probabilistic model, and writes the encoded bytes to w.
+// The bits of each byte are coded from the least significant one to the most significant one, and the encoded bits are packed into bytes in the same order.
+// It is the io counterpart of Encode, and produces the same bits as Encode does, padded with zeros to a whole number of bytes.
+func EncodeStream(w io.Writer, r io.Reader, model ac.Model) error {
+	bw := ac.NewBitWriter(w)
+	if err := EncodeBits(bw, ac.NewBitReader(r), model); err != nil {
+		return err
 	}
+	return bw.Flush()
 }
 
-type arithmeticDecoder struct {
+// EncodeBytes performs arithmetic coding on src given a binary probabilistic model, and returns the encoded bytes.
+// It is a convenience wrapper of EncodeStream for small payloads held in memory.
+func EncodeBytes(src []byte, model ac.Model) []byte {
+	buf := bytes.NewBuffer(nil)
+	// Neither reading from nor writing to memory buffers fail.
+	EncodeStream(buf, bytes.NewReader(src), model)
+	return buf.Bytes()
+}
+
+// DefaultAllowance is the number of bits a lenient decoder supplies beyond the end of the encoded bits by default.
+// The encoder omits the bits after the last ones that determine the final interval, which are at most codeValueBits-2 of the bits the decoder reads ahead.
+const DefaultAllowance = codeValueBits - 2
+
+// DecoderOptions configure how a decoder handles the end of the encoded bits.
+// The zero value is a lenient decoder with the DefaultAllowance, which decodes the output of all encoders of this package.
+type DecoderOptions struct {
+	// Strict makes the decoder return ac.ErrDecodeInsufficientBits as soon as it reads beyond the end of the encoded bits, instead of supplying arbitrary bits.
+	// Only streams that are padded with the bits the decoder reads ahead, such as the segments of an Encoder, can be decoded strictly.
+	// In exchange, truncated streams are always detected.
+	Strict bool
+
+	// Allowance is the number of bits a lenient decoder supplies beyond the end of the encoded bits before returning ac.ErrDecodeInsufficientBits.
+	// Zero means DefaultAllowance.
+	// Allowances smaller than DefaultAllowance reject some valid streams, whereas larger ones let more truncated streams be decoded into garbage.
+	Allowance int
+}
+
+// allowance returns the number of bits that may be supplied beyond the end of the encoded bits.
+func (opts DecoderOptions) allowance() int {
+	if opts.Strict {
+		return 0
+	}
+	if opts.Allowance == 0 {
+		return DefaultAllowance
+	}
+	return opts.Allowance
+}
+
+// A decoder carries the state required by a decoder.
+// The encoded bits are obtained from read, which returns io.EOF when there are no more bits.
+type decoder struct {
 	low   uint64
 	high  uint64
 	value uint64
+
+	read func() (int, error)
+
+	// garbageBits is the number of bits supplied beyond the end of the encoded bits, which may not exceed allowance.
+	garbageBits int
+	allowance   int
+
+	// stats, if not nil, accumulates the statistics of the decoding.
+	stats *ac.Stats
 }
 
-func newAD() *arithmeticDecoder {
-	ad := &arithmeticDecoder{}
-	ad.high = topValue
-	return ad
+func newDecoder(read func() (int, error), stats *ac.Stats, opts DecoderOptions) (*decoder, error) {
+	dc := &decoder{}
+	dc.high = topValue
+	dc.read = read
+	dc.stats = stats
+	dc.allowance = opts.allowance()
+	for i := 1; i <= codeValueBits; i++ {
+		inb, err := dc.readBit()
+		if err != nil {
+			return nil, err
+		}
+		dc.value = 2*dc.value + inb
+	}
+	return dc, nil
+}
+
+func (dc *decoder) readBit() (uint64, error) {
+	if dc.stats != nil {
+		dc.stats.EncodedBits++
+	}
+	b, err := dc.read()
+	if err == nil {
+		return uint64(b), nil
+	}
+	if err != io.EOF {
+		return 0, err
+	}
+	dc.garbageBits++
+	if dc.garbageBits > dc.allowance {
+		return 0, ac.ErrDecodeInsufficientBits
+	}
+	return 1, nil // the returned bit can actually be random
 }
 
+// decode decodes the next bit, whose quantized probability of being zero is prob0.
+func (dc *decoder) decode(prob0 uint32) (int, error) {
+	arange := (dc.high - dc.low) + 1
+	split := dc.low + (arange*uint64(prob0))>>ac.ProbBits
+
+	bit := 1
+	if dc.value < split {
+		bit = 0
+	}
+
+	// narrow range
+	if bit == 1 {
+		dc.low = split
+	} else {
+		dc.high = split - 1
+	}
+
+	// rescale interval
+	for {
+
+		if dc.high < half {
+			// do nothing
+		} else if dc.low >= half {
+			dc.value -= half
+			dc.low -= half
+			dc.high -= half
+		} else if dc.low >= firstQtr && dc.high < thirdQtr {
+			dc.value -= firstQtr
+			dc.low -= firstQtr
+			dc.high -= firstQtr
+		} else {
+			break
+		}
+
+		dc.low = 2 * dc.low
+		dc.high = 2*dc.high + 1
+		if dc.stats != nil {
+			dc.stats.Renormalizations++
+		}
+		inb, err := dc.readBit()
+		if err != nil {
+			return 0, err
+		}
+		dc.value = 2*dc.value + inb
+	}
+	return bit, nil
+}
+
+// Decode decodes a stream of bits encoded by Encode using arithmetic coding.
+// Completion of the decoding is determined by originalSize, which is the number of bits of the original data before encoding.
+// The output decoded bits can be received from dst, which Decode closes when the decoding is complete.
+// Decode expects that model is the exact same probabilistic model used in Encode.
 func Decode(dst chan<- int, src <-chan int, model ac.Model, originalSize int64) error {
 	defer close(dst)
 
-	garbageBits := 0
-	readDecBit := func(src <-chan int) (uint64, error) {
+	dc, err := newDecoder(func() (int, error) {
 		b, ok := <-src
-		if ok {
-			return uint64(b), nil
+		if !ok {
+			return 0, io.EOF
 		}
-		garbageBits++
-		if garbageBits > codeValueBits-2 {
-			return 0, ac.ErrDecodeInsufficientBits
-		}
-		return 1, nil // the returned bit can actually be random
+		return b, nil
+	}, nil, DecoderOptions{})
+	if err != nil {
+		return err
 	}
 
-	ad := newAD()
-	for i := 1; i <= codeValueBits; i++ {
-		inb, err := readDecBit(src)
+	for i := int64(0); i < originalSize; i++ {
+		prob0 := ac.Prob0Int(model)
+		bit, err := dc.decode(prob0)
 		if err != nil {
 			return err
 		}
-		ad.value = 2*ad.value + inb
+		dst <- bit
+		model.Observe(bit)
 	}
+	return nil
+}
 
-	for i := int64(0); i < originalSize; i++ {
-		prob0 := model.Prob0()
+// DecodeBits decodes the bits read from src, which were encoded by EncodeBits, and writes the decoded bits to dst.
+// Completion of the decoding is determined by originalSize, which is the number of bits of the original data before encoding.
+// Bits are transferred in batches, which is much faster than the channels of Decode.
+// DecodeBits expects that model is the exact same probabilistic model used in EncodeBits.
+func DecodeBits(dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) error {
+	return DecodeContext(context.Background(), dst, src, model, originalSize)
+}
 
-		arange := (ad.high - ad.low) + 1
-		split := ad.low + arange*uint64(prob0*topValueDbl)/topValue
+// DecodeContext is like DecodeBits, but stops early and returns ctx.Err() if ctx is cancelled.
+// The context is checked between batches of bits.
+func DecodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) error {
+	if originalSize < 0 {
+		return fmt.Errorf("negative original size %d", originalSize)
+	}
+	return decodeContext(ctx, dst, src, model, originalSize, options{})
+}
 
-		bit := 1
-		if ad.value < split {
-			bit = 0
-		}
-		dst <- bit
-		model.Observe(bit)
+// DecodeStats is like DecodeContext, but also returns the statistics of the decoding.
+func DecodeStats(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64) (ac.Stats, error) {
+	return DecodeOptions(ctx, dst, src, model, originalSize, DecoderOptions{})
+}
 
-		// narrow range
-		if bit == 1 {
-			ad.low = split
-		} else {
-			ad.high = split - 1
-		}
-
-		// rescale interval
-		for {
-
-			if ad.high < half {
-				// do nothing
-			} else if ad.low >= half {
-				ad.value -= half
-				ad.low -= half
-				ad.high -= half
-			} else if ad.low >= firstQtr && ad.high < thirdQtr {
-				ad.value -= firstQtr
-				ad.low -= firstQtr
-				ad.high -= firstQtr
-			} else {
-				break
-			}
+// DecodeOptions is like DecodeStats, but handles the end of the encoded bits as configured by opts.
+func DecodeOptions(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64, opts DecoderOptions) (ac.Stats, error) {
+	var stats ac.Stats
+	if originalSize < 0 {
+		return stats, fmt.Errorf("negative original size %d", originalSize)
+	}
+	if opts.Allowance < 0 {
+		return stats, fmt.Errorf("negative allowance %d", opts.Allowance)
+	}
+	err := decodeContext(ctx, dst, src, model, originalSize, options{stats: &stats, decoder: opts})
+	return stats, err
+}
+
+// DecodeTerminated decodes the bits read from src, which were encoded by EncodeTerminated, and writes the decoded bits to dst.
+// Decoding stops at the end of stream marker, and bits of src after the encoded stream are not consumed unless they are within the read buffer.
+// DecodeTerminated expects that model is the exact same probabilistic model used in EncodeTerminated.
+func DecodeTerminated(dst ac.BitSink, src ac.BitSource, model ac.Model) error {
+	return decodeContext(context.Background(), dst, src, model, -1, options{terminated: true})
+}
+
+// DecodeChecksum decodes the bits read from src, which were encoded by EncodeChecksum with the same period, and writes the decoded bits to dst.
+// If an embedded checksum does not match the decoded data, DecodeChecksum returns an *ac.ChecksumError with the offset of the mismatch.
+func DecodeChecksum(dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize, period int64) error {
+	if originalSize < 0 {
+		return fmt.Errorf("negative original size %d", originalSize)
+	}
+	if period <= 0 {
+		return fmt.Errorf("non-positive checksum period %d", period)
+	}
+	return decodeContext(context.Background(), dst, src, model, originalSize, options{checksumPeriod: period})
+}
+
+// decodeContext decodes originalSize bits from src with the given options.
+// If opts.terminated is true, originalSize should be negative, and the decoding continues until the end of stream marker.
+func decodeContext(ctx context.Context, dst ac.BitSink, src ac.BitSource, model ac.Model, originalSize int64, opts options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dc, err := newDecoder(ac.NewBatchReader(src).Read, opts.stats, opts.decoder)
+	if err != nil {
+		return err
+	}
 
-			ad.low = 2 * ad.low
-			ad.high = 2*ad.high + 1
-			inb, err := readDecBit(src)
+	bw := ac.NewBatchWriter(dst)
+	var checksum ac.Checksum
+	for i := int64(0); opts.terminated || i < originalSize; i++ {
+		if i%ac.BatchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if opts.terminated {
+			end, err := dc.decode(ac.TerminationProb0)
 			if err != nil {
 				return err
 			}
-			ad.value = 2*ad.value + inb
+			if end == 1 {
+				break
+			}
+		}
+		prob0 := ac.Prob0Int(model)
+		bit, err := dc.decode(prob0)
+		if err != nil {
+			return err
+		}
+		model.Observe(bit)
+		if opts.stats != nil {
+			opts.stats.Observe(prob0, bit)
+		}
+		if err := bw.Write(bit); err != nil {
+			return err
+		}
+
+		if opts.checksumPeriod > 0 {
+			checksum.Update(bit)
+			if (i+1)%opts.checksumPeriod == 0 {
+				if err := decodeChecksum(dc, checksum, i+1); err != nil {
+					// Write out the bits decoded so far to help debugging.
+					bw.Flush()
+					return err
+				}
+			}
 		}
 	}
+	return bw.Flush()
+}
+
+// decodeChecksum decodes an embedded checksum, and returns an *ac.ChecksumError if it does not match checksum.
+// The offset is the number of bits decoded so far.
+func decodeChecksum(dc *decoder, checksum ac.Checksum, offset int64) error {
+	var sum uint32
+	for i := uint(0); i < ac.ChecksumBits; i++ {
+		b, err := dc.decode(ac.ProbScale / 2)
+		if err != nil {
+			return err
+		}
+		sum |= uint32(b) << i
+	}
+	if sum != checksum.Sum() {
+		return &ac.ChecksumError{Offset: offset}
+	}
 	return nil
 }
+
+// DecodeStream decodes the bytes read from r, which were encoded by EncodeStream, and writes the decoded bytes to w.
+// Completion of the decoding is determined by n, which is the number of bytes of the original data before encoding.
+// DecodeStream expects that model is the exact same probabilistic model used in EncodeStream.
+func DecodeStream(w io.Writer, r io.Reader, model ac.Model, n int64) error {
+	bw := ac.NewBitWriter(w)
+	if err := DecodeBits(bw, ac.NewBitReader(r), model, n*8); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// DecodeBytes decodes src, which was encoded by EncodeBytes, into the n bytes of the original data.
+// DecodeBytes expects that model is the exact same probabilistic model used in EncodeBytes.
+func DecodeBytes(src []byte, model ac.Model, n int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, n))
+	if err := DecodeStream(buf, bytes.NewReader(src), model, int64(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
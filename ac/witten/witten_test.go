@@ -1,7 +1,12 @@
 package witten
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"sync"
 	"testing"
 
@@ -37,12 +42,7 @@ func testEncode(t *testing.T, model func() ac.Model) {
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
-	x := []int{}
-	for _, bt := range contents {
-		for i := uint(0); i < 8; i++ {
-			x = append(x, int(bt)&(1<<i)>>i)
-		}
-	}
+	x := ac.Bits(contents)
 
 	// Encode
 	src := make(chan int)
@@ -113,3 +113,386 @@ func (m *ConstModel) Prob0() float64 {
 }
 
 func (m *ConstModel) Observe(b int) {}
+
+func TestEncodeStream(t *testing.T) {
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	encoded := bytes.NewBuffer(nil)
+	if err := EncodeStream(encoded, bytes.NewReader(contents), &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	t.Logf("encoded bytes: %d, original bytes: %d", encoded.Len(), len(contents))
+
+	decoded := bytes.NewBuffer(nil)
+	if err := DecodeStream(decoded, encoded, &ConstModel{P0: 0.25}, int64(len(contents))); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), contents) {
+		t.Fatalf("%s", decoded.Bytes())
+	}
+}
+
+func TestEncodeBytes(t *testing.T) {
+	msg := []byte("Four score and seven years ago")
+	encoded := EncodeBytes(msg, &ConstModel{P0: 0.75})
+	decoded, err := DecodeBytes(encoded, &ConstModel{P0: 0.75}, len(msg))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(decoded, msg) {
+		t.Fatalf("%s", decoded)
+	}
+}
+
+func TestEncodeBits(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	if err := EncodeBits(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// The encoded bits are the same as those of the channel based Encode.
+	src := make(chan int)
+	go func() {
+		for _, b := range x {
+			src <- b
+		}
+		close(src)
+	}()
+	dst := make(chan int)
+	go Encode(dst, src, &ConstModel{P0: 0.25})
+	i := 0
+	for b := range dst {
+		if i >= len(encoded.bits) || encoded.bits[i] != b {
+			t.Fatalf("%d", i)
+		}
+		i++
+	}
+	if i != len(encoded.bits) {
+		t.Fatalf("%d %d", i, len(encoded.bits))
+	}
+
+	decoded := &sliceSink{}
+	if err := DecodeBits(decoded, &sliceSource{bits: encoded.bits}, &ConstModel{P0: 0.25}, int64(len(x))); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(decoded.bits) != len(x) {
+		t.Fatalf("%d %d", len(decoded.bits), len(x))
+	}
+	for i, b := range x {
+		if decoded.bits[i] != b {
+			t.Fatalf("%d: %d != %d", i, b, decoded.bits[i])
+		}
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	x := gettysburgBits(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := EncodeContext(ctx, &sliceSink{}, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != context.Canceled {
+		t.Fatalf("%+v", err)
+	}
+
+	encoded := &sliceSink{}
+	if err := EncodeBits(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := DecodeContext(ctx, &sliceSink{}, &sliceSource{bits: encoded.bits}, &ConstModel{P0: 0.25}, int64(len(x))); err != context.Canceled {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestEncodeTerminated(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	if err := EncodeTerminated(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	plain := &sliceSink{}
+	if err := EncodeBits(plain, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	t.Logf("terminated bits: %d, plain bits: %d", len(encoded.bits), len(plain.bits))
+
+	// Trailing bits after the stream do not affect decoding.
+	src := &sliceSource{bits: append(encoded.bits, 1, 0, 1, 1, 0, 1)}
+	decoded := &sliceSink{}
+	if err := DecodeTerminated(decoded, src, &ConstModel{P0: 0.25}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(decoded.bits) != len(x) {
+		t.Fatalf("%d %d", len(decoded.bits), len(x))
+	}
+	for i, b := range x {
+		if decoded.bits[i] != b {
+			t.Fatalf("%d: %d != %d", i, b, decoded.bits[i])
+		}
+	}
+}
+
+func TestEncodeStats(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	stats, err := EncodeStats(context.Background(), encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.5})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stats.Bits != int64(len(x)) || stats.EncodedBits != int64(len(encoded.bits)) || stats.Renormalizations == 0 {
+		t.Fatalf("%+v %d", stats, len(encoded.bits))
+	}
+	if math.Abs(stats.CrossEntropy-float64(len(x))) > 1e-6 {
+		t.Fatalf("%+v", stats)
+	}
+
+	dstats, err := DecodeStats(context.Background(), &sliceSink{}, &sliceSource{bits: encoded.bits}, &ConstModel{P0: 0.5}, int64(len(x)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dstats.Bits != stats.Bits || dstats.CrossEntropy != stats.CrossEntropy || dstats.Renormalizations != stats.Renormalizations {
+		t.Fatalf("%+v %+v", dstats, stats)
+	}
+}
+
+func TestEncodePipelined(t *testing.T) {
+	x := gettysburgBits(t)
+	expected := &sliceSink{}
+	expectedStats, err := EncodeStats(context.Background(), expected, &sliceSource{bits: x}, &ConstModel{P0: 0.75})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	encoded := &sliceSink{}
+	stats, err := EncodePipelined(context.Background(), encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.75})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stats != expectedStats {
+		t.Fatalf("%+v %+v", stats, expectedStats)
+	}
+	if len(encoded.bits) != len(expected.bits) {
+		t.Fatalf("%d %d", len(encoded.bits), len(expected.bits))
+	}
+	for i, b := range expected.bits {
+		if encoded.bits[i] != b {
+			t.Fatalf("%d: %d != %d", i, b, encoded.bits[i])
+		}
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	if err := EncodeBits(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.75}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	decode := func(bits []int, opts DecoderOptions) error {
+		decoded := &sliceSink{}
+		_, err := DecodeOptions(context.Background(), decoded, &sliceSource{bits: bits}, &ConstModel{P0: 0.75}, int64(len(x)), opts)
+		if err != nil {
+			return err
+		}
+		for i, b := range x {
+			if decoded.bits[i] != b {
+				t.Fatalf("%d: %d != %d", i, b, decoded.bits[i])
+			}
+		}
+		return nil
+	}
+
+	// The encoder omits the bits the decoder reads ahead, which only a lenient decoder supplies.
+	if err := decode(encoded.bits, DecoderOptions{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := decode(encoded.bits, DecoderOptions{Strict: true}); err != ac.ErrDecodeInsufficientBits {
+		t.Fatalf("%+v", err)
+	}
+	if err := decode(encoded.bits, DecoderOptions{Allowance: 1}); err != ac.ErrDecodeInsufficientBits {
+		t.Fatalf("%+v", err)
+	}
+
+	// Padding the bits read ahead allows strict decoding.
+	padded := append(append([]int{}, encoded.bits...), make([]int, DefaultAllowance)...)
+	if err := decode(padded, DecoderOptions{Strict: true}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// A truncated stream is detected even by a lenient decoder if enough bits are missing.
+	if err := decode(encoded.bits[:len(encoded.bits)-codeValueBits], DecoderOptions{}); err != ac.ErrDecodeInsufficientBits {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestEncodeChecksum(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	if err := EncodeChecksum(encoded, &sliceSource{bits: x}, &ConstModel{P0: 0.25}, 1024); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	decoded := &sliceSink{}
+	if err := DecodeChecksum(decoded, &sliceSource{bits: encoded.bits}, &ConstModel{P0: 0.25}, int64(len(x)), 1024); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i, b := range x {
+		if decoded.bits[i] != b {
+			t.Fatalf("%d: %d != %d", i, b, decoded.bits[i])
+		}
+	}
+
+	// A decoder whose model diverges from that of the encoder detects the divergence at the next checksum.
+	diverged := &divergingModel{ConstModel: ConstModel{P0: 0.25}, after: 5000}
+	err := DecodeChecksum(&sliceSink{}, &sliceSource{bits: encoded.bits}, diverged, int64(len(x)), 1024)
+	cerr, ok := err.(*ac.ChecksumError)
+	if !ok {
+		t.Fatalf("%+v", err)
+	}
+	if cerr.Offset != 5120 {
+		t.Fatalf("%d", cerr.Offset)
+	}
+}
+
+// A divergingModel changes its prediction after observing a number of bits.
+type divergingModel struct {
+	ConstModel
+	after int
+	n     int
+}
+
+func (m *divergingModel) Prob0() float64 {
+	if m.n >= m.after {
+		return 0.3
+	}
+	return m.P0
+}
+
+func (m *divergingModel) Observe(b int) {
+	m.n++
+}
+
+func BenchmarkEncode(b *testing.B) {
+	x := gettysburgBits(b)
+	b.SetBytes(int64(len(x) / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src := make(chan int)
+		go func() {
+			for _, b := range x {
+				src <- b
+			}
+			close(src)
+		}()
+		dst := make(chan int)
+		go Encode(dst, src, &ConstModel{P0: 0.25})
+		for range dst {
+		}
+	}
+}
+
+func BenchmarkEncodeBits(b *testing.B) {
+	x := gettysburgBits(b)
+	b.SetBytes(int64(len(x) / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := EncodeBits(&sliceSink{}, &sliceSource{bits: x}, &ConstModel{P0: 0.25}); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+}
+
+func gettysburgBits(tb testing.TB) []int {
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		tb.Fatalf("%v", err)
+	}
+	x := ac.Bits(contents)
+	return x
+}
+
+type sliceSource struct {
+	bits []int
+}
+
+func (s *sliceSource) ReadBits(bits []int) (int, error) {
+	if len(s.bits) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(bits, s.bits)
+	s.bits = s.bits[n:]
+	return n, nil
+}
+
+type sliceSink struct {
+	bits []int
+}
+
+func (s *sliceSink) WriteBits(bits []int) error {
+	s.bits = append(s.bits, bits...)
+	return nil
+}
+
+func TestEncoderFlush(t *testing.T) {
+	x := gettysburgBits(t)
+	encoded := &sliceSink{}
+	enc := NewEncoder(encoded, &ConstModel{P0: 0.25})
+	src := &flushedSource{sink: encoded}
+	dec := NewDecoder(src, &ConstModel{P0: 0.25})
+
+	// Each segment is decodable as soon as it is flushed, without reading the bits of subsequent segments.
+	segment := 1000
+	for start := 0; start < len(x); start += segment {
+		end := start + segment
+		if end > len(x) {
+			end = len(x)
+		}
+		for _, b := range x[start:end] {
+			if err := enc.Encode(b); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(encoded.bits)%8 != 0 {
+			t.Fatalf("%d", len(encoded.bits))
+		}
+
+		for i := start; i < end; i++ {
+			b, err := dec.Decode()
+			if err != nil {
+				t.Fatalf("%d %+v", i, err)
+			}
+			if b != x[i] {
+				t.Fatalf("%d: %d != %d", i, x[i], b)
+			}
+		}
+	}
+	src.closed = true
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// A flushedSource reads the bits that have been written to sink, and fails if there are none.
+type flushedSource struct {
+	sink   *sliceSink
+	pos    int
+	closed bool
+}
+
+func (s *flushedSource) ReadBits(bits []int) (int, error) {
+	if s.pos == len(s.sink.bits) {
+		if s.closed {
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("read beyond flushed bits %d", s.pos)
+	}
+	n := copy(bits, s.sink.bits[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+var _ ac.Coder = Coder{}
@@ -1,52 +1,295 @@
 package main
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
-	"crypto/rand"
-	"encoding/base64"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/app/cluster/phylo"
+	"github.com/fumin/ctw/lzp"
+	"github.com/fumin/ctw/order0"
 	"github.com/pkg/errors"
 )
 
 var (
-	intelligenceType = flag.String("i", "ctw", "intelligence type")
+	intelligenceType = flag.String("i", "ctw", "intelligence type, one of ctw, lzp, order0, or gzip")
 	dataDir          = flag.String("d", "mammals10", "data directory")
+	coderName        = flag.String("c", "witten", "arithmetic coder, one of witten, eidma, mq, or golomb, where mq is the fastest")
+	ctwDepth         = flag.Int("depth", 48, "depth of the context tree of CTW")
+	chunkSize        = flag.Int("chunk", 0, "if positive, the size in bytes of the chunks that CTW compresses with a new model each, so that very large data, such as chromosomes, are compressed in bounded memory and time")
+	ctwModel         = flag.String("model", "bit", "model of CTW, either bit for a single context tree over all bits, or byte for a context tree per bit position of a byte")
+	parallelism      = flag.Int("j", runtime.NumCPU(), "number of distances computed concurrently")
+	output           = flag.String("o", "", "file to write the distance matrix to in -format, instead of logging it as comma separated arrays")
+	format           = flag.String("format", "csv", "format of the distance matrix written to -o, one of "+strings.Join(phylo.Formats, ", "))
+	treeMethod       = flag.String("tree", "", "method of building a tree from the distance matrix, one of upgma, nj for neighbor-joining, or quartet, whose tree is printed to standard output in the Newick format")
+	quartetSteps     = flag.Int("steps", 20000, "number of steps of the simulated annealing of -tree quartet")
+	seed             = flag.Int64("seed", 1, "seed of the random starting tree and mutations of -tree quartet, and of the pairs sampled by -max-pairs")
+	maxPairs         = flag.Int("max-pairs", 0, "if positive, the number of pairs sampled at random, whose distances are computed, while the other distances are imputed from them")
+	metric           = flag.String("distance", "ncd", "distance between data, one of ncd for the normalized compression distance, or conditional for the distance from the CTW code length of each datum conditioned on the other")
+	concatOrder      = flag.String("order", "min", "how the complexity of the concatenation of x and y is estimated, one of xy for K(xy), or min or mean of K(xy) and K(yx), since compressors are sensitive to the order")
+	d3Output         = flag.String("d3", "", "file to write the labels, the distance matrix, and the tree of -tree to as a JSON document for D3")
+	mdsOutput        = flag.String("mds", "", "file to write the 2D coordinates of the data, embedded by classical multidimensional scaling of the distance matrix, to in -mds-format")
+	mdsFormat        = flag.String("mds-format", "csv", "format of the coordinates written to -mds, one of "+strings.Join(phylo.EmbeddingFormats, ", "))
+	httpAddr         = flag.String("http", "", "address, such as localhost:8080, to serve a page drawing the distance matrix and the tree of -tree at")
+	journalFile      = flag.String("journal", "", "file to which distances are appended as they are computed, so that a rerun skips them")
+	classifyDir      = flag.String("classify", "", "directory of query files to classify into the classes that are the subdirectories of -d, where queries in a subdirectory named after a class are of that class and are counted in the confusion matrix")
+	anomalyDir       = flag.String("anomaly", "", "directory of a reference corpus, against which each file of -d is scored by its bits per byte by CTW trained on the corpus, flagging outliers")
+	threshold        = flag.Float64("threshold", 0, "bits per byte above which -anomaly flags a file, or 0 for two standard deviations above the mean score")
+	cacheFile        = flag.String("cache", "", "JSON file in which complexities are kept across runs, so that only those of new data are computed")
 )
 
 func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
-	if err := run(*intelligenceType, *dataDir); err != nil {
+	coder, err := ctw.NewCoder(*coderName)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if _, err := ctwOptions(); err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if *parallelism < 1 {
+		log.Fatalf("-j %d less than 1", *parallelism)
+	}
+	if err := checkFormat(*format); err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if *mdsFormat != "csv" && *mdsFormat != "json" {
+		log.Fatalf("unknown -mds-format %q, expected one of %s", *mdsFormat, strings.Join(phylo.EmbeddingFormats, ", "))
+	}
+	if err := checkOrder(*concatOrder); err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if *chunkSize < 0 {
+		log.Fatalf("-chunk %d is negative", *chunkSize)
+	}
+	switch *metric {
+	case "ncd":
+	case "conditional":
+		if *intelligenceType != "ctw" {
+			log.Fatalf("-distance conditional with -i %s, only ctw is supported", *intelligenceType)
+		}
+		if *chunkSize > 0 {
+			log.Fatalf("-distance conditional with -chunk, the code length of data trained on other data is not chunked")
+		}
+	default:
+		log.Fatalf("unknown distance %q, expected ncd or conditional", *metric)
+	}
+	if *treeMethod != "" {
+		if _, err := buildTree(*treeMethod, &phylo.Matrix{}); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	if *anomalyDir != "" {
+		if *intelligenceType != "ctw" {
+			log.Fatalf("-anomaly with -i %s, only ctw is supported", *intelligenceType)
+		}
+		if err := scoreAnomalies(*anomalyDir, *dataDir, *parallelism); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	if *classifyDir != "" {
+		if err := classify(*intelligenceType, *dataDir, *classifyDir, coder, *parallelism); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	if err := run(*intelligenceType, *dataDir, coder, *parallelism); err != nil {
 		log.Fatalf("%+v", err)
 	}
 }
 
-func run(intelligence, dir string) error {
+func run(intelligence, dir string, coder ac.Coder, parallelism int) error {
 	data, err := listFiles(dir)
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
-	distMat, err := distanceMatrix(intelligence, data)
+	contents, err := readFiles(data)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	cacher, err := loadComplexityCache(*cacheFile)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	dist := func(x, y string) (float64, error) {
+		if *metric == "conditional" {
+			return conditionalDistance(cacher, contents, x, y)
+		}
+		return distance(cacher, intelligence, coder, *concatOrder, contents, x, y)
+	}
+	settings := fmt.Sprintf("%s %s", *metric, compressorName(intelligence, coder))
+	if *metric == "ncd" {
+		settings += " order=" + *concatOrder
+	}
+	jour, err := openJournal(*journalFile, settings, contents)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer jour.Close()
+	sampled := samplePairs(len(data), *maxPairs, *seed)
+	distMat, err := distanceMatrix(data, parallelism, sampled, jour, dist)
+	if *cacheFile != "" {
+		// Save the complexities computed before any error, so that they are not computed again.
+		if err := cacher.save(*cacheFile); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	if err := display(data, distMat); err != nil {
+	names := make([]string, len(data))
+	for i, fpath := range data {
+		names[i] = taxonName(fpath)
+	}
+	m, err := phylo.NewMatrix(names, distMat)
+	if err != nil {
 		return errors.Wrap(err, "")
 	}
+	if sampled != nil {
+		imputeMatrix(m, sampled)
+		// Keep the logged matrix consistent with the imputed one.
+		var k int
+		for i := range m.Dist {
+			for j := i + 1; j < len(m.Dist); j++ {
+				distMat[k] = m.Dist[i][j]
+				k++
+			}
+		}
+	}
+	if *output != "" {
+		if err := writeMatrix(*output, *format, m); err != nil {
+			return errors.Wrap(err, "")
+		}
+	} else {
+		if err := display(data, distMat); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+
+	var tree *phylo.Tree
+	if *treeMethod != "" {
+		tree, err = buildTree(*treeMethod, m)
+		if err != nil {
+			return errors.Wrap(err, "")
+		}
+		if err := tree.WriteNewick(os.Stdout); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+
+	if *mdsOutput != "" {
+		if err := writeEmbedding(*mdsOutput, *mdsFormat, phylo.MDS(m, 2)); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	if *d3Output != "" {
+		if err := writeD3(*d3Output, m, tree); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	if *httpAddr != "" {
+		log.Printf("serving the viewer at http://%s", *httpAddr)
+		return errors.Wrap(http.ListenAndServe(*httpAddr, phylo.NewViewer(m, tree)), "")
+	}
 	return nil
 }
 
+// writeEmbedding writes the embedding e to the named file in the given format.
+func writeEmbedding(name, format string, e *phylo.Embedding) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer f.Close()
+	if err := e.Write(f, format); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return errors.Wrap(f.Close(), "")
+}
+
+// writeD3 writes the distance matrix m and the tree, which may be nil, to the named file as the JSON document of phylo.WriteD3.
+func writeD3(name string, m *phylo.Matrix, tree *phylo.Tree) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer f.Close()
+	if err := phylo.WriteD3(f, m, tree); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return errors.Wrap(f.Close(), "")
+}
+
+// buildTree returns the tree built from m by method, which is upgma, nj, or quartet.
+func buildTree(method string, m *phylo.Matrix) (*phylo.Tree, error) {
+	switch method {
+	case "upgma":
+		return phylo.UPGMA(m), nil
+	case "nj":
+		return phylo.NeighborJoining(m), nil
+	case "quartet":
+		tree, score := phylo.QuartetTree(m, *quartetSteps, rand.New(rand.NewSource(*seed)))
+		log.Printf("quartet tree score S(T) = %f", score)
+		return tree, nil
+	}
+	return nil, errors.Errorf("unknown tree method %q, expected upgma, nj, or quartet", method)
+}
+
+// checkFormat returns an error if format is not one of phylo.Formats, so that it is found before the matrix is computed.
+func checkFormat(format string) error {
+	for _, f := range phylo.Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return errors.Errorf("unknown format %q, expected one of %s", format, strings.Join(phylo.Formats, ", "))
+}
+
+// writeMatrix writes the distance matrix m to the named file in the given format.
+func writeMatrix(name, format string, m *phylo.Matrix) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer f.Close()
+	if err := m.Write(f, format); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return errors.Wrap(f.Close(), "")
+}
+
+// taxonName returns the name of the taxon of the file at fpath, which is its base name without the extension.
+func taxonName(fpath string) string {
+	name := filepath.Base(fpath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
 func display(data []string, distMat []float64) error {
 	// Print data as a comma separated array.
 	buf := bytes.NewBuffer(nil)
@@ -55,9 +298,7 @@ func display(data []string, distMat []float64) error {
 			return errors.Wrap(err, "")
 		}
 
-		name := filepath.Base(fpath)
-		base := strings.TrimSuffix(name, filepath.Ext(name))
-		if _, err := buf.WriteString(base); err != nil {
+		if _, err := buf.WriteString(taxonName(fpath)); err != nil {
 			return errors.Wrap(err, "")
 		}
 
@@ -92,22 +333,40 @@ func display(data []string, distMat []float64) error {
 	return nil
 }
 
-func distance(cacher map[string]float64, intelligence, x, y string) (float64, error) {
-	xy, err := concat(x, y)
-	if err != nil {
-		return -1, errors.Wrap(err, "")
+// checkOrder returns an error if order is not one of the values of -order.
+func checkOrder(order string) error {
+	switch order {
+	case "xy", "min", "mean":
+		return nil
 	}
-	defer os.Remove(xy.Name())
-	kxy, err := complexity(cacher, intelligence, xy.Name())
+	return errors.Errorf("unknown order %q, expected one of xy, min, or mean", order)
+}
+
+// distance returns the normalized compression distance between the files x and y, whose contents are given by contents.
+// The complexity of their concatenation is K(xy), or the minimum or the mean of K(xy) and K(yx) if order is min or mean,
+// which removes the asymmetry of the distance due to the sensitivity of the compressor to the order of x and y.
+func distance(cacher *complexityCache, intelligence string, coder ac.Coder, order string, contents map[string][]byte, x, y string) (float64, error) {
+	kxy, err := complexity(cacher, intelligence, coder, contents, x, y)
 	if err != nil {
 		return -1, errors.Wrap(err, "")
 	}
+	if order != "xy" {
+		kyx, err := complexity(cacher, intelligence, coder, contents, y, x)
+		if err != nil {
+			return -1, errors.Wrap(err, "")
+		}
+		if order == "min" {
+			kxy = math.Min(kxy, kyx)
+		} else {
+			kxy = (kxy + kyx) / 2
+		}
+	}
 
-	kx, err := complexity(cacher, intelligence, x)
+	kx, err := complexity(cacher, intelligence, coder, contents, x)
 	if err != nil {
 		return -1, errors.Wrap(err, "")
 	}
-	ky, err := complexity(cacher, intelligence, y)
+	ky, err := complexity(cacher, intelligence, coder, contents, y)
 	if err != nil {
 		return -1, errors.Wrap(err, "")
 	}
@@ -125,104 +384,751 @@ func distance(cacher map[string]float64, intelligence, x, y string) (float64, er
 	return dist, nil
 }
 
-func complexity(cacher map[string]float64, intelligence, x string) (float64, error) {
+// conditionalDistance returns the normalized conditional compression distance max(K(x|y), K(y|x)) / max(K(x), K(y)) between the files x and y, whose contents are given by contents.
+// The conditional complexity K(x|y) is the code length of x by CTW trained on y, which avoids compressing the concatenation of x and y,
+// and the complexities are code lengths estimated by ctw.CodeLength, which is cheaper than compressing.
+func conditionalDistance(cacher *complexityCache, contents map[string][]byte, x, y string) (float64, error) {
+	var k [4]float64
+	for i, c := range [][2]string{{x, ""}, {y, ""}, {x, y}, {y, x}} {
+		var err error
+		if k[i], err = conditionalComplexity(cacher, contents, c[0], c[1]); err != nil {
+			return -1, errors.Wrap(err, "")
+		}
+	}
+	return math.Max(k[2], k[3]) / math.Max(k[0], k[1]), nil
+}
+
+// conditionalComplexity returns the code length in bytes of the file at fpath by CTW trained on the file at prime, or untrained if prime is empty.
+func conditionalComplexity(cacher *complexityCache, contents map[string][]byte, fpath, prime string) (float64, error) {
+	data, primeData := contents[fpath], contents[prime]
+	key := fmt.Sprintf("ctw code length depth=%d model=%s prime=%x %x", *ctwDepth, *ctwModel, sha256.Sum256(primeData), sha256.Sum256(data))
+	size, err := cacher.get(key, func() (float64, error) {
+		opts, err := ctwOptions()
+		if err != nil {
+			return -1, errors.Wrap(err, "")
+		}
+		if prime != "" {
+			opts = append(opts, ctw.WithPrime(primeData))
+		}
+		bits, _, err := ctw.CodeLength(bytes.NewReader(data), opts...)
+		if err != nil {
+			return -1, errors.Wrap(err, "")
+		}
+		return bits / 8, nil
+	})
+	if err != nil {
+		return -1, errors.Wrap(err, "")
+	}
+	return size, nil
+}
+
+// A journal records the computed distances in a file, so that a rerun after an interruption skips them, and is safe for concurrent use.
+// Each line of the file is a JSON object of the file paths and the distance between them, and a key of the settings of the distance and the hashes of the contents of the files,
+// so that a distance is only reused if neither the settings nor the files changed.
+// A nil journal records nothing.
+type journal struct {
+	mu       sync.Mutex
+	f        *os.File
+	settings string
+	hashes   map[string]string
+	done     map[string]float64
+}
+
+// A journalEntry is a line of a journal.
+type journalEntry struct {
+	Key      string  `json:"key"`
+	X        string  `json:"x"`
+	Y        string  `json:"y"`
+	Distance float64 `json:"distance"`
+}
+
+// openJournal opens the journal of the named file for distances of the given settings between files whose contents are given by contents, or returns a nil journal if name is empty.
+func openJournal(name, settings string, contents map[string][]byte) (*journal, error) {
+	if name == "" {
+		return nil, nil
+	}
+	j := &journal{settings: settings, hashes: make(map[string]string, len(contents)), done: make(map[string]float64)}
+	for fpath, b := range contents {
+		j.hashes[fpath] = fmt.Sprintf("%x", sha256.Sum256(b))
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	j.f = f
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			f.Close()
+			return nil, errors.Wrap(err, "")
+		}
+		var e journalEntry
+		if jerr := json.Unmarshal(line, &e); jerr == nil {
+			j.done[e.Key] = e.Distance
+		} else if len(bytes.TrimSpace(line)) > 0 {
+			// The last line is partial if the previous run was killed while writing it.
+			log.Printf("%s: ignoring invalid line %q", name, line)
+		}
+		if err == io.EOF {
+			// End the partial line, so that the next entry starts on its own line.
+			if len(line) > 0 {
+				if _, err := f.Write([]byte("\n")); err != nil {
+					f.Close()
+					return nil, errors.Wrap(err, "")
+				}
+			}
+			return j, nil
+		}
+	}
+}
+
+// key returns the key of the distance between the files x and y.
+func (j *journal) key(x, y string) string {
+	return fmt.Sprintf("%s %s %s", j.settings, j.hashes[x], j.hashes[y])
+}
+
+// lookup returns the distance between the files x and y, and whether it is in the journal.
+func (j *journal) lookup(x, y string) (float64, bool) {
+	if j == nil {
+		return 0, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	d, ok := j.done[j.key(x, y)]
+	return d, ok
+}
+
+// record appends the distance d between the files x and y to the journal.
+func (j *journal) record(x, y string, d float64) error {
+	if j == nil {
+		return nil
+	}
+	b, err := json.Marshal(journalEntry{Key: j.key(x, y), X: x, Y: y, Distance: d})
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
+
+// Close closes the file of the journal.
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return errors.Wrap(j.f.Close(), "")
+}
+
+// A complexityCache caches the complexities of the files and their concatenations, and is safe for concurrent use.
+// A complexity being computed is waited for rather than computed again.
+// Complexities are keyed by the compressor and the hash of the data, so that they can be saved to a file and loaded by later runs, even if files are renamed or added.
+type complexityCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	// loaded are the complexities loaded from a file.
+	loaded map[string]float64
+}
+
+// A cacheEntry is a complexity in a complexityCache, which is computed once done is closed.
+type cacheEntry struct {
+	done chan struct{}
+	size float64
+	err  error
+}
+
+func newComplexityCache() *complexityCache {
+	return &complexityCache{entries: make(map[string]*cacheEntry), loaded: make(map[string]float64)}
+}
+
+// loadComplexityCache returns the cache of the complexities saved to the named file, which is empty if name is empty or the file does not exist.
+func loadComplexityCache(name string) (*complexityCache, error) {
+	c := newComplexityCache()
+	if name == "" {
+		return c, nil
+	}
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	if err := json.Unmarshal(b, &c.loaded); err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	log.Printf("loaded %d complexities from %s", len(c.loaded), name)
+	return c, nil
+}
+
+// save saves the loaded and the successfully computed complexities to the named file as a JSON object.
+func (c *complexityCache) save(name string) error {
+	c.mu.Lock()
+	saved := make(map[string]float64, len(c.loaded)+len(c.entries))
+	for key, size := range c.loaded {
+		saved[key] = size
+	}
+	for key, e := range c.entries {
+		select {
+		case <-e.done:
+			if e.err == nil {
+				saved[key] = e.size
+			}
+		default:
+		}
+	}
+	c.mu.Unlock()
+
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	// Write to a temporary file first, so that an interrupted save does not lose the cache.
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return errors.Wrap(os.Rename(tmp, name), "")
+}
+
+// get returns the complexity of key, computing it by compute if it is not cached.
+func (c *complexityCache) get(key string, compute func() (float64, error)) (float64, error) {
+	c.mu.Lock()
+	if size, ok := c.loaded[key]; ok {
+		c.mu.Unlock()
+		return size, nil
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cacheEntry{done: make(chan struct{})}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-e.done
+	} else {
+		e.size, e.err = compute()
+		close(e.done)
+	}
+	return e.size, e.err
+}
+
+// lzpOrder is the order of the model of lzp.
+const lzpOrder = 8
+
+// ctwOptions returns the options of CTW set by -depth and -model.
+func ctwOptions() ([]ctw.Option, error) {
+	model, err := ctw.ParseModel(*ctwModel)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	if model == ctw.ByteModel && *ctwDepth == 0 {
+		return nil, errors.Errorf("-model byte with -depth 0")
+	}
+	return []ctw.Option{ctw.WithDepth(*ctwDepth), ctw.WithModel(model)}, nil
+}
+
+// compressorName returns the name of the compressor of intelligence, with the parameters that determine its complexities.
+func compressorName(intelligence string, coder ac.Coder) string {
 	switch intelligence {
 	case "ctw":
-		return complexityCTW(cacher, x)
+		return fmt.Sprintf("ctw depth=%d model=%s chunk=%d coder=%T", *ctwDepth, *ctwModel, *chunkSize, coder)
+	case "lzp":
+		return fmt.Sprintf("lzp order=%d coder=%T", lzpOrder, coder)
+	case "order0":
+		return fmt.Sprintf("order0 coder=%T", coder)
 	default:
-		return complexityTarGz(x)
+		return "gzip"
 	}
 }
 
-func complexityCTW(cacher map[string]float64, fpath string) (float64, error) {
-	size, ok := cacher[fpath]
-	if ok {
-		return size, nil
+// complexity returns the compressed size in bytes of the concatenation of the files at fpaths, whose contents are given by contents.
+func complexity(cacher *complexityCache, intelligence string, coder ac.Coder, contents map[string][]byte, fpaths ...string) (float64, error) {
+	var data []byte
+	for _, fpath := range fpaths {
+		data = append(data, contents[fpath]...)
 	}
-
-	buf := bytes.NewBuffer(nil)
-	if err := ctw.Compress(buf, fpath, 48); err != nil {
+	key := fmt.Sprintf("%s %x", compressorName(intelligence, coder), sha256.Sum256(data))
+	size, err := cacher.get(key, func() (float64, error) {
+		switch intelligence {
+		case "ctw":
+			return complexityCTW(coder, data)
+		case "lzp":
+			return complexityModel(coder, data, func() ac.Model { return lzp.NewModel(lzpOrder) })
+		case "order0":
+			return complexityModel(coder, data, func() ac.Model { return order0.NewModel(0) })
+		default:
+			return complexityTarGz(data)
+		}
+	})
+	if err != nil {
 		return -1, errors.Wrap(err, "")
 	}
-	size = float64(buf.Len())
-
-	cacher[fpath] = size
 	return size, nil
 }
 
-func complexityTarGz(fpath string) (float64, error) {
-	dst := "/tmp/dst"
-	if err := exec.Command("tar", "zcf", dst, fpath).Run(); err != nil {
+// complexityCTW returns the size in bytes of data when compressed by CTW with coder, and the depth and model of -depth and -model.
+// If -chunk is positive, data is split into chunks of that size, each compressed with a new model, and the size is the sum of the sizes of the compressed chunks.
+// Since the chunks start at the same offsets of every datum and concatenation, the complexities of all pairs are estimated alike.
+func complexityCTW(coder ac.Coder, data []byte) (float64, error) {
+	opts, err := ctwOptions()
+	if err != nil {
 		return -1, errors.Wrap(err, "")
 	}
-	info, err := os.Stat(dst)
+	opts = append(opts, ctw.WithCoder(coder))
+	chunk := len(data)
+	if *chunkSize > 0 && *chunkSize < chunk {
+		chunk = *chunkSize
+	}
+	// Empty data is compressed once, after which start must move past it.
+	if chunk < 1 {
+		chunk = 1
+	}
+	var size int
+	for start := 0; start == 0 || start < len(data); start += chunk {
+		end := start + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		compressed, err := ctw.CompressBytes(data[start:end], opts...)
+		if err != nil {
+			return -1, errors.Wrap(err, "")
+		}
+		size += len(compressed)
+	}
+	return float64(size), nil
+}
+
+// complexityModel returns the size in bytes of data when arithmetically encoded by coder with the model returned by newModel.
+func complexityModel(coder ac.Coder, data []byte, newModel func() ac.Model) (float64, error) {
+	stats, err := coder.EncodeStats(context.Background(), ac.NewBitWriter(ioutil.Discard), ac.NewBitReader(bytes.NewReader(data)), newModel())
 	if err != nil {
 		return -1, errors.Wrap(err, "")
 	}
-	return float64(info.Size()), nil
+	return float64((stats.EncodedBits + 7) / 8), nil
 }
 
-func concat(x, y string) (*os.File, error) {
-	// Random string for file name.
-	b := make([]byte, 8)
-	if _, err := rand.Read(b); err != nil {
-		return nil, errors.Wrap(err, "")
+// complexityTarGz returns the size of the gzipped tarball of data, as made by tar zcf of a file holding data.
+func complexityTarGz(data []byte) (float64, error) {
+	buf := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{Name: "data", Mode: 0644, Size: int64(len(data))}); err != nil {
+		return -1, errors.Wrap(err, "")
+	}
+	if _, err := tw.Write(data); err != nil {
+		return -1, errors.Wrap(err, "")
+	}
+	if err := tw.Close(); err != nil {
+		return -1, errors.Wrap(err, "")
 	}
-	randStr := base64.RawURLEncoding.EncodeToString([]byte(b))
+	if err := zw.Close(); err != nil {
+		return -1, errors.Wrap(err, "")
+	}
+	return float64(buf.Len()), nil
+}
 
-	// Create file.
-	xyFname := filepath.Join("/tmp", filepath.Base(x)+filepath.Base(y)+randStr)
-	xy, err := os.Create(xyFname)
-	if err != nil {
-		return nil, errors.Wrap(err, "")
+// readFiles returns the contents of the files at fpaths.
+func readFiles(fpaths []string) (map[string][]byte, error) {
+	contents := make(map[string][]byte, len(fpaths))
+	for _, fpath := range fpaths {
+		b, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		contents[fpath] = b
 	}
+	return contents, nil
+}
 
-	// Write to concatenated file.
-	if err := concatFiles(xy, x, y); err != nil {
-		return nil, errors.Wrap(err, "")
+// samplePairs returns whether each of the pairs of n data, in the row-major order of the upper triangle of their matrix, is among maxPairs pairs sampled at random from seed.
+// It returns nil if all pairs are to be computed, which is when maxPairs is not positive or not less than the number of pairs.
+func samplePairs(n, maxPairs int, seed int64) []bool {
+	total := n * (n - 1) / 2
+	if maxPairs <= 0 || maxPairs >= total {
+		return nil
+	}
+	sampled := make([]bool, total)
+	for _, k := range rand.New(rand.NewSource(seed)).Perm(total)[:maxPairs] {
+		sampled[k] = true
+	}
+	return sampled
+}
+
+// imputeMatrix imputes the distances of m between the pairs that are not sampled, as returned by samplePairs.
+func imputeMatrix(m *phylo.Matrix, sampled []bool) {
+	n := len(m.Names)
+	known := make([][]bool, n)
+	for i := range known {
+		known[i] = make([]bool, n)
+	}
+	var k int
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			known[i][j], known[j][i] = sampled[k], sampled[k]
+			k++
+		}
 	}
-	return xy, nil
+	phylo.Impute(m, known)
+	log.Printf("imputed %d of %d distances", len(sampled)-*maxPairs, len(sampled))
 }
 
-func concatFiles(tmpf *os.File, fs ...string) error {
-	for _, fpath := range fs {
-		err := func(fpath string) error {
-			f, err := os.Open(fpath)
-			if err != nil {
-				return errors.Wrap(err, "")
+// distanceMatrix returns the upper triangle of the matrix of the distances between data, in row-major order.
+// The distances are computed by dist in parallelism workers, so dist must be safe for concurrent use.
+// Only the distances of the pairs that are sampled are computed if sampled is not nil, and the others are zero.
+// The distances in the journal jour are not computed again, and those computed are recorded in it.
+// The progress, with the estimated time until all distances are computed, is logged as each distance is computed.
+func distanceMatrix(data []string, parallelism int, sampled []bool, jour *journal, dist func(x, y string) (float64, error)) ([]float64, error) {
+	// A pair is the k-th pair of data, of the i-th and the j-th data.
+	type pair struct {
+		k, i, j int
+	}
+	n := len(data)
+	mat := make([]float64, n*(n-1)/2)
+	errs := make([]error, len(mat))
+	var todo []pair
+	var k, found int
+	for i := 0; i < n-1; i++ {
+		for j := i + 1; j < n; j++ {
+			if sampled == nil || sampled[k] {
+				if d, ok := jour.lookup(data[i], data[j]); ok {
+					mat[k] = d
+					found++
+				} else {
+					todo = append(todo, pair{k: k, i: i, j: j})
+				}
 			}
-			defer f.Close()
-			if _, err := io.Copy(tmpf, f); err != nil {
-				return errors.Wrap(err, "")
+			k++
+		}
+	}
+	if found > 0 {
+		log.Printf("%d of %d distances found in the journal", found, len(mat))
+	}
+
+	start := time.Now()
+	var mu sync.Mutex
+	var done int
+	pairs := make(chan pair)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pairs {
+				dx, dy := data[p.i], data[p.j]
+				d, err := dist(dx, dy)
+				if err == nil {
+					err = jour.record(dx, dy, d)
+				}
+				if err != nil {
+					errs[p.k] = errors.Wrap(err, "")
+					continue
+				}
+				mat[p.k] = d
+
+				mu.Lock()
+				done++
+				elapsed := time.Since(start)
+				eta := time.Duration(float64(elapsed) / float64(done) * float64(len(todo)-done)).Round(time.Second)
+				log.Printf("\"%s\"-\"%s\": %f, %d/%d done, ETA %v", dx, dy, d, done, len(todo), eta)
+				mu.Unlock()
 			}
-			return nil
-		}(fpath)
+		}()
+	}
+	for _, p := range todo {
+		pairs <- p
+	}
+	close(pairs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mat, nil
+}
+
+// classify assigns each query file in queryDir to the class, among the subdirectories of classDir, whose files are the closest to it, and prints the assignments and the confusion matrix to standard output.
+// A query is closest to the class whose concatenated files have the smallest distance to it, or for -distance conditional the smallest code length of the query by CTW trained on them.
+// The queries in a subdirectory of queryDir are of the class of its name, and are counted in the confusion matrix.
+func classify(intelligence, classDir, queryDir string, coder ac.Coder, parallelism int) error {
+	_, classes, err := listDir(classDir)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	if len(classes) == 0 {
+		return errors.Errorf("no classes in %s", classDir)
+	}
+	contents := make(map[string][]byte)
+	classPaths := make([]string, len(classes))
+	for i, class := range classes {
+		classPaths[i] = filepath.Join(classDir, class)
+		files, _, err := listDir(classPaths[i])
 		if err != nil {
 			return errors.Wrap(err, "")
 		}
+		classContents, err := readFiles(files)
+		if err != nil {
+			return errors.Wrap(err, "")
+		}
+		for _, fpath := range files {
+			contents[classPaths[i]] = append(contents[classPaths[i]], classContents[fpath]...)
+		}
 	}
-	if err := tmpf.Close(); err != nil {
+
+	// labels are the classes of the queries, which are empty if unknown.
+	queries, subdirs, err := listDir(queryDir)
+	if err != nil {
 		return errors.Wrap(err, "")
 	}
-	return nil
+	labels := make([]string, len(queries))
+	for _, label := range subdirs {
+		files, _, err := listDir(filepath.Join(queryDir, label))
+		if err != nil {
+			return errors.Wrap(err, "")
+		}
+		for _, fpath := range files {
+			queries = append(queries, fpath)
+			labels = append(labels, label)
+		}
+	}
+	queryContents, err := readFiles(queries)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	for fpath, b := range queryContents {
+		contents[fpath] = b
+	}
+
+	cacher, err := loadComplexityCache(*cacheFile)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	// scores[q][c] is the score of the q-th query for the c-th class, the smallest of which is the class of the query.
+	scores := make([][]float64, len(queries))
+	for q := range scores {
+		scores[q] = make([]float64, len(classes))
+	}
+	var mu sync.Mutex
+	var errs []error
+	jobs := make(chan [2]int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				q, c := job[0], job[1]
+				var score float64
+				var err error
+				if *metric == "conditional" {
+					score, err = conditionalComplexity(cacher, contents, queries[q], classPaths[c])
+				} else {
+					score, err = distance(cacher, intelligence, coder, *concatOrder, contents, queries[q], classPaths[c])
+				}
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, errors.Wrap(err, ""))
+					mu.Unlock()
+					continue
+				}
+				scores[q][c] = score
+				log.Printf("\"%s\"-\"%s\": %f", queries[q], classes[c], score)
+			}
+		}()
+	}
+	for q := range queries {
+		for c := range classes {
+			jobs <- [2]int{q, c}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if *cacheFile != "" {
+		if err := cacher.save(*cacheFile); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	predictions := make([]string, len(queries))
+	for q, row := range scores {
+		best := 0
+		for c, score := range row {
+			if score < row[best] {
+				best = c
+			}
+		}
+		predictions[q] = classes[best]
+	}
+	return printClassification(os.Stdout, classes, queries, labels, predictions)
 }
 
-func distanceMatrix(intelligence string, data []string) ([]float64, error) {
-	cacher := make(map[string]float64)
+// printClassification prints the predicted class of each query, and the confusion matrix and the accuracy of the queries whose classes are known.
+// The rows of the confusion matrix are the classes of the queries and its columns are the predicted classes.
+func printClassification(w io.Writer, classes, queries, labels, predictions []string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "query\tclass\tpredicted\n")
+	for q, query := range queries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", query, labels[q], predictions[q])
+	}
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "")
+	}
 
-	n := len(data)
-	mat := make([]float64, 0, n*(n-1)/2)
-	for i, dx := range data[:n-1] {
-		for _, dy := range data[i+1:] {
-			dist, err := distance(cacher, intelligence, dx, dy)
-			if err != nil {
-				return nil, errors.Wrap(err, "")
+	index := make(map[string]int, len(classes))
+	for c, class := range classes {
+		index[class] = c
+	}
+	confusion := make([][]int, len(classes))
+	for c := range confusion {
+		confusion[c] = make([]int, len(classes))
+	}
+	var known, correct int
+	for q, label := range labels {
+		c, ok := index[label]
+		if !ok {
+			continue
+		}
+		confusion[c][index[predictions[q]]]++
+		known++
+		if label == predictions[q] {
+			correct++
+		}
+	}
+	if known == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(tw, "\n%s\n", strings.Join(append([]string{""}, classes...), "\t"))
+	for c, row := range confusion {
+		fmt.Fprintf(tw, "%s", classes[c])
+		for _, n := range row {
+			fmt.Fprintf(tw, "\t%d", n)
+		}
+		fmt.Fprintf(tw, "\n")
+	}
+	fmt.Fprintf(tw, "\naccuracy\t%d/%d = %.3f\n", correct, known, float64(correct)/float64(known))
+	return errors.Wrap(tw.Flush(), "")
+}
+
+// scoreAnomalies scores each file in dir by its code length in bits per byte by CTW trained on the concatenated files in refDir, and prints the scores to standard output from the highest,
+// flagging the files scoring above -threshold, which are unlike the reference corpus, such as contaminated genomes or corrupted logs.
+func scoreAnomalies(refDir, dir string, parallelism int) error {
+	refFiles, _, err := listDir(refDir)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	if len(refFiles) == 0 {
+		return errors.Errorf("no files in %s", refDir)
+	}
+	files, _, err := listDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	contents, err := readFiles(append(refFiles, files...))
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	for _, fpath := range refFiles {
+		contents[refDir] = append(contents[refDir], contents[fpath]...)
+	}
+
+	cacher, err := loadComplexityCache(*cacheFile)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	scores := make([]float64, len(files))
+	errs := make([]error, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				size, err := conditionalComplexity(cacher, contents, files[i], refDir)
+				if err != nil {
+					errs[i] = errors.Wrap(err, "")
+					continue
+				}
+				if n := len(contents[files[i]]); n > 0 {
+					scores[i] = size * 8 / float64(n)
+				}
+				log.Printf("%s: %f bits per byte", files[i], scores[i])
 			}
-			mat = append(mat, dist)
-			log.Printf("\"%s\"-\"%s\": %f", dx, dy, dist)
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if *cacheFile != "" {
+		if err := cacher.save(*cacheFile); err != nil {
+			return errors.Wrap(err, "")
 		}
 	}
-	return mat, nil
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	limit := *threshold
+	if limit == 0 {
+		var mean, variance float64
+		for _, score := range scores {
+			mean += score / float64(len(scores))
+		}
+		for _, score := range scores {
+			variance += (score - mean) * (score - mean) / float64(len(scores))
+		}
+		limit = mean + 2*math.Sqrt(variance)
+	}
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "file\tsize\tbits per byte\toutlier\n")
+	for _, i := range order {
+		var outlier string
+		if scores[i] > limit {
+			outlier = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.4f\t%s\n", files[i], len(contents[files[i]]), scores[i], outlier)
+	}
+	fmt.Fprintf(tw, "\nthreshold\t%.4f\n", limit)
+	return errors.Wrap(tw.Flush(), "")
+}
+
+// listDir returns the paths of the files in dir, and the names of its subdirectories.
+func listDir(dir string) ([]string, []string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "")
+	}
+	var files, subdirs []string
+	for _, fi := range infos {
+		if fi.IsDir() {
+			subdirs = append(subdirs, fi.Name())
+		} else {
+			files = append(files, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return files, subdirs, nil
 }
 
 func listFiles(dir string) ([]string, error) {
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/pkg/errors"
+)
+
+var (
+	srcDir       = flag.String("s", "", "source directory of FASTA files, or of files holding only symbols of the alphabet")
+	dstDir       = flag.String("d", "", "destination directory")
+	alphabetName = flag.String("alphabet", "dna", "alphabet of the symbols, one of dna for nucleotides in 2 bits, protein for amino acids in 5 bits, or the name of a file mapping symbols to codes")
+	ambiguous    = flag.String("ambiguous", "skip", "policy for ambiguity codes, such as N and the other IUPAC codes of nucleotides or X of amino acids, one of skip to drop them, random to replace each by a random one of the symbols it stands for, or error")
+	split        = flag.Bool("split", false, "write each record of a multi-record FASTA file to its own file named after the record, instead of concatenating the records")
+	seed         = flag.Int64("seed", 1, "seed of -ambiguous random")
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+	switch *ambiguous {
+	case "skip", "random", "error":
+	default:
+		log.Fatalf("unknown -ambiguous %q, expected one of skip, random, or error", *ambiguous)
+	}
+	alpha, err := loadAlphabet(*alphabetName)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	log.Printf("alphabet %s of %v", *alphabetName, alpha)
+	if err := run(alpha, *srcDir, *dstDir); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+func run(alpha *alphabet, srcDir, dstDir string) error {
+	srcs, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	enc := &encoder{alphabet: alpha, policy: *ambiguous, rng: rand.New(rand.NewSource(*seed))}
+	for _, srcInfo := range srcs {
+		if srcInfo.IsDir() {
+			continue
+		}
+		src := srcInfo.Name()
+		if err := encodeFile(enc, filepath.Join(srcDir, src), dstDir, *split); err != nil {
+			return errors.Wrap(err, src)
+		}
+	}
+	return nil
+}
+
+// encodeFile encodes the records of the file at src to dstDir, into a file named after src, or into a file for each record named after it if split is true.
+func encodeFile(enc *encoder, src, dstDir string, split bool) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer f.Close()
+	records, err := readRecords(f, enc.alphabet.fasta)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	base := filepath.Base(src)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	if !split {
+		var seq []byte
+		for _, rec := range records {
+			seq = append(seq, rec.seq...)
+		}
+		records = []record{{id: base, seq: seq}}
+	}
+	for _, rec := range records {
+		// Identifiers such as those of NCBI may have slashes, which are not allowed in file names.
+		name := strings.Replace(rec.id, "/", "_", -1)
+		if name == "" {
+			name = base
+		}
+		if err := writeFile(filepath.Join(dstDir, name+enc.alphabet.ext), func(w io.Writer) error { return enc.encode(w, rec.seq) }); err != nil {
+			return errors.Wrap(err, rec.id)
+		}
+	}
+	return nil
+}
+
+// writeFile creates the named file and writes to it by write.
+func writeFile(name string, write func(io.Writer) error) error {
+	w, err := os.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer w.Close()
+	if err := write(w); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return errors.Wrap(w.Close(), "")
+}
+
+// A record is a sequence in a FASTA file.
+type record struct {
+	// id is the identifier of the record, which is the first word of its header line, or empty if the file has no header.
+	id string
+	// seq holds the symbols of the sequence, without whitespace.
+	seq []byte
+}
+
+// readRecords returns the records of the file read from r, which is a FASTA file if fasta is true.
+// In a FASTA file, lines starting with > are headers, which start records, and lines starting with ; are comments.
+// A file without headers is a single record, so that files holding only symbols are read too.
+func readRecords(r io.Reader, fasta bool) ([]record, error) {
+	var records []record
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "")
+		}
+		switch {
+		case fasta && bytes.HasPrefix(line, []byte(">")):
+			var id string
+			if fields := strings.Fields(string(line[1:])); len(fields) > 0 {
+				id = fields[0]
+			}
+			records = append(records, record{id: id})
+		case fasta && bytes.HasPrefix(line, []byte(";")):
+		default:
+			if len(records) == 0 {
+				records = append(records, record{})
+			}
+			rec := &records[len(records)-1]
+			for _, b := range line {
+				switch b {
+				case ' ', '\t', '\r', '\n':
+				default:
+					rec.seq = append(rec.seq, b)
+				}
+			}
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+	}
+}
+
+// An alphabet maps symbols to codes of a fixed number of bits.
+type alphabet struct {
+	// ext is the extension of the encoded files.
+	ext string
+	// bits is the number of bits of a code.
+	bits int
+	// codes are the codes of the symbols.
+	codes map[byte]int
+	// ambiguous are the symbols that each ambiguity code stands for.
+	ambiguous map[byte]string
+	// ignored are symbols that are not encoded, such as gaps.
+	ignored string
+	// fold is whether symbols are read in either case, as lowercase.
+	fold bool
+	// fasta is whether files are read as FASTA files.
+	fasta bool
+}
+
+func (alpha *alphabet) String() string {
+	return fmt.Sprintf("%d symbols in %d bits", len(alpha.codes), alpha.bits)
+}
+
+// dna is the alphabet of nucleotides, where U is T of RNA and the ambiguity codes are those of IUPAC.
+// Gaps, digits, and the stop codon * are ignored.
+var dna = &alphabet{
+	ext:   ".atcg",
+	bits:  2,
+	codes: map[byte]int{'a': 0, 't': 1, 'c': 2, 'g': 3, 'u': 1},
+	ambiguous: map[byte]string{
+		'r': "ag", 'y': "ct", 's': "cg", 'w': "at", 'k': "gt", 'm': "ac",
+		'b': "cgt", 'd': "agt", 'h': "act", 'v': "acg", 'n': "acgt",
+	},
+	ignored: "-.*0123456789",
+	fold:    true,
+	fasta:   true,
+}
+
+// protein is the alphabet of the twenty amino acids, and selenocysteine U and pyrrolysine O.
+// B stands for D or N, Z for E or Q, J for I or L, and X for any of the twenty amino acids, and gaps, digits, and the stop codon * are ignored.
+var protein = &alphabet{
+	ext:  ".prot",
+	bits: 5,
+	codes: func() map[byte]int {
+		codes := make(map[byte]int)
+		for i, aa := range []byte("acdefghiklmnpqrstvwyuo") {
+			codes[aa] = i
+		}
+		return codes
+	}(),
+	ambiguous: map[byte]string{'b': "dn", 'z': "eq", 'j': "il", 'x': "acdefghiklmnpqrstvwy"},
+	ignored:   "-.*0123456789",
+	fold:      true,
+	fasta:     true,
+}
+
+// loadAlphabet returns the alphabet named dna or protein, or else the alphabet read from the named file.
+// Each line of the file is a symbol, which is a single byte, followed by its code, a non-negative integer.
+// Several symbols may share a code, blank lines and lines starting with # are ignored, and the file is read case-sensitively without ambiguity codes,
+// so that any symbolic data can be encoded.
+func loadAlphabet(name string) (*alphabet, error) {
+	switch name {
+	case "dna":
+		return dna, nil
+	case "protein":
+		return protein, nil
+	}
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	alpha := &alphabet{ext: ".enc", codes: make(map[byte]int)}
+	maxCode := 0
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != 1 {
+			return nil, errors.Errorf("%s:%d: expected a symbol and its code, got %q", name, i+1, line)
+		}
+		code, err := strconv.Atoi(fields[1])
+		if err != nil || code < 0 {
+			return nil, errors.Errorf("%s:%d: invalid code %q", name, i+1, fields[1])
+		}
+		if _, ok := alpha.codes[fields[0][0]]; ok {
+			return nil, errors.Errorf("%s:%d: duplicate symbol %q", name, i+1, fields[0])
+		}
+		alpha.codes[fields[0][0]] = code
+		if code > maxCode {
+			maxCode = code
+		}
+	}
+	if len(alpha.codes) == 0 {
+		return nil, errors.Errorf("%s: no symbols", name)
+	}
+	alpha.bits = bits.Len(uint(maxCode))
+	if alpha.bits == 0 {
+		alpha.bits = 1
+	}
+	return alpha, nil
+}
+
+// An encoder encodes symbols in the codes of its alphabet, with its policy for ambiguity codes.
+type encoder struct {
+	alphabet *alphabet
+	policy   string
+	rng      *rand.Rand
+}
+
+// encode writes the codes of the symbols of seq to w, each in the number of bits of the alphabet starting from the least significant bit.
+// The ambiguity codes are handled by the policy of the encoder, and the ignored symbols of the alphabet are skipped.
+func (enc *encoder) encode(w io.Writer, seq []byte) error {
+	alpha := enc.alphabet
+	bw := ac.NewBitWriter(w)
+	buf := make([]int, alpha.bits)
+	for i, bt := range seq {
+		if strings.IndexByte(alpha.ignored, bt) >= 0 {
+			continue
+		}
+		symbol := bt
+		if alpha.fold {
+			symbol = bytes.ToLower([]byte{bt})[0]
+		}
+		if symbols, ok := alpha.ambiguous[symbol]; ok {
+			switch enc.policy {
+			case "skip":
+				continue
+			case "random":
+				symbol = symbols[enc.rng.Intn(len(symbols))]
+			default:
+				return errors.Errorf("ambiguous symbol %q at %d", bt, i)
+			}
+		}
+		c, ok := alpha.codes[symbol]
+		if !ok {
+			return errors.Errorf("invalid symbol %q at %d", bt, i)
+		}
+
+		for k := range buf {
+			buf[k] = (c >> uint(k)) & 1
+		}
+		if err := bw.WriteBits(buf); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package phylo
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// A d3Document is a distance matrix and its tree in the shapes of the data of the D3 heatmap and hierarchy examples.
+type d3Document struct {
+	// Labels are the names of the taxa.
+	Labels []string `json:"labels"`
+	// Matrix holds the rows of the distance matrix.
+	Matrix [][]float64 `json:"matrix"`
+	// Cells are the entries of the distance matrix, as drawn by a heatmap.
+	Cells []d3Cell `json:"cells"`
+	// Tree is the tree as read by d3.hierarchy, if any.
+	Tree *d3Node `json:"tree,omitempty"`
+}
+
+// A d3Cell is an entry of a distance matrix.
+type d3Cell struct {
+	Row    string  `json:"row"`
+	Column string  `json:"column"`
+	Value  float64 `json:"value"`
+}
+
+// A d3Node is a node of a tree as read by d3.hierarchy.
+type d3Node struct {
+	Name string `json:"name,omitempty"`
+	// Length is omitted if the tree has no branch lengths.
+	Length   *float64  `json:"length,omitempty"`
+	Children []*d3Node `json:"children,omitempty"`
+}
+
+func newD3Document(m *Matrix, tree *Tree) d3Document {
+	doc := d3Document{Labels: m.Names, Matrix: m.Dist, Cells: []d3Cell{}}
+	for i, row := range m.Dist {
+		for j, d := range row {
+			doc.Cells = append(doc.Cells, d3Cell{Row: m.Names[i], Column: m.Names[j], Value: d})
+		}
+	}
+	if tree != nil {
+		doc.Tree = newD3Node(tree)
+	}
+	return doc
+}
+
+func newD3Node(t *Tree) *d3Node {
+	n := &d3Node{Name: t.Name}
+	if !math.IsNaN(t.Length) {
+		length := t.Length
+		n.Length = &length
+	}
+	for _, c := range t.Children {
+		n.Children = append(n.Children, newD3Node(c))
+	}
+	return n
+}
+
+// WriteD3 writes the matrix and the tree, which may be nil, to w as a single JSON document for visualization in a browser with D3.
+// The "labels" are the names of the taxa, the "matrix" holds the rows of the matrix, and the "cells" are its entries as objects of a "row", a "column", and a "value", as in the D3 heatmap examples.
+// The "tree" is the nested objects of a "name", a "length" of the branch to the parent if known, and "children", as read by d3.hierarchy.
+func WriteD3(w io.Writer, m *Matrix, tree *Tree) error {
+	enc := json.NewEncoder(w)
+	return errors.Wrap(enc.Encode(newD3Document(m, tree)), "")
+}
+
+//go:embed viewer.html
+var viewerHTML []byte
+
+// NewViewer returns a handler serving a static page that draws the matrix as a heatmap and the tree, which may be nil, as a dendrogram, with D3 loaded from its CDN.
+// The page is served at / and the document of WriteD3 at /data.json.
+func NewViewer(m *Matrix, tree *Tree) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(viewerHTML)
+	})
+	mux.HandleFunc("/data.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := WriteD3(w, m, tree); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	return mux
+}
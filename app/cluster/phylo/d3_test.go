@@ -0,0 +1,62 @@
+package phylo
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteD3(t *testing.T) {
+	m := &Matrix{Names: []string{"a", "b"}, Dist: [][]float64{{0, 2}, {2, 0}}}
+	for _, test := range []struct {
+		tree     *Tree
+		expected string
+	}{
+		{tree: nil, expected: `{"labels":["a","b"],"matrix":[[0,2],[2,0]],"cells":[{"row":"a","column":"a","value":0},{"row":"a","column":"b","value":2},{"row":"b","column":"a","value":2},{"row":"b","column":"b","value":0}]}`},
+		{tree: UPGMA(m), expected: `"tree":{"length":0,"children":[{"name":"a","length":1},{"name":"b","length":1}]}}`},
+		{tree: &Tree{Length: math.NaN(), Children: []*Tree{{Name: "a", Length: math.NaN()}}}, expected: `"tree":{"children":[{"name":"a"}]}}`},
+	} {
+		buf := bytes.NewBuffer(nil)
+		if err := WriteD3(buf, m, test.tree); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !strings.HasSuffix(buf.String(), test.expected+"\n") {
+			t.Fatalf("%s", buf.String())
+		}
+	}
+}
+
+func TestViewer(t *testing.T) {
+	m := &Matrix{Names: []string{"a", "b"}, Dist: [][]float64{{0, 2}, {2, 0}}}
+	srv := httptest.NewServer(NewViewer(m, UPGMA(m)))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/data.json")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Labels []string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if strings.Join(v.Labels, ",") != "a,b" {
+		t.Fatalf("%+v", v)
+	}
+
+	for path, code := range map[string]int{"/": 200, "/missing": 404} {
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("%s %d", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package phylo
+
+// Impute estimates the distances of the matrix that are not known, where known[i][j] is whether the distance between the i-th and the j-th taxa is known.
+// A distance is estimated as the mean of the known distances plus the effects of the two taxa, which are fitted to the known distances by least squares,
+// so that a taxon that is far from the others it was compared with is estimated to be far from the rest too.
+// A taxon with no known distances has no effect, and its distances are estimated as the mean.
+func Impute(m *Matrix, known [][]bool) {
+	n := len(m.Names)
+	var mean float64
+	var count int
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if known[i][j] {
+				mean += m.Dist[i][j]
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return
+	}
+	mean /= float64(count)
+
+	// Fit the effects by backfitting, which converges in a few iterations for distances.
+	const iterations = 20
+	effects := make([]float64, n)
+	for it := 0; it < iterations; it++ {
+		for i := 0; i < n; i++ {
+			var sum float64
+			var k int
+			for j := 0; j < n; j++ {
+				if i != j && known[i][j] {
+					sum += m.Dist[i][j] - mean - effects[j]
+					k++
+				}
+			}
+			if k > 0 {
+				effects[i] = sum / float64(k)
+			}
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if !known[i][j] {
+				d := mean + effects[i] + effects[j]
+				if d < 0 {
+					d = 0
+				}
+				m.Dist[i][j], m.Dist[j][i] = d, d
+			}
+		}
+	}
+}
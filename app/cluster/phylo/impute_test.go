@@ -0,0 +1,42 @@
+package phylo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestImpute(t *testing.T) {
+	// Distances that are the sums of the effects of the taxa are recovered exactly.
+	effects := []float64{0.1, 0.2, 0.05, 0.3, 0.15, 0.25}
+	n := len(effects)
+	m := &Matrix{Names: make([]string, n), Dist: make([][]float64, n)}
+	known := make([][]bool, n)
+	for i := range m.Dist {
+		m.Dist[i] = make([]float64, n)
+		known[i] = make([]bool, n)
+		for j := range m.Dist[i] {
+			if i != j {
+				m.Dist[i][j] = 0.5 + effects[i] + effects[j]
+			}
+			// Hide every third distance.
+			known[i][j] = (i+j)%3 != 0
+		}
+	}
+	expected := copyDist(m.Dist)
+	for i := range m.Dist {
+		for j := range m.Dist[i] {
+			if !known[i][j] {
+				m.Dist[i][j] = -1
+			}
+		}
+	}
+
+	Impute(m, known)
+	for i := range m.Dist {
+		for j := range m.Dist[i] {
+			if i != j && math.Abs(m.Dist[i][j]-expected[i][j]) > 1e-3 {
+				t.Fatalf("%d %d %f %f", i, j, m.Dist[i][j], expected[i][j])
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package phylo writes the distance matrices computed by the cluster tool in the formats of common analysis tools, builds phylogenetic trees from them, and draws both in a browser.
+package phylo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Matrix is a symmetric matrix of the distances between named taxa, whose diagonal is zero.
+type Matrix struct {
+	// Names are the names of the taxa.
+	Names []string
+
+	// Dist holds the distances between the taxa, with Dist[i][j] the distance between the i-th and the j-th taxa.
+	Dist [][]float64
+}
+
+// NewMatrix returns the matrix of the distances between the named taxa, given by the upper triangle of the matrix in row-major order, that is the distances between the pairs (0, 1), (0, 2), ..., (1, 2), and so on.
+func NewMatrix(names []string, upper []float64) (*Matrix, error) {
+	n := len(names)
+	if len(upper) != n*(n-1)/2 {
+		return nil, errors.Errorf("%d distances for %d taxa", len(upper), n)
+	}
+	m := &Matrix{Names: names, Dist: make([][]float64, n)}
+	for i := range m.Dist {
+		m.Dist[i] = make([]float64, n)
+	}
+	var k int
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			m.Dist[i][j] = upper[k]
+			m.Dist[j][i] = upper[k]
+			k++
+		}
+	}
+	return m, nil
+}
+
+// Formats are the formats accepted by Write.
+var Formats = []string{"csv", "json", "phylip", "phylip-lower"}
+
+// Write writes the matrix to w in the given format, which is one of Formats.
+func (m *Matrix) Write(w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		return m.WriteCSV(w)
+	case "json":
+		return m.WriteJSON(w)
+	case "phylip":
+		return m.WritePhylip(w, false)
+	case "phylip-lower":
+		return m.WritePhylip(w, true)
+	}
+	return errors.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// WriteCSV writes the matrix to w as CSV, whose first row and first column are the names of the taxa, as read by R's read.csv with row.names=1 or pandas' read_csv with index_col=0.
+func (m *Matrix) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{""}, m.Names...)); err != nil {
+		return errors.Wrap(err, "")
+	}
+	for i, row := range m.Dist {
+		record := []string{m.Names[i]}
+		for _, d := range row {
+			record = append(record, formatDist(d))
+		}
+		if err := cw.Write(record); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "")
+}
+
+// WriteJSON writes the matrix to w as a JSON object, whose "names" are the names of the taxa and whose "distances" are the rows of the matrix.
+func (m *Matrix) WriteJSON(w io.Writer) error {
+	v := struct {
+		Names     []string    `json:"names"`
+		Distances [][]float64 `json:"distances"`
+	}{Names: m.Names, Distances: m.Dist}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(v), "")
+}
+
+// WritePhylip writes the matrix to w in the PHYLIP distance matrix format, as read by the neighbor and fitch programs of PHYLIP and by Biopython and scikit-bio.
+// The first line is the number of taxa, and each following line is the name of a taxon padded to ten characters, followed by its distances to the taxa,
+// or only to the taxa before it if lower is true.
+// Names longer than ten characters are followed by a space, as in the relaxed PHYLIP format.
+func (m *Matrix) WritePhylip(w io.Writer, lower bool) error {
+	if _, err := fmt.Fprintf(w, "%d\n", len(m.Names)); err != nil {
+		return errors.Wrap(err, "")
+	}
+	for i, row := range m.Dist {
+		name := m.Names[i]
+		if strings.ContainsAny(name, " \t\n") {
+			return errors.Errorf("name %q has spaces", name)
+		}
+		line := fmt.Sprintf("%-10s", name)
+		if len(name) >= 10 {
+			line += " "
+		}
+		if lower {
+			row = row[:i]
+		}
+		for j, d := range row {
+			if j > 0 {
+				line += " "
+			}
+			line += formatDist(d)
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", strings.TrimRight(line, " ")); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	return nil
+}
+
+// formatDist formats a distance with the fewest digits that represent it exactly.
+func formatDist(d float64) string {
+	return strconv.FormatFloat(d, 'f', -1, 64)
+}
@@ -0,0 +1,58 @@
+package phylo
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMatrix(t *testing.T) {
+	names := []string{"cat", "chimpanzee", "cricetulusGriseus"}
+	m, err := NewMatrix(names, []float64{0.5, 0.25, 0.75})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if expected := [][]float64{{0, 0.5, 0.25}, {0.5, 0, 0.75}, {0.25, 0.75, 0}}; !reflect.DeepEqual(m.Dist, expected) {
+		t.Fatalf("%v", m.Dist)
+	}
+	if _, err := NewMatrix(names, []float64{0.5}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	for _, test := range []struct {
+		format   string
+		expected string
+	}{
+		{format: "csv", expected: ",cat,chimpanzee,cricetulusGriseus\ncat,0,0.5,0.25\nchimpanzee,0.5,0,0.75\ncricetulusGriseus,0.25,0.75,0\n"},
+		{format: "phylip", expected: "3\ncat       0 0.5 0.25\nchimpanzee 0.5 0 0.75\ncricetulusGriseus 0.25 0.75 0\n"},
+		{format: "phylip-lower", expected: "3\ncat\nchimpanzee 0.5\ncricetulusGriseus 0.25 0.75\n"},
+	} {
+		buf := bytes.NewBuffer(nil)
+		if err := m.Write(buf, test.format); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if buf.String() != test.expected {
+			t.Fatalf("%s: %q", test.format, buf.String())
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := m.Write(buf, "json"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var v struct {
+		Names     []string
+		Distances [][]float64
+	}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(v.Names, m.Names) || !reflect.DeepEqual(v.Distances, m.Dist) {
+		t.Fatalf("%+v", v)
+	}
+
+	if err := m.Write(buf, "xml"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
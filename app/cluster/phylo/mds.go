@@ -0,0 +1,213 @@
+package phylo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// An Embedding holds the coordinates of the taxa in a space of a few dimensions, such as the plane.
+type Embedding struct {
+	// Names are the names of the taxa.
+	Names []string
+
+	// Coords holds the coordinates of the taxa, with Coords[i] those of the i-th taxon.
+	Coords [][]float64
+}
+
+// MDS returns the embedding of the taxa of the matrix in dims dimensions by classical multidimensional scaling, whose Euclidean distances approximate those of the matrix.
+// The coordinates are the eigenvectors of the largest eigenvalues of the double centered matrix of the squared distances, scaled by the square roots of the eigenvalues,
+// and are zero in the dimensions whose eigenvalues are negligible or negative, which non-Euclidean distances may give.
+func MDS(m *Matrix, dims int) *Embedding {
+	n := len(m.Names)
+	e := &Embedding{Names: m.Names, Coords: make([][]float64, n)}
+	for i := range e.Coords {
+		e.Coords[i] = make([]float64, dims)
+	}
+	if n == 0 {
+		return e
+	}
+
+	// b is -1/2 J D^2 J, where J centers the rows and columns.
+	b := make([][]float64, n)
+	rowMeans := make([]float64, n)
+	var mean float64
+	for i := range b {
+		b[i] = make([]float64, n)
+		for j := range b[i] {
+			b[i][j] = m.Dist[i][j] * m.Dist[i][j]
+			rowMeans[i] += b[i][j] / float64(n)
+		}
+		mean += rowMeans[i] / float64(n)
+	}
+	for i := range b {
+		for j := range b[i] {
+			b[i][j] = -(b[i][j] - rowMeans[i] - rowMeans[j] + mean) / 2
+		}
+	}
+
+	// Eigenvalues smaller than this fraction of the largest are rounding errors of zero.
+	const negligible = 1e-9
+	var first float64
+	for d := 0; d < dims && d < n; d++ {
+		value, vector := largestEigen(b)
+		if d == 0 {
+			first = value
+		}
+		if value <= negligible*first {
+			break
+		}
+		for i := range e.Coords {
+			e.Coords[i][d] = vector[i] * math.Sqrt(value)
+		}
+		// Deflate b, so that the next dimension is the eigenvector of the next largest eigenvalue.
+		for i := range b {
+			for j := range b[i] {
+				b[i][j] -= value * vector[i] * vector[j]
+			}
+		}
+	}
+	return e
+}
+
+// largestEigen returns the largest eigenvalue of the symmetric matrix a and its unit eigenvector, whose largest component in absolute value is positive.
+// It runs the power iteration on a shifted by the bound of Gershgorin on its eigenvalues, so that the largest eigenvalue, rather than the largest in absolute value, is found.
+func largestEigen(a [][]float64) (float64, []float64) {
+	n := len(a)
+	var shift float64
+	for _, row := range a {
+		var sum float64
+		for _, x := range row {
+			sum += math.Abs(x)
+		}
+		shift = math.Max(shift, sum)
+	}
+
+	// Start from a vector that is unlikely to be orthogonal to the eigenvector, deterministically.
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = 1 + math.Sin(float64(i+1))
+	}
+	normalize(v)
+	const maxIterations, tolerance = 10000, 1e-12
+	w := make([]float64, n)
+	for it := 0; it < maxIterations; it++ {
+		for i, row := range a {
+			w[i] = shift * v[i]
+			for j, x := range row {
+				w[i] += x * v[j]
+			}
+		}
+		normalize(w)
+		var diff float64
+		for i := range v {
+			diff = math.Max(diff, math.Abs(w[i]-v[i]))
+		}
+		v, w = w, v
+		if diff < tolerance {
+			break
+		}
+	}
+
+	var value float64
+	for i, row := range a {
+		for j, x := range row {
+			value += v[i] * x * v[j]
+		}
+	}
+	largest := 0
+	for i := range v {
+		if math.Abs(v[i]) > math.Abs(v[largest]) {
+			largest = i
+		}
+	}
+	if v[largest] < 0 {
+		for i := range v {
+			v[i] = -v[i]
+		}
+	}
+	return value, v
+}
+
+// normalize scales v to unit length, unless it is zero.
+func normalize(v []float64) {
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	if norm = math.Sqrt(norm); norm == 0 {
+		return
+	}
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// EmbeddingFormats are the formats accepted by Embedding.Write.
+var EmbeddingFormats = []string{"csv", "json"}
+
+// Write writes the embedding to w in the given format, which is one of EmbeddingFormats.
+func (e *Embedding) Write(w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		return e.WriteCSV(w)
+	case "json":
+		return e.WriteJSON(w)
+	}
+	return errors.Errorf("unknown format %q, expected csv or json", format)
+}
+
+// WriteCSV writes the embedding to w as CSV, whose header is name followed by x, y, and z, or d1, d2, and so on beyond three dimensions, and whose rows are the names of the taxa and their coordinates.
+func (e *Embedding) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"name"}, e.axes()...)); err != nil {
+		return errors.Wrap(err, "")
+	}
+	for i, coords := range e.Coords {
+		record := []string{e.Names[i]}
+		for _, x := range coords {
+			record = append(record, formatDist(x))
+		}
+		if err := cw.Write(record); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "")
+}
+
+// WriteJSON writes the embedding to w as a JSON array of objects of the "name" of a taxon and its coordinates, keyed as the columns of WriteCSV, as read by D3 scatter plots.
+func (e *Embedding) WriteJSON(w io.Writer) error {
+	axes := e.axes()
+	points := make([]map[string]interface{}, len(e.Coords))
+	for i, coords := range e.Coords {
+		points[i] = map[string]interface{}{"name": e.Names[i]}
+		for d, x := range coords {
+			points[i][axes[d]] = x
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(points), "")
+}
+
+// axes returns the names of the dimensions of the embedding.
+func (e *Embedding) axes() []string {
+	var dims int
+	if len(e.Coords) > 0 {
+		dims = len(e.Coords[0])
+	}
+	axes := make([]string, dims)
+	for d := range axes {
+		if dims <= 3 {
+			axes[d] = string("xyz"[d])
+		} else {
+			axes[d] = "d" + strconv.Itoa(d+1)
+		}
+	}
+	return axes
+}
@@ -0,0 +1,50 @@
+package phylo
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestMDS(t *testing.T) {
+	// The distances between points of the plane are recovered by their embedding in the plane.
+	points := [][]float64{{0, 0}, {3, 0}, {0, 4}, {1, 1}, {-2, 3}}
+	m := &Matrix{Names: []string{"a", "b", "c", "d", "e"}, Dist: make([][]float64, len(points))}
+	for i, p := range points {
+		m.Dist[i] = make([]float64, len(points))
+		for j, q := range points {
+			m.Dist[i][j] = math.Hypot(p[0]-q[0], p[1]-q[1])
+		}
+	}
+	e := MDS(m, 2)
+	for i, p := range e.Coords {
+		for j, q := range e.Coords {
+			if d := math.Hypot(p[0]-q[0], p[1]-q[1]); math.Abs(d-m.Dist[i][j]) > 1e-6 {
+				t.Fatalf("%d %d %f %f", i, j, d, m.Dist[i][j])
+			}
+		}
+	}
+
+	// A third dimension of planar points is zero.
+	for _, p := range MDS(m, 3).Coords {
+		if math.Abs(p[2]) > 1e-6 {
+			t.Fatalf("%v", p)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	e = &Embedding{Names: []string{"a", "b"}, Coords: [][]float64{{0.5, -1}, {0, 2}}}
+	if err := e.Write(buf, "csv"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if buf.String() != "name,x,y\na,0.5,-1\nb,0,2\n" {
+		t.Fatalf("%q", buf.String())
+	}
+	buf.Reset()
+	if err := e.Write(buf, "json"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"name": "a",`)) || !bytes.Contains(buf.Bytes(), []byte(`"y": -1`)) {
+		t.Fatalf("%s", buf.String())
+	}
+}
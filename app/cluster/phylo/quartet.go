@@ -0,0 +1,282 @@
+package phylo
+
+import (
+	"math"
+	"math/rand"
+)
+
+// QuartetTree returns the unrooted ternary tree that best fits the matrix by the quartet method of Cilibrasi and Vitanyi in Clustering by Compression, as implemented by CompLearn, and its score.
+// The cost of a tree is the sum, over every four taxa u, v, w, x, of the distance d(u, v) + d(w, x) of the pairing uv|wx that the tree is consistent with,
+// and the score S(T) = (M - C(T)) / (M - m) normalizes the cost C(T) of the tree between the largest and smallest sums M and m of the costs of the quartets, so that 1 is a perfect fit.
+// The tree is searched by simulated annealing over steps random mutations of leaf swaps, subtree swaps, and subtree transfers, starting from a random tree drawn from rng.
+// The tree is rooted at an inner node for writing, and has no branch lengths.
+func QuartetTree(m *Matrix, steps int, rng *rand.Rand) (*Tree, float64) {
+	n := len(m.Names)
+	if n < 4 {
+		tree := NeighborJoining(m)
+		tree.walk(func(t *Tree) { t.Length = math.NaN() })
+		return tree, 1
+	}
+
+	q := newQuartets(m)
+	cur := randomTopology(n, rng)
+	curScore := q.score(cur)
+	best, bestScore := cur, curScore
+	// The temperature cools geometrically from that at which a mutation that loses a hundredth of the score is usually accepted, to that at which it almost never is.
+	const tempStart, tempEnd = 1e-2, 1e-5
+	for i := 0; i < steps && bestScore < 1; i++ {
+		temp := tempStart * math.Pow(tempEnd/tempStart, float64(i)/float64(steps))
+		next := cur.clone()
+		// As in CompLearn, a step makes k mutations with probability decreasing in k, so that the search can escape local optima.
+		next.mutate(rng)
+		for rng.Intn(2) == 0 {
+			next.mutate(rng)
+		}
+		nextScore := q.score(next)
+		if nextScore >= curScore || rng.Float64() < math.Exp((nextScore-curScore)/temp) {
+			cur, curScore = next, nextScore
+		}
+		if curScore > bestScore {
+			best, bestScore = cur, curScore
+		}
+	}
+	return best.tree(m.Names), bestScore
+}
+
+// walk calls f on every node of the tree.
+func (t *Tree) walk(f func(*Tree)) {
+	f(t)
+	for _, c := range t.Children {
+		c.walk(f)
+	}
+}
+
+// quartets holds the costs of the quartets of the taxa of a matrix.
+type quartets struct {
+	dist [][]float64
+	// min and max are the sums over the quartets of the smallest and largest costs of their pairings.
+	min, max float64
+}
+
+func newQuartets(m *Matrix) *quartets {
+	q := &quartets{dist: m.Dist}
+	q.each(func(u, v, w, x int) {
+		c := [3]float64{q.dist[u][v] + q.dist[w][x], q.dist[u][w] + q.dist[v][x], q.dist[u][x] + q.dist[v][w]}
+		q.min += math.Min(c[0], math.Min(c[1], c[2]))
+		q.max += math.Max(c[0], math.Max(c[1], c[2]))
+	})
+	return q
+}
+
+// each calls f on every four taxa u < v < w < x.
+func (q *quartets) each(f func(u, v, w, x int)) {
+	n := len(q.dist)
+	for u := 0; u < n; u++ {
+		for v := u + 1; v < n; v++ {
+			for w := v + 1; w < n; w++ {
+				for x := w + 1; x < n; x++ {
+					f(u, v, w, x)
+				}
+			}
+		}
+	}
+}
+
+// score returns the normalized score S(T) of the tree t.
+func (q *quartets) score(t *topology) float64 {
+	if q.max == q.min {
+		return 1
+	}
+	// The tree is consistent with the pairing uv|wx of a quartet if the paths between u and v and between w and x are disjoint,
+	// which is when they are shorter than the paths of the other pairings.
+	hops := t.leafHops()
+	var cost float64
+	q.each(func(u, v, w, x int) {
+		uv, uw, ux := hops[u][v]+hops[w][x], hops[u][w]+hops[v][x], hops[u][x]+hops[v][w]
+		switch {
+		case uv < uw && uv < ux:
+			cost += q.dist[u][v] + q.dist[w][x]
+		case uw < ux:
+			cost += q.dist[u][w] + q.dist[v][x]
+		default:
+			cost += q.dist[u][x] + q.dist[v][w]
+		}
+	})
+	return (q.max - cost) / (q.max - q.min)
+}
+
+// A topology is an unrooted ternary tree, whose nodes 0 to n-1 are the leaves of the n taxa and whose nodes n to 2n-3 are the inner nodes.
+// adj holds the neighbors of each node, one for a leaf and three for an inner node.
+type topology struct {
+	n   int
+	adj [][]int
+}
+
+// randomTopology returns a random tree of n taxa, which adds the taxa one by one to random edges.
+func randomTopology(n int, rng *rand.Rand) *topology {
+	t := &topology{n: n, adj: make([][]int, 2*n-2)}
+	t.adj[0], t.adj[1], t.adj[2] = []int{n}, []int{n}, []int{n}
+	t.adj[n] = []int{0, 1, 2}
+	for leaf, inner := 3, n+1; leaf < n; leaf, inner = leaf+1, inner+1 {
+		edges := t.edges(nil)
+		e := edges[rng.Intn(len(edges))]
+		t.insert(inner, leaf, e[0], e[1])
+	}
+	return t
+}
+
+func (t *topology) clone() *topology {
+	c := &topology{n: t.n, adj: make([][]int, len(t.adj))}
+	for i, nbrs := range t.adj {
+		c.adj[i] = append([]int{}, nbrs...)
+	}
+	return c
+}
+
+// edges returns the edges of the tree, skipping those with a node for which skip is true if skip is not nil.
+func (t *topology) edges(skip []bool) [][2]int {
+	var edges [][2]int
+	for u, nbrs := range t.adj {
+		for _, v := range nbrs {
+			if u < v && (skip == nil || (!skip[u] && !skip[v])) {
+				edges = append(edges, [2]int{u, v})
+			}
+		}
+	}
+	return edges
+}
+
+// insert inserts the inner node a into the edge between x and y, and attaches the node b to it.
+func (t *topology) insert(a, b, x, y int) {
+	t.replace(x, y, a)
+	t.replace(y, x, a)
+	t.adj[a] = []int{b, x, y}
+	if len(t.adj[b]) == 0 {
+		t.adj[b] = []int{a}
+	}
+}
+
+// replace replaces the neighbor old of the node u by nbr.
+func (t *topology) replace(u, old, nbr int) {
+	for i, v := range t.adj[u] {
+		if v == old {
+			t.adj[u][i] = nbr
+			return
+		}
+	}
+}
+
+// subtree returns the nodes of the subtree of the node root, which is on the side of the edge from parent to root.
+func (t *topology) subtree(root, parent int) []bool {
+	in := make([]bool, len(t.adj))
+	stack := []int{root}
+	in[root] = true
+	for len(stack) > 0 {
+		u := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, v := range t.adj[u] {
+			if v != parent && !in[v] {
+				in[v] = true
+				stack = append(stack, v)
+			}
+		}
+	}
+	return in
+}
+
+// randomEdge returns a random edge from a to b.
+func (t *topology) randomEdge(rng *rand.Rand) (int, int) {
+	b := rng.Intn(len(t.adj))
+	return t.adj[b][rng.Intn(len(t.adj[b]))], b
+}
+
+// mutate makes a random leaf swap, subtree swap, or subtree transfer, which are the mutations of CompLearn.
+func (t *topology) mutate(rng *rand.Rand) {
+	switch rng.Intn(3) {
+	case 0:
+		// Swap two leaves.
+		i, j := rng.Intn(t.n), rng.Intn(t.n)
+		t.swap(t.adj[i][0], i, t.adj[j][0], j)
+	case 1:
+		// Swap two disjoint subtrees.
+		a, b := t.randomEdge(rng)
+		c, d := t.randomEdge(rng)
+		if !t.subtree(b, a)[d] && !t.subtree(d, c)[b] {
+			t.swap(a, b, c, d)
+		}
+	default:
+		// Transfer a subtree to another edge.
+		a, b := t.randomEdge(rng)
+		if a < t.n {
+			return
+		}
+		in := t.subtree(b, a)
+		var others []int
+		for _, v := range t.adj[a] {
+			if v != b {
+				others = append(others, v)
+			}
+		}
+		p, q := others[0], others[1]
+		t.replace(p, a, q)
+		t.replace(q, a, p)
+		in[a] = true
+		edges := t.edges(in)
+		e := edges[rng.Intn(len(edges))]
+		t.insert(a, b, e[0], e[1])
+	}
+}
+
+// swap swaps the subtree of b, a neighbor of a, with the subtree of d, a neighbor of c, if the subtrees are disjoint and their parents differ.
+func (t *topology) swap(a, b, c, d int) {
+	if a == c || b == d || a == d || b == c {
+		return
+	}
+	t.replace(a, b, d)
+	t.replace(d, c, a)
+	t.replace(c, d, b)
+	t.replace(b, a, c)
+}
+
+// leafHops returns the numbers of edges on the paths between the leaves.
+func (t *topology) leafHops() [][]int {
+	hops := make([][]int, t.n)
+	depth := make([]int, len(t.adj))
+	for leaf := range hops {
+		for i := range depth {
+			depth[i] = -1
+		}
+		depth[leaf] = 0
+		queue := []int{leaf}
+		for len(queue) > 0 {
+			u := queue[0]
+			queue = queue[1:]
+			for _, v := range t.adj[u] {
+				if depth[v] < 0 {
+					depth[v] = depth[u] + 1
+					queue = append(queue, v)
+				}
+			}
+		}
+		hops[leaf] = append([]int{}, depth[:t.n]...)
+	}
+	return hops
+}
+
+// tree returns the tree rooted at the first inner node, whose leaves are named by names.
+func (t *topology) tree(names []string) *Tree {
+	var build func(u, parent int) *Tree
+	build = func(u, parent int) *Tree {
+		node := &Tree{Length: math.NaN()}
+		if u < t.n {
+			node.Name = names[u]
+		}
+		for _, v := range t.adj[u] {
+			if v != parent {
+				node.Children = append(node.Children, build(v, u))
+			}
+		}
+		return node
+	}
+	return build(t.n, -1)
+}
@@ -0,0 +1,90 @@
+package phylo
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestQuartetTree(t *testing.T) {
+	// The distances between the leaves of ((a,b),(c,d)),(e,(f,(g,h))), whose branches have unit length.
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	tree := &Tree{Children: []*Tree{
+		{Children: []*Tree{
+			{Children: []*Tree{{Name: "a"}, {Name: "b"}}},
+			{Children: []*Tree{{Name: "c"}, {Name: "d"}}},
+		}},
+		{Name: "e"},
+		{Children: []*Tree{{Name: "f"}, {Children: []*Tree{{Name: "g"}, {Name: "h"}}}}},
+	}}
+	tree.walk(func(t *Tree) { t.Length = 1 })
+	expected := leafDistances(tree)
+	m := &Matrix{Names: names, Dist: make([][]float64, len(names))}
+	for i, x := range names {
+		m.Dist[i] = make([]float64, len(names))
+		for j, y := range names {
+			m.Dist[i][j] = expected[x][y]
+		}
+	}
+
+	found, score := QuartetTree(m, 2000, rand.New(rand.NewSource(1)))
+	if score != 1 {
+		t.Fatalf("%f %s", score, found.Newick())
+	}
+	found.walk(func(t *Tree) { t.Length = 1 })
+	dist := leafDistances(found)
+	for i, x := range names {
+		for j, y := range names {
+			if dist[x][y] != m.Dist[i][j] {
+				t.Fatalf("%s %s %f %s", x, y, dist[x][y], found.Newick())
+			}
+		}
+	}
+
+	for _, names := range [][]string{{"a"}, {"a", "b", "c"}} {
+		m := &Matrix{Names: names, Dist: make([][]float64, len(names))}
+		for i := range m.Dist {
+			m.Dist[i] = make([]float64, len(names))
+		}
+		found, score := QuartetTree(m, 10, rand.New(rand.NewSource(1)))
+		if score != 1 || len(leafDistances(found)) != len(names) {
+			t.Fatalf("%v %f %s", names, score, found.Newick())
+		}
+	}
+}
+
+func TestTopologyMutate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const n = 12
+	top := randomTopology(n, rng)
+	for i := 0; i < 10000; i++ {
+		top.mutate(rng)
+		for u, nbrs := range top.adj {
+			if degree := len(nbrs); (u < n && degree != 1) || (u >= n && degree != 3) {
+				t.Fatalf("%d: node %d %v", i, u, nbrs)
+			}
+			for _, v := range nbrs {
+				var back int
+				for _, w := range top.adj[v] {
+					if w == u {
+						back++
+					}
+				}
+				if v == u || back != 1 {
+					t.Fatalf("%d: edge %d %d %v", i, u, v, top.adj)
+				}
+			}
+		}
+		if in := top.subtree(0, -1); len(top.edges(nil)) != len(top.adj)-1 || !all(in) {
+			t.Fatalf("%d: not a tree %v", i, top.adj)
+		}
+	}
+}
+
+func all(in []bool) bool {
+	for _, b := range in {
+		if !b {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package phylo
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Tree is a node of a phylogenetic tree, whose leaves are taxa.
+type Tree struct {
+	// Name is the name of the taxon of a leaf, and is empty for an inner node.
+	Name string
+
+	// Length is the length of the branch to the parent of the node, or NaN if the method that built the tree does not give lengths.
+	Length float64
+
+	// Children are the children of an inner node.
+	Children []*Tree
+}
+
+// Newick returns the tree in the Newick format, as read by most phylogenetics software.
+func (t *Tree) Newick() string {
+	buf := bytes.NewBuffer(nil)
+	t.writeNewick(buf, true)
+	buf.WriteString(";")
+	return buf.String()
+}
+
+// WriteNewick writes the tree to w in the Newick format, followed by a newline.
+func (t *Tree) WriteNewick(w io.Writer) error {
+	_, err := io.WriteString(w, t.Newick()+"\n")
+	return errors.Wrap(err, "")
+}
+
+func (t *Tree) writeNewick(buf *bytes.Buffer, root bool) {
+	if len(t.Children) > 0 {
+		buf.WriteString("(")
+		for i, c := range t.Children {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			c.writeNewick(buf, false)
+		}
+		buf.WriteString(")")
+	}
+	buf.WriteString(newickName(t.Name))
+	if !root && !math.IsNaN(t.Length) {
+		buf.WriteString(":" + strconv.FormatFloat(t.Length, 'g', -1, 64))
+	}
+}
+
+// newickName returns name quoted as required by the Newick format, if it has any of the characters that delimit the tree.
+func newickName(name string) string {
+	if !strings.ContainsAny(name, "()[]':;, \t\n") {
+		return name
+	}
+	return "'" + strings.Replace(name, "'", "''", -1) + "'"
+}
+
+// UPGMA returns the rooted tree built from the matrix by the unweighted pair group method with arithmetic mean.
+// Each step joins the two closest clusters under a node at half their distance, and the distance from the joined cluster to another is the mean of the distances between their taxa,
+// so that the tree is ultrametric, with all leaves equally far from the root.
+func UPGMA(m *Matrix) *Tree {
+	n := len(m.Names)
+	if n == 0 {
+		return &Tree{}
+	}
+	dist := copyDist(m.Dist)
+	nodes := leaves(m)
+	sizes := make([]int, n)
+	heights := make([]float64, n)
+	for i := range sizes {
+		sizes[i] = 1
+	}
+	active := activeIndexes(n)
+	for len(active) > 1 {
+		a, b := closest(active, func(i, j int) float64 { return dist[i][j] })
+		height := dist[a][b] / 2
+		nodes[a].Length = math.Max(0, height-heights[a])
+		nodes[b].Length = math.Max(0, height-heights[b])
+		nodes[a] = &Tree{Children: []*Tree{nodes[a], nodes[b]}}
+		for _, k := range active {
+			if k != a && k != b {
+				d := (dist[a][k]*float64(sizes[a]) + dist[b][k]*float64(sizes[b])) / float64(sizes[a]+sizes[b])
+				dist[a][k], dist[k][a] = d, d
+			}
+		}
+		sizes[a] += sizes[b]
+		heights[a] = height
+		active = remove(active, b)
+	}
+	return nodes[active[0]]
+}
+
+// NeighborJoining returns the tree built from the matrix by the neighbor-joining method of Saitou and Nei.
+// Each step joins the pair of nodes that minimizes the total length of the tree, which recovers the tree of an additive matrix exactly.
+// Since the method builds an unrooted tree, the root is the node joining the last three nodes.
+// Negative branch lengths, which non-additive matrices may give, are set to zero.
+func NeighborJoining(m *Matrix) *Tree {
+	n := len(m.Names)
+	if n == 0 {
+		return &Tree{}
+	}
+	dist := copyDist(m.Dist)
+	nodes := leaves(m)
+	active := activeIndexes(n)
+	for len(active) > 3 {
+		r := make([]float64, n)
+		for _, i := range active {
+			for _, k := range active {
+				r[i] += dist[i][k]
+			}
+		}
+		q := float64(len(active) - 2)
+		a, b := closest(active, func(i, j int) float64 { return q*dist[i][j] - r[i] - r[j] })
+		la := dist[a][b]/2 + (r[a]-r[b])/(2*q)
+		nodes[a].Length = math.Max(0, la)
+		nodes[b].Length = math.Max(0, dist[a][b]-la)
+		for _, k := range active {
+			if k != a && k != b {
+				d := (dist[a][k] + dist[b][k] - dist[a][b]) / 2
+				dist[a][k], dist[k][a] = d, d
+			}
+		}
+		nodes[a] = &Tree{Children: []*Tree{nodes[a], nodes[b]}}
+		active = remove(active, b)
+	}
+
+	switch len(active) {
+	case 1:
+		return nodes[active[0]]
+	case 2:
+		a, b := active[0], active[1]
+		nodes[a].Length = dist[a][b] / 2
+		nodes[b].Length = dist[a][b] / 2
+		return &Tree{Children: []*Tree{nodes[a], nodes[b]}}
+	}
+	a, b, c := active[0], active[1], active[2]
+	nodes[a].Length = math.Max(0, (dist[a][b]+dist[a][c]-dist[b][c])/2)
+	nodes[b].Length = math.Max(0, (dist[a][b]+dist[b][c]-dist[a][c])/2)
+	nodes[c].Length = math.Max(0, (dist[a][c]+dist[b][c]-dist[a][b])/2)
+	return &Tree{Children: []*Tree{nodes[a], nodes[b], nodes[c]}}
+}
+
+// copyDist returns a copy of dist, which the tree building methods update as they join nodes.
+func copyDist(dist [][]float64) [][]float64 {
+	c := make([][]float64, len(dist))
+	for i, row := range dist {
+		c[i] = append([]float64{}, row...)
+	}
+	return c
+}
+
+// leaves returns the leaves of the taxa of the matrix.
+func leaves(m *Matrix) []*Tree {
+	nodes := make([]*Tree, len(m.Names))
+	for i, name := range m.Names {
+		nodes[i] = &Tree{Name: name}
+	}
+	return nodes
+}
+
+// activeIndexes returns the indexes of n nodes yet to be joined.
+func activeIndexes(n int) []int {
+	active := make([]int, n)
+	for i := range active {
+		active[i] = i
+	}
+	return active
+}
+
+// closest returns the pair of active nodes, with the first before the second, that minimizes the criterion.
+// Ties are broken in favour of the first pair in row-major order, so that the tree is deterministic.
+func closest(active []int, criterion func(i, j int) float64) (int, int) {
+	a, b := active[0], active[1]
+	best := math.Inf(1)
+	for x, i := range active {
+		for _, j := range active[x+1:] {
+			if c := criterion(i, j); c < best {
+				a, b, best = i, j, c
+			}
+		}
+	}
+	return a, b
+}
+
+// remove returns active without node.
+func remove(active []int, node int) []int {
+	for i, k := range active {
+		if k == node {
+			return append(active[:i], active[i+1:]...)
+		}
+	}
+	return active
+}
@@ -0,0 +1,95 @@
+package phylo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUPGMA(t *testing.T) {
+	m := &Matrix{
+		Names: []string{"a", "b", "c", "d"},
+		Dist:  [][]float64{{0, 2, 6, 10}, {2, 0, 6, 10}, {6, 6, 0, 10}, {10, 10, 10, 0}},
+	}
+	if s := UPGMA(m).Newick(); s != "(((a:1,b:1):2,c:3):2,d:5);" {
+		t.Fatalf("%s", s)
+	}
+}
+
+func TestNeighborJoining(t *testing.T) {
+	// The additive matrix of the example in Wikipedia's article on neighbor joining.
+	m := &Matrix{
+		Names: []string{"a", "b", "c", "d", "e"},
+		Dist: [][]float64{
+			{0, 5, 9, 9, 8},
+			{5, 0, 10, 10, 9},
+			{9, 10, 0, 8, 7},
+			{9, 10, 8, 0, 3},
+			{8, 9, 7, 3, 0},
+		},
+	}
+	tree := NeighborJoining(m)
+	dist := leafDistances(tree)
+	for i, x := range m.Names {
+		for j, y := range m.Names {
+			if i != j && math.Abs(dist[x][y]-m.Dist[i][j]) > 1e-9 {
+				t.Fatalf("%s %s %f %s", x, y, dist[x][y], tree.Newick())
+			}
+		}
+	}
+
+	if s := NeighborJoining(&Matrix{Names: []string{"a"}, Dist: [][]float64{{0}}}).Newick(); s != "a;" {
+		t.Fatalf("%s", s)
+	}
+	if s := NeighborJoining(&Matrix{Names: []string{"a", "b"}, Dist: [][]float64{{0, 2}, {2, 0}}}).Newick(); s != "(a:1,b:1);" {
+		t.Fatalf("%s", s)
+	}
+}
+
+func TestNewick(t *testing.T) {
+	tree := &Tree{Children: []*Tree{
+		{Name: "homo sapiens", Length: 0.5},
+		{Name: "it's", Length: 0.25},
+		{Name: "cat", Length: 1},
+	}}
+	if s := tree.Newick(); s != "('homo sapiens':0.5,'it''s':0.25,cat:1);" {
+		t.Fatalf("%s", s)
+	}
+}
+
+// leafDistances returns the lengths of the paths between the leaves of tree.
+func leafDistances(tree *Tree) map[string]map[string]float64 {
+	// paths holds the path from the root to each leaf, as the nodes on it and their depths.
+	type step struct {
+		node  *Tree
+		depth float64
+	}
+	paths := make(map[string][]step)
+	var walk func(t *Tree, path []step)
+	walk = func(t *Tree, path []step) {
+		depth := 0.0
+		if len(path) > 0 {
+			depth = path[len(path)-1].depth + t.Length
+		}
+		path = append(append([]step{}, path...), step{node: t, depth: depth})
+		if len(t.Children) == 0 {
+			paths[t.Name] = path
+		}
+		for _, c := range t.Children {
+			walk(c, path)
+		}
+	}
+	walk(tree, nil)
+
+	dist := make(map[string]map[string]float64)
+	for x, px := range paths {
+		dist[x] = make(map[string]float64)
+		for y, py := range paths {
+			var lca int
+			for lca+1 < len(px) && lca+1 < len(py) && px[lca+1].node == py[lca+1].node {
+				lca++
+			}
+			dist[x][y] = px[len(px)-1].depth + py[len(py)-1].depth - 2*px[lca].depth
+		}
+	}
+	return dist
+}
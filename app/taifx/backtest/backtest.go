@@ -0,0 +1,89 @@
+// Package backtest simulates trading strategies on historical prices, with the bars read from data feeds, the positions decided by agents, and the profit and loss recorded by portfolios.
+package backtest
+
+import (
+	"io"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/pkg/errors"
+)
+
+// An Agent decides the positions to hold from the bars it observes.
+type Agent interface {
+	// Observe informs the agent of the bar that has just closed.
+	Observe(Bar)
+
+	// Act returns the position to hold over the next bar, given the last entry of the portfolio.
+	Act(Entry) int
+}
+
+// Run trades the bars of feed with the positions decided by agent, until the feed ends or the portfolio is bankrupt.
+// For each bar, the agent acts on the last entry of the portfolio before the bar is revealed, the position is recorded in the portfolio, onRecord, if not nil, is called with the new entry, and only then does the agent observe the bar.
+func Run(feed DataFeed, agent Agent, p *Portfolio, onRecord func(Entry)) error {
+	for !p.Bankrupt() {
+		position := agent.Act(p.Last())
+		bar, err := feed.Next()
+		if err != nil {
+			if errors.Cause(err) == io.EOF {
+				return nil
+			}
+			return errors.Wrap(err, "")
+		}
+		entry := p.Record(position, bar)
+		if onRecord != nil {
+			onRecord(entry)
+		}
+		agent.Observe(bar)
+	}
+	return nil
+}
+
+// WalkForward trades bars in windows, each of train bars on which a new agent is trained, followed by test bars on which it trades, and moves the windows forward by test bars until the bars end or the portfolio is bankrupt.
+// The agent of each window is returned by newAgent from the bars it is trained on, and trades with p, which should start at bars[train-1], so that p records the out-of-sample performance across all windows.
+// If guarded, the test bars of each window are fed by a GuardedFeed, which is also passed to newAgent, so that it can guard the model of the agent; otherwise the GuardedFeed passed is nil.
+func WalkForward(bars []Bar, train, test int, newAgent func([]Bar, *GuardedFeed) (Agent, error), p *Portfolio, onRecord func(Entry), guarded bool) error {
+	if train < 0 || test <= 0 {
+		return errors.Errorf("invalid windows of %d training bars and %d test bars", train, test)
+	}
+	for i := train; i < len(bars) && !p.Bankrupt(); i += test {
+		end := i + test
+		if end > len(bars) {
+			end = len(bars)
+		}
+		var feed DataFeed = NewSliceFeed(bars[i:end])
+		var guard *GuardedFeed
+		if guarded {
+			guard = Guard(feed)
+			feed = guard
+		}
+		agent, err := newAgent(bars[i-train:i], guard)
+		if err != nil {
+			return errors.Wrap(err, "")
+		}
+		if err := Run(feed, agent, p, onRecord); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	return nil
+}
+
+// NextStep is an agent that holds the contracts sized by its sizer, long if its model predicts that the price goes up in the next bar, and short otherwise.
+type NextStep struct {
+	Sizer PositionSizer
+	Model ac.Model
+}
+
+// Observe informs the model of the direction of the bar, and the sizer of the bar.
+func (agent *NextStep) Observe(bar Bar) {
+	agent.Model.Observe(bar.Direction)
+	agent.Sizer.Observe(bar)
+}
+
+// Act returns the position predicted by the model.
+func (agent *NextStep) Act(e Entry) int {
+	pos := agent.Sizer.Size(e)
+	if agent.Model.Prob0() > 0.5 {
+		pos = -pos
+	}
+	return pos
+}
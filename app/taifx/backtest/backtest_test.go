@@ -0,0 +1,177 @@
+package backtest
+
+import (
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPortfolioRecord(t *testing.T) {
+	t0 := time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+	p := NewPortfolio(Bar{Time: t0, Price: 100}, 1000, PerContract(0.5))
+	steps := []struct {
+		position int
+		price    float64
+		balance  float64
+	}{
+		// Buy 2 contracts, which gain 2*10 less the cost of 2*0.5.
+		{2, 110, 1019},
+		// Sell 3 contracts to go short 1, which gains 5 less the cost of 3*0.5.
+		{-1, 105, 1022.5},
+		// Hold the short position, which loses 15.
+		{-1, 120, 1007.5},
+		// Close the position.
+		{0, 130, 1007},
+	}
+	for i, s := range steps {
+		e := p.Record(s.position, Bar{Time: t0.Add(time.Duration(i+1) * time.Minute), Price: s.price})
+		if e.Balance != s.balance || p.Last() != e {
+			t.Fatalf("%d %+v %+v", i, e, s)
+		}
+	}
+	if c := p.Contracts(); c != 6 {
+		t.Fatalf("%d", c)
+	}
+	if p.Trials != 3 || p.Corrects != 2 || math.Abs(p.Accuracy()-2.0/3) > 1e-9 {
+		t.Fatalf("%d %d", p.Trials, p.Corrects)
+	}
+	if p.Bankrupt() {
+		t.Fatalf("%+v", p.Last())
+	}
+	if p.Record(100, Bar{Price: 0}); !p.Bankrupt() {
+		t.Fatalf("%+v", p.Last())
+	}
+}
+
+func TestPortfolioMaxHistory(t *testing.T) {
+	p := NewPortfolio(Bar{Price: 1}, 1, nil)
+	p.MaxHistory = 4
+	for i := 0; i < 10; i++ {
+		p.Record(1, Bar{Price: float64(i + 2)})
+		if len(p.History) > p.MaxHistory {
+			t.Fatalf("%d %d", i, len(p.History))
+		}
+	}
+	if e := p.Last(); e.Price != 11 || e.Balance != 11 {
+		t.Fatalf("%+v", e)
+	}
+}
+
+func TestReadRenkoCSV(t *testing.T) {
+	data := `Index,DateTime,Settle,Price,Volume,Threshold,BasePrice,Diff,Renko,Thres
+1041,2011-01-03 08:48:01,201101,9010.0,2,9.0,9000.0,10.0,True,0.0011111111111111111
+2928,2018-01-03 09:00:33,201101,9000.0,4,9.01,9010.0,10.0,False,0.0011098779134295228
+`
+	bars, err := ReadRenkoCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(bars) != 2 || bars[0].Price != 9010 || bars[0].Direction != 1 || bars[1].Direction != 0 {
+		t.Fatalf("%+v", bars)
+	}
+
+	train, test := Split(bars, time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if len(train) != 1 || len(test) != 1 || test[0] != bars[1] {
+		t.Fatalf("%+v %+v", train, test)
+	}
+
+	if _, err := ReadRenkoCSV(strings.NewReader(strings.Replace(data, "False", "Maybe", 1))); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCandleReader(t *testing.T) {
+	data := `Date,Time,Open,High,Low,Close,Volume
+01/02/2015,09:30,2050.25,2051,2049.5,2050.75,1234
+01/02/2015,09:31,2050.75,2051,2049,2049.5,567
+`
+	cr, err := NewCandleReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	c, err := cr.Read()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := Candle{Time: time.Date(2015, time.January, 2, 9, 30, 0, 0, time.UTC), Open: 2050.25, High: 2051, Low: 2049.5, Close: 2050.75, Volume: 1234}
+	if c != want {
+		t.Fatalf("%+v", c)
+	}
+	bar, err := cr.Next()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if bar.Price != 2049.5 || bar.Direction != 0 {
+		t.Fatalf("%+v", bar)
+	}
+	if _, err := cr.Next(); err != io.EOF {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// constModel predicts that the next bit is zero with a fixed probability, and counts the observed bits.
+type constModel struct {
+	prob0    float64
+	observed int
+}
+
+func (m *constModel) Prob0() float64  { return m.prob0 }
+func (m *constModel) Observe(bit int) { m.observed++ }
+
+func TestRun(t *testing.T) {
+	bars := []Bar{{Price: 11, Direction: 1}, {Price: 12, Direction: 1}, {Price: 10, Direction: 0}}
+	model := &constModel{prob0: 0.2}
+	agent := &NextStep{Sizer: FixedFractional(1), Model: model}
+	p := NewPortfolio(Bar{Price: 10}, 100, nil)
+	var recorded []Entry
+	if err := Run(NewSliceFeed(bars), agent, p, func(e Entry) { recorded = append(recorded, e) }); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(recorded) != 3 || model.observed != 3 {
+		t.Fatalf("%+v %d", recorded, model.observed)
+	}
+	// The agent stays long 10 contracts, as 110/11 and 120/12 are 10 too.
+	if e := p.Last(); e.Position != 10 || e.Balance != 100 {
+		t.Fatalf("%+v", e)
+	}
+
+	// A short position bankrupts the portfolio, after which the rest of the bars are not traded.
+	model.prob0 = 0.8
+	feed := NewSliceFeed([]Bar{{Price: 30}, {Price: 40}})
+	p = NewPortfolio(Bar{Price: 10}, 100, nil)
+	if err := Run(feed, agent, p, nil); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !p.Bankrupt() || feed.Cursor != 1 {
+		t.Fatalf("%+v %d", p.Last(), feed.Cursor)
+	}
+}
+
+func TestWalkForward(t *testing.T) {
+	var bars []Bar
+	for i := 0; i < 10; i++ {
+		bars = append(bars, Bar{Price: float64(10 + i), Direction: 1})
+	}
+	var windows [][]Bar
+	newAgent := func(train []Bar, guard *GuardedFeed) (Agent, error) {
+		windows = append(windows, train)
+		return &NextStep{Sizer: FixedFractional(1), Model: guard.Model(&constModel{prob0: 0.2})}, nil
+	}
+	p := NewPortfolio(bars[2], 1000, nil)
+	if err := WalkForward(bars, 3, 3, newAgent, p, nil, true); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// The windows train on bars 0-2, 3-5, and 6-8, and test on bars 3-5, 6-8, and 9.
+	if len(windows) != 3 || windows[1][0] != bars[3] || windows[2][2] != bars[8] {
+		t.Fatalf("%+v", windows)
+	}
+	if len(p.History) != 8 || p.Last().Time != bars[9].Time || p.Last().Price != 19 {
+		t.Fatalf("%+v", p.History)
+	}
+
+	if err := WalkForward(bars, 3, 0, newAgent, p, nil, false); err == nil {
+		t.Fatalf("expected error")
+	}
+}
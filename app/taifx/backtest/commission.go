@@ -0,0 +1,121 @@
+package backtest
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A CommissionModel models the commission charged by an exchange or a broker on each side of a trade.
+type CommissionModel interface {
+	// Cost returns the commission of buying or selling contracts at price at time t.
+	Cost(t time.Time, contracts int, price float64) float64
+}
+
+// PerContract charges a fixed commission per contract, as futures exchanges do.
+type PerContract float64
+
+// Cost returns the commission of the contracts.
+func (c PerContract) Cost(t time.Time, contracts int, price float64) float64 {
+	return float64(c) * math.Abs(float64(contracts))
+}
+
+// PerShare charges a commission per share, as stock brokers do, with a minimum per order.
+type PerShare struct {
+	Rate float64
+	// Shares is the number of shares of a contract, which is 1 if zero.
+	Shares  int
+	Minimum float64
+}
+
+// Cost returns the commission of the shares of the contracts, or the minimum if it is larger.
+func (c PerShare) Cost(t time.Time, contracts int, price float64) float64 {
+	if contracts == 0 {
+		return 0
+	}
+	shares := c.Shares
+	if shares == 0 {
+		shares = 1
+	}
+	return math.Max(c.Rate*math.Abs(float64(contracts*shares)), c.Minimum)
+}
+
+// Percent charges a fraction of the notional value of the contracts, as many crypto exchanges do.
+type Percent float64
+
+// Cost returns the fraction of the notional value of the contracts.
+func (c Percent) Cost(t time.Time, contracts int, price float64) float64 {
+	return float64(c) * math.Abs(float64(contracts)) * price
+}
+
+// A Tier is a rate per contract that applies from a number of contracts traded in a month.
+type Tier struct {
+	Contracts int
+	Rate      float64
+}
+
+// Tiered charges a commission per contract at the rate of the tier of the number of contracts traded in the month before the order, as brokers do with tiered schedules.
+type Tiered struct {
+	// Tiers are the tiers in increasing order of Contracts, the first of which applies from zero contracts.
+	Tiers []Tier
+
+	month  time.Time
+	traded int
+}
+
+// Cost returns the commission of the contracts, and adds them to the volume of the month.
+func (c *Tiered) Cost(t time.Time, contracts int, price float64) float64 {
+	if month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()); !month.Equal(c.month) {
+		c.month, c.traded = month, 0
+	}
+	n := int(math.Abs(float64(contracts)))
+	var rate float64
+	for _, tier := range c.Tiers {
+		if c.traded < tier.Contracts {
+			break
+		}
+		rate = tier.Rate
+	}
+	c.traded += n
+	return rate * float64(n)
+}
+
+// A CommissionConfig configures a CommissionModel, such as {"Model": "percent", "Rate": 0.001} for 10 basis points of the notional value.
+type CommissionConfig struct {
+	// Model is the model of the commission, which is contract for PerContract, share for PerShare, percent for Percent, or tiered for Tiered.
+	// If empty, the commission is the default per contract given to NewCommissionModel.
+	Model string
+	// Rate is the commission per contract of contract, per share of share, or the fraction of the notional value of percent.
+	Rate float64
+	// Shares and Minimum are the shares of a contract and the minimum commission of an order of share.
+	Shares  int
+	Minimum float64
+	// Tiers are the tiers of tiered.
+	Tiers []Tier
+}
+
+// NewCommissionModel returns a model configured by config, which is perContract per contract if the model of config is empty.
+func NewCommissionModel(config CommissionConfig, perContract float64) (CommissionModel, error) {
+	switch config.Model {
+	case "":
+		return PerContract(perContract), nil
+	case "contract":
+		return PerContract(config.Rate), nil
+	case "share":
+		return PerShare{Rate: config.Rate, Shares: config.Shares, Minimum: config.Minimum}, nil
+	case "percent":
+		return Percent(config.Rate), nil
+	case "tiered":
+		if len(config.Tiers) == 0 || config.Tiers[0].Contracts != 0 {
+			return nil, errors.Errorf("the first of the tiers %+v does not apply from zero contracts", config.Tiers)
+		}
+		if !sort.SliceIsSorted(config.Tiers, func(i, j int) bool { return config.Tiers[i].Contracts < config.Tiers[j].Contracts }) {
+			return nil, errors.Errorf("unsorted tiers %+v", config.Tiers)
+		}
+		return &Tiered{Tiers: config.Tiers}, nil
+	default:
+		return nil, errors.Errorf("unknown model %q, expected contract, share, percent, or tiered", config.Model)
+	}
+}
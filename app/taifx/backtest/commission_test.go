@@ -0,0 +1,80 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCommission(t *testing.T) {
+	t0 := time.Date(2018, time.January, 31, 0, 0, 0, 0, time.UTC)
+	if c := PerContract(2.5).Cost(t0, -3, 100); c != 7.5 {
+		t.Fatalf("%f", c)
+	}
+	if c := Percent(0.001).Cost(t0, -3, 100); math.Abs(c-0.3) > 1e-9 {
+		t.Fatalf("%f", c)
+	}
+
+	share := PerShare{Rate: 0.005, Shares: 100, Minimum: 1}
+	if c := share.Cost(t0, 1, 100); c != 1 {
+		t.Fatalf("%f", c)
+	}
+	if c := share.Cost(t0, -4, 100); c != 2 {
+		t.Fatalf("%f", c)
+	}
+	if c := share.Cost(t0, 0, 100); c != 0 {
+		t.Fatalf("%f", c)
+	}
+
+	// The volume of the month before each order decides its tier, and a new month starts from the first tier.
+	tiered := &Tiered{Tiers: []Tier{{0, 1}, {10, 0.5}, {20, 0.25}}}
+	for _, order := range []struct {
+		t         time.Time
+		contracts int
+		cost      float64
+	}{
+		{t0, 8, 8},
+		{t0, -4, 4},
+		{t0, 8, 4},
+		{t0, 3, 0.75},
+		{t0.AddDate(0, 0, 1), 2, 2},
+	} {
+		if c := tiered.Cost(order.t, order.contracts, 100); c != order.cost {
+			t.Fatalf("%+v %f", order, c)
+		}
+	}
+}
+
+func TestNewCommissionModel(t *testing.T) {
+	c, err := NewCommissionModel(CommissionConfig{Model: "share", Rate: 0.005, Shares: 100, Minimum: 1}, 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if c != (PerShare{Rate: 0.005, Shares: 100, Minimum: 1}) {
+		t.Fatalf("%+v", c)
+	}
+	if _, err := NewCommissionModel(CommissionConfig{Model: "tiered", Tiers: []Tier{{0, 1}, {10, 0.5}}}, 0); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, config := range []CommissionConfig{
+		{Model: "tiered"},
+		{Model: "tiered", Tiers: []Tier{{5, 1}}},
+		{Model: "tiered", Tiers: []Tier{{0, 1}, {20, 0.5}, {10, 0.25}}},
+		{Model: "free"},
+	} {
+		if _, err := NewCommissionModel(config, 0); err == nil {
+			t.Fatalf("expected error for %+v", config)
+		}
+	}
+
+	// An empty model is the default per contract.
+	if c, err := NewCommissionModel(CommissionConfig{}, 1.5); err != nil || c.Cost(time.Time{}, 2, 100) != 3 {
+		t.Fatalf("%v %+v", err, c)
+	}
+
+	// A portfolio pays the commission on the change of its position.
+	p := NewPortfolio(Bar{Price: 100}, 1000, Percent(0.01))
+	if e := p.Record(-2, Bar{Price: 90}); e.TransactionCost != 2 || e.Balance != 1018 {
+		t.Fatalf("%+v", e)
+	}
+}
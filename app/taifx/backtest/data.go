@@ -0,0 +1,209 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Bar is the price of an instrument at a time, such as the close of a candle or of a renko brick.
+type Bar struct {
+	Time  time.Time
+	Price float64
+	// Direction is 1 if the price went up, and 0 if it went down, which is the bit predicted by the models.
+	Direction int
+	// Volume is the volume traded over the bar, or zero if unknown.
+	Volume int64
+}
+
+// A Candle holds the prices and the volume traded over a period starting at Time.
+type Candle struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume int64
+}
+
+// A DataFeed is a source of bars in chronological order.
+type DataFeed interface {
+	// Next returns the next bar, or io.EOF if there are no more bars.
+	Next() (Bar, error)
+}
+
+// A SliceFeed is a DataFeed of bars held in memory.
+type SliceFeed struct {
+	Bars []Bar
+	// Cursor is the index of the next bar.
+	Cursor int
+}
+
+// NewSliceFeed returns a feed of bars.
+func NewSliceFeed(bars []Bar) *SliceFeed {
+	return &SliceFeed{Bars: bars}
+}
+
+// Next returns the bar at the cursor, and advances the cursor.
+func (f *SliceFeed) Next() (Bar, error) {
+	if f.Cursor >= len(f.Bars) {
+		return Bar{}, io.EOF
+	}
+	bar := f.Bars[f.Cursor]
+	f.Cursor++
+	return bar, nil
+}
+
+// Split returns the bars before t, and those at or after t.
+func Split(bars []Bar, t time.Time) ([]Bar, []Bar) {
+	for i, bar := range bars {
+		if !bar.Time.Before(t) {
+			return bars[:i], bars[i:]
+		}
+	}
+	return bars, nil
+}
+
+// ReadRenkoCSV returns the renko bricks in the CSV read from r, whose header is followed by rows of an index, the time in the format "2006-01-02 15:04:05", a settlement month, the price, the volume, and, in the ninth column, whether the brick went up, True or False.
+func ReadRenkoCSV(r io.Reader) ([]Bar, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	// Remove header.
+	records = records[1:]
+
+	bars := make([]Bar, 0, len(records))
+	for _, r := range records {
+		if len(r) < 9 {
+			return nil, errors.Errorf("expected at least 9 columns, got %+v", r)
+		}
+		t, err := time.Parse("2006-01-02 15:04:05", r[1])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
+		}
+		price, err := strconv.ParseFloat(r[3], 64)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
+		}
+		volume, err := strconv.ParseInt(r[4], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
+		}
+		var direction int
+		switch r[8] {
+		case "True":
+			direction = 1
+		case "False":
+			direction = 0
+		default:
+			return nil, errors.Errorf("invalid direction %q in %+v", r[8], r)
+		}
+		bars = append(bars, Bar{Time: t, Price: price, Direction: direction, Volume: volume})
+	}
+	return bars, nil
+}
+
+// A CandleReader reads candles from a CSV, whose header is followed by rows of the date in the format "01/02/2006", the time in the format "15:04", the open, high, low, and close prices, and the volume.
+// It is also a DataFeed of the closes of the candles.
+type CandleReader struct {
+	r *csv.Reader
+}
+
+// NewCandleReader returns a reader of the candles in the CSV read from r.
+func NewCandleReader(r io.Reader) (*CandleReader, error) {
+	cr := &CandleReader{r: csv.NewReader(r)}
+	// Remove header.
+	if _, err := cr.r.Read(); err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	return cr, nil
+}
+
+// Read returns the next candle, or io.EOF if there are no more candles.
+func (cr *CandleReader) Read() (Candle, error) {
+	rec, err := cr.r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return Candle{}, io.EOF
+		}
+		return Candle{}, errors.Wrap(err, "")
+	}
+	if len(rec) < 7 {
+		return Candle{}, errors.Errorf("expected 7 columns, got %+v", rec)
+	}
+
+	t, err := time.Parse("01/02/2006 15:04", rec[0]+" "+rec[1])
+	if err != nil {
+		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
+	}
+	c := Candle{Time: t}
+	for i, p := range []*float64{&c.Open, &c.High, &c.Low, &c.Close} {
+		*p, err = strconv.ParseFloat(rec[2+i], 64)
+		if err != nil {
+			return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
+		}
+	}
+	c.Volume, err = strconv.ParseInt(rec[6], 10, 64)
+	if err != nil {
+		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
+	}
+	return c, nil
+}
+
+// Next returns the bar of the close of the next candle, whose Direction is 1 if the candle closed above its open, and 0 otherwise.
+func (cr *CandleReader) Next() (Bar, error) {
+	c, err := cr.Read()
+	if err != nil {
+		return Bar{}, err
+	}
+	return closeBar(c), nil
+}
+
+// closeBar returns the bar of the close of the candle, whose Direction is 1 if the candle closed above its open, and 0 otherwise.
+func closeBar(c Candle) Bar {
+	bar := Bar{Time: c.Time, Price: c.Close, Volume: c.Volume}
+	if c.Close > c.Open {
+		bar.Direction = 1
+	}
+	return bar
+}
+
+// A CandleSlice is a CandleSource of candles held in memory, which may be shared by several backtests.
+type CandleSlice struct {
+	Candles []Candle
+	// Cursor is the index of the next candle.
+	Cursor int
+}
+
+// Read returns the candle at the cursor, and advances the cursor.
+func (s *CandleSlice) Read() (Candle, error) {
+	if s.Cursor >= len(s.Candles) {
+		return Candle{}, io.EOF
+	}
+	c := s.Candles[s.Cursor]
+	s.Cursor++
+	return c, nil
+}
+
+// ReadCandles returns the candles of source, until io.EOF.
+func ReadCandles(source CandleSource) ([]Candle, error) {
+	var candles []Candle
+	for {
+		c, err := source.Read()
+		if err != nil {
+			if err == io.EOF {
+				return candles, nil
+			}
+			return nil, errors.Wrap(err, "")
+		}
+		candles = append(candles, c)
+	}
+}
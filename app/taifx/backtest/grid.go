@@ -0,0 +1,144 @@
+package backtest
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Params are the hyperparameters of a backtest.
+type Params struct {
+	Depth           int
+	Threshold       float64
+	Leverage        float64
+	TransactionCost float64
+}
+
+// A Grid holds the values of each hyperparameter, of which every combination is searched.
+type Grid struct {
+	Depth           []int
+	Threshold       []float64
+	Leverage        []float64
+	TransactionCost []float64
+}
+
+// Params returns the combinations of the values of the grid.
+// A hyperparameter without values takes its zero value.
+func (g Grid) Params() []Params {
+	depths, thresholds, leverages, costs := g.Depth, g.Threshold, g.Leverage, g.TransactionCost
+	if len(depths) == 0 {
+		depths = []int{0}
+	}
+	if len(thresholds) == 0 {
+		thresholds = []float64{0}
+	}
+	if len(leverages) == 0 {
+		leverages = []float64{0}
+	}
+	if len(costs) == 0 {
+		costs = []float64{0}
+	}
+	var params []Params
+	for _, d := range depths {
+		for _, th := range thresholds {
+			for _, l := range leverages {
+				for _, c := range costs {
+					params = append(params, Params{Depth: d, Threshold: th, Leverage: l, TransactionCost: c})
+				}
+			}
+		}
+	}
+	return params
+}
+
+// A Result is the summary of the backtest of a combination of hyperparameters.
+type Result struct {
+	Params
+	Summary Summary
+}
+
+// GridSearch returns the results of running the backtests of params, of which workers are run in parallel.
+// The results are in the order of params.
+func GridSearch(params []Params, workers int, run func(Params) (Summary, error)) ([]Result, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]Result, len(params))
+	errs := make([]error, len(params))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j].Params = params[j]
+				results[j].Summary, errs[j] = run(params[j])
+			}
+		}()
+	}
+	for j := range params {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	for j, err := range errs {
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("%+v", params[j]))
+		}
+	}
+	return results, nil
+}
+
+// SortResults sorts the results by the metric, which is the name of a field of Summary, such as Sharpe, best first.
+// The best is the lowest for MaxDrawdown, and the highest otherwise, with undefined metrics last.
+func SortResults(results []Result, metric string) error {
+	field, ok := reflect.TypeOf(Summary{}).FieldByName(metric)
+	if !ok {
+		return errors.Errorf("unknown metric %q", metric)
+	}
+	value := func(r Result) float64 {
+		v := reflect.ValueOf(r.Summary).FieldByIndex(field.Index)
+		switch v.Kind() {
+		case reflect.Int:
+			return float64(v.Int())
+		default:
+			return v.Float()
+		}
+	}
+	lowest := metric == "MaxDrawdown"
+	sort.SliceStable(results, func(i, j int) bool {
+		vi, vj := value(results[i]), value(results[j])
+		if math.IsNaN(vj) {
+			return !math.IsNaN(vi)
+		}
+		if lowest {
+			return vi < vj
+		}
+		return vi > vj
+	})
+	return nil
+}
+
+// ResultsCSVHeader is the header of the rows of WriteResults.
+const ResultsCSVHeader = "depth,threshold,leverage,transactionCost,bars,balance,return,sharpe,sortino,maxDrawdown,trades,winRate,profitFactor,exposure"
+
+// WriteResults writes the results as rows of CSV, following ResultsCSVHeader.
+func WriteResults(w io.Writer, results []Result) error {
+	if _, err := fmt.Fprintln(w, ResultsCSVHeader); err != nil {
+		return errors.Wrap(err, "")
+	}
+	for _, r := range results {
+		s := r.Summary
+		if _, err := fmt.Fprintf(w, "%d,%g,%g,%g,%d,%.2f,%.4f,%.3f,%.3f,%.4f,%d,%.4f,%.3f,%.4f\n", r.Depth, r.Threshold, r.Leverage, r.TransactionCost, s.Bars, s.Balance, s.Return, s.Sharpe, s.Sortino, s.MaxDrawdown, s.Trades, s.WinRate, s.ProfitFactor, s.Exposure); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package backtest
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestGridSearch(t *testing.T) {
+	grid := Grid{Depth: []int{1, 2}, Threshold: []float64{0.1, 0.2, 0.3}, Leverage: []float64{1}}
+	params := grid.Params()
+	if len(params) != 6 || params[1] != (Params{Depth: 1, Threshold: 0.2, Leverage: 1}) {
+		t.Fatalf("%+v", params)
+	}
+
+	run := func(p Params) (Summary, error) {
+		s := Summary{Sharpe: float64(p.Depth) * p.Threshold, MaxDrawdown: p.Threshold, Trades: p.Depth}
+		if p.Depth == 2 && p.Threshold == 0.3 {
+			s.Sharpe = math.NaN()
+		}
+		return s, nil
+	}
+	results, err := GridSearch(params, 3, run)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i, r := range results {
+		if r.Params != params[i] {
+			t.Fatalf("%d %+v", i, r)
+		}
+	}
+
+	if err := SortResults(results, "Sharpe"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var sharpes []float64
+	for _, r := range results {
+		sharpes = append(sharpes, r.Summary.Sharpe)
+	}
+	want := []float64{0.4, 0.3, 0.2, 0.2, 0.1}
+	for i, s := range want {
+		if math.Abs(sharpes[i]-s) > 1e-9 {
+			t.Fatalf("%+v", sharpes)
+		}
+	}
+	if !math.IsNaN(sharpes[5]) {
+		t.Fatalf("%+v", sharpes)
+	}
+
+	if err := SortResults(results, "MaxDrawdown"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if results[0].Summary.MaxDrawdown != 0.1 || results[5].Summary.MaxDrawdown != 0.3 {
+		t.Fatalf("%+v", results)
+	}
+	if err := SortResults(results, "Trades"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if results[0].Depth != 2 {
+		t.Fatalf("%+v", results)
+	}
+	if err := SortResults(results, "Luck"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	var b bytes.Buffer
+	if err := WriteResults(&b, results[:1]); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || lines[0] != ResultsCSVHeader || !strings.HasPrefix(lines[1], "2,0.1,1,0,") {
+		t.Fatalf("%q", lines)
+	}
+
+	_, err = GridSearch(params, 2, func(p Params) (Summary, error) {
+		if p.Depth == 2 {
+			return Summary{}, errors.Errorf("failed")
+		}
+		return Summary{}, nil
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
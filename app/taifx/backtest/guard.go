@@ -0,0 +1,77 @@
+package backtest
+
+import (
+	"fmt"
+
+	"github.com/fumin/ctw/ac"
+)
+
+// A GuardedFeed is a DataFeed that guards against look-ahead bias, for debugging, by holding the bars it has revealed, and panicking if an agent looks up a bar it has yet to reveal, or observes such a bar into its model.
+// Agents should look up past bars through the GuardedFeed, rather than through the slice or the feed it wraps, so that a look-ahead is caught where it happens.
+type GuardedFeed struct {
+	feed DataFeed
+	bars []Bar
+}
+
+// Guard returns feed wrapped to guard against look-ahead bias.
+func Guard(feed DataFeed) *GuardedFeed {
+	return &GuardedFeed{feed: feed}
+}
+
+// Next returns the next bar of the wrapped feed, which is then revealed, or panics if the bar is before the last bar revealed.
+// Bars of the same time, such as the renko bricks formed by a candle, are revealed one at a time.
+func (f *GuardedFeed) Next() (Bar, error) {
+	bar, err := f.feed.Next()
+	if err != nil {
+		return Bar{}, err
+	}
+	if n := len(f.bars); n > 0 && bar.Time.Before(f.bars[n-1].Time) {
+		panic(fmt.Sprintf("bar %d at %s is before the last bar revealed at %s", n, bar.Time, f.bars[n-1].Time))
+	}
+	f.bars = append(f.bars, bar)
+	return bar, nil
+}
+
+// Len returns the number of bars revealed.
+func (f *GuardedFeed) Len() int {
+	return len(f.bars)
+}
+
+// Bar returns the i-th bar revealed, or panics if the feed has yet to reveal it.
+func (f *GuardedFeed) Bar(i int) Bar {
+	if i >= len(f.bars) {
+		panic(fmt.Sprintf("look-ahead: looking up bar %d, but only %d bars have been revealed", i, len(f.bars)))
+	}
+	return f.bars[i]
+}
+
+// Model returns model wrapped to panic if it observes more bits than the bars revealed, which are the directions of the bars that an agent such as NextStep observes.
+// The bits observed before wrapping, such as those of training, are not counted, so the model should be wrapped before the feed reveals its first bar.
+// If f is nil, model is returned unchanged, so that agents can be built the same way whether or not they are guarded.
+func (f *GuardedFeed) Model(model ac.Model) ac.Model {
+	if f == nil {
+		return model
+	}
+	return &guardedModel{model: model, feed: f}
+}
+
+// A guardedModel is a Model that panics if it observes more bits than the bars revealed by its feed.
+type guardedModel struct {
+	model    ac.Model
+	feed     *GuardedFeed
+	observed int
+}
+
+// Prob0 returns the probability of zero of the wrapped model.
+func (m *guardedModel) Prob0() float64 {
+	return m.model.Prob0()
+}
+
+// Observe informs the wrapped model of bit, or panics if the bar of bit has not been revealed.
+func (m *guardedModel) Observe(bit int) {
+	if m.observed >= m.feed.Len() {
+		panic(fmt.Sprintf("look-ahead: observing bit %d into the model, but only %d bars have been revealed", m.observed, m.feed.Len()))
+	}
+	m.observed++
+	m.model.Observe(bit)
+}
@@ -0,0 +1,90 @@
+package backtest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// peekingAgent looks up, through its feed, the bar after the last one revealed before acting, which is the bar that the feed is about to reveal.
+type peekingAgent struct {
+	feed *GuardedFeed
+}
+
+func (a *peekingAgent) Observe(Bar) {}
+
+func (a *peekingAgent) Act(Entry) int {
+	if a.feed.Bar(a.feed.Len()).Direction == 1 {
+		return 1
+	}
+	return -1
+}
+
+// leakingAgent is a NextStep that, before acting, observes into its model the direction of the next bar of the test bars it holds.
+type leakingAgent struct {
+	NextStep
+	bars []Bar
+	i    int
+}
+
+func (a *leakingAgent) Observe(Bar) { a.i++ }
+
+func (a *leakingAgent) Act(e Entry) int {
+	if a.i < len(a.bars) {
+		a.Model.Observe(a.bars[a.i].Direction)
+	}
+	return a.NextStep.Act(e)
+}
+
+// runGuarded runs on bars the agent returned by newAgent given the guarded feed of bars, and returns the message of its panic, if any.
+func runGuarded(bars []Bar, newAgent func(*GuardedFeed) Agent) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = r.(string)
+		}
+	}()
+	feed := Guard(NewSliceFeed(bars))
+	if err := Run(feed, newAgent(feed), NewPortfolio(Bar{Price: 1}, 100, nil), nil); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestGuard(t *testing.T) {
+	t0 := time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+	bars := []Bar{{Time: t0, Price: 1}, {Time: t0.Add(time.Minute), Price: 2, Direction: 1}, {Time: t0.Add(time.Minute), Price: 3, Direction: 1}, {Time: t0.Add(2 * time.Minute), Price: 2}}
+
+	// Agents that observe bars only as they are revealed pass, even if bars share their times.
+	nextStep := func(feed *GuardedFeed) Agent {
+		return &NextStep{Sizer: FixedContracts(1), Model: feed.Model(&constModel{prob0: 0.2})}
+	}
+	if msg := runGuarded(bars, nextStep); msg != "" {
+		t.Fatalf("%s", msg)
+	}
+
+	// Looking up the next bar through the feed at the first Act peeks into the future.
+	msg := runGuarded(bars, func(feed *GuardedFeed) Agent { return &peekingAgent{feed: feed} })
+	if msg != "look-ahead: looking up bar 0, but only 0 bars have been revealed" {
+		t.Fatalf("%s", msg)
+	}
+
+	// Observing into the model the direction of a test bar before the feed reveals it peeks into the future.
+	msg = runGuarded(bars, func(feed *GuardedFeed) Agent {
+		return &leakingAgent{NextStep: NextStep{Sizer: FixedContracts(1), Model: feed.Model(&constModel{prob0: 0.2})}, bars: bars}
+	})
+	if msg != "look-ahead: observing bit 0 into the model, but only 0 bars have been revealed" {
+		t.Fatalf("%s", msg)
+	}
+
+	// Feeds out of chronological order panic.
+	msg = runGuarded([]Bar{bars[1], bars[0]}, nextStep)
+	if !strings.Contains(msg, "is before the last bar revealed") {
+		t.Fatalf("%s", msg)
+	}
+
+	// A nil GuardedFeed leaves the model unguarded.
+	model := &constModel{prob0: 0.2}
+	if (*GuardedFeed)(nil).Model(model) != model {
+		t.Fatalf("guarded")
+	}
+}
@@ -0,0 +1,62 @@
+package backtest
+
+import (
+	"math"
+)
+
+// A Margin models the margin required of positions, as fractions of their notional value, which short positions may require at other rates.
+// The zero Margin requires none.
+type Margin struct {
+	// Initial is the margin required to open a position, or to add to it.
+	// The positions that exceed it are reduced to the largest that the balance allows.
+	Initial float64
+	// Maintenance is the margin required to hold a position, below which the position is liquidated.
+	Maintenance float64
+
+	// ShortInitial and ShortMaintenance, if positive, are the margins of short positions, which are Initial and Maintenance otherwise.
+	ShortInitial     float64
+	ShortMaintenance float64
+}
+
+// rates returns the initial and maintenance margins of the position.
+func (m Margin) rates(position int) (float64, float64) {
+	initial, maintenance := m.Initial, m.Maintenance
+	if position < 0 {
+		if m.ShortInitial > 0 {
+			initial = m.ShortInitial
+		}
+		if m.ShortMaintenance > 0 {
+			maintenance = m.ShortMaintenance
+		}
+	}
+	return initial, maintenance
+}
+
+// limit returns the position closest to position whose initial margin at price is covered by balance, except that a position in the direction of prev may be held up to the size of prev.
+func (m Margin) limit(prev, position int, balance, price float64) int {
+	initial, _ := m.rates(position)
+	if initial <= 0 || price <= 0 {
+		return position
+	}
+	allowed := int(math.Max(0, balance) / (initial * price))
+	if signOf(prev) == signOf(position) && abs(prev) > allowed {
+		allowed = abs(prev)
+	}
+	if abs(position) <= allowed {
+		return position
+	}
+	return signOf(position) * allowed
+}
+
+// call reports whether balance falls short of the maintenance margin of the position at price.
+func (m Margin) call(position int, balance, price float64) bool {
+	_, maintenance := m.rates(position)
+	return maintenance > 0 && position != 0 && balance < maintenance*float64(abs(position))*price
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,48 @@
+package backtest
+
+import (
+	"testing"
+)
+
+func TestMargin(t *testing.T) {
+	p := NewPortfolio(Bar{Price: 100}, 1000, nil)
+	p.Margin = Margin{Initial: 0.5, Maintenance: 0.4, ShortInitial: 1}
+
+	// The initial margin of 50 per contract allows 20 contracts long, and 10 short.
+	if e := p.Record(30, Bar{Price: 100}); e.Position != 20 {
+		t.Fatalf("%+v", e)
+	}
+	if e := p.Record(-30, Bar{Price: 100}); e.Position != -10 {
+		t.Fatalf("%+v", e)
+	}
+	if e := p.Record(25, Bar{Price: 101}); e.Position != 20 || e.Balance != 1020 || e.Liquidated {
+		t.Fatalf("%+v", e)
+	}
+
+	// A fall of the balance below the initial margin does not reduce the position held, but prevents adding to it.
+	if e := p.Record(20, Bar{Price: 99}); e.Position != 20 || e.Balance != 980 || e.Liquidated {
+		t.Fatalf("%+v", e)
+	}
+	if e := p.Record(21, Bar{Price: 99}); e.Position != 20 {
+		t.Fatalf("%+v", e)
+	}
+
+	// The balance of 700 covers the maintenance margin of 20*85*0.4 = 680, but that of 600 falls short of 20*80*0.4 = 640, so that the position is liquidated at the next bar.
+	e := p.Record(20, Bar{Price: 85})
+	if e.Balance != 700 || e.Liquidated {
+		t.Fatalf("%+v", e)
+	}
+	e = p.Record(20, Bar{Price: 80})
+	if e.Balance != 600 || !e.Liquidated {
+		t.Fatalf("%+v", e)
+	}
+	if e := p.Record(20, Bar{Price: 78}); e.Position != 0 || e.Balance != 600 {
+		t.Fatalf("%+v", e)
+	}
+	if e := p.Record(20, Bar{Price: 78}); e.Position != 15 {
+		t.Fatalf("%+v", e)
+	}
+	if s := p.Summary(); s.Liquidations != 1 {
+		t.Fatalf("%+v", s)
+	}
+}
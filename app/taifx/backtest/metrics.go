@@ -0,0 +1,232 @@
+package backtest
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"text/tabwriter"
+	"time"
+)
+
+// metrics accumulates the performance of a portfolio bar by bar, so that it is available even after History is trimmed.
+type metrics struct {
+	start time.Time
+	bars  int
+
+	// sumReturns, sumSquares, and sumDownside are the sums of the returns of the bars, of their squares, and of the squares of the negative ones.
+	sumReturns  float64
+	sumSquares  float64
+	sumDownside float64
+
+	peak        float64
+	maxDrawdown float64
+
+	// trade is the open trade, whose Direction is zero if there is no open trade, and trades are the closed ones.
+	trade  Trade
+	trades []Trade
+
+	wins        int
+	losses      int
+	grossProfit float64
+	grossLoss   float64
+}
+
+func newMetrics(start Entry) metrics {
+	return metrics{start: start.Time, peak: start.Balance}
+}
+
+// record accumulates the entry e following prev.
+// A trade is a run of bars holding positions of the same direction, whose profit or loss includes the costs of opening and closing its contracts.
+func (m *metrics) record(prev, e Entry) {
+	m.bars++
+	if prev.Balance != 0 {
+		r := (e.Balance - prev.Balance) / prev.Balance
+		m.sumReturns += r
+		m.sumSquares += r * r
+		if r < 0 {
+			m.sumDownside += r * r
+		}
+	}
+	if e.Balance > m.peak {
+		m.peak = e.Balance
+	}
+	if m.peak > 0 {
+		m.maxDrawdown = math.Max(m.maxDrawdown, (m.peak-e.Balance)/m.peak)
+	}
+
+	sign := signOf(e.Position)
+	cost := e.TransactionCost + e.Slippage
+	if sign == m.trade.Direction {
+		if sign != 0 {
+			m.trade.hold(e, e.ProfitLoss-cost)
+		}
+		return
+	}
+	// The costs of the position change are split between closing the old trade and opening the new one, by their numbers of contracts.
+	closing := math.Abs(float64(prev.Position)) / math.Abs(float64(e.Position-prev.Position))
+	if m.trade.Direction != 0 {
+		m.trade.ProfitLoss -= closing * cost
+		m.closeTrade(prev)
+	}
+	if sign != 0 {
+		m.trade = Trade{Open: prev.Time, Direction: sign, EntryPrice: prev.Price}
+		m.trade.hold(e, e.ProfitLoss-(1-closing)*cost)
+	}
+}
+
+// closeTrade closes the open trade at the entry.
+func (m *metrics) closeTrade(e Entry) {
+	m.trade.Close, m.trade.ExitPrice = e.Time, e.Price
+	if m.trade.ProfitLoss > 0 {
+		m.wins++
+		m.grossProfit += m.trade.ProfitLoss
+	} else {
+		m.losses++
+		m.grossLoss -= m.trade.ProfitLoss
+	}
+	m.trades = append(m.trades, m.trade)
+	m.trade = Trade{}
+}
+
+// A Trade is a run of bars holding positions of the same direction.
+type Trade struct {
+	// Open and Close are the times of the entries at which the trade was opened and closed, and EntryPrice and ExitPrice their prices.
+	Open       time.Time
+	Close      time.Time
+	EntryPrice float64
+	ExitPrice  float64
+	// Direction is 1 for a long trade, and -1 for a short one.
+	Direction int
+	// Contracts is the largest number of contracts held.
+	Contracts int
+	// Bars is the number of bars held.
+	Bars int
+	// ProfitLoss is the profit or loss of the trade, after the costs of opening and closing its contracts.
+	ProfitLoss float64
+}
+
+// hold adds to the trade the bar of the entry, over which it made the profit or loss pl.
+func (t *Trade) hold(e Entry, pl float64) {
+	t.Bars++
+	if c := int(math.Abs(float64(e.Position))); c > t.Contracts {
+		t.Contracts = c
+	}
+	t.ProfitLoss += pl
+}
+
+func signOf(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+// A Summary holds the performance metrics of a portfolio.
+// Metrics that are undefined, such as the win rate of a portfolio that never traded, are NaN.
+type Summary struct {
+	// Bars is the number of recorded bars.
+	Bars int
+	// Balance is the final balance, and Return its change relative to the initial balance.
+	Balance float64
+	Return  float64
+
+	// Sharpe and Sortino are the ratios of the mean return of a bar to the standard deviation of the returns, and to their downside deviation, annualized by the number of bars per year.
+	Sharpe  float64
+	Sortino float64
+	// MaxDrawdown is the largest fall of the balance from a previous peak, as a fraction of the peak.
+	MaxDrawdown float64
+
+	// Trades is the number of trades, which are runs of bars holding positions of the same direction, with an open trade counted as closed at the last bar.
+	Trades int
+	// WinRate is the fraction of the trades that made a profit.
+	WinRate float64
+	// AverageWin is the mean profit of the winning trades, and AverageLoss the mean loss of the others, as a positive number.
+	AverageWin  float64
+	AverageLoss float64
+	// ProfitFactor is the gross profit of the winning trades divided by the gross loss of the others.
+	ProfitFactor float64
+	// Exposure is the fraction of the bars over which a position was held.
+	Exposure float64
+
+	// Accuracy is the fraction of the bars over which a position was held that made a profit.
+	Accuracy float64
+	// Contracts is the number of contracts bought or sold.
+	Contracts int
+	// Liquidations is the number of positions liquidated for falling short of the maintenance margin.
+	Liquidations int
+}
+
+// Summary returns the performance metrics of the portfolio.
+func (p *Portfolio) Summary() Summary {
+	m := p.metrics
+	last := p.Last()
+	if m.trade.Direction != 0 {
+		m.closeTrade(last)
+	}
+
+	s := Summary{}
+	s.Bars = m.bars
+	s.Balance = last.Balance
+	s.Return = last.Balance/p.initialBalance - 1
+
+	n := float64(m.bars)
+	mean := m.sumReturns / n
+	std := math.Sqrt(math.Max(0, m.sumSquares/n-mean*mean))
+	downside := math.Sqrt(m.sumDownside / n)
+	annualize := 1.0
+	if years := last.Time.Sub(m.start).Hours() / (24 * 365.25); years > 0 {
+		annualize = math.Sqrt(n / years)
+	}
+	s.Sharpe = mean / std * annualize
+	s.Sortino = mean / downside * annualize
+	s.MaxDrawdown = m.maxDrawdown
+
+	s.Trades = m.wins + m.losses
+	s.WinRate = float64(m.wins) / float64(s.Trades)
+	s.AverageWin = m.grossProfit / float64(m.wins)
+	s.AverageLoss = m.grossLoss / float64(m.losses)
+	s.ProfitFactor = m.grossProfit / m.grossLoss
+	s.Exposure = float64(p.Trials) / n
+
+	s.Accuracy = p.Accuracy()
+	s.Contracts = p.Contracts()
+	s.Liquidations = p.liquidations
+	return s
+}
+
+// Trades returns the trades of the portfolio, with an open trade closed at the last entry.
+func (p *Portfolio) Trades() []Trade {
+	trades := append([]Trade(nil), p.metrics.trades...)
+	if t := p.metrics.trade; t.Direction != 0 {
+		last := p.Last()
+		t.Close, t.ExitPrice = last.Time, last.Price
+		trades = append(trades, t)
+	}
+	return trades
+}
+
+// String returns the summary as a block of aligned lines of the names and values of the metrics.
+func (s Summary) String() string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "bars\t%d\n", s.Bars)
+	fmt.Fprintf(w, "balance\t%.2f\n", s.Balance)
+	fmt.Fprintf(w, "return\t%.2f%%\n", s.Return*100)
+	fmt.Fprintf(w, "sharpe ratio\t%.3f\n", s.Sharpe)
+	fmt.Fprintf(w, "sortino ratio\t%.3f\n", s.Sortino)
+	fmt.Fprintf(w, "max drawdown\t%.2f%%\n", s.MaxDrawdown*100)
+	fmt.Fprintf(w, "trades\t%d\n", s.Trades)
+	fmt.Fprintf(w, "win rate\t%.2f%%\n", s.WinRate*100)
+	fmt.Fprintf(w, "average win\t%.2f\n", s.AverageWin)
+	fmt.Fprintf(w, "average loss\t%.2f\n", s.AverageLoss)
+	fmt.Fprintf(w, "profit factor\t%.3f\n", s.ProfitFactor)
+	fmt.Fprintf(w, "exposure\t%.2f%%\n", s.Exposure*100)
+	fmt.Fprintf(w, "accuracy\t%.2f%%\n", s.Accuracy*100)
+	fmt.Fprintf(w, "contracts\t%d\n", s.Contracts)
+	fmt.Fprintf(w, "liquidations\t%d\n", s.Liquidations)
+	w.Flush()
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package backtest
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	t0 := time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+	p := NewPortfolio(Bar{Time: t0, Price: 100}, 1000, PerContract(1))
+	steps := []struct {
+		position int
+		price    float64
+	}{
+		// A long trade of 2 contracts, which makes 2*(110-100) - 2*1 - 2*1 = 16.
+		{2, 105},
+		{2, 110},
+		// Reversing to a short trade of 1 contract, which loses 120-110 + 1 + 1 = 12.
+		{-1, 120},
+		// Out of the market.
+		{0, 120},
+		// An open long trade of 1 contract, which makes 130-120 - 1 = 9.
+		{1, 130},
+	}
+	for i, s := range steps {
+		p.Record(s.position, Bar{Time: t0.AddDate(0, 0, i+1), Price: s.price})
+	}
+
+	s := p.Summary()
+	if s.Bars != 5 || s.Balance != 1013 || math.Abs(s.Return-0.013) > 1e-9 {
+		t.Fatalf("%+v", s)
+	}
+	if s.Trades != 3 || math.Abs(s.WinRate-2.0/3) > 1e-9 {
+		t.Fatalf("%+v", s)
+	}
+	if s.AverageWin != 12.5 || s.AverageLoss != 12 || math.Abs(s.ProfitFactor-25.0/12) > 1e-9 {
+		t.Fatalf("%+v", s)
+	}
+	if s.Exposure != 0.8 || s.Contracts != 7 {
+		t.Fatalf("%+v", s)
+	}
+	// The peak of 1018 falls to 1004 after the short trade.
+	if math.Abs(s.MaxDrawdown-14.0/1018) > 1e-9 {
+		t.Fatalf("%+v", s)
+	}
+	if !(s.Sharpe > 0 && s.Sortino > s.Sharpe) {
+		t.Fatalf("%+v", s)
+	}
+
+	// The summary does not close the open trade of the portfolio.
+	p.Record(1, Bar{Time: t0.AddDate(0, 0, 7), Price: 140})
+	if s := p.Summary(); s.Trades != 3 || s.AverageWin != 17.5 {
+		t.Fatalf("%+v", s)
+	}
+	if str := s.String(); !strings.Contains(str, "profit factor") {
+		t.Fatalf("%s", str)
+	}
+}
+
+func TestSummaryUndefined(t *testing.T) {
+	p := NewPortfolio(Bar{Price: 100}, 1000, nil)
+	p.Record(0, Bar{Price: 110})
+	s := p.Summary()
+	if s.Trades != 0 || !math.IsNaN(s.WinRate) || !math.IsNaN(s.Sharpe) || s.MaxDrawdown != 0 || s.Exposure != 0 {
+		t.Fatalf("%+v", s)
+	}
+}
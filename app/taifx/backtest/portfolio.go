@@ -0,0 +1,128 @@
+package backtest
+
+import (
+	"fmt"
+	"time"
+)
+
+// An Entry is the state of a portfolio after a bar.
+type Entry struct {
+	Time  time.Time
+	Price float64
+	// Position is the number of contracts held over the bar, which is negative for a short position.
+	Position        int
+	TransactionCost float64
+	Slippage        float64
+	ProfitLoss      float64
+	Balance         float64
+	// Liquidated is whether the balance fell short of the maintenance margin of the position, which is then closed at the price of the entry.
+	Liquidated bool
+}
+
+// CSVHeader is the header of the rows of Entry.CSV.
+const CSVHeader = "time,price,position,transactionCost,slippage,profitLoss,balance"
+
+// CSV returns the entry as a row of CSV.
+func (e Entry) CSV() string {
+	return fmt.Sprintf("%s,%.2f,%d,%.2f,%.2f,%.2f,%.2f", e.Time.Format("2006-01-02 15:04:05"), e.Price, e.Position, e.TransactionCost, e.Slippage, e.ProfitLoss, e.Balance)
+}
+
+// A Portfolio holds positions in a single instrument, and records their profit and loss bar by bar.
+type Portfolio struct {
+	// Commission is the model of the commission of buying or selling contracts, which is free if nil.
+	Commission CommissionModel
+	// Slippage is the model of the slippage of the fills of the contracts bought or sold.
+	Slippage Slippage
+	// Margin is the margin required of the positions.
+	Margin Margin
+	// History holds the entries of the bars, starting with that of the initial balance.
+	History []Entry
+	// MaxHistory, if positive, is the length of History beyond which its older half is dropped, which saves memory in long runs.
+	MaxHistory int
+
+	// Trials is the number of bars over which a position was held, and Corrects the number of those that made a profit.
+	Trials   int
+	Corrects int
+
+	contracts      int
+	liquidations   int
+	initialBalance float64
+	metrics        metrics
+}
+
+// NewPortfolio returns a portfolio of the balance at the bar start, with no position, which pays commission on its trades.
+func NewPortfolio(start Bar, balance float64, commission CommissionModel) *Portfolio {
+	p := &Portfolio{}
+	p.Commission = commission
+	p.History = append(p.History, Entry{Time: start.Time, Price: start.Price, Balance: balance})
+	p.initialBalance = balance
+	p.metrics = newMetrics(p.Last())
+	return p
+}
+
+// Last returns the last entry.
+func (p *Portfolio) Last() Entry {
+	return p.History[len(p.History)-1]
+}
+
+// Record records holding position over the bar, which is entered at the price of the last entry, and returns the new entry.
+// The commission, recorded as the transaction cost, and the slippage are paid on the change of the position, and the profit or loss is that of the move of the price to that of the bar.
+// The position is reduced to that allowed by the initial margin, and is zero if the last entry was liquidated.
+func (p *Portfolio) Record(position int, bar Bar) Entry {
+	prev := p.Last()
+	if prev.Liquidated {
+		position = 0
+	} else {
+		position = p.Margin.limit(prev.Position, position, prev.Balance, prev.Price)
+	}
+
+	posChg := position - prev.Position
+	if posChg < 0 {
+		posChg = -posChg
+	}
+	p.contracts += posChg
+
+	e := Entry{}
+	e.Time = bar.Time
+	e.Price = bar.Price
+	e.Position = position
+	if p.Commission != nil {
+		e.TransactionCost = p.Commission.Cost(prev.Time, posChg, prev.Price)
+	}
+	e.Slippage = p.Slippage.Cost(posChg, prev.Price, bar.Volume)
+	e.ProfitLoss = (bar.Price - prev.Price) * float64(position)
+	e.Balance = prev.Balance - e.TransactionCost - e.Slippage + e.ProfitLoss
+	if p.Margin.call(position, e.Balance, e.Price) {
+		e.Liquidated = true
+		p.liquidations++
+	}
+	p.History = append(p.History, e)
+
+	if position != 0 {
+		p.Trials++
+		if e.ProfitLoss > 0 {
+			p.Corrects++
+		}
+	}
+	p.metrics.record(prev, e)
+
+	if p.MaxHistory > 0 && len(p.History) > p.MaxHistory {
+		p.History = append(p.History[:0], p.History[len(p.History)/2:]...)
+	}
+	return e
+}
+
+// Bankrupt reports whether the balance is negative.
+func (p *Portfolio) Bankrupt() bool {
+	return p.Last().Balance < 0
+}
+
+// Contracts returns the number of contracts bought or sold.
+func (p *Portfolio) Contracts() int {
+	return p.contracts
+}
+
+// Accuracy returns the fraction of the bars over which a position was held that made a profit.
+func (p *Portfolio) Accuracy() float64 {
+	return float64(p.Corrects) / float64(p.Trials)
+}
@@ -0,0 +1,188 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Report holds the summary, the equity curve, and the trades of a backtest.
+type Report struct {
+	Summary Summary
+	// Equity is the equity curve, as the entries of the portfolio.
+	Equity []Entry
+	Trades []Trade
+}
+
+// Report returns the report of the portfolio, whose equity curve is its History, which lacks the dropped entries if MaxHistory is positive.
+func (p *Portfolio) Report() Report {
+	return Report{Summary: p.Summary(), Equity: append([]Entry(nil), p.History...), Trades: p.Trades()}
+}
+
+// MarshalJSON returns the summary as a JSON object, whose undefined metrics are null.
+func (s Summary) MarshalJSON() ([]byte, error) {
+	v := reflect.ValueOf(s)
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i := 0; i < v.NumField(); i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%q:", v.Type().Field(i).Name)
+		if x, ok := v.Field(i).Interface().(float64); ok && (math.IsNaN(x) || math.IsInf(x, 0)) {
+			b.WriteString("null")
+			continue
+		}
+		field, err := json.Marshal(v.Field(i).Interface())
+		if err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		b.Write(field)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// WriteJSON writes the report as JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(r), "")
+}
+
+// WriteHTML writes the report as a self-contained HTML page of charts of the equity and the drawdown, and of tables of the summary and the trades.
+func (r Report) WriteHTML(w io.Writer) error {
+	equity := make([]float64, len(r.Equity))
+	drawdown := make([]float64, len(r.Equity))
+	var peak float64
+	for i, e := range r.Equity {
+		equity[i] = e.Balance
+		peak = math.Max(peak, e.Balance)
+		if peak > 0 {
+			drawdown[i] = -(peak - e.Balance) / peak * 100
+		}
+	}
+	data := struct {
+		Report
+		SummaryLines [][2]string
+		Equity       chart
+		Drawdown     chart
+		Start, End   string
+	}{Report: r, Equity: newChart(equity), Drawdown: newChart(drawdown)}
+	for _, line := range strings.Split(strings.TrimSpace(r.Summary.String()), "\n") {
+		fields := strings.Fields(line)
+		data.SummaryLines = append(data.SummaryLines, [2]string{strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1]})
+	}
+	if len(r.Equity) > 0 {
+		data.Start = r.Equity[0].Time.Format("2006-01-02 15:04:05")
+		data.End = r.Equity[len(r.Equity)-1].Time.Format("2006-01-02 15:04:05")
+	}
+	return errors.Wrap(reportTemplate.Execute(w, data), "")
+}
+
+// WriteFiles writes the report as JSON to prefix.json, and as HTML to prefix.html.
+func (r Report) WriteFiles(prefix string) error {
+	for _, out := range []struct {
+		ext   string
+		write func(io.Writer) error
+	}{{".json", r.WriteJSON}, {".html", r.WriteHTML}} {
+		f, err := os.Create(prefix + out.ext)
+		if err != nil {
+			return errors.Wrap(err, "")
+		}
+		if err := out.write(f); err != nil {
+			f.Close()
+			return errors.Wrap(err, "")
+		}
+		if err := f.Close(); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	return nil
+}
+
+// chartWidth and chartHeight are the dimensions of the charts of reports.
+const (
+	chartWidth  = 960
+	chartHeight = 240
+)
+
+// A chart is a line chart of a series, drawn as an SVG polyline.
+type chart struct {
+	Points   string
+	Min, Max float64
+}
+
+// newChart returns the chart of the series, scaled to the range of its values.
+func newChart(series []float64) chart {
+	c := chart{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, v := range series {
+		c.Min, c.Max = math.Min(c.Min, v), math.Max(c.Max, v)
+	}
+	span := c.Max - c.Min
+	if span == 0 {
+		span = 1
+	}
+	var b strings.Builder
+	for i, v := range series {
+		x := 0.0
+		if len(series) > 1 {
+			x = float64(i) / float64(len(series)-1) * chartWidth
+		}
+		y := chartHeight - (v-c.Min)/span*chartHeight
+		fmt.Fprintf(&b, "%.1f,%.1f ", x, y)
+	}
+	c.Points = strings.TrimSpace(b.String())
+	return c
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Backtest report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+svg { background: #fafafa; border: 1px solid #ddd; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { padding: 0.2em 0.8em; text-align: right; border-bottom: 1px solid #eee; }
+td:first-child, th:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Backtest report</h1>
+<p>{{.Start}} to {{.End}}</p>
+
+<h2>Equity</h2>
+<p>{{printf "%.2f" .Equity.Min}} to {{printf "%.2f" .Equity.Max}}</p>
+<svg width="960" height="240" viewBox="0 0 960 240" preserveAspectRatio="none">
+<polyline fill="none" stroke="#1f77b4" stroke-width="1.5" points="{{.Equity.Points}}"/>
+</svg>
+
+<h2>Drawdown</h2>
+<p>{{printf "%.2f" .Drawdown.Min}}% to {{printf "%.2f" .Drawdown.Max}}%</p>
+<svg width="960" height="240" viewBox="0 0 960 240" preserveAspectRatio="none">
+<polyline fill="none" stroke="#d62728" stroke-width="1.5" points="{{.Drawdown.Points}}"/>
+</svg>
+
+<h2>Summary</h2>
+<table>
+{{range .SummaryLines}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td></tr>
+{{end}}</table>
+
+<h2>Trades</h2>
+<table>
+<tr><th>open</th><th>close</th><th>direction</th><th>contracts</th><th>bars</th><th>entry price</th><th>exit price</th><th>profit and loss</th></tr>
+{{range .Trades}}<tr><td>{{.Open.Format "2006-01-02 15:04:05"}}</td><td>{{.Close.Format "2006-01-02 15:04:05"}}</td><td>{{if eq .Direction 1}}long{{else}}short{{end}}</td><td>{{.Contracts}}</td><td>{{.Bars}}</td><td>{{printf "%.2f" .EntryPrice}}</td><td>{{printf "%.2f" .ExitPrice}}</td><td>{{printf "%.2f" .ProfitLoss}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
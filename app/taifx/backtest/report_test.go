@@ -0,0 +1,71 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	t0 := time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+	p := NewPortfolio(Bar{Time: t0, Price: 100}, 1000, PerContract(1))
+	for i, s := range []struct {
+		position int
+		price    float64
+	}{{2, 105}, {2, 110}, {-1, 120}, {0, 120}, {1, 130}} {
+		p.Record(s.position, Bar{Time: t0.AddDate(0, 0, i+1), Price: s.price})
+	}
+
+	r := p.Report()
+	want := []Trade{
+		{Open: t0, Close: t0.AddDate(0, 0, 2), EntryPrice: 100, ExitPrice: 110, Direction: 1, Contracts: 2, Bars: 2, ProfitLoss: 16},
+		{Open: t0.AddDate(0, 0, 2), Close: t0.AddDate(0, 0, 3), EntryPrice: 110, ExitPrice: 120, Direction: -1, Contracts: 1, Bars: 1, ProfitLoss: -12},
+		{Open: t0.AddDate(0, 0, 4), Close: t0.AddDate(0, 0, 5), EntryPrice: 120, ExitPrice: 130, Direction: 1, Contracts: 1, Bars: 1, ProfitLoss: 9},
+	}
+	if len(r.Trades) != len(want) {
+		t.Fatalf("%+v", r.Trades)
+	}
+	for i, tr := range r.Trades {
+		if tr != want[i] {
+			t.Fatalf("%d %+v", i, tr)
+		}
+	}
+	if len(r.Equity) != 6 || r.Equity[5].Balance != 1013 {
+		t.Fatalf("%+v", r.Equity)
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteJSON(&b); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if decoded.Summary.Trades != 3 || len(decoded.Equity) != 6 || decoded.Trades[1] != want[1] {
+		t.Fatalf("%+v", decoded)
+	}
+
+	// Undefined metrics are null.
+	empty := NewPortfolio(Bar{Time: t0, Price: 100}, 1000, PerContract(1)).Report()
+	b.Reset()
+	if err := empty.WriteJSON(&b); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !strings.Contains(b.String(), `"WinRate": null`) {
+		t.Fatalf("%s", b.String())
+	}
+
+	b.Reset()
+	if err := r.WriteHTML(&b); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	html := b.String()
+	for _, s := range []string{`points="0.0,240.0 `, "<td>profit factor</td><td>2.083</td>", "<td>short</td><td>1</td><td>1</td><td>110.00</td><td>120.00</td><td>-12.00</td>"} {
+		if !strings.Contains(html, s) {
+			t.Fatalf("%q not in %s", s, html)
+		}
+	}
+}
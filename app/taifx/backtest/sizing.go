@@ -0,0 +1,178 @@
+package backtest
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// A PositionSizer decides the number of contracts that agents hold, whose direction the agents decide.
+type PositionSizer interface {
+	// Observe informs the sizer of the bar that has just closed.
+	Observe(Bar)
+
+	// Size returns the number of contracts to hold over the next bar, given the last entry of the portfolio.
+	// Agents call it on every entry, even if they do not trade, so that sizers may learn from the entries.
+	Size(Entry) int
+}
+
+// FixedFractional sizes positions to a notional value of a fraction of the balance, which is the leverage of the position.
+type FixedFractional float64
+
+// Observe does nothing.
+func (f FixedFractional) Observe(Bar) {}
+
+// Size returns the contracts worth the fraction of the balance.
+func (f FixedFractional) Size(e Entry) int {
+	return int(e.Balance / e.Price * float64(f))
+}
+
+// FixedContracts sizes positions to a fixed number of contracts.
+type FixedContracts int
+
+// Observe does nothing.
+func (f FixedContracts) Observe(Bar) {}
+
+// Size returns the number of contracts.
+func (f FixedContracts) Size(Entry) int {
+	return int(f)
+}
+
+// Kelly sizes positions by a fraction of the Kelly criterion, W - (1-W)/R, where W is the fraction of the bars held that were profitable and R is the ratio of the average profit to the average loss per contract of those bars, after costs.
+// The criterion is the fraction of the balance to risk, which is lost in the average loss, so that the position is Fraction times the criterion times the balance over the average loss per contract.
+type Kelly struct {
+	// Fraction is the fraction of the criterion, such as 0.5 for half Kelly, which is less volatile at the cost of a little growth.
+	Fraction float64
+	// Leverage, if positive, caps the notional value of the position at Leverage times the balance.
+	Leverage float64
+	// MinBars is the number of bars held before the criterion is estimated, over which, and until a loss, a single contract is held.
+	MinBars int
+
+	wins   int
+	losses int
+	profit float64
+	loss   float64
+}
+
+// Observe does nothing.
+func (k *Kelly) Observe(Bar) {}
+
+// Size records the outcome of the bar of the entry, if a position was held over it, and returns the position sized by the criterion.
+func (k *Kelly) Size(e Entry) int {
+	if e.Position != 0 {
+		pl := (e.ProfitLoss - e.TransactionCost - e.Slippage) / math.Abs(float64(e.Position))
+		switch {
+		case pl > 0:
+			k.wins++
+			k.profit += pl
+		case pl < 0:
+			k.losses++
+			k.loss -= pl
+		}
+	}
+
+	if k.wins+k.losses < k.MinBars || k.losses == 0 {
+		return 1
+	}
+	if k.wins == 0 {
+		return 0
+	}
+	w := float64(k.wins) / float64(k.wins+k.losses)
+	avgLoss := k.loss / float64(k.losses)
+	r := (k.profit / float64(k.wins)) / avgLoss
+	f := w - (1-w)/r
+	if f <= 0 {
+		return 0
+	}
+	return capLeverage(k.Fraction*f*e.Balance/avgLoss, e, k.Leverage)
+}
+
+// VolTarget sizes positions so that the standard deviation of the profit and loss per bar is a Target fraction of the balance, with the volatility of the price estimated from the returns of the last Period bars.
+type VolTarget struct {
+	Target float64
+	Period int
+	// Leverage, if positive, caps the notional value of the position at Leverage times the balance.
+	Leverage float64
+
+	prev    float64
+	returns []float64
+}
+
+// Observe records the return of the bar.
+func (v *VolTarget) Observe(bar Bar) {
+	if v.prev != 0 {
+		v.returns = append(v.returns, bar.Price/v.prev-1)
+		if len(v.returns) > v.Period {
+			v.returns = v.returns[1:]
+		}
+	}
+	v.prev = bar.Price
+}
+
+// Size returns the position of the target volatility, or zero before Period returns are observed or if the price has not moved.
+func (v *VolTarget) Size(e Entry) int {
+	if len(v.returns) < v.Period || v.Period < 2 {
+		return 0
+	}
+	var mean float64
+	for _, r := range v.returns {
+		mean += r
+	}
+	mean /= float64(len(v.returns))
+	var variance float64
+	for _, r := range v.returns {
+		variance += (r - mean) * (r - mean)
+	}
+	std := math.Sqrt(variance / float64(len(v.returns)-1))
+	if std == 0 {
+		return 0
+	}
+	return capLeverage(v.Target*e.Balance/(std*e.Price), e, v.Leverage)
+}
+
+// capLeverage returns the contracts, at most those worth leverage times the balance if leverage is positive.
+func capLeverage(contracts float64, e Entry, leverage float64) int {
+	if leverage > 0 {
+		contracts = math.Min(contracts, e.Balance/e.Price*leverage)
+	}
+	return int(contracts)
+}
+
+// A SizerConfig configures a PositionSizer.
+type SizerConfig struct {
+	// Sizing is the sizing of the positions, which is fractional for FixedFractional, contracts for FixedContracts, kelly for Kelly, or vol for VolTarget.
+	Sizing string
+	// Fraction is the fraction of the balance of fractional, or of the criterion of kelly.
+	Fraction float64
+	// Contracts is the number of contracts of contracts.
+	Contracts int
+	// Leverage, if positive, caps the positions of kelly and vol.
+	Leverage float64
+	// MinBars is the number of bars of kelly before the criterion is estimated.
+	MinBars int
+	// Target and Period are the target volatility of the balance per bar, and the number of bars over which the volatility is estimated, of vol.
+	Target float64
+	Period int
+}
+
+// NewPositionSizer returns a sizer configured by config.
+func NewPositionSizer(config SizerConfig) (PositionSizer, error) {
+	switch config.Sizing {
+	case "fractional":
+		return FixedFractional(config.Fraction), nil
+	case "contracts":
+		return FixedContracts(config.Contracts), nil
+	case "kelly":
+		if config.Fraction <= 0 {
+			return nil, errors.Errorf("invalid fraction %f", config.Fraction)
+		}
+		return &Kelly{Fraction: config.Fraction, Leverage: config.Leverage, MinBars: config.MinBars}, nil
+	case "vol":
+		if config.Period < 2 {
+			return nil, errors.Errorf("invalid period %d", config.Period)
+		}
+		return &VolTarget{Target: config.Target, Period: config.Period, Leverage: config.Leverage}, nil
+	default:
+		return nil, errors.Errorf("unknown sizing %q, expected fractional, contracts, kelly, or vol", config.Sizing)
+	}
+}
@@ -0,0 +1,85 @@
+package backtest
+
+import (
+	"testing"
+)
+
+func TestFixedSizers(t *testing.T) {
+	e := Entry{Price: 100, Balance: 1050}
+	if size := FixedFractional(2).Size(e); size != 21 {
+		t.Fatalf("%d", size)
+	}
+	if size := FixedContracts(3).Size(e); size != 3 {
+		t.Fatalf("%d", size)
+	}
+}
+
+func TestKelly(t *testing.T) {
+	k := &Kelly{Fraction: 0.5, MinBars: 4}
+	entries := []Entry{
+		{Price: 100, Balance: 1000},
+		{Price: 100, Balance: 1000, Position: 1, ProfitLoss: 2},
+		{Price: 100, Balance: 1000, Position: -2, ProfitLoss: 4},
+		{Price: 100, Balance: 1000, Position: 0, ProfitLoss: 0},
+		{Price: 100, Balance: 1000, Position: 1, ProfitLoss: 3, TransactionCost: 1},
+	}
+	for _, e := range entries {
+		if size := k.Size(e); size != 1 {
+			t.Fatalf("%+v %d", e, size)
+		}
+	}
+
+	// W is 3/4, the average win is 2, and the average loss is 1, so that the criterion is 3/4 - 1/4/2 = 5/8.
+	e := Entry{Price: 100, Balance: 1000, Position: 2, ProfitLoss: -2}
+	if size := k.Size(e); size != 312 {
+		t.Fatalf("%d", size)
+	}
+	k.Leverage = 2
+	if size := k.Size(Entry{Price: 100, Balance: 1000}); size != 20 {
+		t.Fatalf("%d", size)
+	}
+
+	// Losing bars turn the criterion negative.
+	for i := 0; i < 7; i++ {
+		k.Size(Entry{Price: 100, Balance: 1000, Position: 1, ProfitLoss: -1})
+	}
+	if size := k.Size(Entry{Price: 100, Balance: 1000}); size != 0 {
+		t.Fatalf("%d", size)
+	}
+}
+
+func TestVolTarget(t *testing.T) {
+	v := &VolTarget{Target: 0.01, Period: 2}
+	e := Entry{Price: 100, Balance: 1000}
+	for _, price := range []float64{100, 101} {
+		v.Observe(Bar{Price: price})
+		if size := v.Size(e); size != 0 {
+			t.Fatalf("%d", size)
+		}
+	}
+	// The returns of 1% and -1% have a standard deviation of sqrt(2)%, so that the position is 1000 * 0.01 / (0.01 * sqrt(2) * 100).
+	v.Observe(Bar{Price: 99.99})
+	if size := v.Size(e); size != 7 {
+		t.Fatalf("%d", size)
+	}
+	v.Leverage = 0.5
+	if size := v.Size(e); size != 5 {
+		t.Fatalf("%d", size)
+	}
+}
+
+func TestNewPositionSizer(t *testing.T) {
+	sizer, err := NewPositionSizer(SizerConfig{Sizing: "fractional", Fraction: 1})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := sizer.(FixedFractional); !ok {
+		t.Fatalf("%T", sizer)
+	}
+	if _, err := NewPositionSizer(SizerConfig{Sizing: "vol", Period: 1}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := NewPositionSizer(SizerConfig{Sizing: "martingale"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,26 @@
+package backtest
+
+// A Slippage models the difference between the price at which contracts are filled and the price of the last bar, which is paid on each side of a trade.
+// The zero Slippage fills at the price of the last bar.
+type Slippage struct {
+	// Ticks is the slippage of a contract in ticks of TickSize.
+	Ticks    float64
+	TickSize float64
+	// BPS is the slippage of a contract in basis points of the price.
+	BPS float64
+	// Impact, if positive, scales the slippage by 1 + Impact * contracts / volume, so that orders that are large relative to the volume of the bar are filled at worse prices.
+	// Bars of unknown volume, which is zero, are not scaled.
+	Impact float64
+}
+
+// Cost returns the slippage of trading contracts at price over a bar of volume.
+func (s Slippage) Cost(contracts int, price float64, volume int64) float64 {
+	if contracts < 0 {
+		contracts = -contracts
+	}
+	cost := float64(contracts) * (s.Ticks*s.TickSize + s.BPS/10000*price)
+	if s.Impact > 0 && volume > 0 {
+		cost *= 1 + s.Impact*float64(contracts)/float64(volume)
+	}
+	return cost
+}
@@ -0,0 +1,42 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSlippage(t *testing.T) {
+	tests := []struct {
+		slippage  Slippage
+		contracts int
+		volume    int64
+		cost      float64
+	}{
+		{Slippage{}, 3, 0, 0},
+		{Slippage{Ticks: 2, TickSize: 0.25}, -3, 0, 1.5},
+		{Slippage{BPS: 10}, 2, 0, 4},
+		{Slippage{Ticks: 1, TickSize: 1, BPS: 10, Impact: 0.5}, 2, 4, 2 * 3 * 1.25},
+		{Slippage{Ticks: 1, TickSize: 1, Impact: 0.5}, 2, 0, 2},
+	}
+	for i, test := range tests {
+		if c := test.slippage.Cost(test.contracts, 2000, test.volume); math.Abs(c-test.cost) > 1e-9 {
+			t.Fatalf("%d %f %+v", i, c, test)
+		}
+	}
+}
+
+func TestPortfolioSlippage(t *testing.T) {
+	p := NewPortfolio(Bar{Price: 100}, 1000, PerContract(1))
+	p.Slippage = Slippage{Ticks: 1, TickSize: 0.5}
+	// Buying 2 contracts at 100 slips by 2*0.5.
+	if e := p.Record(2, Bar{Price: 110, Volume: 10}); e.Slippage != 1 || e.Balance != 1017 {
+		t.Fatalf("%+v", e)
+	}
+	// Selling them at 110 slips by 1 again, which the trade pays for.
+	if e := p.Record(0, Bar{Price: 110}); e.Slippage != 1 || e.Balance != 1014 {
+		t.Fatalf("%+v", e)
+	}
+	if s := p.Summary(); s.Trades != 1 || s.AverageWin != 14 {
+		t.Fatalf("%+v", s)
+	}
+}
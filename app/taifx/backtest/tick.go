@@ -0,0 +1,190 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Tick is a trade.
+type Tick struct {
+	Time  time.Time
+	Price float64
+	Size  int64
+}
+
+// A TickReader reads ticks from a CSV of rows of the time, the price, and the size of the trades, which may have a header.
+type TickReader struct {
+	r      *csv.Reader
+	layout string
+	rows   int
+}
+
+// NewTickReader returns a reader of the ticks in the CSV read from r, whose times are in layout, as of time.Parse, or in seconds or milliseconds since the Unix epoch if layout is unix or unixms.
+func NewTickReader(r io.Reader, layout string) *TickReader {
+	return &TickReader{r: csv.NewReader(r), layout: layout}
+}
+
+// Read returns the next tick, or io.EOF if there are no more ticks.
+func (tr *TickReader) Read() (Tick, error) {
+	for {
+		rec, err := tr.r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return Tick{}, io.EOF
+			}
+			return Tick{}, errors.Wrap(err, "")
+		}
+		tr.rows++
+		if len(rec) < 3 {
+			return Tick{}, errors.Errorf("expected 3 columns, got %+v", rec)
+		}
+		price, err := strconv.ParseFloat(rec[1], 64)
+		if err != nil {
+			// Skip the header.
+			if tr.rows == 1 {
+				continue
+			}
+			return Tick{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
+		}
+		t, err := ParseTime(tr.layout, rec[0])
+		if err != nil {
+			return Tick{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
+		}
+		size, err := strconv.ParseInt(rec[2], 10, 64)
+		if err != nil {
+			return Tick{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
+		}
+		return Tick{Time: t, Price: price, Size: size}, nil
+	}
+}
+
+// ParseTime parses s as a time in layout, as of time.Parse, or as seconds or milliseconds since the Unix epoch if layout is unix or unixms.
+func ParseTime(layout, s string) (time.Time, error) {
+	switch layout {
+	case "unix", "unixms":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "")
+		}
+		if layout == "unixms" {
+			return time.Unix(0, n*int64(time.Millisecond)).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+	t, err := time.Parse(layout, s)
+	return t, errors.Wrap(err, "")
+}
+
+// A TickSource is a source of ticks in chronological order, such as a TickReader.
+type TickSource interface {
+	// Read returns the next tick, or io.EOF if there are no more ticks.
+	Read() (Tick, error)
+}
+
+// A CandleSource is a source of candles in chronological order, such as a CandleReader or a CandleAggregator.
+type CandleSource interface {
+	// Read returns the next candle, or io.EOF if there are no more candles.
+	Read() (Candle, error)
+}
+
+// A CandleAggregator aggregates ticks into candles of a period as they are read.
+// It is also a DataFeed of the closes of the candles.
+type CandleAggregator struct {
+	ticks  TickSource
+	period time.Duration
+	// pending is the first tick of the next candle, if it has been read.
+	pending *Tick
+}
+
+// NewCandleAggregator returns an aggregator of ticks into candles of period, which start at multiples of period since the zero time, such as at whole minutes.
+// If period is not positive, each tick is a candle.
+func NewCandleAggregator(ticks TickSource, period time.Duration) *CandleAggregator {
+	return &CandleAggregator{ticks: ticks, period: period}
+}
+
+// Read returns the next candle, which holds the ticks of its period, or io.EOF if there are no more ticks.
+// Periods without ticks have no candles.
+func (a *CandleAggregator) Read() (Candle, error) {
+	var first Tick
+	if a.pending != nil {
+		first, a.pending = *a.pending, nil
+	} else {
+		t, err := a.ticks.Read()
+		if err != nil {
+			if err == io.EOF {
+				return Candle{}, io.EOF
+			}
+			return Candle{}, errors.Wrap(err, "")
+		}
+		first = t
+	}
+	c := Candle{Time: first.Time, Open: first.Price, High: first.Price, Low: first.Price, Close: first.Price, Volume: first.Size}
+	if a.period <= 0 {
+		return c, nil
+	}
+
+	c.Time = first.Time.Truncate(a.period)
+	end := c.Time.Add(a.period)
+	for {
+		t, err := a.ticks.Read()
+		if err != nil {
+			if err == io.EOF {
+				return c, nil
+			}
+			return Candle{}, errors.Wrap(err, "")
+		}
+		if !t.Time.Before(end) {
+			a.pending = &t
+			return c, nil
+		}
+		if t.Price > c.High {
+			c.High = t.Price
+		}
+		if t.Price < c.Low {
+			c.Low = t.Price
+		}
+		c.Close = t.Price
+		c.Volume += t.Size
+	}
+}
+
+// Next returns the bar of the close of the next candle, as CandleReader.Next.
+func (a *CandleAggregator) Next() (Bar, error) {
+	c, err := a.Read()
+	if err != nil {
+		return Bar{}, err
+	}
+	return closeBar(c), nil
+}
+
+// A TickConfig configures the aggregation of ticks into candles.
+type TickConfig struct {
+	// Layout is the layout of the times of the ticks, as of NewTickReader.
+	Layout string
+	// Period is the period of the candles, such as 1m, or empty for a candle of each tick.
+	Period string
+}
+
+// NewCandleSource returns a source of the candles in the CSV read from r, as read by a CandleReader, or, if ticks is not nil, of the candles aggregated from the ticks in the CSV as configured by ticks.
+func NewCandleSource(r io.Reader, ticks *TickConfig) (CandleSource, error) {
+	if ticks == nil {
+		cr, err := NewCandleReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		return cr, nil
+	}
+	var period time.Duration
+	if ticks.Period != "" {
+		var err error
+		if period, err = time.ParseDuration(ticks.Period); err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+	}
+	return NewCandleAggregator(NewTickReader(r, ticks.Layout), period), nil
+}
@@ -0,0 +1,101 @@
+package backtest
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTickReader(t *testing.T) {
+	data := `time,price,size
+2020-03-02 09:30:00.250,100.5,2
+2020-03-02 09:30:01.000,101,1
+`
+	tr := NewTickReader(strings.NewReader(data), "2006-01-02 15:04:05.000")
+	tick, err := tr.Read()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := Tick{Time: time.Date(2020, time.March, 2, 9, 30, 0, 250*int(time.Millisecond), time.UTC), Price: 100.5, Size: 2}
+	if tick != want {
+		t.Fatalf("%+v", tick)
+	}
+	if _, err := tr.Read(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := tr.Read(); err != io.EOF {
+		t.Fatalf("%+v", err)
+	}
+
+	tick, err = NewTickReader(strings.NewReader("1583141400123,99,5\n"), "unixms").Read()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if tick.Time.UnixNano() != 1583141400123*int64(time.Millisecond) || tick.Price != 99 || tick.Size != 5 {
+		t.Fatalf("%+v", tick)
+	}
+
+	if _, err := NewTickReader(strings.NewReader("1,2,3\n1,x,3\n"), "unix").Read(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	tr = NewTickReader(strings.NewReader("1,2,3\n1,x,3\n"), "unix")
+	tr.Read()
+	if _, err := tr.Read(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCandleAggregator(t *testing.T) {
+	data := `0,10,1
+20,12,2
+59,9,1
+60,11,4
+185,13,1
+`
+	a := NewCandleAggregator(NewTickReader(strings.NewReader(data), "unix"), time.Minute)
+	var candles []Candle
+	for {
+		c, err := a.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		candles = append(candles, c)
+	}
+	// The minute from 120 has no ticks, and so no candle.
+	want := []Candle{
+		{Time: time.Unix(0, 0).UTC(), Open: 10, High: 12, Low: 9, Close: 9, Volume: 4},
+		{Time: time.Unix(60, 0).UTC(), Open: 11, High: 11, Low: 11, Close: 11, Volume: 4},
+		{Time: time.Unix(180, 0).UTC(), Open: 13, High: 13, Low: 13, Close: 13, Volume: 1},
+	}
+	if len(candles) != len(want) {
+		t.Fatalf("%+v", candles)
+	}
+	for i := range want {
+		if candles[i] != want[i] {
+			t.Fatalf("%d %+v", i, candles[i])
+		}
+	}
+
+	// Without a period, each tick is a candle.
+	src, err := NewCandleSource(strings.NewReader(data), &TickConfig{Layout: "unix"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	feed := src.(DataFeed)
+	for i := 0; i < 5; i++ {
+		if _, err := feed.Next(); err != nil {
+			t.Fatalf("%d %+v", i, err)
+		}
+	}
+	if _, err := feed.Next(); err != io.EOF {
+		t.Fatalf("%+v", err)
+	}
+
+	if _, err := NewCandleSource(strings.NewReader(data), &TickConfig{Layout: "unix", Period: "1 minute"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
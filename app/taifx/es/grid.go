@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/app/taifx/backtest"
+	"github.com/fumin/ctw/app/taifx/renko"
+	"github.com/pkg/errors"
+)
+
+var (
+	flagConfig = flag.String("c", `{
+		"Data": "/Users/mac/Desktop/es_1m.csv",
+		"Test": "2015-01-01",
+		"Balance": 10000,
+		"Grid": {
+			"Depth": [16, 32, 48],
+			"Threshold": [0.001, 0.002],
+			"Leverage": [1],
+			"TransactionCost": [0.05]
+		},
+		"Metric": "Sharpe",
+		"Workers": 4
+		}`, "configuration")
+)
+
+// backtestParams trains a model on the bricks before the test start, and returns the summary of trading the bricks after it, with the hyperparameters of params.
+func backtestParams(config Config, candles []backtest.Candle, test time.Time, params backtest.Params) (backtest.Summary, error) {
+	brick := config.Renko
+	if brick.Sizing == "" {
+		brick.Sizing = "percent"
+	}
+	brick.Size = params.Threshold
+	builder, err := renko.New(brick)
+	if err != nil {
+		return backtest.Summary{}, errors.Wrap(err, "")
+	}
+	data := renko.NewFeed(&backtest.CandleSlice{Candles: candles}, builder)
+
+	context := make([]int, 0, params.Depth)
+	for i := 0; i < params.Depth; i++ {
+		rk, err := data.Next()
+		if err != nil {
+			return backtest.Summary{}, errors.Wrap(err, "")
+		}
+		context = append(context, rk.Direction)
+	}
+	model := ctw.NewCTW(context)
+
+	// Train.
+	var prevRenko backtest.Bar
+	for {
+		rk, err := data.Next()
+		if err != nil {
+			return backtest.Summary{}, errors.Wrap(err, "")
+		}
+		model.Observe(rk.Direction)
+
+		if rk.Time.After(test) {
+			prevRenko = rk
+			break
+		}
+	}
+
+	// Test.
+	portfolio := backtest.NewPortfolio(prevRenko, config.Balance, backtest.PerContract(params.TransactionCost))
+	portfolio.MaxHistory = 128
+	portfolio.Slippage = config.Slippage
+	portfolio.Margin = config.Margin
+	agent := &backtest.NextStep{Sizer: backtest.FixedFractional(params.Leverage), Model: model}
+	if err := backtest.Run(data, agent, portfolio, nil); err != nil {
+		return backtest.Summary{}, errors.Wrap(err, "")
+	}
+	return portfolio.Summary(), nil
+}
+
+func run(config Config) error {
+	test, err := time.Parse("2006-01-02", config.Test)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	f, err := os.Open(config.Data)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer f.Close()
+	source, err := backtest.NewCandleSource(f, config.Ticks)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	candles, err := backtest.ReadCandles(source)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	log.Printf("read %d candles", len(candles))
+
+	// Check the metric before the search, rather than after it.
+	if err := backtest.SortResults(nil, config.Metric); err != nil {
+		return errors.Wrap(err, "")
+	}
+	params := config.Grid.Params()
+	log.Printf("searching %d combinations", len(params))
+	results, err := backtest.GridSearch(params, config.Workers, func(p backtest.Params) (backtest.Summary, error) {
+		s, err := backtestParams(config, candles, test, p)
+		if err != nil {
+			return backtest.Summary{}, errors.Wrap(err, "")
+		}
+		log.Printf("%+v: return %.2f%%, sharpe %.3f", p, s.Return*100, s.Sharpe)
+		return s, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	if err := backtest.SortResults(results, config.Metric); err != nil {
+		return errors.Wrap(err, "")
+	}
+	if err := backtest.WriteResults(os.Stdout, results); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	return nil
+}
+
+type Config struct {
+	Data string
+	// Test is the date in the format 2006-01-02 after which the bricks are traded, and before which the models are trained.
+	Test    string
+	Balance float64
+
+	// Grid holds the values of the hyperparameters, whose Threshold is the size of the bricks.
+	Grid backtest.Grid
+	// Metric is the field of the summaries by which the results are sorted, such as Sharpe or MaxDrawdown.
+	Metric string
+	// Workers is the number of backtests run in parallel.
+	Workers int
+
+	// Renko configures the bricks, whose sizing is percent if empty, and whose size is the Threshold of the grid.
+	Renko renko.Config
+
+	// Ticks, if not null, configures the aggregation into candles of the ticks of Data, which is then a file of ticks rather than candles.
+	Ticks *backtest.TickConfig
+
+	// Slippage is the slippage of the fills, such as {"Ticks": 1, "TickSize": 0.25} for a tick per side.
+	Slippage backtest.Slippage
+
+	// Margin is the margin required of the positions, such as {"Initial": 0.1, "Maintenance": 0.08}, below which they are liquidated.
+	Margin backtest.Margin
+}
+
+func parseConfig() (Config, error) {
+	config := Config{}
+	if err := json.Unmarshal([]byte(*flagConfig), &config); err != nil {
+		return Config{}, errors.Wrap(err, "")
+	}
+	configB, err := json.Marshal(config)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "")
+	}
+	log.Printf("config: %s", configB)
+	return config, nil
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+
+	config, err := parseConfig()
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if err := run(config); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
@@ -1,19 +1,18 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"math/rand"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/app/taifx/backtest"
+	"github.com/fumin/ctw/app/taifx/renko"
 	"github.com/pkg/errors"
 )
 
@@ -28,220 +27,26 @@ var (
                 }`, "configuration")
 )
 
-type Data struct {
-	Threashold float64
-	f          *os.File
-	r          *csv.Reader
-	curCandle  Candle
-}
-
-func NewData(config Config) (*Data, error) {
-	data := &Data{}
-	data.Threashold = config.Threashold
-
-	var err error
-	data.f, err = os.Open(config.Data)
-	if err != nil {
-		return nil, errors.Wrap(err, "")
-	}
-	data.r = csv.NewReader(data.f)
-	if err != nil {
-		return nil, errors.Wrap(err, "")
-	}
-	// Remove header.
-	if _, err := data.r.Read(); err != nil {
-		return nil, errors.Wrap(err, "")
-	}
-
-	data.curCandle, err = data.read()
-	if err != nil {
-		return nil, errors.Wrap(err, "")
-	}
-
-	return data, nil
-}
-
-func (data *Data) Close() error {
-	if err := data.f.Close(); err != nil {
-		return errors.Wrap(err, "")
-	}
-	return nil
-}
-
-type Renko struct {
-	Time      time.Time
-	Price     float64
-	Direction int
-}
-
-func (data *Data) Renko() (Renko, error) {
-	for {
-		cnd, err := data.read()
-		if err != nil {
-			return Renko{}, errors.Wrap(err, "")
-		}
-		if cnd.Close/data.curCandle.Close > 1+data.Threashold {
-			data.curCandle = cnd
-			return Renko{Time: cnd.Time, Price: cnd.Close, Direction: 1}, nil
-		}
-		if cnd.Close/data.curCandle.Close < 1-data.Threashold {
-			data.curCandle = cnd
-			return Renko{Time: cnd.Time, Price: cnd.Close, Direction: 0}, nil
-		}
-	}
-}
-
-type Candle struct {
-	Time   time.Time
-	Open   float64
-	High   float64
-	Low    float64
-	Close  float64
-	Volume int64
-}
-
-func (data *Data) read() (Candle, error) {
-	rec, err := data.r.Read()
-	if err != nil {
-		return Candle{}, errors.Wrap(err, "")
-	}
-
-	dtStr := rec[0]
-	timeStr := rec[1]
-	t, err := time.Parse("01/02/2006 15:04", dtStr+" "+timeStr)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c := Candle{}
-	c.Time = t
-
-	c.Open, err = strconv.ParseFloat(rec[2], 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c.High, err = strconv.ParseFloat(rec[3], 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c.Low, err = strconv.ParseFloat(rec[4], 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c.Close, err = strconv.ParseFloat(rec[5], 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c.Volume, err = strconv.ParseInt(rec[6], 10, 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-
-	return c, nil
-}
-
-type Entry struct {
-	Time            time.Time
-	Price           float64
-	Position        int
-	TransactionCost float64
-	ProfitLoss      float64
-	Balance         float64
-}
-
-type Tester struct {
-	TransactionCost float64
-	History         []Entry
-
-	Trials   float64
-	Corrects float64
-}
-
-func NewTester(config Config, prevRenko Renko) *Tester {
-	tester := &Tester{}
-	tester.TransactionCost = config.TransactionCost
-
-	entry := Entry{}
-	entry.Time = prevRenko.Time
-	entry.Price = prevRenko.Price
-	entry.Balance = config.Balance
-	tester.History = append(tester.History, entry)
-
-	return tester
-}
-
-func (tester *Tester) Record(position int, rk Renko) {
-	prev := tester.History[len(tester.History)-1]
-
-	posChg := math.Abs(float64(position - prev.Position))
-	tcost := posChg * tester.TransactionCost
-
-	profitLoss := (rk.Price - prev.Price) * float64(prev.Position)
-
-	entry := Entry{}
-	entry.Time = rk.Time
-	entry.Price = rk.Price
-	entry.Position = position
-	entry.TransactionCost = tcost
-	entry.ProfitLoss = profitLoss
-	entry.Balance = prev.Balance - tcost + profitLoss
-	tester.History = append(tester.History, entry)
-
-	if prev.Position != 0 {
-		tester.Trials += 1
-		if profitLoss > 0 {
-			tester.Corrects += 1
-		}
-	}
-
-	tester.PrintCSV()
-}
-
-func (tester *Tester) PrintCSV() {
-	h := tester.History[len(tester.History)-1]
-	tStr := h.Time.Format("2006-01-02 15:04")
-	fmt.Printf("%s,%.2f,%d,%.2f,%.2f,%.2f\n", tStr, h.Price, h.Position, h.TransactionCost, h.ProfitLoss, h.Balance)
-}
-
-func (tester *Tester) Contracts() int {
-	contracts := 0
-	for i, h := range tester.History[1:] {
-		posChg := h.Position - tester.History[i].Position
-		if posChg < 0 {
-			posChg = -posChg
-		}
-		contracts += posChg
-	}
-	return contracts
-}
-
-type NextStep struct {
-	Leverage float64
-	Model    *ctw.CTW
-}
-
-func (agent *NextStep) Act(price, balance float64, prevPos int) int {
-	pos := int(balance / price * agent.Leverage)
-
-	prob0 := agent.Model.Prob0()
-	if prob0 > 0.5 {
-		pos = -pos
-	}
-
-	return pos
-}
-
 type RolloutAgent struct {
 	Threashold      float64
 	TransactionCost float64
-	Leverage        float64
+	Sizer           backtest.PositionSizer
 	Depth           int
 	NumSimulations  int
+	model           *ctw.CTW
 	reverter        *ctw.CTWReverter
 
 	tick int
 }
 
-func (agent *RolloutAgent) Act(price, balance float64, prevPos int) int {
+func (agent *RolloutAgent) Observe(rk backtest.Bar) {
+	agent.model.Observe(rk.Direction)
+	agent.Sizer.Observe(rk)
+}
+
+func (agent *RolloutAgent) Act(e backtest.Entry) int {
+	price, prevPos := e.Price, e.Position
+	pos := agent.Sizer.Size(e)
 	agent.tick++
 	if agent.tick < agent.Depth {
 		return prevPos
@@ -254,7 +59,6 @@ func (agent *RolloutAgent) Act(price, balance float64, prevPos int) int {
 	}
 	nextPrice /= float64(agent.NumSimulations)
 
-	pos := int(balance / price * agent.Leverage)
 	longPL := agent.ProfitLoss(price, nextPrice, prevPos, pos)
 	shortPL := agent.ProfitLoss(price, nextPrice, prevPos, -pos)
 
@@ -298,14 +102,24 @@ func (agent *RolloutAgent) rollout(price float64) float64 {
 }
 
 func run(config Config) error {
-	data, err := NewData(config)
+	f, err := os.Open(config.Data)
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
+	defer f.Close()
+	candles, err := backtest.NewCandleSource(f, config.Ticks)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	builder, err := renko.New(config.renko())
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	data := renko.NewFeed(candles, builder)
 
 	context := make([]int, 0, config.Depth)
 	for i := 0; i < config.Depth; i++ {
-		rk, err := data.Renko()
+		rk, err := data.Next()
 		if err != nil {
 			return errors.Wrap(err, "")
 		}
@@ -314,9 +128,9 @@ func run(config Config) error {
 	model := ctw.NewCTW(context)
 
 	// Train.
-	var prevRenko Renko
+	var prevRenko backtest.Bar
 	for {
-		rk, err := data.Renko()
+		rk, err := data.Next()
 		if err != nil {
 			return errors.Wrap(err, "")
 		}
@@ -329,26 +143,39 @@ func run(config Config) error {
 	}
 
 	// Test.
-	tester := NewTester(config, prevRenko)
-	agent := NextStep{Leverage: config.Leverage, Model: model}
-	// agent := RolloutAgent{Threashold: config.Threashold, TransactionCost: config.TransactionCost, Leverage: config.Leverage, reverter: ctw.NewCTWReverter(model), Depth: 5, NumSimulations: 4096}
-	for {
-		prev := tester.History[len(tester.History)-1]
-		position := agent.Act(prev.Price, prev.Balance, prev.Position)
-
-		rk, err := data.Renko()
-		if err != nil {
-			if errors.Cause(err) == io.EOF {
-				break
-			}
+	commission, err := backtest.NewCommissionModel(config.Commission, config.TransactionCost)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	portfolio := backtest.NewPortfolio(prevRenko, config.Balance, commission)
+	portfolio.Slippage = config.Slippage
+	portfolio.Margin = config.Margin
+	sizer, err := config.sizer()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	var feed backtest.DataFeed = data
+	var guard *backtest.GuardedFeed
+	if config.Guard {
+		guard = backtest.Guard(feed)
+		feed = guard
+	}
+	agent := &backtest.NextStep{Sizer: sizer, Model: guard.Model(model)}
+	// agent := &RolloutAgent{Threashold: config.Threashold, TransactionCost: config.TransactionCost, Sizer: sizer, model: model, reverter: ctw.NewCTWReverter(model), Depth: 5, NumSimulations: 4096}
+	var printEntry func(backtest.Entry)
+	if config.Report == "" {
+		printEntry = func(e backtest.Entry) { fmt.Println(e.CSV()) }
+	}
+	if err := backtest.Run(feed, agent, portfolio, printEntry); err != nil {
+		return errors.Wrap(err, "")
+	}
+	log.Printf("summary:\n%s", portfolio.Summary())
+	if config.Report != "" {
+		if err := portfolio.Report().WriteFiles(config.Report); err != nil {
 			return errors.Wrap(err, "")
 		}
-
-		model.Observe(rk.Direction)
-		tester.Record(position, rk)
+		log.Printf("report written to %s.json and %s.html", config.Report, config.Report)
 	}
-	log.Printf("accuracy: %f", tester.Corrects/tester.Trials)
-	log.Printf("contracts: %d", tester.Contracts())
 
 	return nil
 }
@@ -360,6 +187,48 @@ type Config struct {
 	Depth           int
 	Leverage        float64
 	Balance         float64
+
+	// Renko configures the bricks, which are of the percentage Threashold of the price if its size is zero.
+	Renko renko.Config
+
+	// Ticks, if not null, configures the aggregation into candles of the ticks of Data, which is then a file of ticks rather than candles.
+	Ticks *backtest.TickConfig
+
+	// Slippage is the slippage of the fills, such as {"Ticks": 1, "TickSize": 0.25} for a tick per side.
+	Slippage backtest.Slippage
+
+	// Margin is the margin required of the positions, such as {"Initial": 0.1, "Maintenance": 0.08}, below which they are liquidated.
+	Margin backtest.Margin
+
+	// Guard, if true, panics on look-ahead bias, as detected by backtest.Guard, for debugging.
+	Guard bool
+
+	// Commission is the commission of the trades, which is TransactionCost per contract if its model is empty.
+	Commission backtest.CommissionConfig
+
+	// Report, if not empty, is the prefix of the paths of the JSON and HTML reports, such as out/es for out/es.json and out/es.html, which are written instead of printing the entries.
+	Report string
+
+	// Sizer sizes the positions, which are worth Leverage times the balance if its sizing is empty.
+	Sizer backtest.SizerConfig
+}
+
+// sizer returns a new sizer of the positions.
+func (config Config) sizer() (backtest.PositionSizer, error) {
+	sizing := config.Sizer
+	if sizing.Sizing == "" {
+		sizing = backtest.SizerConfig{Sizing: "fractional", Fraction: config.Leverage}
+	}
+	return backtest.NewPositionSizer(sizing)
+}
+
+// renko returns the configuration of the bricks.
+func (config Config) renko() renko.Config {
+	brick := config.Renko
+	if brick.Size == 0 {
+		brick.Sizing, brick.Size = "percent", config.Threashold
+	}
+	return brick
 }
 
 func parseConfig() (Config, error) {
@@ -1,19 +1,18 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"math/rand"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/app/taifx/backtest"
+	"github.com/fumin/ctw/app/taifx/renko"
 	"github.com/pkg/errors"
 )
 
@@ -29,251 +28,75 @@ var (
                 }`, "configuration")
 )
 
-type Data struct {
-	f *os.File
-	r *csv.Reader
+// A candleFeed is a DataFeed of the closes of candles, which keeps the last candle read.
+type candleFeed struct {
+	candles backtest.CandleSource
+	last    backtest.Candle
 }
 
-func NewData(config Config) (*Data, error) {
-	data := &Data{}
-
-	var err error
-	data.f, err = os.Open(config.Data)
-	if err != nil {
-		return nil, errors.Wrap(err, "")
-	}
-	data.r = csv.NewReader(data.f)
-	if err != nil {
-		return nil, errors.Wrap(err, "")
-	}
-	// Remove header.
-	if _, err := data.r.Read(); err != nil {
-		return nil, errors.Wrap(err, "")
-	}
-
-	return data, nil
-}
-
-func (data *Data) Close() error {
-	if err := data.f.Close(); err != nil {
-		return errors.Wrap(err, "")
-	}
-	return nil
-}
-
-type Candle struct {
-	Time   time.Time
-	Open   float64
-	High   float64
-	Low    float64
-	Close  float64
-	Volume int64
-}
-
-func (data *Data) Read() (Candle, error) {
-	rec, err := data.r.Read()
-	if err != nil {
-		return Candle{}, errors.Wrap(err, "")
-	}
-
-	dtStr := rec[0]
-	timeStr := rec[1]
-	t, err := time.Parse("01/02/2006 15:04", dtStr+" "+timeStr)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c := Candle{}
-	c.Time = t
-
-	c.Open, err = strconv.ParseFloat(rec[2], 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c.High, err = strconv.ParseFloat(rec[3], 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c.Low, err = strconv.ParseFloat(rec[4], 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-	c.Close, err = strconv.ParseFloat(rec[5], 64)
+func (feed *candleFeed) Next() (backtest.Bar, error) {
+	c, err := feed.candles.Read()
 	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
+		return backtest.Bar{}, err
 	}
-	c.Volume, err = strconv.ParseInt(rec[6], 10, 64)
-	if err != nil {
-		return Candle{}, errors.Wrap(err, fmt.Sprintf("%+v", rec))
-	}
-
-	return c, nil
-}
-
-type Renko struct {
-	Time      time.Time
-	Price     float64
-	Direction int
-}
-
-type Agent interface {
-	SetModel(*ctw.CTW)
-	Observe(Renko)
-	Act(float64, float64, int) int
+	feed.last = c
+	return backtest.Bar{Time: c.Time, Price: c.Close, Volume: c.Volume}, nil
 }
 
+// A RenkoWrapper is an agent trading the candles of a candleFeed by the agent it wraps, which observes the renko bricks of the candles and acts only when a brick is formed.
+// The wrapped agent is created by NewAgent with a model whose context is the directions of the first Depth bricks.
 type RenkoWrapper struct {
-	Threashold float64
-	Depth      int
-	curCandle  *Candle
-	context    []int
-	Agent      Agent
+	Depth    int
+	NewAgent func(*ctw.CTW) backtest.Agent
+	feed     *candleFeed
+	builder  *renko.Builder
+	agent    backtest.Agent
+	context  []int
+	// renko is the last brick formed by the last observed candle, if any.
+	renko *renko.Brick
 }
 
-func NewRenkoWrapper(config Config) *RenkoWrapper {
+func NewRenkoWrapper(config Config, feed *candleFeed) (*RenkoWrapper, error) {
 	wrapper := &RenkoWrapper{}
-	wrapper.Threashold = config.Threashold
 	wrapper.Depth = config.Depth
-	return wrapper
-}
-
-func (wrapper *RenkoWrapper) Observe(candle Candle) *Renko {
-	if wrapper.curCandle == nil {
-		wrapper.curCandle = &candle
-		return nil
-	}
-
-	direction := -1
-	if candle.Close/wrapper.curCandle.Close > 1+wrapper.Threashold {
-		wrapper.curCandle = &candle
-		direction = 1
-	}
-	if candle.Close/wrapper.curCandle.Close < 1-wrapper.Threashold {
-		wrapper.curCandle = &candle
-		direction = 0
-	}
-	if direction == -1 {
-		return nil
+	wrapper.feed = feed
+	var err error
+	wrapper.builder, err = renko.New(config.renko())
+	if err != nil {
+		return nil, errors.Wrap(err, "")
 	}
+	return wrapper, nil
+}
 
-	if len(wrapper.context) < wrapper.Depth {
-		wrapper.context = append(wrapper.context, direction)
+// Observe builds the bricks of the last candle of the feed, which is that of the bar.
+func (wrapper *RenkoWrapper) Observe(backtest.Bar) {
+	wrapper.renko = nil
+	for _, brick := range wrapper.builder.Add(wrapper.feed.last) {
 		if len(wrapper.context) < wrapper.Depth {
-			return nil
+			wrapper.context = append(wrapper.context, brick.Direction)
+			if len(wrapper.context) == wrapper.Depth {
+				wrapper.agent = wrapper.NewAgent(ctw.NewCTW(wrapper.context))
+			}
+			continue
 		}
-		model := ctw.NewCTW(wrapper.context)
-		wrapper.Agent.SetModel(model)
-		return nil
-	}
-
-	renko := Renko{Time: candle.Time, Price: candle.Close, Direction: direction}
-	wrapper.Agent.Observe(renko)
-	return &renko
-}
 
-func (wrapper *RenkoWrapper) Act(candle Candle, balance float64, position int) (int, *Renko) {
-	renko := wrapper.Observe(candle)
-	if renko == nil {
-		return position, nil
+		wrapper.agent.Observe(brick.Bar())
+		brick := brick
+		wrapper.renko = &brick
 	}
-	return wrapper.Agent.Act(renko.Price, balance, position), renko
-}
-
-type Entry struct {
-	Time            time.Time
-	Price           float64
-	Position        int
-	TransactionCost float64
-	ProfitLoss      float64
-	Balance         float64
-}
-
-type Tester struct {
-	TransactionCost float64
-	History         []Entry
-	MaxHistory      int
-
-	Trials   float64
-	Corrects float64
 }
 
-func NewTester(config Config, prevCandle Candle) *Tester {
-	tester := &Tester{}
-	tester.TransactionCost = config.TransactionCost
-	tester.MaxHistory = 128
-
-	entry := Entry{}
-	entry.Time = prevCandle.Time
-	entry.Price = prevCandle.Close
-	entry.Balance = config.Balance
-	tester.History = append(tester.History, entry)
-
-	return tester
-}
-
-func (tester *Tester) Record(position int, candle Candle) {
-	prev := tester.History[len(tester.History)-1]
-
-	posChg := math.Abs(float64(position - prev.Position))
-	tcost := posChg * tester.TransactionCost
-
-	profitLoss := (candle.Close - prev.Price) * float64(prev.Position)
-
-	entry := Entry{}
-	entry.Time = candle.Time
-	entry.Price = candle.Close
-	entry.Position = position
-	entry.TransactionCost = tcost
-	entry.ProfitLoss = profitLoss
-	entry.Balance = prev.Balance - tcost + profitLoss
-	tester.History = append(tester.History, entry)
-
-	if len(tester.History) > tester.MaxHistory {
-		tester.trim()
-	}
-}
-
-func (tester *Tester) PrintCSV() {
-	h := tester.History[len(tester.History)-1]
-	tStr := h.Time.Format("2006-01-02 15:04")
-	fmt.Printf("%s,%.2f,%d,%.2f,%.2f,%.2f\n", tStr, h.Price, h.Position, h.TransactionCost, h.ProfitLoss, h.Balance)
-}
-
-func (tester *Tester) trim() {
-	start := len(tester.History) / 2
-	for i := start; i < len(tester.History); i++ {
-		tester.History[i-start] = tester.History[i]
-	}
-	numLeft := len(tester.History) - start
-	tester.History = tester.History[:numLeft]
-}
-
-type NextStep struct {
-	Leverage float64
-	Model    *ctw.CTW
-}
-
-func (agent *NextStep) SetModel(model *ctw.CTW) {
-	agent.Model = model
-}
-
-func (agent *NextStep) Observe(rk Renko) {
-	agent.Model.Observe(rk.Direction)
-}
-
-func (agent *NextStep) Act(price, balance float64, prevPos int) int {
-	pos := int(balance / price * agent.Leverage)
-	prob0 := agent.Model.Prob0()
-	if prob0 > 0.5 {
-		pos = -pos
+func (wrapper *RenkoWrapper) Act(e backtest.Entry) int {
+	if wrapper.renko == nil {
+		return e.Position
 	}
-
-	return pos
+	return wrapper.agent.Act(e)
 }
 
 type RolloutAgent struct {
 	Threashold      float64
 	TransactionCost float64
-	Leverage        float64
+	Sizer           backtest.PositionSizer
 	Depth           int
 	NumSimulations  int
 	model           *ctw.CTW
@@ -282,16 +105,14 @@ type RolloutAgent struct {
 	tick int
 }
 
-func (agent *RolloutAgent) SetModel(model *ctw.CTW) {
-	agent.model = model
-	agent.reverter = ctw.NewCTWReverter(model)
-}
-
-func (agent *RolloutAgent) Observe(rk Renko) {
+func (agent *RolloutAgent) Observe(rk backtest.Bar) {
 	agent.model.Observe(rk.Direction)
+	agent.Sizer.Observe(rk)
 }
 
-func (agent *RolloutAgent) Act(price, balance float64, prevPos int) int {
+func (agent *RolloutAgent) Act(e backtest.Entry) int {
+	price, prevPos := e.Price, e.Position
+	pos := agent.Sizer.Size(e)
 	agent.tick++
 	if agent.tick < agent.Depth {
 		return prevPos
@@ -308,7 +129,6 @@ func (agent *RolloutAgent) Act(price, balance float64, prevPos int) int {
 	}
 	nextPrice /= float64(agent.NumSimulations)
 
-	pos := int(balance / price * agent.Leverage)
 	longPL := agent.profitLoss(price, nextPrice, prevPos, pos)
 	shortPL := agent.profitLoss(price, nextPrice, prevPos, -pos)
 
@@ -352,25 +172,37 @@ func (agent *RolloutAgent) rollout(price float64) float64 {
 }
 
 func run(config Config) error {
-	data, err := NewData(config)
+	f, err := os.Open(config.Data)
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
+	defer f.Close()
+	candles, err := backtest.NewCandleSource(f, config.Ticks)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	data := &candleFeed{candles: candles}
 
-	wrapper := NewRenkoWrapper(config)
-	wrapper.Agent = &NextStep{Leverage: config.Leverage}
-	wrapper.Agent = &RolloutAgent{Threashold: config.Threashold, TransactionCost: config.TransactionCost, Leverage: config.Leverage, Depth: 10, NumSimulations: 4096}
-
-	prevCandle, err := data.Read()
+	wrapper, err := NewRenkoWrapper(config, data)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	sizer, err := config.sizer()
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
+	// wrapper.NewAgent = func(model *ctw.CTW) backtest.Agent { return &backtest.NextStep{Sizer: sizer, Model: model} }
+	wrapper.NewAgent = func(model *ctw.CTW) backtest.Agent {
+		return &RolloutAgent{Threashold: config.Threashold, TransactionCost: config.TransactionCost, Sizer: sizer, Depth: 10, NumSimulations: 4096, model: model, reverter: ctw.NewCTWReverter(model)}
+	}
+
+	var prevCandle backtest.Bar
 	for {
-		wrapper.Observe(prevCandle)
-		candle, err := data.Read()
+		candle, err := data.Next()
 		if err != nil {
 			return errors.Wrap(err, "")
 		}
+		wrapper.Observe(candle)
 		prevCandle = candle
 
 		if candle.Time.After(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)) {
@@ -378,25 +210,42 @@ func run(config Config) error {
 		}
 	}
 
-	tester := NewTester(config, prevCandle)
-	for {
-		prev := tester.History[len(tester.History)-1]
-		action, rk := wrapper.Act(prevCandle, prev.Balance, prev.Position)
-		candle, err := data.Read()
-		if err != nil {
-			if errors.Cause(err) == io.EOF {
-				break
-			}
-			return errors.Wrap(err, "")
+	commission, err := backtest.NewCommissionModel(config.Commission, config.TransactionCost)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	portfolio := backtest.NewPortfolio(prevCandle, config.Balance, commission)
+	portfolio.MaxHistory = 128
+	portfolio.Slippage = config.Slippage
+	portfolio.Margin = config.Margin
+	// Print, or report, only the candles after those at which the agent acted, since the history is trimmed.
+	equity := []backtest.Entry{portfolio.Last()}
+	printEntry := func(e backtest.Entry) {
+		if wrapper.renko == nil {
+			return
 		}
-		prevCandle = candle
-
-		tester.Record(action, candle)
-
-		if rk != nil {
-			tester.PrintCSV()
+		if config.Report == "" {
+			fmt.Println(e.CSV())
+		} else {
+			equity = append(equity, e)
 		}
 	}
+	var feed backtest.DataFeed = data
+	if config.Guard {
+		feed = backtest.Guard(feed)
+	}
+	if err := backtest.Run(feed, wrapper, portfolio, printEntry); err != nil {
+		return errors.Wrap(err, "")
+	}
+	log.Printf("summary:\n%s", portfolio.Summary())
+	if config.Report != "" {
+		report := portfolio.Report()
+		report.Equity = equity
+		if err := report.WriteFiles(config.Report); err != nil {
+			return errors.Wrap(err, "")
+		}
+		log.Printf("report written to %s.json and %s.html", config.Report, config.Report)
+	}
 
 	return nil
 }
@@ -409,6 +258,48 @@ type Config struct {
 	Depth           int
 	Leverage        float64
 	Balance         float64
+
+	// Renko configures the bricks, which are of the percentage Threashold of the price if its size is zero.
+	Renko renko.Config
+
+	// Ticks, if not null, configures the aggregation into candles of the ticks of Data, which is then a file of ticks rather than candles.
+	Ticks *backtest.TickConfig
+
+	// Slippage is the slippage of the fills, such as {"Ticks": 1, "TickSize": 0.25} for a tick per side.
+	Slippage backtest.Slippage
+
+	// Margin is the margin required of the positions, such as {"Initial": 0.1, "Maintenance": 0.08}, below which they are liquidated.
+	Margin backtest.Margin
+
+	// Guard, if true, panics on bars out of chronological order, as detected by backtest.Guard, for debugging; the model of the agent, which plans by observing hypothetical bars, is not guarded.
+	Guard bool
+
+	// Commission is the commission of the trades, which is TransactionCost per contract if its model is empty.
+	Commission backtest.CommissionConfig
+
+	// Report, if not empty, is the prefix of the paths of the JSON and HTML reports, such as out/es for out/es.json and out/es.html, which are written instead of printing the entries.
+	Report string
+
+	// Sizer sizes the positions, which are worth Leverage times the balance if its sizing is empty.
+	Sizer backtest.SizerConfig
+}
+
+// sizer returns a new sizer of the positions.
+func (config Config) sizer() (backtest.PositionSizer, error) {
+	sizing := config.Sizer
+	if sizing.Sizing == "" {
+		sizing = backtest.SizerConfig{Sizing: "fractional", Fraction: config.Leverage}
+	}
+	return backtest.NewPositionSizer(sizing)
+}
+
+// renko returns the configuration of the bricks.
+func (config Config) renko() renko.Config {
+	brick := config.Renko
+	if brick.Size == 0 {
+		brick.Sizing, brick.Size = "percent", config.Threashold
+	}
+	return brick
 }
 
 func parseConfig() (Config, error) {
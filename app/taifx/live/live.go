@@ -0,0 +1,179 @@
+// Package live runs the agents of package backtest in real time, on ticks streamed over WebSocket, with their orders written to a log and their positions held in a paper account.
+package live
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fumin/ctw/app/taifx/backtest"
+	"github.com/pkg/errors"
+)
+
+// A Mapping maps the fields of JSON messages to ticks.
+type Mapping struct {
+	// Time, Price, and Size are the paths of the fields of the time, the price, and the size of a trade, as keys separated by dots, such as data.p, where the keys of arrays are indexes.
+	// Messages without Price, such as the acknowledgements of subscriptions, are not ticks.
+	// If Time is empty, ticks are timed when they are received, and if Size is empty, their sizes are zero, and otherwise they are rounded to whole units.
+	Time  string
+	Price string
+	Size  string
+	// TimeLayout is the layout of the times, as of backtest.ParseTime.
+	TimeLayout string
+}
+
+// Tick returns the tick of the message, and whether the message is a tick.
+// The values of the fields may be JSON numbers or strings, as streamed by many exchanges to preserve precision.
+func (m Mapping) Tick(msg []byte, received time.Time) (backtest.Tick, bool, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return backtest.Tick{}, false, errors.Wrap(err, string(msg))
+	}
+
+	priceStr, ok := lookup(v, m.Price)
+	if !ok {
+		return backtest.Tick{}, false, nil
+	}
+	tick := backtest.Tick{Time: received}
+	var err error
+	if tick.Price, err = strconv.ParseFloat(priceStr, 64); err != nil {
+		return backtest.Tick{}, false, errors.Wrap(err, string(msg))
+	}
+	if m.Time != "" {
+		s, ok := lookup(v, m.Time)
+		if !ok {
+			return backtest.Tick{}, false, errors.Errorf("no time %s in %s", m.Time, msg)
+		}
+		if tick.Time, err = backtest.ParseTime(m.TimeLayout, s); err != nil {
+			return backtest.Tick{}, false, errors.Wrap(err, string(msg))
+		}
+	}
+	if m.Size != "" {
+		s, ok := lookup(v, m.Size)
+		if !ok {
+			return backtest.Tick{}, false, errors.Errorf("no size %s in %s", m.Size, msg)
+		}
+		size, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return backtest.Tick{}, false, errors.Wrap(err, string(msg))
+		}
+		tick.Size = int64(math.Round(size))
+	}
+	return tick, true, nil
+}
+
+// lookup returns the field of v at path as a string, and whether it is a number or a string.
+func lookup(v interface{}, path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = x[key]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return "", false
+			}
+			v = x[i]
+		default:
+			return "", false
+		}
+	}
+	switch x := v.(type) {
+	case json.Number:
+		return x.String(), true
+	case string:
+		return x, true
+	}
+	return "", false
+}
+
+// A Feed is a backtest.TickSource of the trades streamed over WebSocket.
+type Feed struct {
+	conn    *Conn
+	mapping Mapping
+}
+
+// DialFeed connects to the WebSocket server at url, sends it subscribe, unless it is empty, and returns a feed of the ticks mapped from its messages by mapping.
+func DialFeed(url, subscribe string, mapping Mapping) (*Feed, error) {
+	if mapping.Price == "" {
+		return nil, errors.Errorf("no price in the mapping")
+	}
+	conn, err := Dial(url, http.Header{})
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	if subscribe != "" {
+		if err := conn.WriteText([]byte(subscribe)); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "")
+		}
+	}
+	return &Feed{conn: conn, mapping: mapping}, nil
+}
+
+// Read blocks until the next tick is received, and returns it, or io.EOF if the server closed the connection.
+func (f *Feed) Read() (backtest.Tick, error) {
+	for {
+		msg, err := f.conn.ReadMessage()
+		if err != nil {
+			return backtest.Tick{}, err
+		}
+		tick, ok, err := f.mapping.Tick(msg, time.Now())
+		if err != nil {
+			return backtest.Tick{}, errors.Wrap(err, "")
+		}
+		if ok {
+			return tick, nil
+		}
+	}
+}
+
+// Close closes the connection.
+func (f *Feed) Close() error {
+	return f.conn.Close()
+}
+
+// An OrderLogger is an agent that writes to Log the orders that change the position of the portfolio to those decided by the agent it wraps.
+type OrderLogger struct {
+	Agent backtest.Agent
+	Log   *log.Logger
+}
+
+// Observe informs the wrapped agent of the bar.
+func (o *OrderLogger) Observe(bar backtest.Bar) {
+	o.Agent.Observe(bar)
+}
+
+// Act returns the position decided by the wrapped agent, and logs the order for it, if any.
+func (o *OrderLogger) Act(e backtest.Entry) int {
+	position := o.Agent.Act(e)
+	if order := Order(e.Position, position); order != "" {
+		o.Log.Printf("%s at %.2f, from position %d to %d, after the bar of %s", order, e.Price, e.Position, position, e.Time.Format("2006-01-02 15:04:05"))
+	}
+	return position
+}
+
+// Order returns the order that changes the position from position0 to position1, such as "buy 3", or empty if they are the same.
+func Order(position0, position1 int) string {
+	switch d := position1 - position0; {
+	case d > 0:
+		return fmt.Sprintf("buy %d", d)
+	case d < 0:
+		return fmt.Sprintf("sell %d", -d)
+	}
+	return ""
+}
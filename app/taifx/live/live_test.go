@@ -0,0 +1,153 @@
+package live
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fumin/ctw/app/taifx/backtest"
+)
+
+// serverFrame returns an unmasked frame, as sent by servers.
+func serverFrame(fin bool, op byte, payload string) []byte {
+	b := op
+	if fin {
+		b |= 0x80
+	}
+	return append([]byte{b, byte(len(payload))}, payload...)
+}
+
+// readClientFrame returns the opcode and the unmasked payload of a frame sent by a client.
+func readClientFrame(t *testing.T, br *bufio.Reader) (byte, string) {
+	head := make([]byte, 6)
+	if _, err := io.ReadFull(br, head); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if head[1]&0x80 == 0 {
+		t.Fatalf("unmasked frame %x", head)
+	}
+	payload := make([]byte, head[1]&0x7f)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i := range payload {
+		payload[i] ^= head[2+i%4]
+	}
+	return head[0] & 0x0f, string(payload)
+}
+
+// newServer returns a WebSocket server that completes the handshake and hands the connection to serve.
+func newServer(t *testing.T, serve func(net.Conn, *bufio.Reader)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.URL.Path != "/trades" {
+			t.Errorf("%+v", r)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("%+v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		brw.WriteString("Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		brw.Flush()
+		serve(conn, brw.Reader)
+	}))
+}
+
+func TestFeed(t *testing.T) {
+	pong := make(chan string, 1)
+	srv := newServer(t, func(conn net.Conn, br *bufio.Reader) {
+		if op, msg := readClientFrame(t, br); op != opText || msg != `{"op":"subscribe"}` {
+			t.Errorf("%d %s", op, msg)
+		}
+		var frames []byte
+		frames = append(frames, serverFrame(true, opText, `{"op":"subscribed"}`)...)
+		frames = append(frames, serverFrame(true, opPing, "hi")...)
+		frames = append(frames, serverFrame(false, opText, `{"data":[{"T":1583141400123,`)...)
+		frames = append(frames, serverFrame(true, opContinuation, `"p":"100.5","q":2}]}`)...)
+		frames = append(frames, serverFrame(true, opClose, "")...)
+		conn.Write(frames)
+		op, msg := readClientFrame(t, br)
+		if op != opPong {
+			t.Errorf("%d %s", op, msg)
+		}
+		pong <- msg
+		readClientFrame(t, br)
+	})
+	defer srv.Close()
+
+	mapping := Mapping{Time: "data.0.T", Price: "data.0.p", Size: "data.0.q", TimeLayout: "unixms"}
+	feed, err := DialFeed("ws"+strings.TrimPrefix(srv.URL, "http")+"/trades", `{"op":"subscribe"}`, mapping)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer feed.Close()
+	tick, err := feed.Read()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := backtest.Tick{Time: time.Unix(0, 1583141400123*int64(time.Millisecond)).UTC(), Price: 100.5, Size: 2}
+	if tick != want {
+		t.Fatalf("%+v", tick)
+	}
+	if msg := <-pong; msg != "hi" {
+		t.Fatalf("%s", msg)
+	}
+	if _, err := feed.Read(); err != io.EOF {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestMapping(t *testing.T) {
+	received := time.Date(2020, time.March, 2, 9, 30, 0, 0, time.UTC)
+	tick, ok, err := Mapping{Price: "price"}.Tick([]byte(`{"price":12345.6789012}`), received)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !ok || tick != (backtest.Tick{Time: received, Price: 12345.6789012}) {
+		t.Fatalf("%v %+v", ok, tick)
+	}
+
+	if _, ok, err := (Mapping{Price: "price"}).Tick([]byte(`{"type":"heartbeat"}`), received); ok || err != nil {
+		t.Fatalf("%v %+v", ok, err)
+	}
+	if _, _, err := (Mapping{Price: "price"}).Tick([]byte(`{"price":"x"}`), received); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, _, err := (Mapping{Time: "t", Price: "price", TimeLayout: "unix"}).Tick([]byte(`{"price":1}`), received); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+type constAgent int
+
+func (a constAgent) Observe(backtest.Bar)   {}
+func (a constAgent) Act(backtest.Entry) int { return int(a) }
+
+func TestOrderLogger(t *testing.T) {
+	var buf bytes.Buffer
+	agent := &OrderLogger{Agent: constAgent(-2), Log: log.New(&buf, "", 0)}
+	e := backtest.Entry{Time: time.Date(2020, time.March, 2, 9, 30, 0, 0, time.UTC), Price: 100, Position: 3}
+	if position := agent.Act(e); position != -2 {
+		t.Fatalf("%d", position)
+	}
+	if got := buf.String(); got != "sell 5 at 100.00, from position 3 to -2, after the bar of 2020-03-02 09:30:00\n" {
+		t.Fatalf("%q", got)
+	}
+
+	buf.Reset()
+	e.Position = -2
+	agent.Act(e)
+	if buf.Len() != 0 {
+		t.Fatalf("%q", buf.String())
+	}
+}
@@ -0,0 +1,228 @@
+package live
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// The opcodes of the frames of the WebSocket protocol.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// maxMessage is the largest message read, beyond which the connection is broken rather than the memory exhausted.
+const maxMessage = 1 << 24
+
+// A Conn is the client side of a connection of the WebSocket protocol of RFC 6455, which reads text and binary messages and answers pings.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// mu guards the writes, which are made by both the reads, in answering pings, and the callers.
+	mu sync.Mutex
+}
+
+// Dial opens a connection to the ws or wss URL rawurl, with the header added to the request of the handshake.
+func Dial(rawurl string, header http.Header) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+		conn, err = net.Dial("tcp", host)
+	case "wss":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		conn, err = tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, errors.Errorf("unknown scheme %q, expected ws or wss", u.Scheme)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+
+	c := &Conn{conn: conn, br: bufio.NewReader(conn)}
+	if err := c.handshake(u, header); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "")
+	}
+	return c, nil
+}
+
+func (c *Conn) handshake(u *url.URL, header http.Header) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Wrap(err, "")
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{Method: "GET", URL: &url.URL{Path: u.Path, RawQuery: u.RawQuery}, Host: u.Host, Header: http.Header{}}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(c.conn); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	resp, err := http.ReadResponse(c.br, req)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return errors.Errorf("handshake failed with status %s", resp.Status)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != acceptKey(key) {
+		return errors.Errorf("invalid Sec-WebSocket-Accept %q", accept)
+	}
+	return nil
+}
+
+// acceptKey returns the Sec-WebSocket-Accept of a server that accepts the Sec-WebSocket-Key key.
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// ReadMessage returns the next text or binary message, or io.EOF if the server closed the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, errors.Wrap(err, "")
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return nil, io.EOF
+		case opText, opBinary, opContinuation:
+		default:
+			return nil, errors.Errorf("unknown opcode %d", op)
+		}
+		if len(msg)+len(payload) > maxMessage {
+			return nil, errors.Errorf("message longer than %d bytes", maxMessage)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, errors.Wrap(err, "")
+	}
+	fin, op := head[0]&0x80 != 0, head[0]&0x0f
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, errors.Wrap(err, "")
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, errors.Wrap(err, "")
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > maxMessage {
+		return false, 0, nil, errors.Errorf("frame longer than %d bytes", maxMessage)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, errors.Wrap(err, "")
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, errors.Wrap(err, "")
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// WriteText sends a text message.
+func (c *Conn) WriteText(msg []byte) error {
+	return c.writeFrame(opText, msg)
+}
+
+// writeFrame writes a single frame, which is masked as required of clients.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	frame := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(n))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return errors.Wrap(err, "")
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(frame)
+	return errors.Wrap(err, "")
+}
+
+// Close sends a close frame, and closes the connection.
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, nil)
+	return errors.Wrap(c.conn.Close(), "")
+}
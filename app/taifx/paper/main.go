@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/app/taifx/backtest"
+	"github.com/fumin/ctw/app/taifx/live"
+	"github.com/fumin/ctw/app/taifx/renko"
+	"github.com/pkg/errors"
+)
+
+var (
+	flagConfig = flag.String("c", `{
+		"URL": "wss://stream.binance.com:9443/ws/btcusdt@trade",
+		"Mapping": {"Time": "T", "Price": "p", "TimeLayout": "unixms"},
+		"Period": "1m",
+		"Renko": {"Sizing": "percent", "Size": 0.001},
+		"Depth": 48,
+		"Leverage": 1,
+		"Balance": 10000
+		}`, "configuration")
+)
+
+// train returns a model trained on the bricks built by builder from the candles of the history in config, if any.
+func train(config Config, builder *renko.Builder) (*ctw.CTW, error) {
+	model := ctw.NewCTWDepth(config.Depth, nil)
+	if config.History == "" {
+		return model, nil
+	}
+	f, err := os.Open(config.History)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	defer f.Close()
+	candles, err := backtest.NewCandleSource(f, config.HistoryTicks)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	history := renko.NewFeed(candles, builder)
+	var n int
+	for {
+		rk, err := history.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, "")
+		}
+		model.Observe(rk.Direction)
+		n++
+	}
+	log.Printf("trained on %d bricks", n)
+	return model, nil
+}
+
+func run(config Config) error {
+	var period time.Duration
+	if config.Period != "" {
+		var err error
+		if period, err = time.ParseDuration(config.Period); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	builder, err := renko.New(config.Renko)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	model, err := train(config, builder)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	orders := log.New(os.Stderr, "order: ", log.LstdFlags|log.Lmicroseconds)
+	if config.Orders != "" {
+		f, err := os.OpenFile(config.Orders, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return errors.Wrap(err, "")
+		}
+		defer f.Close()
+		orders = log.New(f, "", log.LstdFlags|log.Lmicroseconds)
+	}
+
+	ticks, err := live.DialFeed(config.URL, config.Subscribe, config.Mapping)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer ticks.Close()
+	// Stop on interrupt by closing the connection, which ends the run.
+	var interrupted int32
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		atomic.StoreInt32(&interrupted, 1)
+		ticks.Close()
+	}()
+	data := renko.NewFeed(backtest.NewCandleAggregator(ticks, period), builder)
+
+	start, err := data.Next()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	model.Observe(start.Direction)
+	log.Printf("paper trading from %+v", start)
+
+	commission, err := backtest.NewCommissionModel(config.Commission, config.TransactionCost)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	portfolio := backtest.NewPortfolio(start, config.Balance, commission)
+	portfolio.Slippage = config.Slippage
+	portfolio.Margin = config.Margin
+	sizer, err := config.sizer()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	var feed backtest.DataFeed = data
+	var guard *backtest.GuardedFeed
+	if config.Guard {
+		guard = backtest.Guard(feed)
+		feed = guard
+	}
+	agent := &live.OrderLogger{Agent: &backtest.NextStep{Sizer: sizer, Model: guard.Model(model)}, Log: orders}
+	var printEntry func(backtest.Entry)
+	if config.Report == "" {
+		fmt.Println(backtest.CSVHeader)
+		printEntry = func(e backtest.Entry) { fmt.Println(e.CSV()) }
+	}
+	if err := backtest.Run(feed, agent, portfolio, printEntry); err != nil && atomic.LoadInt32(&interrupted) == 0 {
+		return errors.Wrap(err, "")
+	}
+	log.Printf("summary:\n%s", portfolio.Summary())
+	if config.Report != "" {
+		if err := portfolio.Report().WriteFiles(config.Report); err != nil {
+			return errors.Wrap(err, "")
+		}
+		log.Printf("report written to %s.json and %s.html", config.Report, config.Report)
+	}
+
+	return nil
+}
+
+type Config struct {
+	// URL is the WebSocket endpoint of the trades, to which Subscribe, if not empty, is sent on connecting.
+	URL       string
+	Subscribe string
+	// Mapping maps the messages of the endpoint to ticks.
+	Mapping live.Mapping
+
+	// Period is the period of the candles into which the ticks are aggregated, such as 1m, or empty for a candle of each tick.
+	Period string
+	Renko  renko.Config
+
+	Depth           int
+	Leverage        float64
+	Balance         float64
+	TransactionCost float64
+	Slippage        backtest.Slippage
+	// Margin is the margin required of the positions, such as {"Initial": 0.1, "Maintenance": 0.08}, below which they are liquidated.
+	Margin backtest.Margin
+	// Commission is the commission of the trades, which is TransactionCost per contract if its model is empty.
+	Commission backtest.CommissionConfig
+	// Sizer sizes the positions, which are worth Leverage times the balance if its sizing is empty.
+	Sizer backtest.SizerConfig
+
+	// History, if not empty, is a file of candles, or of ticks if HistoryTicks is not null, on whose bricks the model is trained before trading.
+	History      string
+	HistoryTicks *backtest.TickConfig
+
+	// Report, if not empty, is the prefix of the paths of the JSON and HTML reports, such as out/es for out/es.json and out/es.html, which are written instead of printing the entries.
+	Report string
+
+	// Guard, if true, panics on look-ahead bias, as detected by backtest.Guard, for debugging.
+	Guard bool
+
+	// Orders, if not empty, is the file to which the orders are appended, instead of the standard error.
+	Orders string
+}
+
+// sizer returns a new sizer of the positions.
+func (config Config) sizer() (backtest.PositionSizer, error) {
+	sizing := config.Sizer
+	if sizing.Sizing == "" {
+		sizing = backtest.SizerConfig{Sizing: "fractional", Fraction: config.Leverage}
+	}
+	return backtest.NewPositionSizer(sizing)
+}
+
+func parseConfig() (Config, error) {
+	config := Config{}
+	if err := json.Unmarshal([]byte(*flagConfig), &config); err != nil {
+		return Config{}, errors.Wrap(err, "")
+	}
+	configB, err := json.Marshal(config)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "")
+	}
+	log.Printf("config: %s", configB)
+	return config, nil
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+
+	config, err := parseConfig()
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if err := run(config); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Package renko builds renko bricks from candles, with bricks sized by a percentage of the price, by a fixed number of points, or by a multiple of the average true range.
+package renko
+
+import (
+	"io"
+	"math"
+	"time"
+
+	"github.com/fumin/ctw/app/taifx/backtest"
+	"github.com/pkg/errors"
+)
+
+// A Brick is a renko brick.
+type Brick struct {
+	// Time is the time of the candle that formed the brick.
+	Time  time.Time
+	Open  float64
+	Close float64
+	// High and Low are the extremes of the prices while the brick was formed, whose excesses beyond Open and Close are the wicks of the brick.
+	High float64
+	Low  float64
+	// Direction is 1 for an up brick, and 0 for a down brick.
+	Direction int
+	// Volume is the volume of the candles since the last brick, or zero if the brick was formed by the same candle as the last one.
+	Volume int64
+}
+
+// Bar returns the bar of the close of the brick.
+func (b Brick) Bar() backtest.Bar {
+	return backtest.Bar{Time: b.Time, Price: b.Close, Direction: b.Direction, Volume: b.Volume}
+}
+
+// A Sizer determines the sizes of bricks.
+type Sizer interface {
+	// Update informs the sizer of a candle, before the bricks it forms.
+	Update(backtest.Candle)
+
+	// Size returns the size of the bricks following a brick that closed at price, or zero if it is not yet known.
+	Size(price float64) float64
+}
+
+// Percent sizes bricks by a fraction of the close of the last brick.
+type Percent float64
+
+// Update does nothing.
+func (p Percent) Update(backtest.Candle) {}
+
+// Size returns the fraction of price.
+func (p Percent) Size(price float64) float64 { return float64(p) * price }
+
+// Fixed sizes bricks by a fixed number of points.
+type Fixed float64
+
+// Update does nothing.
+func (f Fixed) Update(backtest.Candle) {}
+
+// Size returns the number of points.
+func (f Fixed) Size(float64) float64 { return float64(f) }
+
+// ATR sizes bricks by a multiple of the average true range of the candles, which is smoothed as by Wilder.
+type ATR struct {
+	Period     int
+	Multiplier float64
+
+	n         int
+	atr       float64
+	prevClose float64
+}
+
+// Update updates the average true range with the candle.
+func (a *ATR) Update(c backtest.Candle) {
+	tr := c.High - c.Low
+	if a.n > 0 {
+		tr = math.Max(tr, math.Max(math.Abs(c.High-a.prevClose), math.Abs(c.Low-a.prevClose)))
+	}
+	a.prevClose = c.Close
+	a.n++
+	if a.n <= a.Period {
+		a.atr += (tr - a.atr) / float64(a.n)
+	} else {
+		a.atr = (a.atr*float64(a.Period-1) + tr) / float64(a.Period)
+	}
+}
+
+// Size returns the multiple of the average true range, or zero before Period candles.
+func (a *ATR) Size(float64) float64 {
+	if a.n < a.Period {
+		return 0
+	}
+	return a.Multiplier * a.atr
+}
+
+// A Config configures a Builder.
+type Config struct {
+	// Sizing is the sizing of the bricks, which is percent, fixed, or atr.
+	Sizing string
+	// Size is the fraction of the price of percent, the number of points of fixed, or the multiple of the average true range of atr.
+	Size float64
+	// Period is the number of candles of the average true range of atr.
+	Period int
+	// Reversal is the number of bricks by which the price must move against the last brick to form a brick in the other direction, which is 1 if zero.
+	// Classic renko charts reverse by 2 bricks.
+	Reversal int
+	// Wicks is whether the bricks are formed by the highs and lows of the candles, rather than only by their closes.
+	Wicks bool
+}
+
+// A Builder builds renko bricks from candles.
+type Builder struct {
+	sizer    Sizer
+	reversal float64
+	wicks    bool
+
+	started bool
+	last    Brick
+	high    float64
+	low     float64
+	volume  int64
+}
+
+// New returns a builder configured by config.
+func New(config Config) (*Builder, error) {
+	var sizer Sizer
+	switch config.Sizing {
+	case "percent":
+		sizer = Percent(config.Size)
+	case "fixed":
+		sizer = Fixed(config.Size)
+	case "atr":
+		if config.Period < 1 {
+			return nil, errors.Errorf("invalid period %d", config.Period)
+		}
+		sizer = &ATR{Period: config.Period, Multiplier: config.Size}
+	default:
+		return nil, errors.Errorf("unknown sizing %q, expected percent, fixed, or atr", config.Sizing)
+	}
+	if config.Size <= 0 {
+		return nil, errors.Errorf("invalid size %f", config.Size)
+	}
+	if config.Reversal < 0 {
+		return nil, errors.Errorf("invalid reversal %d", config.Reversal)
+	}
+	return NewBuilder(sizer, config.Reversal, config.Wicks), nil
+}
+
+// NewBuilder returns a builder of bricks sized by sizer, which reverse by reversal bricks, or 1 if zero, and which are formed by the highs and lows of the candles if wicks is true.
+func NewBuilder(sizer Sizer, reversal int, wicks bool) *Builder {
+	if reversal == 0 {
+		reversal = 1
+	}
+	return &Builder{sizer: sizer, reversal: float64(reversal), wicks: wicks}
+}
+
+// Add returns the bricks formed by the candle, of which there may be none or several.
+// The first price of the candles, which is the open of the first candle with wicks and its close otherwise, only sets the price from which the bricks are formed.
+// With wicks, the prices of a candle are taken to go from its open to its low and then its high if it closed at or above its open, and to its high and then its low otherwise, before its close.
+func (b *Builder) Add(c backtest.Candle) []Brick {
+	b.sizer.Update(c)
+	b.volume += c.Volume
+
+	path := []float64{c.Close}
+	if b.wicks {
+		if c.Close >= c.Open {
+			path = []float64{c.Open, c.Low, c.High, c.Close}
+		} else {
+			path = []float64{c.Open, c.High, c.Low, c.Close}
+		}
+	}
+	var bricks []Brick
+	for _, price := range path {
+		bricks = b.move(c.Time, price, bricks)
+	}
+	return bricks
+}
+
+// move appends to bricks those formed by the price moving to price at t.
+func (b *Builder) move(t time.Time, price float64, bricks []Brick) []Brick {
+	if !b.started {
+		b.started = true
+		b.last = Brick{Open: price, Close: price, Direction: -1}
+		b.high, b.low = price, price
+		return bricks
+	}
+
+	for {
+		size := b.sizer.Size(b.last.Close)
+		if size <= 0 {
+			break
+		}
+		up, down := b.last.Close+size, b.last.Close-size
+		switch b.last.Direction {
+		case 1:
+			down = b.last.Close - b.reversal*size
+		case 0:
+			up = b.last.Close + b.reversal*size
+		}
+
+		if price > down && price < up {
+			break
+		}
+
+		brick := Brick{Time: t, Volume: b.volume}
+		if price >= up {
+			brick.Direction = 1
+			brick.Open, brick.Close = up-size, up
+			brick.High, brick.Low = up, math.Min(b.low, brick.Open)
+		} else {
+			brick.Direction = 0
+			brick.Open, brick.Close = down+size, down
+			brick.High, brick.Low = math.Max(b.high, brick.Open), down
+		}
+		bricks = append(bricks, brick)
+		b.last = brick
+		b.high, b.low = brick.Close, brick.Close
+		b.volume = 0
+	}
+	b.high = math.Max(b.high, price)
+	b.low = math.Min(b.low, price)
+	return bricks
+}
+
+// A Feed is a backtest.DataFeed of the bricks built from candles.
+type Feed struct {
+	candles backtest.CandleSource
+	builder *Builder
+	bricks  []Brick
+}
+
+// NewFeed returns a feed of the bricks built by builder from candles.
+func NewFeed(candles backtest.CandleSource, builder *Builder) *Feed {
+	return &Feed{candles: candles, builder: builder}
+}
+
+// Next returns the bar of the next brick, or io.EOF if the candles end before it is formed.
+func (f *Feed) Next() (backtest.Bar, error) {
+	brick, err := f.NextBrick()
+	if err != nil {
+		return backtest.Bar{}, err
+	}
+	return brick.Bar(), nil
+}
+
+// NextBrick returns the next brick, or io.EOF if the candles end before it is formed.
+func (f *Feed) NextBrick() (Brick, error) {
+	for len(f.bricks) == 0 {
+		c, err := f.candles.Read()
+		if err != nil {
+			if err == io.EOF {
+				return Brick{}, io.EOF
+			}
+			return Brick{}, errors.Wrap(err, "")
+		}
+		f.bricks = f.builder.Add(c)
+	}
+	brick := f.bricks[0]
+	f.bricks = f.bricks[1:]
+	return brick, nil
+}
@@ -0,0 +1,155 @@
+package renko
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fumin/ctw/app/taifx/backtest"
+)
+
+// closes returns candles closing at prices, whose opens, highs, and lows are their closes.
+func closes(prices ...float64) []backtest.Candle {
+	var candles []backtest.Candle
+	for i, p := range prices {
+		candles = append(candles, backtest.Candle{Time: time.Unix(int64(i), 0), Open: p, High: p, Low: p, Close: p, Volume: 1})
+	}
+	return candles
+}
+
+// build returns the bricks built by b from candles, as pairs of their opens and closes.
+func build(b *Builder, candles []backtest.Candle) [][2]float64 {
+	var bricks [][2]float64
+	for _, c := range candles {
+		for _, brick := range b.Add(c) {
+			bricks = append(bricks, [2]float64{brick.Open, brick.Close})
+		}
+	}
+	return bricks
+}
+
+func equal(a, b [][2]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i][0]-b[i][0]) > 1e-9 || math.Abs(a[i][1]-b[i][1]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFixed(t *testing.T) {
+	candles := closes(100, 104, 111, 125, 119, 115, 99)
+	// The move to 125 forms two bricks, and the fall to 99 two bricks in reverse.
+	want := [][2]float64{{100, 110}, {110, 120}, {120, 110}, {110, 100}}
+	if got := build(NewBuilder(Fixed(10), 1, false), candles); !equal(got, want) {
+		t.Fatalf("%v", got)
+	}
+
+	// A reversal by two bricks opens at the bottom of the last up brick.
+	want = [][2]float64{{100, 110}, {110, 120}, {110, 100}}
+	if got := build(NewBuilder(Fixed(10), 2, false), candles); !equal(got, want) {
+		t.Fatalf("%v", got)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	got := build(NewBuilder(Percent(0.1), 1, false), closes(100, 111, 122, 108))
+	want := [][2]float64{{100, 110}, {110, 121}, {121, 108.9}}
+	if !equal(got, want) {
+		t.Fatalf("%v", got)
+	}
+}
+
+func TestATR(t *testing.T) {
+	atr := &ATR{Period: 2, Multiplier: 2}
+	atr.Update(backtest.Candle{High: 11, Low: 9, Close: 10})
+	if s := atr.Size(0); s != 0 {
+		t.Fatalf("%f", s)
+	}
+	// The true range of the gap up from 10 is 14 - 10.
+	atr.Update(backtest.Candle{High: 14, Low: 13, Close: 13})
+	if s := atr.Size(0); s != 2*3 {
+		t.Fatalf("%f", s)
+	}
+	atr.Update(backtest.Candle{High: 14, Low: 12, Close: 13})
+	if s := atr.Size(0); s != 2*2.5 {
+		t.Fatalf("%f", s)
+	}
+}
+
+func TestWicks(t *testing.T) {
+	// The candle dips to 95 before rising to 112, which forms an up brick with a lower wick, but its close of 104 forms no brick.
+	c := backtest.Candle{Open: 100, High: 112, Low: 95, Close: 104, Volume: 7}
+	b := NewBuilder(Fixed(10), 1, false)
+	if bricks := b.Add(backtest.Candle{Close: 100}); len(bricks) != 0 {
+		t.Fatalf("%+v", bricks)
+	}
+	if bricks := b.Add(c); len(bricks) != 0 {
+		t.Fatalf("%+v", bricks)
+	}
+
+	b = NewBuilder(Fixed(10), 1, true)
+	b.Add(backtest.Candle{Open: 100, High: 100, Low: 100, Close: 100, Volume: 3})
+	bricks := b.Add(c)
+	want := Brick{Open: 100, Close: 110, High: 110, Low: 95, Direction: 1, Volume: 10}
+	if len(bricks) != 1 || bricks[0] != want {
+		t.Fatalf("%+v", bricks)
+	}
+
+	// A falling candle goes to its high before its low.
+	bricks = b.Add(backtest.Candle{Open: 109, High: 118, Low: 99, Close: 101})
+	if len(bricks) != 1 || bricks[0].Open != 110 || bricks[0].Close != 100 || bricks[0].High != 118 {
+		t.Fatalf("%+v", bricks)
+	}
+}
+
+type sliceSource []backtest.Candle
+
+func (s *sliceSource) Read() (backtest.Candle, error) {
+	if len(*s) == 0 {
+		return backtest.Candle{}, io.EOF
+	}
+	c := (*s)[0]
+	*s = (*s)[1:]
+	return c, nil
+}
+
+func TestFeed(t *testing.T) {
+	src := sliceSource(closes(100, 125, 126, 109))
+	feed := NewFeed(&src, NewBuilder(Fixed(10), 1, false))
+	var bars []backtest.Bar
+	for {
+		bar, err := feed.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		bars = append(bars, bar)
+	}
+	if len(bars) != 3 || bars[1].Price != 120 || bars[2].Direction != 0 {
+		t.Fatalf("%+v", bars)
+	}
+	// The volume of the candles since the last brick goes to the first brick formed by a candle.
+	if bars[0].Volume != 2 || bars[1].Volume != 0 || bars[2].Volume != 2 {
+		t.Fatalf("%+v", bars)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, config := range []Config{{Sizing: "percent", Size: 0.01}, {Sizing: "fixed", Size: 1, Reversal: 2, Wicks: true}, {Sizing: "atr", Size: 1, Period: 14}} {
+		if _, err := New(config); err != nil {
+			t.Fatalf("%+v %+v", config, err)
+		}
+	}
+	for _, config := range []Config{{Sizing: "box", Size: 1}, {Sizing: "fixed"}, {Sizing: "atr", Size: 1}, {Sizing: "fixed", Size: 1, Reversal: -1}} {
+		if _, err := New(config); err == nil {
+			t.Fatalf("%+v", config)
+		}
+	}
+}
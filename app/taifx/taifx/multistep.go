@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -9,10 +8,10 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/app/taifx/backtest"
 	"github.com/fumin/ctw/app/taifx/mcts"
 	"github.com/pkg/errors"
 )
@@ -20,168 +19,55 @@ import (
 var (
 	flagConfig = flag.String("c", `{
 		"Data": "txf_renko_0001.csv",
-		"PriceDelta": 0.001
+		"PriceDelta": 0.001,
 		"TransactionCost": 0.5,
 		"Depth": 48,
-		"Leverage": 3,
+		"Leverage": 3
 		}`, "configuration")
 )
 
-type Bar struct {
-	Time      time.Time
-	Price     float64
-	Direction int
-}
-
-func parseData(config Config) ([]Bar, []Bar, error) {
-	f, err := os.Open(config.Data)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "")
-	}
-	defer f.Close()
-	records, err := csv.NewReader(f).ReadAll()
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "")
-	}
-	// Remove header.
-	records = records[1:]
-
-	train := make([]Bar, 0, 1024)
-	test := make([]Bar, 0, 1024)
-	for _, r := range records {
-		timeStr := r[1]
-		priceStr := r[3]
-		directionStr := r[8]
-
-		t, err := time.Parse("2006-01-02 15:04:05", timeStr)
-		if err != nil {
-			return nil, nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
-		}
-		price, err := strconv.ParseFloat(priceStr, 64)
-		if err != nil {
-			return nil, nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
-		}
-		var direction int
-		switch directionStr {
-		case "True":
-			direction = 1
-		case "False":
-			direction = 0
-		default:
-			return nil, nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
-		}
-		bar := Bar{}
-		bar.Time = t
-		bar.Price = price
-		bar.Direction = direction
-
-		if t.Before(time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)) {
-			train = append(train, bar)
-		} else {
-			test = append(test, bar)
-		}
-	}
-
-	return train, test, nil
-}
-
-type Data struct {
-	Bar    []Bar
-	Cursor int
-}
-
-func NewData(bar []Bar) *Data {
-	d := &Data{}
-	d.Bar = bar
-	d.Cursor = 0
-	return d
-}
-
-func (d *Data) Consume() Bar {
-	bar := d.Bar[d.Cursor]
-	d.Cursor++
-	return bar
-}
-
-type StatItem struct {
-	Time            time.Time
-	Price           float64
-	Action          int
-	Position        int
-	TransactionCost float64
-	ProfitLoss      float64
-	Balance         float64
-}
-
-type Stat struct {
-	TransactionCost float64
-	Leverage        float64
-	Items           []StatItem
-}
-
-func NewStat(transactionCost, leverage float64, item StatItem) *Stat {
-	s := &Stat{}
-	s.TransactionCost = transactionCost
-	s.Leverage = leverage
-	s.Items = make([]StatItem, 0, 1024)
-	s.Items = append(s.Items, item)
-	return s
-}
-
-func (s *Stat) Record(action int, nextBar Bar) {
-	prevItem := s.Items[len(s.Items)-1]
-
-	item := StatItem{}
-	item.Time = nextBar.Time
-	item.Price = nextBar.Price
-	item.Action = action
-	item.Position = action * int(prevItem.Balance/prevItem.Price*s.Leverage)
-	item.TransactionCost = s.TransactionCost * math.Abs(float64(item.Position-prevItem.Position))
-
-	profitLoss := nextBar.Price - prevItem.Price
-	profitLoss *= float64(item.Position)
-	item.ProfitLoss = profitLoss
-
-	item.Balance = prevItem.Balance + profitLoss - item.TransactionCost
-
-	s.Items = append(s.Items, item)
-}
-
-func (s *Stat) Bankrupt() bool {
-	item := s.Items[len(s.Items)-1]
-	if item.Balance < 0 {
-		return true
-	}
-	return false
-}
-
-type nextStep struct{}
-
-func (a *nextStep) trade(model *ctw.CTW) int {
-	prob0 := model.Prob0()
-	if prob0 > 0.5 {
-		return -1
-	}
-	return 1
-}
-
+// An mctsAgent plans the direction of its position every steps bars by Monte Carlo tree search over the prices simulated by its model.
 type mctsAgent struct {
 	priceDelta float64
 	tcost      float64
+	sizer      backtest.PositionSizer
+	model      *ctw.CTW
 	algo       *mcts.MCTS
 	states     []mctsState
+
+	steps  int
+	step   int
+	action int
 }
 
-func newMCTSAgent(priceDelta, tcost float64, steps int) *mctsAgent {
+func newMCTSAgent(model *ctw.CTW, priceDelta, tcost float64, sizer backtest.PositionSizer, steps int) *mctsAgent {
 	agent := &mctsAgent{}
 	agent.priceDelta = priceDelta
 	agent.tcost = tcost
+	agent.sizer = sizer
+	agent.model = model
 	agent.algo = mcts.NewMCTS()
 	// plus 1 for the root state.
 	agent.states = make([]mctsState, steps+1)
+	agent.steps = steps
 	return agent
 }
 
+func (agent *mctsAgent) Observe(bar backtest.Bar) {
+	agent.model.Observe(bar.Direction)
+	agent.sizer.Observe(bar)
+}
+
+// Act holds the contracts sized by its sizer in the direction planned at the last multiple of steps bars.
+func (agent *mctsAgent) Act(e backtest.Entry) int {
+	size := agent.sizer.Size(e)
+	if agent.step%agent.steps == 0 {
+		agent.action = agent.trade(e.Price, e.Position)
+	}
+	agent.step++
+	return agent.action * size
+}
+
 type mctsState struct {
 	price    float64
 	position int
@@ -264,11 +150,11 @@ func (env *mctsEnv) Reward() float64 {
 	return profitLoss - transactionCost
 }
 
-func (agent *mctsAgent) trade(model *ctw.CTW, price float64, position int) int {
+func (agent *mctsAgent) trade(price float64, position int) int {
 	env := &mctsEnv{}
 	env.priceDelta = agent.priceDelta
 	env.tcost = agent.tcost
-	env.reverter = ctw.NewCTWReverter(model)
+	env.reverter = ctw.NewCTWReverter(agent.model)
 	env.states = agent.states
 	env.states[0] = mctsState{price: price, position: position}
 	agent.algo.NewRoot()
@@ -316,75 +202,65 @@ func (agent *mctsAgent) trade(model *ctw.CTW, price float64, position int) int {
 }
 
 func run(config Config) error {
-	trainBar, testBar, err := parseData(config)
+	f, err := os.Open(config.Data)
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
+	defer f.Close()
+	bars, err := backtest.ReadRenkoCSV(f)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	trainBar, testBar := backtest.Split(bars, time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC))
 
 	log.Printf("train %+v", trainBar[:3])
 	log.Printf("test %+v", testBar[:3])
 
-	trainData := NewData(trainBar)
-	testData := NewData(testBar)
-
 	context := make([]int, 0, config.Depth)
-	for i := 0; i < config.Depth; i++ {
-		context = append(context, trainData.Consume().Direction)
+	for _, bar := range trainBar[:config.Depth] {
+		context = append(context, bar.Direction)
 	}
 	model := ctw.NewCTW(context)
 
 	// Train.
-	for {
-		if trainData.Cursor >= len(trainData.Bar) {
-			break
-		}
-		bar := trainData.Consume()
+	for _, bar := range trainBar[config.Depth:] {
 		model.Observe(bar.Direction)
 	}
 
 	// Test.
-	curBar := trainData.Bar[len(trainData.Bar)-1]
-	item0 := StatItem{}
-	item0.Time = curBar.Time
-	item0.Price = curBar.Price
-	item0.Balance = 20000
-	testStat := NewStat(config.TransactionCost, config.Leverage, item0)
-	// agent := nextStep{}
-	agent := newMCTSAgent(config.PriceDelta, config.TransactionCost, 24)
-	fmt.Printf("time,price,action,position,transactionCost,profitLoss,balance\n")
-	step := 0
-	for {
-		var action int
-		if step%24 == 0 {
-			curItem := testStat.Items[len(testStat.Items)-1]
-			action = agent.trade(model, curItem.Price, curItem.Position)
-		} else {
-			curItem := testStat.Items[len(testStat.Items)-1]
-			action = curItem.Action
-		}
-		step++
-
-		if testData.Cursor >= len(testData.Bar) {
-			break
-		}
-		nextBar := testData.Consume()
-
-		testStat.Record(action, nextBar)
-		if testStat.Bankrupt() {
-			break
+	commission, err := backtest.NewCommissionModel(config.Commission, config.TransactionCost)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	portfolio := backtest.NewPortfolio(trainBar[len(trainBar)-1], 20000, commission)
+	portfolio.Slippage = config.Slippage
+	portfolio.Margin = config.Margin
+	sizer, err := config.sizer()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	// agent := &backtest.NextStep{Sizer: sizer, Model: model}
+	agent := newMCTSAgent(model, config.PriceDelta, config.TransactionCost, sizer, 24)
+	var printEntry func(backtest.Entry)
+	if config.Report == "" {
+		fmt.Println(backtest.CSVHeader)
+		printEntry = func(e backtest.Entry) { fmt.Println(e.CSV()) }
+	}
+	var feed backtest.DataFeed = backtest.NewSliceFeed(testBar)
+	if config.Guard {
+		feed = backtest.Guard(feed)
+	}
+	if err := backtest.Run(feed, agent, portfolio, printEntry); err != nil {
+		return errors.Wrap(err, "")
+	}
+	log.Printf("summary:\n%s", portfolio.Summary())
+	if config.Report != "" {
+		if err := portfolio.Report().WriteFiles(config.Report); err != nil {
+			return errors.Wrap(err, "")
 		}
-
-		model.Observe(nextBar.Direction)
-
-		s := testStat.Items[len(testStat.Items)-1]
-		fmt.Printf("%s,%.0f,%d,%d,%.2f,%.0f,%.2f\n", s.Time.Format("2006-01-02 15:04:05"), s.Price, s.Action, s.Position, s.TransactionCost, s.ProfitLoss, s.Balance)
+		log.Printf("report written to %s.json and %s.html", config.Report, config.Report)
 	}
 
-	// fmt.Printf("time,price,action,position,transactionCost,profitLoss,balance\n")
-	// for _, s := range testStat.Items {
-	// 	fmt.Printf("%s,%.0f,%d,%d,%.2f,%.0f,%.2f\n", s.Time.Format("2006-01-02 15:04:05"), s.Price, s.Action, s.Position, s.TransactionCost, s.ProfitLoss, s.Balance)
-	// }
-
 	return nil
 }
 
@@ -394,6 +270,33 @@ type Config struct {
 	TransactionCost float64
 	Depth           int
 	Leverage        float64
+
+	// Slippage is the slippage of the fills, such as {"Ticks": 1, "TickSize": 0.25} for a tick per side.
+	Slippage backtest.Slippage
+
+	// Margin is the margin required of the positions, such as {"Initial": 0.1, "Maintenance": 0.08}, below which they are liquidated.
+	Margin backtest.Margin
+
+	// Guard, if true, panics on bars out of chronological order, as detected by backtest.Guard, for debugging; the model of the agent, which plans by observing hypothetical bars, is not guarded.
+	Guard bool
+
+	// Commission is the commission of the trades, which is TransactionCost per contract if its model is empty.
+	Commission backtest.CommissionConfig
+
+	// Report, if not empty, is the prefix of the paths of the JSON and HTML reports, such as out/es for out/es.json and out/es.html, which are written instead of printing the entries.
+	Report string
+
+	// Sizer sizes the positions, which are worth Leverage times the balance if its sizing is empty.
+	Sizer backtest.SizerConfig
+}
+
+// sizer returns a new sizer of the positions.
+func (config Config) sizer() (backtest.PositionSizer, error) {
+	sizing := config.Sizer
+	if sizing.Sizing == "" {
+		sizing = backtest.SizerConfig{Sizing: "fractional", Fraction: config.Leverage}
+	}
+	return backtest.NewPositionSizer(sizing)
 }
 
 func parseConfig() (Config, error) {
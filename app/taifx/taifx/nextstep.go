@@ -1,217 +1,158 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"math"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/app/taifx/backtest"
+	"github.com/fumin/ctw/markov"
 	"github.com/pkg/errors"
 )
 
 var (
 	flagConfig = flag.String("c", `{
 		"Data": "txf_renko_0001.csv",
+		"Model": "ctw",
 		"Depth": 48
 		}`, "configuration")
 )
 
-type Bar struct {
-	Time      time.Time
-	Price     float64
-	Direction int
-}
-
-func parseData(config Config) ([]Bar, []Bar, error) {
+func run(config Config) error {
 	f, err := os.Open(config.Data)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "")
+		return errors.Wrap(err, "")
 	}
 	defer f.Close()
-	records, err := csv.NewReader(f).ReadAll()
+	bars, err := backtest.ReadRenkoCSV(f)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "")
+		return errors.Wrap(err, "")
 	}
-	// Remove header.
-	records = records[1:]
-
-	train := make([]Bar, 0, 1024)
-	test := make([]Bar, 0, 1024)
-	for _, r := range records {
-		timeStr := r[1]
-		priceStr := r[3]
-		directionStr := r[8]
-
-		t, err := time.Parse("2006-01-02 15:04:05", timeStr)
+	newAgent := func(trainBar []backtest.Bar, guard *backtest.GuardedFeed) (backtest.Agent, error) {
+		model, err := newModel(config)
 		if err != nil {
-			return nil, nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
+			return nil, errors.Wrap(err, "")
+		}
+		for _, bar := range trainBar {
+			model.Observe(bar.Direction)
 		}
-		price, err := strconv.ParseFloat(priceStr, 64)
+		sizer, err := config.sizer()
 		if err != nil {
-			return nil, nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
+			return nil, errors.Wrap(err, "")
 		}
-		var direction int
-		switch directionStr {
-		case "True":
-			direction = 1
-		case "False":
-			direction = 0
-		default:
-			return nil, nil, errors.Wrap(err, fmt.Sprintf("%+v", r))
+		return &backtest.NextStep{Sizer: sizer, Model: guard.Model(model)}, nil
+	}
+
+	commission, err := backtest.NewCommissionModel(config.Commission, 0)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	var portfolio *backtest.Portfolio
+	if config.Test > 0 {
+		if config.Train < 1 || config.Train >= len(bars) {
+			return errors.Errorf("%d training bars out of %d bars", config.Train, len(bars))
+		}
+		log.Printf("walk forward in windows of %d training bars and %d test bars from %s", config.Train, config.Test, bars[config.Train].Time)
+		portfolio = backtest.NewPortfolio(bars[config.Train-1], 20000, commission)
+		portfolio.Slippage = config.Slippage
+		portfolio.Margin = config.Margin
+		if err := backtest.WalkForward(bars, config.Train, config.Test, newAgent, portfolio, nil, config.Guard); err != nil {
+			return errors.Wrap(err, "")
 		}
-		bar := Bar{}
-		bar.Time = t
-		bar.Price = price
-		bar.Direction = direction
-
-		if t.Before(time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)) {
-			train = append(train, bar)
-		} else {
-			test = append(test, bar)
+	} else {
+		trainBar, testBar := backtest.Split(bars, time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC))
+		log.Printf("train %+v", trainBar[:3])
+		log.Printf("test %+v", testBar[:3])
+
+		var feed backtest.DataFeed = backtest.NewSliceFeed(testBar)
+		var guard *backtest.GuardedFeed
+		if config.Guard {
+			guard = backtest.Guard(feed)
+			feed = guard
+		}
+		agent, err := newAgent(trainBar, guard)
+		if err != nil {
+			return errors.Wrap(err, "")
+		}
+		portfolio = backtest.NewPortfolio(trainBar[len(trainBar)-1], 20000, commission)
+		portfolio.Slippage = config.Slippage
+		portfolio.Margin = config.Margin
+		if err := backtest.Run(feed, agent, portfolio, nil); err != nil {
+			return errors.Wrap(err, "")
 		}
 	}
 
-	return train, test, nil
-}
-
-type Data struct {
-	Bar    []Bar
-	Cursor int
-}
-
-func NewData(bar []Bar) *Data {
-	d := &Data{}
-	d.Bar = bar
-	d.Cursor = 0
-	return d
-}
-
-func (d *Data) Consume() Bar {
-	bar := d.Bar[d.Cursor]
-	d.Cursor++
-	return bar
-}
-
-type StatItem struct {
-	Time       time.Time
-	Price      float64
-	Prediction int
-	ProfitLoss float64
-	Balance    float64
-}
-
-type Stat struct {
-	Leverage float64
-	Items    []StatItem
-}
-
-func NewStat(balance, leverage float64) *Stat {
-	s := &Stat{}
-	s.Leverage = leverage
-	s.Items = make([]StatItem, 0, 1024)
-
-	item := StatItem{}
-	item.Balance = balance
-	s.Items = append(s.Items, item)
-
-	return s
-}
-
-func (s *Stat) Record(curBar Bar, prob0 float64, nextBar Bar) {
-	prediction := 0
-	if prob0 < 0.5 {
-		prediction = 1
+	if config.Report == "" {
+		fmt.Println(backtest.CSVHeader)
+		for _, e := range portfolio.History {
+			fmt.Println(e.CSV())
+		}
 	}
-
-	prevItem := s.Items[len(s.Items)-1]
-
-	item := StatItem{}
-	item.Time = nextBar.Time
-	item.Price = nextBar.Price
-	item.Prediction = prediction
-
-	profitLoss := nextBar.Price - curBar.Price
-	if prediction == 0 {
-		profitLoss *= -1
+	log.Printf("summary:\n%s", portfolio.Summary())
+	if config.Report != "" {
+		if err := portfolio.Report().WriteFiles(config.Report); err != nil {
+			return errors.Wrap(err, "")
+		}
+		log.Printf("report written to %s.json and %s.html", config.Report, config.Report)
 	}
-	positionSize := math.Floor(prevItem.Balance / curBar.Price * s.Leverage)
-	profitLoss *= positionSize
-
-	item.ProfitLoss = profitLoss
-	item.Balance = prevItem.Balance + profitLoss
 
-	s.Items = append(s.Items, item)
+	return nil
 }
 
-func (s *Stat) Bankrupt() bool {
-	item := s.Items[len(s.Items)-1]
-	if item.Balance < 0 {
-		return true
+// newModel returns the model named in config, whose depth is config.Depth.
+// The CTW model starts predicting with shallow contexts, whereas the Markov model starts with a context of zeros.
+func newModel(config Config) (ac.Model, error) {
+	switch config.Model {
+	case "", "ctw":
+		return ctw.NewCTWDepth(config.Depth, nil), nil
+	case "markov":
+		return markov.NewModel(make([]int, config.Depth)), nil
+	default:
+		return nil, errors.Errorf("unknown model %q", config.Model)
 	}
-	return false
 }
 
-func run(config Config) error {
-	trainBar, testBar, err := parseData(config)
-	if err != nil {
-		return errors.Wrap(err, "")
-	}
-
-	log.Printf("train %+v", trainBar[:3])
-	log.Printf("test %+v", testBar[:3])
-
-	trainData := NewData(trainBar)
-	testData := NewData(testBar)
+type Config struct {
+	Data  string
+	Model string
+	Depth int
 
-	context := make([]int, 0, config.Depth)
-	for i := 0; i < config.Depth; i++ {
-		context = append(context, trainData.Consume().Direction)
-	}
-	model := ctw.NewCTW(context)
+	// Train and Test, if Test is positive, are the numbers of bars of the windows of a walk forward, in which a new model is trained on each Train bars and tested on the following Test bars.
+	// Otherwise, the model is trained on the bars before 2018 and tested on the rest.
+	Train int
+	Test  int
 
-	for {
-		if trainData.Cursor >= len(trainData.Bar) {
-			break
-		}
-		bar := trainData.Consume()
-		model.Observe(bar.Direction)
-	}
+	// Slippage is the slippage of the fills, such as {"Ticks": 1, "TickSize": 0.25} for a tick per side.
+	Slippage backtest.Slippage
 
-	testStat := NewStat(20000, 1)
-	curBar := trainData.Bar[len(trainData.Bar)-1]
-	for {
-		prob0 := model.Prob0()
-		if testData.Cursor >= len(testData.Bar) {
-			break
-		}
-		nextBar := testData.Consume()
+	// Margin is the margin required of the positions, such as {"Initial": 0.1, "Maintenance": 0.08}, below which they are liquidated.
+	Margin backtest.Margin
 
-		testStat.Record(curBar, prob0, nextBar)
-		if testStat.Bankrupt() {
-			break
-		}
+	// Guard, if true, panics on look-ahead bias, as detected by backtest.Guard, for debugging.
+	Guard bool
 
-		model.Observe(nextBar.Direction)
-		curBar = nextBar
-	}
+	// Report, if not empty, is the prefix of the paths of the JSON and HTML reports, such as out/es for out/es.json and out/es.html, which are written instead of printing the entries.
+	Report string
 
-	fmt.Printf("time,price,prediction,profitloss,balance\n")
-	for _, s := range testStat.Items {
-		fmt.Printf("%s,%.0f,%d,%.0f,%.0f\n", s.Time.Format("2006-01-02 15:04:05"), s.Price, s.Prediction, s.ProfitLoss, s.Balance)
-	}
+	// Commission is the commission of the trades, which are free if its model is empty.
+	Commission backtest.CommissionConfig
 
-	return nil
+	// Sizer sizes the positions, which are worth the balance if its sizing is empty.
+	Sizer backtest.SizerConfig
 }
 
-type Config struct {
-	Data  string
-	Depth int
+// sizer returns a new sizer of the positions.
+func (config Config) sizer() (backtest.PositionSizer, error) {
+	sizing := config.Sizer
+	if sizing.Sizing == "" {
+		sizing = backtest.SizerConfig{Sizing: "fractional", Fraction: 1}
+	}
+	return backtest.NewPositionSizer(sizing)
 }
 
 func parseConfig() (Config, error) {
@@ -0,0 +1,244 @@
+package ctw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// archiveMagic is the magic number at the start of an archive.
+	archiveMagic = "CTWARCHV"
+
+	// archiveIndexMagic is the sync marker that precedes the member index of an archive.
+	archiveIndexMagic = "CTWAINDX"
+)
+
+// An ArchiveMember describes a file stored in an archive.
+type ArchiveMember struct {
+	// Name is the slash separated path of the file.
+	Name string
+
+	// Size is the number of bytes of the file.
+	Size int64
+
+	offset         int64
+	compressedSize int64
+}
+
+// A countingWriter counts the bytes written to an io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// CompressArchive compresses the named files into an archive, which is conventionally given the extension .ctwa.
+// Each file is compressed independently as by CompressWith with opts, so that single members can be extracted without decompressing the others.
+// The members are named by the slash separated forms of names, which must be relative paths without "..".
+//
+// The format is the magic number "CTWARCHV", followed by the compressed stream of each file, the sync marker "CTWAINDX",
+// the number of members as a big endian uint32, and for each member the length of its name as a big endian uint16, the name,
+// and its original size, offset, and compressed size as big endian int64s.
+// The archive ends with the offset of the index as a big endian int64.
+func CompressArchive(w io.Writer, names []string, opts ...Option) error {
+	members := make([]string, len(names))
+	for i, name := range names {
+		members[i] = filepath.ToSlash(name)
+	}
+	return compressArchive(w, members, names, opts)
+}
+
+// CompressArchiveDir compresses the regular files under dir into an archive, as CompressArchive does with opts.
+// Each member is named by the slash separated path of its file relative to the parent of dir, so that extracting the archive recreates dir.
+// If keep is not nil, only the files for which keep returns true are compressed, and the directories for which it returns false are skipped,
+// where keep is given the slash separated path relative to dir and the FileInfo of each file and directory below dir.
+// The files are compressed in lexical order.
+func CompressArchiveDir(w io.Writer, dir string, keep func(name string, fi os.FileInfo) bool, opts ...Option) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	// The parent of the root is the root itself, so the members of the root are named relative to it.
+	parent := filepath.Dir(abs)
+	var members, names []string
+	err = filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		if keep != nil && !keep(filepath.ToSlash(rel), fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		member, err := filepath.Rel(parent, filepath.Join(abs, rel))
+		if err != nil {
+			return err
+		}
+		members = append(members, filepath.ToSlash(member))
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return compressArchive(w, members, names, opts)
+}
+
+// compressArchive compresses the named files into an archive, naming their members by members.
+func compressArchive(w io.Writer, members, names []string, opts []Option) error {
+	index := make([]ArchiveMember, 0, len(members))
+	seen := make(map[string]bool)
+	for _, member := range members {
+		if err := checkMemberName(member); err != nil {
+			return err
+		}
+		if seen[member] {
+			return fmt.Errorf("duplicate member %s", member)
+		}
+		seen[member] = true
+		index = append(index, ArchiveMember{Name: member})
+	}
+
+	cw := &countingWriter{w: w}
+	if _, err := io.WriteString(cw, archiveMagic); err != nil {
+		return err
+	}
+	for i, name := range names {
+		offset := cw.n
+		stats, err := compressFile(cw, name, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		index[i].Size = stats.Bits / 8
+		index[i].offset = offset
+		index[i].compressedSize = cw.n - offset
+	}
+	return writeArchiveIndex(w, index, cw.n)
+}
+
+// writeArchiveIndex writes the index of the members, which starts at offset, to w.
+func writeArchiveIndex(w io.Writer, members []ArchiveMember, offset int64) error {
+	index := bytes.NewBuffer(nil)
+	index.WriteString(archiveIndexMagic)
+	binary.Write(index, binary.BigEndian, uint32(len(members)))
+	for _, m := range members {
+		binary.Write(index, binary.BigEndian, uint16(len(m.Name)))
+		index.WriteString(m.Name)
+		binary.Write(index, binary.BigEndian, m.Size)
+		binary.Write(index, binary.BigEndian, m.offset)
+		binary.Write(index, binary.BigEndian, m.compressedSize)
+	}
+	binary.Write(index, binary.BigEndian, offset)
+	_, err := w.Write(index.Bytes())
+	return err
+}
+
+// checkMemberName returns an error if name is not a valid member name, which would escape the directory it is extracted to.
+func checkMemberName(name string) error {
+	if name == "" || len(name) > 0xFFFF {
+		return fmt.Errorf("invalid member name of length %d", len(name))
+	}
+	if path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid member name %s", name)
+	}
+	return nil
+}
+
+// An ArchiveReader reads the members of an archive written by CompressArchive.
+// It is safe for concurrent use, provided that the underlying io.ReaderAt is.
+type ArchiveReader struct {
+	// Members are the members of the archive, in the order they were added.
+	Members []ArchiveMember
+
+	r    io.ReaderAt
+	opts []Option
+}
+
+// NewArchiveReader returns an ArchiveReader reading from r, which holds size bytes of the output of CompressArchive.
+// The members are decompressed as by DecompressWith with opts, which should give the same coder and prime as those used in CompressArchive.
+func NewArchiveReader(r io.ReaderAt, size int64, opts ...Option) (*ArchiveReader, error) {
+	magic := make([]byte, len(archiveMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != archiveMagic {
+		return nil, fmt.Errorf("not a ctw archive")
+	}
+
+	// Read the offset of the index, which are the last bytes.
+	if size < int64(len(archiveMagic))+8 {
+		return nil, fmt.Errorf("archive size %d too small", size)
+	}
+	var indexOffset int64
+	if err := binary.Read(io.NewSectionReader(r, size-8, 8), binary.BigEndian, &indexOffset); err != nil {
+		return nil, err
+	}
+	if indexOffset < int64(len(archiveMagic)) || indexOffset > size-8 {
+		return nil, fmt.Errorf("invalid index offset %d", indexOffset)
+	}
+
+	// Read the index.
+	index := io.NewSectionReader(r, indexOffset, size-8-indexOffset)
+	magic = make([]byte, len(archiveIndexMagic))
+	if _, err := io.ReadFull(index, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != archiveIndexMagic {
+		return nil, fmt.Errorf("missing index sync marker at %d", indexOffset)
+	}
+	var n uint32
+	if err := binary.Read(index, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	ar := &ArchiveReader{r: r, opts: opts}
+	for i := uint32(0); i < n; i++ {
+		var nameLen uint16
+		if err := binary.Read(index, binary.BigEndian, &nameLen); err != nil {
+			return nil, err
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(index, name); err != nil {
+			return nil, err
+		}
+		m := ArchiveMember{Name: string(name)}
+		if err := checkMemberName(m.Name); err != nil {
+			return nil, err
+		}
+		for _, v := range []*int64{&m.Size, &m.offset, &m.compressedSize} {
+			if err := binary.Read(index, binary.BigEndian, v); err != nil {
+				return nil, err
+			}
+		}
+		if m.offset < int64(len(archiveMagic)) || m.compressedSize < 0 || m.offset+m.compressedSize > indexOffset {
+			return nil, fmt.Errorf("member %s at %d of size %d out of range", m.Name, m.offset, m.compressedSize)
+		}
+		ar.Members = append(ar.Members, m)
+	}
+	return ar, nil
+}
+
+// Extract decompresses the named member and writes it to w.
+func (ar *ArchiveReader) Extract(w io.Writer, name string) error {
+	for _, m := range ar.Members {
+		if m.Name == name {
+			return DecompressWith(w, io.NewSectionReader(ar.r, m.offset, m.compressedSize), ar.opts...)
+		}
+	}
+	return fmt.Errorf("no member %s", name)
+}
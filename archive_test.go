@@ -0,0 +1,126 @@
+package ctw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	t.Parallel()
+	names := []string{"gettysburg.txt", "testdata/golden/zeros.bin", "testdata/golden/empty.bin"}
+	buf := bytes.NewBuffer(nil)
+	if err := CompressArchive(buf, names, WithDepth(16)); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ar, err := NewArchiveReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(ar.Members) != len(names) {
+		t.Fatalf("%+v", ar.Members)
+	}
+	// Extract the members in reverse order, to check that each can be extracted on its own.
+	for i := len(names) - 1; i >= 0; i-- {
+		m := ar.Members[i]
+		original, err := ioutil.ReadFile(names[i])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if m.Name != names[i] || m.Size != int64(len(original)) {
+			t.Fatalf("%+v", m)
+		}
+		extracted := bytes.NewBuffer(nil)
+		if err := ar.Extract(extracted, m.Name); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(extracted.Bytes(), original) {
+			t.Fatalf("%s %s", m.Name, extracted.Bytes())
+		}
+	}
+	if err := ar.Extract(ioutil.Discard, "missing"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Compressed streams are not archives.
+	compressed := bytes.NewBuffer(nil)
+	if err := CompressFile(compressed, "gettysburg.txt", 16, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := NewArchiveReader(bytes.NewReader(compressed.Bytes()), int64(compressed.Len())); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestArchiveMemberName(t *testing.T) {
+	t.Parallel()
+	for _, name := range []string{"/etc/passwd", "../gettysburg.txt", "testdata/../gettysburg.txt"} {
+		if err := CompressArchive(ioutil.Discard, []string{name}); err == nil {
+			t.Fatalf("%s", name)
+		}
+	}
+	if err := CompressArchive(ioutil.Discard, []string{"gettysburg.txt", "gettysburg.txt"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCompressArchiveDir(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "ctw")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "data")
+	files := map[string]string{
+		"a.txt":       "four score",
+		"sub/b.txt":   "and seven years ago",
+		"sub/c.bin":   "our fathers",
+		"skip/d.txt":  "brought forth",
+		"sub/e/f.txt": "on this continent",
+	}
+	for name, content := range files {
+		fpath := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// Skip the directory skip, and the files other than text files.
+	keep := func(name string, fi os.FileInfo) bool {
+		if fi.IsDir() {
+			return name != "skip"
+		}
+		return filepath.Ext(name) == ".txt"
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := CompressArchiveDir(buf, root, keep, WithDepth(16)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ar, err := NewArchiveReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var names []string
+	for _, m := range ar.Members {
+		names = append(names, m.Name)
+		extracted := bytes.NewBuffer(nil)
+		if err := ar.Extract(extracted, m.Name); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if extracted.String() != files[strings.TrimPrefix(m.Name, "data/")] {
+			t.Fatalf("%s %s", m.Name, extracted.Bytes())
+		}
+	}
+	if expected := []string{"data/a.txt", "data/sub/b.txt", "data/sub/e/f.txt"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("%v", names)
+	}
+}
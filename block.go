@@ -0,0 +1,226 @@
+package ctw
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/fumin/ctw/ac"
+)
+
+const (
+	// blockMagic is the sync marker that precedes every block.
+	blockMagic = "CTWBLOCK"
+
+	// indexMagic is the sync marker that precedes the block index.
+	indexMagic = "CTWINDEX"
+
+	// blockHeaderSize is the size of the sync marker, the original size, and the encoded size of a block.
+	blockHeaderSize = len(blockMagic) + 4 + 4
+)
+
+// CompressBlocks compresses the bytes read from r into independently decodable blocks, each holding blockSize bytes of the original data except possibly the last one.
+// Each block is coded with a fresh Context Tree Weighting model of depth depth, using coder, or the witten coder if coder is nil.
+// The compressed result, which is written to w, ends with an index of the blocks, so that a BlockReader can seek into it without decoding from the start.
+//
+// The format is a sequence of blocks, each of which is the sync marker "CTWBLOCK", the original and encoded sizes as big endian uint32s, and the encoded bytes.
+// A block that coding would not make smaller is stored uncoded instead, which is marked by the highest bit of its encoded size.
+// The blocks are followed by the sync marker "CTWINDEX", the block size as a big endian uint32, the total original size and the offset of each block as big endian int64s,
+// and finally the offset of the index as a big endian int64.
+//
+// Small blocks allow finer seeking, but compress worse since each block starts with an empty model.
+func CompressBlocks(w io.Writer, r io.Reader, depth, blockSize int, coder ac.Coder) error {
+	if blockSize <= 0 || blockSize >= storedFlag {
+		return fmt.Errorf("block size %d out of range [1, %d)", blockSize, storedFlag)
+	}
+	if coder == nil {
+		coder = defaultCoder
+	}
+
+	var offset, size int64
+	var offsets []int64
+	block := make([]byte, blockSize)
+	encoded := bytes.NewBuffer(nil)
+	for {
+		n, err := io.ReadFull(r, block)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		encoded.Reset()
+		bw := ac.NewBitWriter(encoded)
+		model := NewCTW(make([]int, depth))
+		if _, err := coder.EncodeStats(context.Background(), bw, ac.NewBitReader(bytes.NewReader(block[:n])), model); err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+
+		payload, encodedSize := encoded.Bytes(), uint32(encoded.Len())
+		if len(payload) >= n {
+			payload, encodedSize = block[:n], uint32(n)|storedFlag
+		}
+		header := make([]byte, blockHeaderSize)
+		copy(header, blockMagic)
+		binary.BigEndian.PutUint32(header[len(blockMagic):], uint32(n))
+		binary.BigEndian.PutUint32(header[len(blockMagic)+4:], encodedSize)
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+		offsets = append(offsets, offset)
+		offset += int64(blockHeaderSize + len(payload))
+		size += int64(n)
+
+		if n < blockSize {
+			break
+		}
+	}
+
+	// Write the index.
+	index := bytes.NewBuffer(nil)
+	index.WriteString(indexMagic)
+	binary.Write(index, binary.BigEndian, uint32(blockSize))
+	binary.Write(index, binary.BigEndian, size)
+	binary.Write(index, binary.BigEndian, offsets)
+	binary.Write(index, binary.BigEndian, offset)
+	_, err := w.Write(index.Bytes())
+	return err
+}
+
+// A BlockReader decodes the blocks written by CompressBlocks, giving random access to the original data.
+// It is safe for concurrent use, provided that the underlying io.ReaderAt is.
+type BlockReader struct {
+	r     io.ReaderAt
+	depth int
+	coder ac.Coder
+
+	blockSize int64
+	size      int64
+	offsets   []int64
+}
+
+// NewBlockReader returns a BlockReader reading from r, which holds size bytes of the output of CompressBlocks.
+// The depth and coder should be the same as those used in CompressBlocks, where a nil coder means the witten coder.
+func NewBlockReader(r io.ReaderAt, size int64, depth int, coder ac.Coder) (*BlockReader, error) {
+	if coder == nil {
+		coder = defaultCoder
+	}
+	br := &BlockReader{r: r, depth: depth, coder: coder}
+
+	// Read the offset of the index, which are the last bytes.
+	if size < 8 {
+		return nil, fmt.Errorf("compressed size %d too small", size)
+	}
+	var indexOffset int64
+	if err := binary.Read(io.NewSectionReader(r, size-8, 8), binary.BigEndian, &indexOffset); err != nil {
+		return nil, err
+	}
+	const fixedIndexSize = int64(len(indexMagic) + 4 + 8)
+	if indexOffset < 0 || indexOffset+fixedIndexSize+8 > size || (size-8-indexOffset-fixedIndexSize)%8 != 0 {
+		return nil, fmt.Errorf("invalid index offset %d", indexOffset)
+	}
+
+	// Read the index.
+	index := io.NewSectionReader(r, indexOffset, size-8-indexOffset)
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(index, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != indexMagic {
+		return nil, fmt.Errorf("missing index sync marker at %d", indexOffset)
+	}
+	var blockSize uint32
+	if err := binary.Read(index, binary.BigEndian, &blockSize); err != nil {
+		return nil, err
+	}
+	br.blockSize = int64(blockSize)
+	if err := binary.Read(index, binary.BigEndian, &br.size); err != nil {
+		return nil, err
+	}
+	br.offsets = make([]int64, (size-8-indexOffset-fixedIndexSize)/8)
+	if err := binary.Read(index, binary.BigEndian, br.offsets); err != nil {
+		return nil, err
+	}
+	if br.blockSize == 0 || int64(len(br.offsets)) != (br.size+br.blockSize-1)/br.blockSize {
+		return nil, fmt.Errorf("%d blocks of size %d inconsistent with original size %d", len(br.offsets), br.blockSize, br.size)
+	}
+	return br, nil
+}
+
+// Size returns the size of the original data.
+func (br *BlockReader) Size() int64 {
+	return br.size
+}
+
+// NumBlocks returns the number of blocks.
+func (br *BlockReader) NumBlocks() int {
+	return len(br.offsets)
+}
+
+// Block returns the original data of the i-th block, which starts at i times the block size.
+func (br *BlockReader) Block(i int) ([]byte, error) {
+	if i < 0 || i >= len(br.offsets) {
+		return nil, fmt.Errorf("block %d out of range [0, %d)", i, len(br.offsets))
+	}
+	header := make([]byte, blockHeaderSize)
+	if _, err := br.r.ReadAt(header, br.offsets[i]); err != nil {
+		return nil, err
+	}
+	if string(header[:len(blockMagic)]) != blockMagic {
+		return nil, fmt.Errorf("missing sync marker of block %d at %d", i, br.offsets[i])
+	}
+	n := binary.BigEndian.Uint32(header[len(blockMagic):])
+	encodedSize := binary.BigEndian.Uint32(header[len(blockMagic)+4:])
+	if encodedSize&storedFlag != 0 {
+		if encodedSize&^storedFlag != n {
+			return nil, fmt.Errorf("stored block %d of %d bytes with size %d", i, n, encodedSize&^storedFlag)
+		}
+		p := make([]byte, n)
+		if _, err := br.r.ReadAt(p, br.offsets[i]+int64(blockHeaderSize)); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	src := io.NewSectionReader(br.r, br.offsets[i]+int64(blockHeaderSize), int64(encodedSize))
+	buf := bytes.NewBuffer(make([]byte, 0, n))
+	bw := ac.NewBitWriter(buf)
+	model := NewCTW(make([]int, br.depth))
+	if _, err := br.coder.DecodeStats(context.Background(), bw, ac.NewBitReader(src), model, int64(n)*8); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadAt reads len(p) bytes of the original data starting at offset off, decoding only the blocks that overlap them.
+// It implements the io.ReaderAt interface.
+func (br *BlockReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= br.size {
+			return n, io.EOF
+		}
+		block, err := br.Block(int(pos / br.blockSize))
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos%br.blockSize:])
+	}
+	return n, nil
+}
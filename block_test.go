@@ -0,0 +1,100 @@
+package ctw
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestBlockReader(t *testing.T) {
+	t.Parallel()
+	const depth = 16
+	const blockSize = 300
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := CompressBlocks(buf, bytes.NewReader(gettys), depth, blockSize, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	br, err := NewBlockReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), depth, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if br.Size() != int64(len(gettys)) || br.NumBlocks() != (len(gettys)+blockSize-1)/blockSize {
+		t.Fatalf("%d %d", br.Size(), br.NumBlocks())
+	}
+
+	// Read a range straddling two blocks in the middle of the data.
+	p := make([]byte, 100)
+	if _, err := br.ReadAt(p, 2*blockSize-50); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(p, gettys[2*blockSize-50:2*blockSize+50]) {
+		t.Fatalf("%s", p)
+	}
+
+	// Read past the end.
+	n, err := br.ReadAt(p, int64(len(gettys)-10))
+	if n != 10 || err != io.EOF || !bytes.Equal(p[:n], gettys[len(gettys)-10:]) {
+		t.Fatalf("%d %v %s", n, err, p[:n])
+	}
+
+	decompressed, err := ioutil.ReadAll(io.NewSectionReader(br, 0, br.Size()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed, gettys) {
+		t.Fatalf("%s", decompressed)
+	}
+}
+
+// TestBlockStored tests that blocks of incompressible data are stored uncoded.
+func TestBlockStored(t *testing.T) {
+	t.Parallel()
+	const blockSize = 500
+	data := make([]byte, 2*blockSize)
+	rand.New(rand.NewSource(0)).Read(data[:blockSize])
+	buf := bytes.NewBuffer(nil)
+	if err := CompressBlocks(buf, bytes.NewReader(data), 16, blockSize, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if buf.Len() > len(data)/2+2*blockHeaderSize+blockSize/10+len(indexMagic)+4+8+3*8 {
+		t.Fatalf("%d", buf.Len())
+	}
+	br, err := NewBlockReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), 16, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	decompressed, err := ioutil.ReadAll(io.NewSectionReader(br, 0, br.Size()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Fatalf("%d %d", len(decompressed), len(data))
+	}
+}
+
+func TestBlockReaderCorrupt(t *testing.T) {
+	t.Parallel()
+	buf := bytes.NewBuffer(nil)
+	if err := CompressBlocks(buf, bytes.NewReader([]byte("four score and seven years ago")), 8, 10, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	compressed := buf.Bytes()
+	compressed[0] = 'X'
+	br, err := NewBlockReader(bytes.NewReader(compressed), int64(len(compressed)), 8, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := br.Block(0); err == nil {
+		t.Fatalf("expected missing sync marker")
+	}
+	if _, err := br.Block(1); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
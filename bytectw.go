@@ -0,0 +1,159 @@
+package ctw
+
+import (
+	"log"
+)
+
+// A ByteCTW is a Context Tree Weighting based probabilistic model for sequences of bytes.
+// Each byte is decomposed into 8 binary decisions, from the least significant bit to the most significant one.
+// Each decision is predicted by its own context tree, whose context is the bits preceding the decision.
+// In particular, the context of a decision includes the earlier bits of the same byte,
+// so that each tree learns the conditional distribution of a bit given its position and the bits before it.
+//
+// ByteCTW implements the arithmetic coding Model interface, in which bits are expected in the same order as ObserveByte decomposes them.
+type ByteCTW struct {
+	bits  []int
+	pool  *nodePool
+	roots [8]uint32
+
+	// pos is the position within the current byte of the next bit.
+	pos uint
+	// partial holds the bits of the current byte that have been observed.
+	partial int
+
+	epsilon float64
+
+	// prune reports whether the trees are pruned when they reach the maximum number of nodes, instead of being frozen.
+	prune bool
+}
+
+// NewByteCTW returns a new ByteCTW whose context trees' depth are len(bits).
+// The prior context of the trees is given by bits.
+func NewByteCTW(bits []int) *ByteCTW {
+	model := &ByteCTW{bits: bits, pool: &nodePool{}, epsilon: DefaultEpsilon}
+	for i := range model.roots {
+		model.roots[i] = model.pool.get()
+	}
+	return model
+}
+
+// SetMaxNodes limits the total number of nodes of the context trees to n, as CTW.SetMaxNodes does for a single tree.
+func (model *ByteCTW) SetMaxNodes(n int) {
+	if n < 0 {
+		log.Fatalf("wrong max nodes %d", n)
+	}
+	model.pool.limit = n
+}
+
+// SetEpsilon sets the minimum probability of either bit returned by Prob0, as CTW.SetEpsilon does for a single tree.
+func (model *ByteCTW) SetEpsilon(epsilon float64) {
+	if epsilon < 0 || epsilon >= 0.5 {
+		log.Fatalf("wrong epsilon %f", epsilon)
+	}
+	model.epsilon = epsilon
+}
+
+// SetPrune sets whether the context trees are pruned when they reach the maximum number of nodes, as CTW.SetPrune does for a single tree.
+func (model *ByteCTW) SetPrune(prune bool) {
+	model.prune = prune
+}
+
+// PeakNodes returns the largest total number of nodes the context trees have had.
+func (model *ByteCTW) PeakNodes() int {
+	return model.pool.peak
+}
+
+// Release releases the memory of the context trees, so that it can be reused by other models.
+// The model must not be used after Release.
+func (model *ByteCTW) Release() {
+	model.pool.release()
+	model.roots = [8]uint32{}
+}
+
+// Prob0 returns the probability that the next bit be zero.
+func (model *ByteCTW) Prob0() float64 {
+	prob0 := predict(model.pool, model.roots[model.pos], model.bits, 0, 0, 0)
+	return clamp(prob0, model.epsilon)
+}
+
+// Observe updates the model, given that the sequence is followed by bit.
+func (model *ByteCTW) Observe(bit int) {
+	model.observe(bit)
+	if model.prune && !model.pool.available(len(model.bits)+1) {
+		model.pool.prune(model.roots[:], model.pool.limit/2)
+	}
+}
+
+// ObserveByte updates the model, given that the sequence is followed by the byte c.
+// ObserveByte should only be called at byte boundaries, that is when the number of bits sent to Observe is a multiple of 8.
+func (model *ByteCTW) ObserveByte(c byte) {
+	if model.pos != 0 {
+		log.Fatalf("ObserveByte at bit position %d", model.pos)
+	}
+	for i := uint(0); i < 8; i++ {
+		model.Observe((int(c) & (1 << i)) >> i)
+	}
+}
+
+// Dist returns the probability distribution of the next byte.
+// The i-th element of the returned slice is the probability that the next byte be i.
+// If some bits of the next byte have already been observed, the distribution is conditioned on those bits.
+func (model *ByteCTW) Dist() []float64 {
+	dist := make([]float64, 256)
+	model.dist(dist, 1)
+	return dist
+}
+
+// dist computes the probabilities of all bytes that begin with the currently observed partial byte,
+// and stores them in dist after multiplying them by p.
+func (model *ByteCTW) dist(dist []float64, p float64) {
+	pos, partial := model.pos, model.partial
+	prob0 := model.Prob0()
+	for bit, pb := range []float64{prob0, 1 - prob0} {
+		c := partial | (bit << pos)
+		traversal, dropped := model.observe(bit)
+		if model.pos == 0 {
+			dist[c] = p * pb
+		} else {
+			model.dist(dist, p*pb)
+		}
+		model.unobserve(traversal, dropped)
+		model.pos, model.partial = pos, partial
+	}
+}
+
+// observe updates the context tree of the current bit position and shifts bit into the context.
+// It returns the traversal of the tree and the bit dropped from the context, so that the observation can be reverted by unobserve.
+func (model *ByteCTW) observe(bit int) ([]snapshot, int) {
+	traversal := update(model.pool, model.roots[model.pos], model.bits, bit, 0)
+
+	dropped := 0
+	if len(model.bits) > 0 {
+		dropped = model.bits[0]
+		for i := 1; i < len(model.bits); i++ {
+			model.bits[i-1] = model.bits[i]
+		}
+		model.bits[len(model.bits)-1] = bit
+	}
+
+	model.partial |= bit << model.pos
+	model.pos++
+	if model.pos == 8 {
+		model.pos = 0
+		model.partial = 0
+	}
+	return traversal, dropped
+}
+
+// unobserve reverts an observation made by observe.
+// Callers are responsible for restoring the position within the current byte.
+func (model *ByteCTW) unobserve(traversal []snapshot, dropped int) {
+	revert(model.pool, traversal)
+	if len(model.bits) == 0 {
+		return
+	}
+	for i := len(model.bits) - 1; i > 0; i-- {
+		model.bits[i] = model.bits[i-1]
+	}
+	model.bits[0] = dropped
+}
@@ -0,0 +1,107 @@
+package ctw
+
+import (
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+func TestByteCTWDist(t *testing.T) {
+	t.Parallel()
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	model := NewByteCTW(make([]int, 16))
+	for _, c := range contents[:256] {
+		model.ObserveByte(c)
+	}
+
+	dist := model.Dist()
+	var sum float64
+	for _, p := range dist {
+		sum += p
+	}
+	if math.Abs(sum-1) > 1e-8 {
+		t.Fatalf("%f", sum)
+	}
+
+	// The probability of a byte should be the product of the probabilities of its bits.
+	next := contents[256]
+	var prob float64 = 1
+	for i := uint(0); i < 8; i++ {
+		bit := (int(next) & (1 << i)) >> i
+		prob0 := model.Prob0()
+		if bit == 0 {
+			prob *= prob0
+		} else {
+			prob *= 1 - prob0
+		}
+		model.Observe(bit)
+	}
+	if math.Abs(prob-dist[next]) > 1e-12 {
+		t.Fatalf("%f %f", prob, dist[next])
+	}
+}
+
+func TestByteCTWDistPartial(t *testing.T) {
+	t.Parallel()
+	model := NewByteCTW(make([]int, 16))
+	for _, c := range []byte("four score and seven years ago") {
+		model.ObserveByte(c)
+	}
+
+	// Observe the lowest 3 bits of 'o', and check that the distribution only covers bytes with the same lowest 3 bits.
+	for i := uint(0); i < 3; i++ {
+		model.Observe((int('o') & (1 << i)) >> i)
+	}
+	prob0 := model.Prob0()
+	dist := model.Dist()
+	var sum float64
+	for c, p := range dist {
+		if c&7 != 'o'&7 && p != 0 {
+			t.Errorf("%d %f", c, p)
+		}
+		sum += p
+	}
+	if math.Abs(sum-1) > 1e-8 {
+		t.Fatalf("%f", sum)
+	}
+
+	// Dist should leave the model intact.
+	if p := model.Prob0(); p != prob0 {
+		t.Fatalf("%f %f", p, prob0)
+	}
+}
+
+func TestByteCTWEpsilon(t *testing.T) {
+	t.Parallel()
+	model := NewByteCTW(make([]int, 8))
+	model.SetEpsilon(0.01)
+	for i := 0; i < 1000; i++ {
+		model.ObserveByte(0)
+	}
+	if p := model.Prob0(); p != 0.99 {
+		t.Fatalf("%f", p)
+	}
+}
+
+func TestByteCTWDepth0(t *testing.T) {
+	t.Parallel()
+	// Without context, each bit position is predicted by its frequency alone.
+	model := NewByteCTW(nil)
+	for _, c := range []byte("aaaa") {
+		model.ObserveByte(c)
+	}
+	dist := model.Dist()
+	var sum float64
+	for c, p := range dist {
+		if p > dist['a'] {
+			t.Errorf("%d %f %f", c, p, dist['a'])
+		}
+		sum += p
+	}
+	if math.Abs(sum-1) > 1e-8 {
+		t.Fatalf("%f", sum)
+	}
+}
@@ -0,0 +1,122 @@
+package ctw
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// checkpointMagic is the magic number at the start of a checkpoint written by Writer.Checkpoint.
+const checkpointMagic = "CTWCHKPT"
+
+// Checkpoint flushes the buffered bytes as a frame, and writes the state of the Writer to cp, from which ResumeWriter resumes the compression.
+// Since frames are coded independently, the state is that of the model alone, along with the checksum and the statistics of the data so far.
+// To survive a crash, the compressed stream should be synced to stable storage before the checkpoint is.
+//
+// The format is the magic number "CTWCHKPT", the Header of the stream, the checksum and the statistics of the data so far,
+// the number of bytes of the compressed stream so far, and the state of the model, all in big endian.
+func (z *Writer) Checkpoint(cp io.Writer) error {
+	if err := z.Flush(); err != nil {
+		return err
+	}
+	if z.closed {
+		return fmt.Errorf("checkpoint of closed Writer")
+	}
+	if z.err = z.start(nil); z.err != nil {
+		return z.err
+	}
+
+	sw := &stateWriter{w: bufio.NewWriter(cp)}
+	sw.put([]byte(checkpointMagic))
+	if sw.err == nil {
+		sw.err = z.opts.header(framedSize).write(sw.w)
+	}
+	sw.put(z.crc)
+	sw.put(z.stats.Bits)
+	sw.put(z.stats.EncodedBits)
+	sw.put(z.stats.CrossEntropy)
+	sw.put(z.stats.Renormalizations)
+	sw.put(int64(z.stats.PeakNodes))
+	sw.put(z.w.n)
+	z.model.writeState(sw)
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+// Offsets returns the number of bytes of the original data written to the Writer, and the number of bytes of the compressed stream written to the underlying io.Writer.
+// Right after a Checkpoint, they are where ResumeWriter resumes the data and the stream.
+func (z *Writer) Offsets() (in, out int64) {
+	return z.stats.Bits/8 + int64(len(z.buf)), z.w.n
+}
+
+// ResumeWriter returns a Writer that resumes the compression from the checkpoint read from cp, as written by Writer.Checkpoint.
+// The compressed stream must be truncated to the out offset of Offsets, and w must append to it,
+// and the data written to the returned Writer must continue from the in offset of Offsets.
+// Of the options, only WithCoder and WithPrime apply, since the rest are read from the checkpoint, and they should be the same as those of the checkpointed Writer.
+// Like Decompress, ResumeWriter takes the coder from the Header of the checkpoint if it records it, in which case a different coder given by WithCoder is an error.
+func ResumeWriter(w io.Writer, cp io.Reader, opts ...Option) (*Writer, error) {
+	sr := &stateReader{r: bufio.NewReader(cp)}
+	magic := make([]byte, len(checkpointMagic))
+	sr.get(magic)
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if string(magic) != checkpointMagic {
+		return nil, fmt.Errorf("not a ctw checkpoint")
+	}
+	h, err := ReadHeader(sr.r)
+	if err != nil {
+		return nil, err
+	}
+	if h.Size != framedSize {
+		return nil, fmt.Errorf("checkpoint of a stream of size %d", h.Size)
+	}
+	o := newOptions(opts)
+	if err := h.checkPrime(o.prime); err != nil {
+		return nil, err
+	}
+	if o.coder, err = o.decoder(h); err != nil {
+		return nil, err
+	}
+	o.depth, o.model, o.maxNodes, o.prune = h.Depth, h.Model, h.MaxNodes, h.Prune
+	o.info = nil
+	if h.Metadata {
+		o.info = headerInfo{h: h}
+	}
+
+	z := &Writer{opts: o, buf: make([]byte, 0, frameSize), encoded: bytes.NewBuffer(nil), wroteHeader: true}
+	var peakNodes, out int64
+	sr.get(&z.crc)
+	sr.get(&z.stats.Bits)
+	sr.get(&z.stats.EncodedBits)
+	sr.get(&z.stats.CrossEntropy)
+	sr.get(&z.stats.Renormalizations)
+	sr.get(&peakNodes)
+	sr.get(&out)
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	z.stats.PeakNodes = int(peakNodes)
+	z.w = &countingWriter{w: w, n: out}
+	if z.model, err = readModelState(sr, h.Model); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// A headerInfo is the os.FileInfo of the original file recorded in a Header, so that a resumed Writer records it in its checkpoints as well.
+type headerInfo struct {
+	h Header
+}
+
+func (fi headerInfo) Name() string       { return fi.h.Name }
+func (fi headerInfo) Size() int64        { return fi.h.Size }
+func (fi headerInfo) Mode() os.FileMode  { return fi.h.Mode }
+func (fi headerInfo) ModTime() time.Time { return fi.h.ModTime }
+func (fi headerInfo) IsDir() bool        { return false }
+func (fi headerInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,131 @@
+package ctw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/fumin/ctw/ac/mq"
+	"github.com/fumin/ctw/ac/witten"
+)
+
+// TestCheckpoint tests that resuming from a checkpoint gives the same stream as compressing without interruption.
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, model := range []ModelKind{BitModel, ByteModel} {
+		opts := []Option{WithModel(model), WithDepth(16), WithMaxNodes(5000), WithPrune(true)}
+		buf := bytes.NewBuffer(nil)
+		z := NewWriter(buf, opts...)
+		if _, err := z.Write(gettys[:500]); err != nil {
+			t.Fatalf("%v", err)
+		}
+		cp := bytes.NewBuffer(nil)
+		if err := z.Checkpoint(cp); err != nil {
+			t.Fatalf("%v", err)
+		}
+		in, out := z.Offsets()
+		if in != 500 || out != int64(buf.Len()) {
+			t.Fatalf("%d %d %d", in, out, buf.Len())
+		}
+		if _, err := z.Write(gettys[in:]); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		resumed := bytes.NewBuffer(append([]byte{}, buf.Bytes()[:out]...))
+		rz, err := ResumeWriter(resumed, cp)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := rz.Write(gettys[in:]); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := rz.Close(); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(resumed.Bytes(), buf.Bytes()) {
+			t.Fatalf("%d %d", resumed.Len(), buf.Len())
+		}
+		if rz.Stats() != z.Stats() {
+			t.Fatalf("%+v %+v", rz.Stats(), z.Stats())
+		}
+
+		decompressed := bytes.NewBuffer(nil)
+		if err := Decompress(decompressed, resumed, nil); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(decompressed.Bytes(), gettys) {
+			t.Fatalf("%s", decompressed.Bytes())
+		}
+	}
+
+	if _, err := ResumeWriter(ioutil.Discard, bytes.NewReader([]byte("not a checkpoint"))); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// TestResumeCoder tests that resuming without options keeps the coder and the metadata of the checkpointed Writer.
+func TestResumeCoder(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fi, err := os.Stat("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	z := NewWriter(buf, WithDepth(16), WithCoder(mq.Coder{}), WithFileInfo(fi))
+	if _, err := z.Write(gettys[:500]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	cp := bytes.NewBuffer(nil)
+	if err := z.Checkpoint(cp); err != nil {
+		t.Fatalf("%v", err)
+	}
+	in, out := z.Offsets()
+
+	if _, err := ResumeWriter(ioutil.Discard, bytes.NewReader(cp.Bytes()), WithCoder(witten.Coder{})); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	resumed := bytes.NewBuffer(append([]byte{}, buf.Bytes()[:out]...))
+	rz, err := ResumeWriter(resumed, cp)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := rz.Write(gettys[in:]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// A checkpoint of the resumed Writer records the same header.
+	cp2 := bytes.NewBuffer(nil)
+	if err := rz.Checkpoint(cp2); err != nil {
+		t.Fatalf("%v", err)
+	}
+	h, err := ReadHeader(bytes.NewReader(cp2.Bytes()[len(checkpointMagic):]))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if h.Coder != MQCoder || !h.Metadata || h.Name != "gettysburg.txt" || h.ModTime.Unix() != fi.ModTime().Unix() || h.Mode != fi.Mode().Perm() {
+		t.Fatalf("%+v", h)
+	}
+	if err := rz.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	decompressed, err := DecompressBytes(resumed.Bytes())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed, gettys) {
+		t.Fatalf("%s", decompressed)
+	}
+}
@@ -0,0 +1,36 @@
+package ctw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// checksumSize is the size of the CRC-32 checksum of the original data, which ends the streams of format version 2.
+const checksumSize = 4
+
+// ErrChecksum is returned when the decompressed data does not match the checksum of the original data recorded in the stream.
+var ErrChecksum = fmt.Errorf("checksum mismatch")
+
+// writeChecksum writes the checksum sum to w.
+func writeChecksum(w io.Writer, sum uint32) error {
+	b := make([]byte, checksumSize)
+	binary.BigEndian.PutUint32(b, sum)
+	_, err := w.Write(b)
+	return err
+}
+
+// readChecksum reads the checksum from r, and returns ErrChecksum if it is not sum.
+func readChecksum(r io.Reader, sum uint32) error {
+	b := make([]byte, checksumSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if binary.BigEndian.Uint32(b) != sum {
+		return ErrChecksum
+	}
+	return nil
+}
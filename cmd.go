@@ -1,191 +1,356 @@
 package ctw
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/binary"
+	"context"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"os"
 
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/ac/eidma"
+	"github.com/fumin/ctw/ac/golomb"
+	"github.com/fumin/ctw/ac/mq"
 	"github.com/fumin/ctw/ac/witten"
 )
 
-// Compress compresses the named file using arithmetic coding supplied with a Context Tree Weighting probabilistic model of depth depth.
-// The compressed result is written to w.
-func Compress(w io.Writer, name string, depth int) error {
-	// Write file size
-	fi, err := os.Stat(name)
-	if err != nil {
-		return err
+// defaultCoder is the coder used when none is given.
+// Since updating deep models is expensive, encoding is pipelined with the updates, which does not change the encoded result.
+var defaultCoder ac.Coder = witten.Coder{Pipelined: true}
+
+// NewCoder returns the arithmetic coder of the given name, which is one of "witten", "eidma", "mq", or "golomb".
+// The golomb coder is not an arithmetic coder, and is only worthwhile for data whose bits the model predicts with nearly constant probabilities.
+func NewCoder(name string) (ac.Coder, error) {
+	switch name {
+	case "witten":
+		return witten.Coder{}, nil
+	case "eidma":
+		return eidma.NewCoder(eidma.EncoderOptions{})
+	case "mq":
+		return mq.Coder{}, nil
+	case "golomb":
+		return golomb.Coder{}, nil
+	}
+	return nil, fmt.Errorf("unknown coder %q", name)
+}
+
+// A CoderKind identifies the arithmetic coder of a stream, which is recorded in its Header, so that decompressing needs no coder to be given.
+type CoderKind uint8
+
+const (
+	// UnknownCoder is the coder of the streams that do not record it, which are those of format version 1, and those coded by a coder other than the ones returned by NewCoder.
+	// Such streams are decompressed with the coder given by WithCoder, or the witten coder if none is given.
+	UnknownCoder CoderKind = 0
+
+	// WittenCoder, EIDMACoder, MQCoder, and GolombCoder are the coders returned by NewCoder of the same names.
+	WittenCoder CoderKind = 1
+	EIDMACoder  CoderKind = 2
+	MQCoder     CoderKind = 3
+	GolombCoder CoderKind = 4
+)
+
+func (k CoderKind) String() string {
+	switch k {
+	case UnknownCoder:
+		return "unknown"
+	case WittenCoder:
+		return "witten"
+	case EIDMACoder:
+		return "eidma"
+	case MQCoder:
+		return "mq"
+	case GolombCoder:
+		return "golomb"
+	}
+	return fmt.Sprintf("CoderKind(%d)", uint8(k))
+}
+
+// coderKind returns the kind of coder, which is UnknownCoder unless coder codes streams as one returned by NewCoder does.
+// Coders that wrap another, such as those returned by ac.WithProgress, are of the kind of the coder they wrap.
+func coderKind(coder ac.Coder) CoderKind {
+	for {
+		u, ok := coder.(interface{ Unwrap() ac.Coder })
+		if !ok {
+			break
+		}
+		coder = u.Unwrap()
+	}
+	switch c := coder.(type) {
+	case witten.Coder:
+		if !c.Runs {
+			return WittenCoder
+		}
+	case *eidma.Coder:
+		if c.Precision() == eidma.DefaultPrecision {
+			return EIDMACoder
+		}
+	case mq.Coder:
+		return MQCoder
+	case golomb.Coder:
+		return GolombCoder
 	}
-	buf := new(bytes.Buffer)
-	err = binary.Write(buf, binary.BigEndian, fi.Size())
+	return UnknownCoder
+}
+
+// Compress compresses the size bytes read from r using arithmetic coding supplied with a Context Tree Weighting probabilistic model of depth depth.
+// The arithmetic coding is performed by coder, or by the witten coder if coder is nil.
+// The compressed result, which starts with a Header recording the depth, the maximum number of nodes of the context tree, and the size,
+// followed by the encoded bytes in chunks of up to 64KiB, each preceded by its size as a big endian uint32 and the last of which is empty,
+// and ends with the big endian CRC-32 checksum of the original data, is written to w.
+// Since the size is recorded before the data, r must supply exactly size bytes, and Compress returns an error if it supplies fewer.
+func Compress(w io.Writer, r io.Reader, size int64, depth int, coder ac.Coder) error {
+	_, err := CompressStats(w, r, size, depth, coder)
+	return err
+}
+
+// Stats are the statistics of compressing data.
+type Stats struct {
+	ac.Stats
+
+	// PeakNodes is the largest number of nodes of the context tree, which determines the memory used.
+	PeakNodes int
+}
+
+// add adds the statistics of coding more data to s.
+func (s *Stats) add(t ac.Stats, peakNodes int) {
+	s.Bits += t.Bits
+	s.EncodedBits += t.EncodedBits
+	s.CrossEntropy += t.CrossEntropy
+	s.Renormalizations += t.Renormalizations
+	s.PeakNodes = peakNodes
+}
+
+// CompressStats is like Compress, but also returns the statistics of the compression.
+func CompressStats(w io.Writer, r io.Reader, size int64, depth int, coder ac.Coder) (Stats, error) {
+	return CompressWith(w, r, size, WithDepth(depth), WithCoder(coder))
+}
+
+// CompressWith is like CompressStats, but configured by opts, which default to a bit model of depth DefaultDepth and the witten coder.
+// The kind of model, the maximum number of nodes, and the checksum of the prime are recorded in the Header, so that decompressing needs only the coder and the prime.
+// With WithTwoPass, the Header is followed by the size of the summary of the model as a big endian uint32, and the summary written by WriteSummary,
+// unless the stream compressed online is no larger, in which case the online stream is written, whose Header records that it is not of two passes.
+func CompressWith(w io.Writer, r io.Reader, size int64, opts ...Option) (Stats, error) {
+	var stats Stats
+	o := newOptions(opts)
+	if err := o.check(); err != nil {
+		return stats, err
+	}
+	if size < 0 {
+		return stats, fmt.Errorf("negative size %d", size)
+	}
+	if o.auto {
+		sample := make([]byte, DepthSampleSize)
+		if int64(len(sample)) > size {
+			sample = sample[:size]
+		}
+		n, err := io.ReadFull(r, sample)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return stats, err
+		}
+		o.chooseDepth(sample[:n])
+		r = io.MultiReader(bytes.NewReader(sample[:n]), r)
+	}
+	if o.twoPass > 0 {
+		data, err := ioutil.ReadAll(io.LimitReader(r, size))
+		if err != nil {
+			return stats, err
+		}
+		if int64(len(data)) != size {
+			return stats, fmt.Errorf("read %d bytes, expected %d", len(data), size)
+		}
+		return o.compressTwoPass(w, data)
+	}
+	return o.compress(w, r, size, newModel(o.header(size), o.prime))
+}
+
+// compress writes the Header of a stream of size bytes to w, followed by the size bytes read from r coded with model.
+func (o options) compress(w io.Writer, r io.Reader, size int64, model treeModel) (Stats, error) {
+	if err := o.header(size).write(w); err != nil {
+		return Stats{}, err
+	}
+	return o.encode(w, r, size, model)
+}
+
+// encode writes the size bytes read from r coded with model to w as the payload of a stream, followed by their checksum.
+func (o options) encode(w io.Writer, r io.Reader, size int64, model treeModel) (Stats, error) {
+	var stats Stats
+	pw := newPayloadWriter(w)
+	bw := ac.NewBitWriter(pw)
+	sum := crc32.NewIEEE()
+	acStats, err := o.coder.EncodeStats(context.Background(), bw, ac.NewBitReader(io.TeeReader(io.LimitReader(r, size), sum)), model)
+	stats.add(acStats, model.PeakNodes())
 	if err != nil {
-		return err
+		return stats, err
 	}
-	if _, err = w.Write(buf.Bytes()); err != nil {
-		return err
+	if stats.Bits != size*8 {
+		return stats, fmt.Errorf("read %d bytes, expected %d", stats.Bits/8, size)
+	}
+	if err := bw.Flush(); err != nil {
+		return stats, err
+	}
+	if err := pw.Close(); err != nil {
+		return stats, err
 	}
+	return stats, writeChecksum(w, sum.Sum32())
+}
+
+// CompressFile is like Compress, but compresses the named file.
+func CompressFile(w io.Writer, name string, depth int, coder ac.Coder) error {
+	_, err := CompressFileStats(w, name, depth, coder)
+	return err
+}
+
+// CompressFileStats is like CompressFile, but also returns the statistics of the compression.
+func CompressFileStats(w io.Writer, name string, depth int, coder ac.Coder) (Stats, error) {
+	return compressFile(w, name, []Option{WithDepth(depth), WithCoder(coder)})
+}
 
-	// Send file contents to encoder through the src channel.
+// compressFile compresses the named file with CompressWith.
+func compressFile(w io.Writer, name string, opts []Option) (Stats, error) {
 	f, err := os.Open(name)
 	if err != nil {
-		return err
+		return Stats{}, err
 	}
 	defer f.Close()
-	src := make(chan int)
-	errc := make(chan error, 1)
-	// We allow the reader to terminate early via a stopReader channel,
-	// in case for example, a downstream error occured when writing to w.
-	stopReader := make(chan struct{}, 1)
-	go func() {
-		defer close(src)
-		errc <- func() error {
-			scanner := bufio.NewScanner(f)
-			scanner.Split(bufio.ScanBytes)
-			for scanner.Scan() {
-				var bt byte = scanner.Bytes()[0]
-				for i := uint(0); i < 8; i++ {
-					select {
-					case src <- ((int(bt) & (1 << i)) >> i):
-					case <-stopReader:
-					}
-				}
-			}
-			if err := scanner.Err(); err != nil {
-				return err
-			}
-			return nil
-		}()
-	}()
-
-	// Collect encoded bits into bytes and write to w
-	dst := make(chan int)
-	dsterrc := make(chan error, 1)
-	go func() {
-		dsterrc <- func() error {
-			defer func() { stopReader <- struct{}{} }()
-			buf := []byte{0}
-			var bt *byte = &buf[0]
-			var i uint = 0
-			for b := range dst {
-				if b == 1 {
-					*bt |= (1 << i)
-				}
-				i += 1
-
-				if i == 8 {
-					if _, err := w.Write(buf); err != nil {
-						return err
-					}
-					*bt = 0
-					i = 0
-				}
-			}
-			if i > 0 {
-				if _, err := w.Write(buf); err != nil {
-					return err
-				}
-			}
-			return nil
-		}()
-	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return Stats{}, err
+	}
+	return CompressWith(w, f, fi.Size(), opts...)
+}
 
-	model := NewCTW(make([]int, depth))
-	witten.Encode(dst, src, model)
+// Decompress decompress a compressed stream of bytes generated by Compress or a Writer.
+// Decompress reads the compressed bytes from r, and writes the decompressed result to w.
+// The kind and depth of the Context Tree Weighting model are read from the Header of the stream.
+// Decompress writes the result as it is decoded, and its memory is bounded by the maximum number of nodes in the Header rather than the size of the data.
+// The coder is read from the Header as well, unless the stream does not record it, in which case Decompress expects the same coder used in Compress, where a nil coder means the witten coder.
+// A non-nil coder other than the one recorded in the Header is an error.
+// Decompress returns ErrChecksum if the decompressed data is corrupt.
+//
+// Like gzip, r may hold several concatenated streams, whose decompressed data are written to w one after another, and bytes following a stream that do not start another are an error.
+// Streams of format version 1 written by Compress are not delimited, and hence must be the last in r.
+func Decompress(w io.Writer, r io.Reader, coder ac.Coder) error {
+	return DecompressWith(w, r, WithCoder(coder))
+}
 
-	if err := <-errc; err != nil {
+// DecompressWith is like Decompress, but configured by opts, of which only WithCoder and WithPrime apply, since the rest are read from the Header of each stream.
+// A primed stream must be decompressed with the same prime, which is given to all of the concatenated streams.
+func DecompressWith(w io.Writer, r io.Reader, opts ...Option) error {
+	h, err := ReadHeader(r)
+	if err != nil {
 		return err
 	}
-	if err := <-dsterrc; err != nil {
-		return err
+	return decompressStreams(w, r, h, newOptions(opts))
+}
+
+// CompressBytes compresses data as by CompressWith with opts, and returns the complete stream, including its Header and checksum.
+func CompressBytes(data []byte, opts ...Option) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, headerSize+len(data)/2+checksumSize))
+	if _, err := CompressWith(buf, bytes.NewReader(data), int64(len(data)), opts...); err != nil {
+		return nil, err
 	}
-	return nil
+	return buf.Bytes(), nil
 }
 
-// Decompress decompress a compressed stream of bytes generated by Compress.
-// Decompress reads the compressed bytes from r, and writes the decompressed result to w.
-// Decompress expects the same Context Tree Weighting depth used in Compress.
-func Decompress(w io.Writer, r io.Reader, depth int) error {
-	var numBytes int64
-	err := binary.Read(r, binary.BigEndian, &numBytes)
+// DecompressBytes decompresses p, which holds one or more complete streams as written by CompressBytes, Compress, or a Writer, as by DecompressWith with opts.
+func DecompressBytes(p []byte, opts ...Option) ([]byte, error) {
+	r := bytes.NewReader(p)
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	// Trust the recorded size only as far as the stream could plausibly expand, so that a corrupt header cannot allocate too much.
+	if h.Size > 0 && h.Size <= 64*int64(len(p)) {
+		buf.Grow(int(h.Size))
+	}
+	if err := decompressStreams(buf, r, h, newOptions(opts)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressStreams decompresses the stream described by h, whose header has already been read from r, and the streams concatenated after it.
+func decompressStreams(w io.Writer, r io.Reader, h Header, o options) error {
+	for {
+		if err := decompress(w, r, h, o); err != nil {
+			return err
+		}
+		var err error
+		if h, err = readNextHeader(r); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// readNextHeader reads the header of the next of concatenated streams, and returns io.EOF if r has ended instead.
+func readNextHeader(r io.Reader) (Header, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return Header{}, err
+	}
+	return ReadHeader(io.MultiReader(bytes.NewReader(b), r))
+}
+
+// decompress decompresses the stream described by h, whose header has already been read from r.
+// Unless the stream is of format version 1, which has no checksum, decompress returns ErrChecksum if the decompressed data does not match the checksum ending the stream.
+func decompress(w io.Writer, r io.Reader, h Header, o options) error {
+	if err := h.checkPrime(o.prime); err != nil {
+		return err
+	}
+	coder, err := o.decoder(h)
 	if err != nil {
 		return err
 	}
+	var model treeModel
+	if h.TwoPass {
+		m, err := readTwoPass(r)
+		if err != nil {
+			return err
+		}
+		model = m
+	} else {
+		model = newModel(h, o.prime)
+	}
+	if h.Version == 1 {
+		// Streams of format version 1 are of known size, and their encoded bytes are neither delimited nor followed by a checksum.
+		bw := ac.NewBitWriter(w)
+		if _, err := coder.DecodeStats(context.Background(), bw, ac.NewBitReader(r), model, h.Size*8); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
 
-	// Read bits from r and send them to the decoder through the src channel.
-	// Errors during reading are surfaced through the errc channel.
-	// Since the last byte might contain superfluous bits not belonging to the encoded result (there is no reason why the number of encoded bits should be multipies of 8),
-	// we need a stopReader channel to avoid the reader goroutine from blocking indefinitely..
-	src := make(chan int)
-	srcErrc := make(chan error, 1)
-	stopReader := make(chan struct{}, 1)
-	go func() {
-		defer close(src)
-		srcErrc <- func() error {
-			scanner := bufio.NewScanner(r)
-			scanner.Split(bufio.ScanBytes)
-			for scanner.Scan() {
-				var bt byte = scanner.Bytes()[0]
-				for i := uint(0); i < 8; i++ {
-					select {
-					case src <- ((int(bt) & (1 << i)) >> i):
-					case <-stopReader:
-						return nil
-					}
-				}
+	sum := crc32.NewIEEE()
+	w = io.MultiWriter(w, sum)
+	if h.Size == framedSize {
+		for {
+			err := decodeFrame(w, r, coder, model)
+			if err == io.EOF {
+				break
 			}
-			if err := scanner.Err(); err != nil {
+			if err != nil {
 				return err
 			}
-			return nil
-		}()
-	}()
-
-	// Collect decoded bits and write them out as bytes.
-	// Upon completion or error, signal the reader goroutine to terminate via the stopReader channel.
-	dst := make(chan int)
-	dstErrc := make(chan error, 1)
-	go func() {
-		dstErrc <- func() error {
-			defer func() { stopReader <- struct{}{} }()
-			buf := []byte{0}
-			var bt *byte = &buf[0]
-			var i uint = 0
-			for b := range dst {
-				if b == 1 {
-					*bt |= (1 << i)
-				}
-				i += 1
-
-				if i == 8 {
-					if _, err := w.Write(buf); err != nil {
-						return err
-					}
-					*bt = 0
-					i = 0
-				}
-			}
-			if i > 0 {
-				if _, err := w.Write(buf); err != nil {
-					return err
-				}
-			}
-			return nil
-		}()
-	}()
-
-	model := NewCTW(make([]int, depth))
-	decodeErr := witten.Decode(dst, src, model, numBytes*8)
+		}
+		return readChecksum(r, sum.Sum32())
+	}
 
-	if err := <-srcErrc; err != nil {
+	pr := &payloadReader{r: r}
+	bw := ac.NewBitWriter(w)
+	if _, err := coder.DecodeStats(context.Background(), bw, ac.NewBitReader(pr), model, h.Size*8); err != nil {
 		return err
 	}
-	if err := <-dstErrc; err != nil {
+	if err := bw.Flush(); err != nil {
 		return err
 	}
-	if decodeErr != nil {
-		return decodeErr
+	// Skip the bits of the coder that were not read.
+	if err := pr.skip(); err != nil {
+		return err
 	}
-	return nil
+	return readChecksum(r, sum.Sum32())
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fumin/ctw"
+	"github.com/pkg/errors"
+)
+
+// A compressor compresses data, returning the compressed size.
+type compressor struct {
+	name     string
+	compress func(data []byte) (int64, error)
+}
+
+// A result is the compressed size of a file and the time it took.
+type result struct {
+	size    int64
+	elapsed time.Duration
+}
+
+func runBench(fs *flag.FlagSet, args []string) error {
+	var model modelFlags
+	var coding codingFlags
+	model.register(fs)
+	coding.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	_, opts, err := coding.options()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	modelOpts, err := model.options()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	opts = append(opts, modelOpts...)
+	compressors := []compressor{
+		{name: "ctw", compress: func(data []byte) (int64, error) {
+			cw := &countingWriter{w: ioutil.Discard}
+			_, err := ctw.CompressWith(cw, bytes.NewReader(data), int64(len(data)), opts...)
+			return cw.n, err
+		}},
+		{name: "gzip", compress: func(data []byte) (int64, error) {
+			cw := &countingWriter{w: ioutil.Discard}
+			zw := gzip.NewWriter(cw)
+			if _, err := zw.Write(data); err != nil {
+				return 0, err
+			}
+			err := zw.Close()
+			return cw.n, err
+		}},
+		{name: "flate-9", compress: func(data []byte) (int64, error) {
+			cw := &countingWriter{w: ioutil.Discard}
+			fw, err := flate.NewWriter(cw, flate.BestCompression)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := fw.Write(data); err != nil {
+				return 0, err
+			}
+			err = fw.Close()
+			return cw.n, err
+		}},
+	}
+	return bench(fs.Arg(0), compressors)
+}
+
+// bench compresses the files of dir with each of compressors, and prints the table of the results to stdout.
+func bench(dir string, compressors []compressor) error {
+	names, err := listFiles(dir)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "file\tsize\t")
+	for _, c := range compressors {
+		fmt.Fprintf(tw, "%s\tbpb\tMiB/s\t", c.name)
+	}
+	fmt.Fprintf(tw, "\n")
+
+	var total int64
+	totals := make([]result, len(compressors))
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return errors.Wrap(err, "")
+		}
+		total += int64(len(data))
+		fmt.Fprintf(tw, "%s\t%d\t", name, len(data))
+		for i, c := range compressors {
+			start := time.Now()
+			size, err := c.compress(data)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("%s %s", c.name, name))
+			}
+			r := result{size: size, elapsed: time.Since(start)}
+			totals[i].size += r.size
+			totals[i].elapsed += r.elapsed
+			fmt.Fprintf(tw, "%s", r.format(int64(len(data))))
+		}
+		fmt.Fprintf(tw, "\n")
+	}
+	fmt.Fprintf(tw, "total\t%d\t", total)
+	for _, r := range totals {
+		fmt.Fprintf(tw, "%s", r.format(total))
+	}
+	fmt.Fprintf(tw, "\n")
+	return tw.Flush()
+}
+
+// format returns the table cells of the compressed size, the bits per byte, and the speed of compressing n bytes.
+func (r result) format(n int64) string {
+	var bpb float64
+	if n > 0 {
+		bpb = float64(r.size*8) / float64(n)
+	}
+	speed := float64(n) / (1 << 20) / r.elapsed.Seconds()
+	return fmt.Sprintf("%d\t%.3f\t%.2f\t", r.size, bpb, speed)
+}
+
+// listFiles returns the names of the regular files in dir, in lexical order.
+func listFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/ac"
+)
+
+// compressCmd holds the flags and arguments of the compress command.
+type compressCmd struct {
+	model           modelFlags
+	coding          codingFlags
+	verbose         bool
+	progress        bool
+	archive         bool
+	twoPass         int
+	metadata        bool
+	maxmem          string
+	encrypt         bool
+	passfile        string
+	checkpoint      string
+	checkpointEvery string
+	resume          bool
+	output          string
+	include         globs
+	exclude         globs
+	args            []string
+
+	// dir is the directory to compress into an archive, if the only argument is a directory.
+	dir string
+}
+
+func runCompress(fs *flag.FlagSet, args []string) error {
+	c := &compressCmd{}
+	c.model.register(fs)
+	c.coding.register(fs)
+	fs.BoolVar(&c.verbose, "verbose", false, "print compression statistics to stderr")
+	fs.BoolVar(&c.progress, "progress", false, "report the percentage done and the throughput to stderr")
+	fs.BoolVar(&c.archive, "archive", false, "compress all of the named files into a single archive")
+	fs.IntVar(&c.twoPass, "twopass", 0, "if positive, train the model over the whole input before coding with it frozen, storing the contexts seen at least this many times in the output, unless coding online is smaller")
+	fs.BoolVar(&c.metadata, "metadata", false, "store the name, modification time, and permission bits of the file, which decompress -restore restores")
+	fs.StringVar(&c.maxmem, "maxmem", "", "maximum memory of the context tree, such as 512M or 2G, beyond which the least visited contexts are pruned")
+	fs.BoolVar(&c.encrypt, "encrypt", false, "encrypt the output with AES-256-GCM, with a key derived from the passphrase in the file given by -passfile, or else in the environment variable "+passphraseEnv)
+	fs.StringVar(&c.passfile, "passfile", "", "file whose first line is the passphrase of -encrypt")
+	fs.StringVar(&c.checkpoint, "checkpoint", "", "file to periodically save the state of the compression of a file into -o, from which -resume resumes an interrupted compression")
+	fs.StringVar(&c.checkpointEvery, "checkpoint-every", "256M", "amount of input, such as 64M or 1G, between checkpoints")
+	fs.BoolVar(&c.resume, "resume", false, "resume the compression from the file given by -checkpoint")
+	fs.StringVar(&c.output, "o", "", "output file, standard output if empty")
+	fs.Var(&c.include, "include", "glob of the files under a directory to compress, matched against the base name of a file if it has no slash, and otherwise against its path relative to the directory, which may be given several times, in which case a file matching any of them is compressed")
+	fs.Var(&c.exclude, "exclude", "glob, matched as that of -include, of the files and directories under a directory not to compress, which may be given several times")
+	fs.Parse(args)
+	c.args = fs.Args()
+	if c.archive && len(c.args) == 0 {
+		return errUsage
+	}
+	if len(c.args) == 1 {
+		if fi, err := os.Stat(c.args[0]); err == nil && fi.IsDir() {
+			c.dir = c.args[0]
+		}
+	}
+	if (c.archive || c.dir != "") && c.encrypt {
+		return fmt.Errorf("-encrypt is not supported with archives, whose members are read randomly")
+	}
+	if (len(c.include) > 0 || len(c.exclude) > 0) && c.dir == "" {
+		return fmt.Errorf("-include and -exclude require a directory")
+	}
+	var pass string
+	if c.encrypt {
+		var err error
+		if pass, err = passphrase(c.passfile); err != nil {
+			return err
+		}
+	}
+
+	coder, opts, err := c.coding.options()
+	if err != nil {
+		return err
+	}
+	modelOpts, err := c.model.options()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, modelOpts...)
+	if c.twoPass > 0 {
+		opts = append(opts, ctw.WithTwoPass(c.twoPass))
+	}
+	if c.maxmem != "" {
+		mem, err := parseSize(c.maxmem)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, ctw.WithMaxNodes(ctw.MaxNodesForMemory(mem)), ctw.WithPrune(true))
+	}
+
+	if c.checkpoint != "" {
+		return c.compressCheckpointed(opts)
+	}
+	if c.output == "" {
+		if isTerminal(os.Stdout) {
+			return fmt.Errorf("refusing to write compressed data to a terminal, use -o or redirect standard output")
+		}
+		return c.run(os.Stdout, pass, coder, opts)
+	}
+	f, err := os.Create(c.output)
+	if err != nil {
+		return err
+	}
+	if err := c.run(f, pass, coder, opts); err != nil {
+		f.Close()
+		os.Remove(c.output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(c.output)
+		return err
+	}
+	return nil
+}
+
+// run compresses the input given by the command line arguments, and writes the result to w, encrypted with pass if -encrypt is given.
+func (c *compressCmd) run(w io.Writer, pass string, coder ac.Coder, opts []ctw.Option) error {
+	if !c.encrypt {
+		return c.compressInput(w, coder, opts)
+	}
+	e, err := ctw.NewEncryptWriter(w, pass)
+	if err != nil {
+		return err
+	}
+	if err := c.compressInput(e, coder, opts); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// compressInput compresses the input given by the command line arguments with coder and opts, and writes the result to w.
+func (c *compressCmd) compressInput(w io.Writer, coder ac.Coder, opts []ctw.Option) error {
+	if c.dir != "" {
+		return ctw.CompressArchiveDir(w, c.dir, c.keep, opts...)
+	}
+	if c.archive {
+		return ctw.CompressArchive(w, c.args, opts...)
+	}
+	start := time.Now()
+	cw := &countingWriter{w: w}
+	stats, err := c.compress(cw, coder, opts)
+	if err != nil {
+		return err
+	}
+	if c.verbose {
+		report(stats, cw.n, time.Since(start))
+	}
+	return nil
+}
+
+// name returns the name of the file to compress, which is empty or - for standard input.
+func (c *compressCmd) name() string {
+	if len(c.args) == 0 {
+		return ""
+	}
+	return c.args[0]
+}
+
+// compress compresses the named file, or standard input, and writes the result to w.
+func (c *compressCmd) compress(w io.Writer, coder ac.Coder, opts []ctw.Option) (ctw.Stats, error) {
+	name := c.name()
+	if name == "" || name == "-" {
+		if c.metadata {
+			return ctw.Stats{}, fmt.Errorf("-metadata requires a file")
+		}
+		return c.compressStdin(w, opts)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return ctw.Stats{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return ctw.Stats{}, err
+	}
+	if c.metadata {
+		opts = append(opts, ctw.WithFileInfo(fi))
+	}
+	if c.progress {
+		m := &meter{total: fi.Size(), start: time.Now()}
+		coder = ac.WithProgress(coder, 8<<20, func(bits, encodedBits int64) {
+			m.report(bits/8, fmt.Sprintf("compressed to %d bytes", encodedBits/8))
+		})
+		opts = append(opts, ctw.WithCoder(coder))
+	}
+	return ctw.CompressWith(w, f, fi.Size(), opts...)
+}
+
+// compressStdin compresses standard input, whose size is not known in advance, with a ctw.Writer.
+func (c *compressCmd) compressStdin(w io.Writer, opts []ctw.Option) (ctw.Stats, error) {
+	var r io.Reader = os.Stdin
+	if c.progress {
+		r = &progressReader{r: r, meter: &meter{start: time.Now()}, next: 8 << 20}
+	}
+	z := ctw.NewWriter(w, opts...)
+	if _, err := io.Copy(z, r); err != nil {
+		return z.Stats(), err
+	}
+	err := z.Close()
+	return z.Stats(), err
+}
+
+// compressCheckpointed compresses the named file into the file given by -o with a ctw.Writer, saving a checkpoint to the file given by -checkpoint every -checkpoint-every bytes,
+// and resuming from the checkpoint if -resume is given.
+// The checkpoint is removed once the compression completes.
+func (c *compressCmd) compressCheckpointed(opts []ctw.Option) error {
+	name := c.name()
+	if name == "" || name == "-" || c.output == "" || c.archive || c.dir != "" || c.encrypt {
+		return fmt.Errorf("-checkpoint requires a file and -o, and is not supported with archives or -encrypt")
+	}
+	every, err := parseSize(c.checkpointEvery)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var out *os.File
+	var z *ctw.Writer
+	if c.resume {
+		if out, z, err = c.resumeCheckpoint(in, opts); err != nil {
+			return err
+		}
+	} else {
+		if out, err = os.Create(c.output); err != nil {
+			return err
+		}
+		z = ctw.NewWriter(out, opts...)
+	}
+	defer out.Close()
+
+	for {
+		n, err := io.CopyN(z, in, every)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n < every {
+			break
+		}
+		if err := c.saveCheckpoint(out, z); err != nil {
+			return err
+		}
+		if c.progress {
+			done, _ := z.Offsets()
+			log.Printf("checkpointed at %d bytes", done)
+		}
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if c.verbose {
+		fi, err := os.Stat(c.output)
+		if err != nil {
+			return err
+		}
+		report(z.Stats(), fi.Size(), time.Since(start))
+	}
+	return os.Remove(c.checkpoint)
+}
+
+// resumeCheckpoint opens the file given by -o, truncated to where the checkpoint given by -checkpoint was saved, and returns the resumed ctw.Writer appending to it.
+// The input in is positioned at the corresponding offset.
+func (c *compressCmd) resumeCheckpoint(in *os.File, opts []ctw.Option) (*os.File, *ctw.Writer, error) {
+	cp, err := os.Open(c.checkpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cp.Close()
+	out, err := os.OpenFile(c.output, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	z, err := ctw.ResumeWriter(out, cp, opts...)
+	if err != nil {
+		out.Close()
+		return nil, nil, err
+	}
+	inOffset, outOffset := z.Offsets()
+	if err := out.Truncate(outOffset); err != nil {
+		out.Close()
+		return nil, nil, err
+	}
+	if _, err := out.Seek(outOffset, io.SeekStart); err != nil {
+		out.Close()
+		return nil, nil, err
+	}
+	if _, err := in.Seek(inOffset, io.SeekStart); err != nil {
+		out.Close()
+		return nil, nil, err
+	}
+	return out, z, nil
+}
+
+// saveCheckpoint saves the checkpoint of z, which writes to out, to the file given by -checkpoint.
+// The output is synced before the checkpoint replaces the previous one, so that a checkpoint never refers to compressed data that was lost.
+func (c *compressCmd) saveCheckpoint(out *os.File, z *ctw.Writer) error {
+	if err := z.Flush(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	tmp := c.checkpoint + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := z.Checkpoint(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.checkpoint)
+}
+
+// keep reports whether the file or directory of the given path relative to the directory being compressed is kept, according to -include and -exclude.
+// Directories are only excluded, so that the files under them can still be included.
+func (c *compressCmd) keep(name string, fi os.FileInfo) bool {
+	if c.exclude.match(name) {
+		return false
+	}
+	return fi.IsDir() || len(c.include) == 0 || c.include.match(name)
+}
+
+// globs is a flag.Value of the patterns given by a flag, which may be given several times.
+type globs []string
+
+func (g *globs) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globs) Set(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid glob %q", pattern)
+	}
+	*g = append(*g, pattern)
+	return nil
+}
+
+// match reports whether the slash separated path name matches one of the patterns.
+// A pattern without a slash is matched against the base name of name, and otherwise against the whole of name.
+func (g globs) match(name string) bool {
+	for _, pattern := range g {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// report prints the statistics of compressing into size bytes in elapsed time to stderr.
+func report(stats ctw.Stats, size int64, elapsed time.Duration) {
+	in := stats.Bits / 8
+	var ratio, bitsPerByte float64
+	if in > 0 {
+		ratio = float64(size) / float64(in)
+		bitsPerByte = float64(size*8) / float64(in)
+	}
+	log.Printf("input %d bytes, output %d bytes, ratio %.4f, %.4f bits per byte, elapsed %v, peak %d tree nodes", in, size, ratio, bitsPerByte, elapsed, stats.PeakNodes)
+}
+
+// A progressReader reports the number of bytes read to stderr periodically.
+type progressReader struct {
+	r     io.Reader
+	meter *meter
+	n     int64
+	next  int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.n += int64(n)
+	if pr.n >= pr.next || err == io.EOF {
+		pr.meter.report(pr.n, "read")
+		pr.next = pr.n + 8<<20
+	}
+	return n, err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fumin/ctw"
+	"github.com/pkg/errors"
+)
+
+// decompressCmd holds the flags of the decompress command.
+type decompressCmd struct {
+	coding   codingFlags
+	progress bool
+	archive  string
+	list     bool
+	restore  bool
+	passfile string
+	output   string
+}
+
+func runDecompress(fs *flag.FlagSet, args []string) error {
+	c := &decompressCmd{}
+	c.coding.register(fs)
+	fs.BoolVar(&c.progress, "progress", false, "report the percentage done and the throughput to stderr")
+	fs.StringVar(&c.archive, "archive", "", "archive to extract the named members, or all members if none are named, into the current directory")
+	fs.BoolVar(&c.list, "list", false, "list the members of the archive instead of extracting them")
+	fs.BoolVar(&c.restore, "restore", false, "restore the name, modification time, and permission bits stored by compress -metadata, writing to the stored name unless -o is given")
+	fs.StringVar(&c.passfile, "passfile", "", "file whose first line is the passphrase of an encrypted stream, which is otherwise taken from the environment variable "+passphraseEnv)
+	fs.StringVar(&c.output, "o", "", "output file, standard output if empty, or for an archive the directory to extract into, the current directory if empty")
+	fs.Parse(args)
+	_, opts, err := c.coding.options()
+	if err != nil {
+		return err
+	}
+	if c.archive != "" {
+		return c.extract(fs.Args(), opts)
+	}
+
+	in, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, _, err := decrypt(in, c.passfile)
+	if err != nil {
+		return err
+	}
+	zr, err := ctw.NewReader(r, opts...)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	if c.restore && !zr.Header.Metadata {
+		return fmt.Errorf("no file metadata stored in the stream")
+	}
+	if c.output == "" && !c.restore {
+		return c.decompress(os.Stdout, zr)
+	}
+
+	// Without -o, the file is restored under its stored name, which must not overwrite an existing file.
+	out, flags := c.output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC
+	if out == "" {
+		out, flags = zr.Header.Name, os.O_WRONLY|os.O_CREATE|os.O_EXCL
+		if out == "" || out != filepath.Base(out) || out == "." || out == ".." {
+			return fmt.Errorf("invalid stored name %q", out)
+		}
+	}
+	f, err := os.OpenFile(out, flags, 0666)
+	if err != nil {
+		return err
+	}
+	if err := c.decompress(f, zr); err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(out)
+		return err
+	}
+	if c.restore {
+		if err := os.Chmod(out, zr.Header.Mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(out, zr.Header.ModTime, zr.Header.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decompress writes the data decompressed by zr to w.
+func (c *decompressCmd) decompress(w io.Writer, zr *ctw.Reader) error {
+	if c.progress {
+		// The size is unknown for streams compressed from standard input.
+		var total int64
+		if zr.Header.Size > 0 {
+			total = zr.Header.Size
+		}
+		w = &progressWriter{w: w, meter: &meter{total: total, start: time.Now()}, next: 8 << 20}
+	}
+	if _, err := io.Copy(w, zr); err != nil {
+		return err
+	}
+	if pw, ok := w.(*progressWriter); ok {
+		pw.meter.report(pw.n, "decompressed")
+	}
+	return nil
+}
+
+// A progressWriter reports the number of bytes written to stderr periodically.
+type progressWriter struct {
+	w     io.Writer
+	meter *meter
+	n     int64
+	next  int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.n += int64(n)
+	if pw.n >= pw.next {
+		pw.meter.report(pw.n, "decompressed")
+		pw.next = pw.n + 8<<20
+	}
+	return n, err
+}
+
+// extract extracts the named members of the archive given by -archive, or all members if names is empty.
+func (c *decompressCmd) extract(names []string, opts []ctw.Option) error {
+	f, err := os.Open(c.archive)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	ar, err := ctw.NewArchiveReader(f, fi.Size(), opts...)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	if c.list {
+		for _, m := range ar.Members {
+			fmt.Printf("%d\t%s\n", m.Size, m.Name)
+		}
+		return nil
+	}
+	if len(names) == 0 {
+		for _, m := range ar.Members {
+			names = append(names, m.Name)
+		}
+	}
+	for _, name := range names {
+		if err := c.extractMember(ar, name); err != nil {
+			return errors.Wrap(err, name)
+		}
+	}
+	return nil
+}
+
+func (c *decompressCmd) extractMember(ar *ctw.ArchiveReader, name string) error {
+	fpath := filepath.Join(c.output, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+		return errors.Wrap(err, "")
+	}
+	f, err := os.Create(fpath)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	defer f.Close()
+	if err := ar.Extract(f, name); err != nil {
+		return errors.Wrap(err, "")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
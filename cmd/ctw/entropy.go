@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fumin/ctw"
+	"github.com/pkg/errors"
+)
+
+func runEntropy(fs *flag.FlagSet, args []string) error {
+	var model modelFlags
+	model.register(fs)
+	prime := fs.String("prime", "", "file to train the model on before estimating")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+	opts, err := model.options()
+	if err != nil {
+		return err
+	}
+	if *prime != "" {
+		p, err := ioutil.ReadFile(*prime)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, ctw.WithPrime(p))
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "file\tsize\testimate\tbpb\t\n")
+	for _, name := range fs.Args() {
+		length, n, err := codeLength(name, opts)
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+		var bpb float64
+		if n > 0 {
+			bpb = length / float64(n)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.0f\t%.3f\t\n", name, n, length/8, bpb)
+	}
+	return tw.Flush()
+}
+
+// codeLength returns the code length in bits of the named file, or of standard input if name is -, and its size.
+func codeLength(name string, opts []ctw.Option) (float64, int64, error) {
+	f, err := openInput(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	return ctw.CodeLength(f, opts...)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fumin/ctw"
+	"github.com/fumin/ctw/ac"
+)
+
+// codingFlags are the flags choosing how a stream is coded.
+// The prime must be the same when compressing and decompressing a stream, whereas the coder is recorded in the stream.
+type codingFlags struct {
+	coder string
+	prime string
+}
+
+func (c *codingFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.coder, "coder", "", "arithmetic coder, one of witten, eidma, mq, or golomb, which defaults to witten when compressing and to the coder recorded in the stream when decompressing")
+	fs.StringVar(&c.prime, "prime", "", "file to train the model on before coding, which must be given to both compress and decompress")
+}
+
+// options returns the coder and the options given by the flags.
+// Without -coder, the coder is the witten coder, but the options leave it to be read from the stream when decompressing.
+func (c *codingFlags) options() (ac.Coder, []ctw.Option, error) {
+	name := c.coder
+	if name == "" {
+		name = "witten"
+	}
+	coder, err := ctw.NewCoder(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var opts []ctw.Option
+	if c.coder != "" {
+		opts = append(opts, ctw.WithCoder(coder))
+	}
+	if c.prime != "" {
+		p, err := ioutil.ReadFile(c.prime)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, ctw.WithPrime(p))
+	}
+	return coder, opts, nil
+}
+
+// modelFlags are the flags configuring the model, which are recorded in the header of a stream.
+type modelFlags struct {
+	depth string
+	model string
+}
+
+func (m *modelFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&m.depth, "depth", "48", "depth of Context Tree Weighting, or auto to choose the depth that best compresses a sample of the input")
+	fs.StringVar(&m.model, "model", "bit", "model, either bit for a single context tree over all bits, which suits binaries, or byte for a context tree per bit position of a byte, which suits text")
+}
+
+// options returns the options given by the flags.
+func (m *modelFlags) options() ([]ctw.Option, error) {
+	model, err := ctw.ParseModel(m.model)
+	if err != nil {
+		return nil, err
+	}
+	opts := []ctw.Option{ctw.WithModel(model)}
+	if m.depth == "auto" {
+		return append(opts, ctw.WithAutoDepth()), nil
+	}
+	d, err := strconv.Atoi(m.depth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid depth %q", m.depth)
+	}
+	return append(opts, ctw.WithDepth(d)), nil
+}
+
+// passphraseEnv is the environment variable holding the passphrase, if -passfile is not given.
+const passphraseEnv = "CTW_PASSPHRASE"
+
+// passphrase returns the passphrase in the first line of passfile, or else in the environment variable passphraseEnv if passfile is empty.
+func passphrase(passfile string) (string, error) {
+	pass := os.Getenv(passphraseEnv)
+	if passfile != "" {
+		b, err := ioutil.ReadFile(passfile)
+		if err != nil {
+			return "", err
+		}
+		pass = strings.TrimSuffix(strings.SplitN(string(b), "\n", 2)[0], "\r")
+	}
+	if pass == "" {
+		return "", fmt.Errorf("empty passphrase, set -passfile or %s", passphraseEnv)
+	}
+	return pass, nil
+}
+
+// openInput opens the named file, or returns standard input if name is empty or -.
+func openInput(name string) (*os.File, error) {
+	if name == "" || name == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
+
+// decrypt returns a reader of the compressed stream read from r, which is decrypted with the passphrase given by passfile if it was written by compress -encrypt.
+// It also reports whether the stream was encrypted.
+func decrypt(r io.Reader, passfile string) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(8)
+	if err != nil || !ctw.IsEncrypted(magic) {
+		return br, false, nil
+	}
+	pass, err := passphrase(passfile)
+	if err != nil {
+		return nil, true, fmt.Errorf("encrypted stream: %v", err)
+	}
+	dr, err := ctw.NewDecryptReader(br, pass)
+	return dr, true, err
+}
+
+// parseSize parses a number of bytes, optionally followed by one of the binary suffixes K, M, or G.
+func parseSize(size string) (int64, error) {
+	s, mult := size, int64(1)
+	if n := len(s); n > 0 {
+		switch strings.ToUpper(s[n-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * mult, nil
+}
+
+// A countingWriter counts the bytes written to an io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// isTerminal reports whether f is a terminal, which is approximated by a character device other than the null device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
+
+// A meter reports the progress of processing a total number of bytes, which is unknown if zero.
+type meter struct {
+	total int64
+	start time.Time
+}
+
+// report prints to stderr that n bytes have been processed, along with the percentage of the total and the throughput, followed by detail.
+func (m *meter) report(n int64, detail string) {
+	rate := float64(n) / (1 << 20) / time.Since(m.start).Seconds()
+	if m.total > 0 {
+		log.Printf("%d/%d bytes (%.1f%%) %s, %.2f MiB/s", n, m.total, 100*float64(n)/float64(m.total), detail, rate)
+		return
+	}
+	log.Printf("%d bytes %s, %.2f MiB/s", n, detail, rate)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fumin/ctw"
+)
+
+func runInspect(fs *flag.FlagSet, args []string) error {
+	passfile := fs.String("passfile", "", "file whose first line is the passphrase of an encrypted stream, which is otherwise taken from the environment variable "+passphraseEnv)
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return errUsage
+	}
+
+	in, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, encrypted, err := decrypt(in, *passfile)
+	if err != nil {
+		return err
+	}
+	h, err := ctw.ReadHeader(r)
+	if err != nil {
+		return err
+	}
+	// The compressed size is only known for files.
+	var compressed int64 = -1
+	if fi, err := in.Stat(); err == nil && fi.Mode().IsRegular() {
+		compressed = fi.Size()
+	}
+	return printHeader(os.Stdout, h, encrypted, compressed)
+}
+
+// printHeader prints the fields of h, and whether the stream is encrypted, to w.
+// The compressed size of the stream is also printed, along with the compression ratio, unless it is negative.
+func printHeader(w io.Writer, h ctw.Header, encrypted bool, compressed int64) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "version\t%d\n", h.Version)
+	fmt.Fprintf(tw, "model\t%v\n", h.Model)
+	if h.Coder != ctw.UnknownCoder {
+		fmt.Fprintf(tw, "coder\t%v\n", h.Coder)
+	} else {
+		fmt.Fprintf(tw, "coder\tnot recorded\n")
+	}
+	fmt.Fprintf(tw, "depth\t%d\n", h.Depth)
+	if h.MaxNodes > 0 {
+		fmt.Fprintf(tw, "max nodes\t%d\n", h.MaxNodes)
+		fmt.Fprintf(tw, "prune\t%t\n", h.Prune)
+	} else {
+		fmt.Fprintf(tw, "max nodes\tunlimited\n")
+	}
+	fmt.Fprintf(tw, "two-pass\t%t\n", h.TwoPass)
+	if h.Primed {
+		fmt.Fprintf(tw, "primed\ttrue, prime checksum %08x\n", h.PrimeChecksum)
+	} else {
+		fmt.Fprintf(tw, "primed\tfalse\n")
+	}
+	fmt.Fprintf(tw, "encrypted\t%t\n", encrypted)
+	if h.Size >= 0 {
+		fmt.Fprintf(tw, "size\t%d\n", h.Size)
+	} else {
+		fmt.Fprintf(tw, "size\tunknown, coded in frames\n")
+	}
+	if compressed >= 0 {
+		fmt.Fprintf(tw, "compressed\t%d\n", compressed)
+		if h.Size > 0 {
+			fmt.Fprintf(tw, "ratio\t%.4f, %.4f bits per byte\n", float64(compressed)/float64(h.Size), float64(compressed*8)/float64(h.Size))
+		}
+	}
+	if h.Metadata {
+		fmt.Fprintf(tw, "name\t%s\n", h.Name)
+		fmt.Fprintf(tw, "modified\t%v\n", h.ModTime)
+		fmt.Fprintf(tw, "mode\t%v\n", h.Mode)
+	}
+	return tw.Flush()
+}
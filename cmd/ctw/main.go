@@ -0,0 +1,135 @@
+// Command ctw compresses and decompresses files with Context Tree Weighting,
+// and offers tools to benchmark the compression, estimate the entropy of files, inspect compressed streams, and verify the test vectors of the format.
+//
+// Usage:
+//
+//	ctw <command> [flags] [arguments]
+//
+// Run ctw <command> -h for the flags of a command.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// A command is a subcommand of ctw.
+type command struct {
+	name string
+
+	// synopses are the synopses of the arguments of the command.
+	synopses []string
+
+	// doc describes the arguments of the command.
+	doc string
+
+	// short is a one-line description of the command.
+	short string
+
+	// run parses the flags of the command into fs, and runs the command.
+	run func(fs *flag.FlagSet, args []string) error
+}
+
+// errUsage is returned by the run function of a command whose arguments are invalid, so that its usage is printed.
+var errUsage = fmt.Errorf("invalid arguments")
+
+var commands = []command{
+	{
+		name:     "compress",
+		synopses: []string{"[flags] [filename]", "[flags] -archive filename...", "[flags] directory"},
+		doc:      "Without a filename, or if the filename is -, standard input is compressed. A directory is compressed into an archive of the files under it.",
+		short:    "compress a file, standard input, or several files or a directory into an archive",
+		run:      runCompress,
+	},
+	{
+		name:     "decompress",
+		synopses: []string{"[flags] [filename]", "[flags] -archive archive [member...]"},
+		doc:      "Without a filename, or if the filename is -, standard input is decompressed.",
+		short:    "decompress a stream, or extract the members of an archive",
+		run:      runDecompress,
+	},
+	{
+		name:     "bench",
+		synopses: []string{"[flags] directory"},
+		doc:      "The regular files of the directory are compressed with CTW, gzip, and flate, and their compressed sizes and speeds are compared.",
+		short:    "compare CTW with gzip and flate on the files of a directory",
+		run:      runBench,
+	},
+	{
+		name:     "entropy",
+		synopses: []string{"[flags] filename..."},
+		doc:      "The bits per byte of each file are estimated from the probabilities predicted by the model, without compressing it. If a filename is -, standard input is estimated.",
+		short:    "estimate the compressed sizes of files",
+		run:      runEntropy,
+	},
+	{
+		name:     "inspect",
+		synopses: []string{"[flags] [filename]"},
+		doc:      "Without a filename, or if the filename is -, standard input is inspected.",
+		short:    "print the header of a compressed stream",
+		run:      runInspect,
+	},
+	{
+		name:     "verify",
+		synopses: []string{"[flags]"},
+		short:    "check or regenerate the golden test vectors",
+		run:      runVerify,
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage()
+		return
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		fs := flag.NewFlagSet("ctw "+c.name, flag.ExitOnError)
+		fs.Usage = func() { c.printUsage(fs) }
+		err := c.run(fs, os.Args[2:])
+		if err == errUsage {
+			fs.Usage()
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "ctw: unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// usage prints the commands of ctw to stderr.
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ctw <command> [flags] [arguments]\n\nThe commands are:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s%s\n", c.name, c.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun ctw <command> -h for the flags of a command.\n")
+}
+
+// printUsage prints the usage of the command, whose flags are fs, to stderr.
+func (c command) printUsage(fs *flag.FlagSet) {
+	for i, s := range c.synopses {
+		prefix := "usage:"
+		if i > 0 {
+			prefix = ""
+		}
+		fmt.Fprintf(os.Stderr, "%6s ctw %s %s\n", prefix, c.name, s)
+	}
+	if c.doc != "" {
+		fmt.Fprintf(os.Stderr, "%s\n", c.doc)
+	}
+	fs.PrintDefaults()
+}
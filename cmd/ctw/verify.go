@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/fumin/ctw/golden"
+)
+
+func runVerify(fs *flag.FlagSet, args []string) error {
+	dir := fs.String("dir", "testdata/golden", "directory of the test vectors")
+	update := fs.Bool("update", false, "overwrite the expected outputs instead of checking them")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	if err := golden.Verify(*dir, *update); err != nil {
+		return err
+	}
+	if !*update {
+		log.Printf("all test vectors verified")
+	}
+	return nil
+}
@@ -2,12 +2,29 @@ package ctw
 
 import (
 	"bytes"
+	"context"
+	"hash/crc32"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/ac/witten"
 )
 
 func TestCompress(t *testing.T) {
+	for _, coderName := range []string{"witten", "eidma", "mq", "golomb"} {
+		coder, err := NewCoder(coderName)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		testCompress(t, coder)
+	}
+}
+
+func testCompress(t *testing.T, coder ac.Coder) {
 	const name = "gettysburg.txt"
 	const depth = 48
 
@@ -18,7 +35,7 @@ func TestCompress(t *testing.T) {
 	}
 	defer f.Close()
 	defer os.Remove(f.Name())
-	if err := Compress(f, name, depth); err != nil {
+	if err := CompressFile(f, name, depth, coder); err != nil {
 		t.Fatalf("%v", err)
 	}
 
@@ -33,7 +50,7 @@ func TestCompress(t *testing.T) {
 	}
 	defer df.Close()
 	defer os.Remove(df.Name())
-	if err := Decompress(df, f, depth); err != nil {
+	if err := Decompress(df, f, coder); err != nil {
 		t.Fatalf("%v", err)
 	}
 
@@ -54,3 +71,546 @@ func TestCompress(t *testing.T) {
 		t.Errorf("%v %v", gettys, decom)
 	}
 }
+
+func BenchmarkCompress(b *testing.B) {
+	const name = "gettysburg.txt"
+	const depth = 48
+	for i := 0; i < b.N; i++ {
+		if err := CompressFile(ioutil.Discard, name, depth, nil); err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+}
+
+func BenchmarkCompressSerial(b *testing.B) {
+	const name = "gettysburg.txt"
+	const depth = 48
+	for i := 0; i < b.N; i++ {
+		if err := CompressFile(ioutil.Discard, name, depth, witten.Coder{}); err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+}
+
+func TestHeader(t *testing.T) {
+	const name = "gettysburg.txt"
+	const depth = 12
+	buf := bytes.NewBuffer(nil)
+	if err := CompressFile(buf, name, depth, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	compressed := buf.Bytes()
+
+	h, err := ReadHeader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	gettys, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if h.Version != FormatVersion || h.Depth != depth || h.MaxNodes != DefaultMaxNodes || h.Size != int64(len(gettys)) {
+		t.Fatalf("%+v", h)
+	}
+
+	// Decompress should use the depth in the header.
+	decompressed := bytes.NewBuffer(nil)
+	if err := Decompress(decompressed, bytes.NewReader(compressed), nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), gettys) {
+		t.Fatalf("%s", decompressed.Bytes())
+	}
+
+	// Version 1 streams, whose header has no maximum number of nodes and which have no checksum, should still be decompressed.
+	v1 := append([]byte{}, compressed[:len(headerMagic)+1+2]...)
+	v1[len(headerMagic)] = 1
+	v1 = append(v1, compressed[headerSize-8:headerSize]...)
+	// The encoded bytes, which are short enough to be a single chunk, are preceded by the size of the chunk, and followed by the final empty chunk.
+	v1 = append(v1, compressed[headerSize+4:len(compressed)-4-checksumSize]...)
+	decompressed.Reset()
+	if err := Decompress(decompressed, bytes.NewReader(v1), nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), gettys) {
+		t.Fatalf("%s", decompressed.Bytes())
+	}
+
+	// Streams without a valid header should be rejected.
+	corrupt := append([]byte{}, compressed...)
+	corrupt[0] = 'X'
+	if err := Decompress(ioutil.Discard, bytes.NewReader(corrupt), nil); err == nil {
+		t.Fatalf("expected error")
+	}
+	corrupt = append([]byte{}, compressed...)
+	corrupt[len(headerMagic)] = FormatVersion + 1
+	if err := Decompress(ioutil.Discard, bytes.NewReader(corrupt), nil); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := Decompress(ioutil.Discard, bytes.NewReader(compressed[:5]), nil); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCoderKind(t *testing.T) {
+	data := []byte("four score and seven years ago")
+	for _, name := range []string{"witten", "eidma", "mq", "golomb"} {
+		coder, err := NewCoder(name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		compressed, err := CompressBytes(data, WithDepth(16), WithCoder(ac.WithProgress(coder, 8, func(int64, int64) {})))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		h, err := ReadHeader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if h.Coder.String() != name {
+			t.Fatalf("%s %v", name, h.Coder)
+		}
+
+		// The coder recorded in the header is used unless one is given, which must be the recorded one.
+		decompressed, err := DecompressBytes(compressed)
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Fatalf("%s %v %s", name, err, decompressed)
+		}
+		if decompressed, err := DecompressBytes(compressed, WithCoder(coder)); err != nil || !bytes.Equal(decompressed, data) {
+			t.Fatalf("%s %v %s", name, err, decompressed)
+		}
+		other := witten.Coder{}
+		if name == "witten" {
+			other = witten.Coder{Runs: true}
+		}
+		if _, err := DecompressBytes(compressed, WithCoder(other)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	// Coders not returned by NewCoder are not recorded, and must be given to decompress.
+	coder := witten.Coder{Runs: true}
+	compressed, err := CompressBytes(data, WithDepth(16), WithCoder(coder))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if h, err := ReadHeader(bytes.NewReader(compressed)); err != nil || h.Coder != UnknownCoder {
+		t.Fatalf("%+v %v", h, err)
+	}
+	if decompressed, err := DecompressBytes(compressed, WithCoder(coder)); err != nil || !bytes.Equal(decompressed, data) {
+		t.Fatalf("%v %s", err, decompressed)
+	}
+}
+
+func TestCompressReader(t *testing.T) {
+	const depth = 16
+	data := []byte("four score and seven years ago")
+	buf := bytes.NewBuffer(nil)
+	if err := Compress(buf, bytes.NewReader(data), int64(len(data)), depth, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	decompressed := bytes.NewBuffer(nil)
+	if err := Decompress(decompressed, buf, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), data) {
+		t.Fatalf("%s", decompressed.Bytes())
+	}
+
+	// A reader shorter than the given size is an error.
+	if err := Compress(ioutil.Discard, bytes.NewReader(data), int64(len(data))+1, depth, nil); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// TestDecompressMaxNodes tests that streams whose context tree is limited to a few nodes are decompressed with the same limit.
+func TestDecompressMaxNodes(t *testing.T) {
+	const depth = 48
+	data, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	const maxNodes = 1000
+	buf := bytes.NewBuffer(nil)
+	if err := (Header{Version: FormatVersion, Depth: depth, MaxNodes: maxNodes, Size: int64(len(data))}).write(buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	pw := newPayloadWriter(buf)
+	bw := ac.NewBitWriter(pw)
+	model := NewCTW(make([]int, depth))
+	model.SetMaxNodes(maxNodes)
+	if _, err := defaultCoder.EncodeStats(context.Background(), bw, ac.NewBitReader(bytes.NewReader(data)), model); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := writeChecksum(buf, crc32.ChecksumIEEE(data)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if model.pool.size() != maxNodes {
+		t.Fatalf("%d", model.pool.size())
+	}
+
+	decompressed := bytes.NewBuffer(nil)
+	if err := Decompress(decompressed, buf, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), data) {
+		t.Fatalf("%s", decompressed.Bytes())
+	}
+}
+
+// TestChecksum tests that corrupting the encoded bits or the checksum of a stream is detected.
+func TestChecksum(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	compressed := bytes.NewBuffer(nil)
+	if err := Compress(compressed, bytes.NewReader(gettys), int64(len(gettys)), 16, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	written := bytes.NewBuffer(nil)
+	z := NewWriter(written, WithDepth(16))
+	if _, err := z.Write(gettys); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, stream := range [][]byte{compressed.Bytes(), written.Bytes()} {
+		// Corrupt encoded bits may also be detected by the decoder itself.
+		corrupt := append([]byte{}, stream...)
+		corrupt[headerSize+frameHeaderSize+100] ^= 0x10
+		if err := Decompress(ioutil.Discard, bytes.NewReader(corrupt), nil); err == nil {
+			t.Fatalf("expected error")
+		}
+		corrupt = append([]byte{}, stream...)
+		corrupt[len(corrupt)-1] ^= 0x10
+		if err := Decompress(ioutil.Discard, bytes.NewReader(corrupt), nil); err != ErrChecksum {
+			t.Fatalf("%v", err)
+		}
+		if err := Decompress(ioutil.Discard, bytes.NewReader(stream[:len(stream)-1]), nil); err == nil {
+			t.Fatalf("expected error")
+		}
+	}
+}
+
+// TestConcatenated tests that concatenated streams are decompressed one after another.
+func TestConcatenated(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Random data, whose encoded bytes span several chunks.
+	random := make([]byte, 3*payloadChunkSize/2)
+	rand.New(rand.NewSource(0)).Read(random)
+
+	var streams, expected []byte
+	for _, data := range [][]byte{gettys, random, nil, gettys} {
+		compressed, err := CompressBytes(data, WithDepth(16))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		streams = append(streams, compressed...)
+		expected = append(expected, data...)
+	}
+	written := bytes.NewBuffer(nil)
+	z := NewWriter(written, WithDepth(16))
+	if _, err := z.Write(gettys); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	streams = append(streams, written.Bytes()...)
+	expected = append(expected, gettys...)
+
+	decompressed := bytes.NewBuffer(nil)
+	if err := Decompress(decompressed, bytes.NewReader(streams), nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), expected) {
+		t.Fatalf("%d %d", decompressed.Len(), len(expected))
+	}
+	zr, err := NewReader(bytes.NewReader(streams))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	read, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(read, expected) {
+		t.Fatalf("%d %d", len(read), len(expected))
+	}
+
+	// Bytes after the last stream must start another stream.
+	if err := Decompress(ioutil.Discard, bytes.NewReader(append(streams, "garbage"...)), nil); err != ErrHeader {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestCompressByteModel(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := CompressWith(buf, bytes.NewReader(gettys), int64(len(gettys)), WithModel(ByteModel), WithDepth(16)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	h, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if h.Model != ByteModel {
+		t.Fatalf("%+v", h)
+	}
+	decompressed := bytes.NewBuffer(nil)
+	if err := Decompress(decompressed, buf, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), gettys) {
+		t.Fatalf("%s", decompressed.Bytes())
+	}
+
+	if _, err := CompressWith(ioutil.Discard, bytes.NewReader(gettys), int64(len(gettys)), WithModel(ByteModel), WithDepth(0)); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// TestCompressPrune tests that streams compressed with a pruned tree record the pruning in their headers and decompress correctly.
+func TestCompressPrune(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, model := range []ModelKind{BitModel, ByteModel} {
+		buf := bytes.NewBuffer(nil)
+		stats, err := CompressWith(buf, bytes.NewReader(gettys), int64(len(gettys)), WithModel(model), WithDepth(16), WithMaxNodes(2000), WithPrune(true))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if stats.PeakNodes > 2000 {
+			t.Fatalf("%d", stats.PeakNodes)
+		}
+		h, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !h.Prune {
+			t.Fatalf("%+v", h)
+		}
+		decompressed := bytes.NewBuffer(nil)
+		if err := Decompress(decompressed, buf, nil); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(decompressed.Bytes(), gettys) {
+			t.Fatalf("%s", decompressed.Bytes())
+		}
+	}
+
+	if _, err := CompressWith(ioutil.Discard, bytes.NewReader(gettys), int64(len(gettys)), WithPrune(true), WithMaxNodes(0)); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCompressTwoPass(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// A stream of two passes decompresses with the frozen model of its summary.
+	o := newOptions([]Option{WithDepth(16), WithTwoPass(4)})
+	buf := bytes.NewBuffer(nil)
+	if err := o.header(int64(len(gettys))).write(buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	model, err := o.writeTwoPass(buf, gettys)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := o.encode(buf, bytes.NewReader(gettys), int64(len(gettys)), model); err != nil {
+		t.Fatalf("%v", err)
+	}
+	h, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !h.TwoPass {
+		t.Fatalf("%+v", h)
+	}
+	decompressed := bytes.NewBuffer(nil)
+	if err := Decompress(decompressed, buf, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), gettys) {
+		t.Fatalf("%s", decompressed.Bytes())
+	}
+
+	if _, err := CompressWith(ioutil.Discard, bytes.NewReader(gettys), int64(len(gettys)), WithModel(ByteModel), WithTwoPass(4)); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := NewWriter(ioutil.Discard, WithTwoPass(4)).Write(gettys); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// TestCompressTwoPassOnline tests that compressing in two passes is never worse than compressing online, which is written instead when it is smaller.
+func TestCompressTwoPassOnline(t *testing.T) {
+	t.Parallel()
+	names, err := filepath.Glob("testdata/golden/*.*")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, name := range names {
+		if filepath.Ext(name) == ".ctw" || filepath.Ext(name) == ".json" {
+			continue
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, depth := range []int{0, 16, 48} {
+			online, err := CompressBytes(data, WithDepth(depth))
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			for _, minCount := range []int{1, 2, 8, 32, 128} {
+				compressed, err := CompressBytes(data, WithDepth(depth), WithTwoPass(minCount))
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+				if len(compressed) > len(online) {
+					t.Fatalf("%s depth %d min count %d: %d > %d", name, depth, minCount, len(compressed), len(online))
+				}
+				decompressed, err := DecompressBytes(compressed)
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+				if !bytes.Equal(decompressed, data) {
+					t.Fatalf("%s depth %d min count %d", name, depth, minCount)
+				}
+			}
+		}
+	}
+}
+
+func TestFileInfo(t *testing.T) {
+	t.Parallel()
+	fi, err := os.Stat("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := CompressWith(buf, bytes.NewReader(gettys), int64(len(gettys)), WithDepth(16), WithFileInfo(fi)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	h, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !h.Metadata || h.Name != "gettysburg.txt" || h.ModTime.Unix() != fi.ModTime().Unix() || h.Mode != fi.Mode().Perm() {
+		t.Fatalf("%+v", h)
+	}
+	decompressed := bytes.NewBuffer(nil)
+	if err := Decompress(decompressed, buf, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), gettys) {
+		t.Fatalf("%s", decompressed.Bytes())
+	}
+}
+
+func TestCompressBytes(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, data := range [][]byte{nil, gettys} {
+		compressed, err := CompressBytes(data, WithDepth(16))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		decompressed, err := DecompressBytes(compressed)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Fatalf("%s", decompressed)
+		}
+	}
+
+	// Streams of a Writer are also accepted, but trailing bytes that do not start another stream are not.
+	buf := bytes.NewBuffer(nil)
+	z := NewWriter(buf, WithDepth(16))
+	if _, err := z.Write(gettys); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	decompressed, err := DecompressBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed, gettys) {
+		t.Fatalf("%s", decompressed)
+	}
+	if _, err := DecompressBytes(append(buf.Bytes(), 0)); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestPrime(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	prime, data := gettys[:1000], gettys[1000:]
+	cold := bytes.NewBuffer(nil)
+	if err := Compress(cold, bytes.NewReader(data), int64(len(data)), DefaultDepth, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	primed := bytes.NewBuffer(nil)
+	if _, err := CompressWith(primed, bytes.NewReader(data), int64(len(data)), WithPrime(prime)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if primed.Len() >= cold.Len() {
+		t.Fatalf("%d %d", primed.Len(), cold.Len())
+	}
+
+	decompressed := bytes.NewBuffer(nil)
+	if err := DecompressWith(decompressed, bytes.NewReader(primed.Bytes()), WithPrime(prime)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), data) {
+		t.Fatalf("%s", decompressed.Bytes())
+	}
+
+	// The prime is required, and must be the same.
+	if err := Decompress(ioutil.Discard, bytes.NewReader(primed.Bytes()), nil); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := DecompressWith(ioutil.Discard, bytes.NewReader(primed.Bytes()), WithPrime(gettys[:999])); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -2,8 +2,8 @@
 // Also contained is an implementation of the Rissanen-Langdon Arithmetic Coding algorithm, which is combined with Context Tree Weighting to create a lossless compression/decompression utility.
 //
 // Below is an example of using this package to compress Lincoln's Gettysburg address:
-//    go run compress/main.go gettysburg.txt > gettys.ctw
-//    cat gettys.ctw | go run decompress/main.go > gettys.dctw
+//    go run ./cmd/ctw compress gettysburg.txt > gettys.ctw
+//    cat gettys.ctw | go run ./cmd/ctw decompress > gettys.dctw
 //    diff gettysburg.txt gettys.dctw
 //
 // Reference:
@@ -13,6 +13,8 @@ package ctw
 import (
 	"log"
 	"math"
+
+	"github.com/fumin/ctw/ac"
 )
 
 // logaddexp performs log(exp(x) + exp(y))
@@ -31,43 +33,54 @@ func logaddexp(x, y float64) float64 {
 
 // treeNode represents a suffix in a Context Tree Weighting.
 // It holds the log probability of the source sequence given the suffix represented by the node.
+//
+// Instead of computing the weighted probability of a node from the probabilities of its children,
+// we follow Section 4 of the EIDMA report and keep in each node the ratio beta between its KT estimate and the product of the weighted probabilities of its children.
+// The conditional probability of a new bit at a node can then be computed from beta, its KT estimate, and the conditional probability of its child on the context path alone.
+//
+// Nodes are stored contiguously in a nodePool and refer to their children by indices into the pool instead of pointers.
+// This makes nodes smaller, improves the locality of deep trees, and frees the garbage collector from scanning them.
 type treeNode struct {
 	LogProb float64 // log probability of suffix
 
-	a    uint32  // number of zeros with suffix
-	b    uint32  // number of ones with suffix
-	lktp float64 // log probability of the Krichevsky-Trofimov (KT) Estimation, given our current number of zeros and ones.
+	a       uint32  // number of zeros with suffix
+	b       uint32  // number of ones with suffix
+	lktp    float64 // log probability of the Krichevsky-Trofimov (KT) Estimation, given our current number of zeros and ones.
+	logBeta float64 // log of the ratio between the KT estimate and the product of the weighted probabilities of the children.
 
-	left  *treeNode // the sub-suffix that ends with one
-	right *treeNode // the sub-suffix that ends with zero
+	left  uint32 // the sub-suffix that ends with one
+	right uint32 // the sub-suffix that ends with zero
 }
 
 type snapshot struct {
-	node  *treeNode
+	node  uint32
 	state treeNode
 	isNew bool
 }
 
-func revert(traversed []snapshot) {
+// revert reverts the changes made by update, and recycles the nodes update created into pool.
+func revert(pool *nodePool, traversed []snapshot) {
 	for i, ss := range traversed {
-		node := ss.node
+		node := &pool.nodes[ss.node]
 		node.lktp = ss.state.lktp
 		node.a = ss.state.a
 		node.b = ss.state.b
+		node.logBeta = ss.state.logBeta
 		node.LogProb = ss.state.LogProb
 
-		// The memory releasing logic below saves memory.
-		// However, it might increase GC times if the released memory is added back again.
-		// This happens when our predictions are faily consistent with the eventually arriving data.
-		// Here we emphasize performance by not doing this memory saving optimization.
-		//
+		// Release the nodes created by update.
+		// Since the descendants of a new node are also new, all nodes after the first new one are released.
+		// The released nodes are recycled by pool, so that adding them back again does not take more memory.
 		if i < len(traversed)-1 {
 			next := traversed[i+1]
 			if next.isNew {
 				if next.node == node.right {
-					node.right = nil
+					node.right = nilNode
 				} else {
-					node.left = nil
+					node.left = nilNode
+				}
+				for _, released := range traversed[i+1:] {
+					pool.put(released.node)
 				}
 				break
 			}
@@ -76,103 +89,395 @@ func revert(traversed []snapshot) {
 }
 
 // update updates the tree according to the rules of CTW.
+// Pool is where the nodes of the tree are stored.
 // Root is the root of the context tree.
 // Bits is the last few bits of the sequence, len(bits) should be the depth of the tree.
 // Bit is the new bit following the sequence.
-func update(root *treeNode, bits []int, bit int) []snapshot {
+// SwitchRate is the rate at which each node switches between its KT estimate and the weighting of its children, see weigh for details.
+func update(pool *nodePool, root uint32, bits []int, bit int, switchRate float64) []snapshot {
 	if bit != 0 && bit != 1 {
 		log.Fatalf("wrong bit %d", bit)
 	}
 
 	// Update the counts of zeros and ones of each node.
-	traversed := []snapshot{}
+	// Note that allocating nodes may move the nodes of the pool, so we must not hold pointers to nodes across calls to pool.get.
+	traversed := make([]snapshot, 0, len(bits)+1)
 	node := root
-	traversed = append(traversed, snapshot{node: node, state: *node, isNew: false})
-	krichevskyTrofimov(node, bit)
+	traversed = append(traversed, snapshot{node: node, state: pool.nodes[node], isNew: false})
+	krichevskyTrofimov(&pool.nodes[node], bit)
 
+	// If the pool is full, the path ends at the deepest existing node.
 	for d := 0; d < len(bits); d++ {
 		isNew := false
 		if bits[len(bits)-1-d] == 0 {
-			if node.right == nil {
-				node.right = &treeNode{}
+			if pool.nodes[node].right == nilNode {
+				if !pool.available(1) {
+					break
+				}
+				child := pool.get()
+				pool.nodes[node].right = child
 				isNew = true
 			}
-			node = node.right
+			node = pool.nodes[node].right
 		} else {
-			if node.left == nil {
-				node.left = &treeNode{}
+			if pool.nodes[node].left == nilNode {
+				if !pool.available(1) {
+					break
+				}
+				child := pool.get()
+				pool.nodes[node].left = child
 				isNew = true
 			}
-			node = node.left
+			node = pool.nodes[node].left
 		}
 
-		traversed = append(traversed, snapshot{node: node, state: *node, isNew: isNew})
-		krichevskyTrofimov(node, bit)
+		traversed = append(traversed, snapshot{node: node, state: pool.nodes[node], isNew: isNew})
+		krichevskyTrofimov(&pool.nodes[node], bit)
 	}
 
-	// Update the actual node probabilities.
+	// Update the actual node probabilities, from the deepest node up to the root.
+	// The conditional probability of bit at the deepest node is its KT estimate,
+	// and that of the other nodes is the weighting of their KT estimates and the conditional probabilities of their children.
+	var pw float64
 	for i := len(traversed) - 1; i >= 0; i-- {
 		ss := traversed[i]
-		node := ss.node
-
-		if node.left != nil || node.right != nil {
-			var lp float64 = 0
-			if node.left != nil {
-				lp = node.left.LogProb
-			}
-			var rp float64 = 0
-			if node.right != nil {
-				rp = node.right.LogProb
-			}
-			w := 0.5
-			node.LogProb = logaddexp(math.Log(w)+node.lktp, math.Log(1-w)+lp+rp)
+		node := &pool.nodes[ss.node]
+		pe := ktProb(ss.state.a, ss.state.b, bit)
+		if i == len(traversed)-1 {
+			pw = pe
 		} else {
-			node.LogProb = node.lktp
+			pw, node.logBeta = weigh(node.logBeta, pe, pw, switchRate)
 		}
+		node.LogProb += math.Log(pw)
 	}
 
 	return traversed
 }
 
+// predict returns the conditional probability of bit at node, without modifying the tree.
+// The computation follows exactly that of update, so that it gives the same result as update would.
+// Node is at depth d of the tree, and may be nilNode if it does not exist yet.
+func predict(pool *nodePool, node uint32, bits []int, d int, bit int, switchRate float64) float64 {
+	return predictNew(pool, node, bits, d, bit, switchRate, 0)
+}
+
+// predictNew is like predict, given that update would have to create the allocs nodes above node on the context path.
+// Like update, it stops at the deepest node that the pool has room for.
+func predictNew(pool *nodePool, node uint32, bits []int, d int, bit int, switchRate float64, allocs int) float64 {
+	var n treeNode
+	if node != nilNode {
+		n = pool.nodes[node]
+	}
+	pe := ktProb(n.a, n.b, bit)
+	if d == len(bits) {
+		return pe
+	}
+
+	child := n.left
+	if bits[len(bits)-1-d] == 0 {
+		child = n.right
+	}
+	if child == nilNode {
+		if !pool.available(allocs + 1) {
+			return pe
+		}
+		allocs++
+	}
+	pc := predictNew(pool, child, bits, d+1, bit, switchRate, allocs)
+	pw, _ := weigh(n.logBeta, pe, pc, switchRate)
+	return pw
+}
+
+// maxLogBeta bounds the magnitude of the log of beta, to keep beta from overflowing.
+// The bound is large enough that clamping has no noticeable effect on the weighted probabilities.
+const maxLogBeta = 500
+
+// weigh returns the weighted conditional probability of a node, as well as the log of its updated beta,
+// given the log of its current beta, its conditional KT estimate pe, and the weighted conditional probability of its child on the context path pc.
+//
+// For the plain CTW where switchRate is zero, beta is the ratio between the KT estimate of the node and the product of the weighted probabilities of its children.
+// A non-zero switchRate allows each node to switch between trusting its own KT estimate and trusting its children as the data changes,
+// by moving a fraction switchRate of the posterior weight of each of the two hypotheses to the other after each bit.
+// This makes the model more adaptive to non-stationary data.
+func weigh(logBeta, pe, pc, switchRate float64) (float64, float64) {
+	beta := math.Exp(logBeta)
+	pw := (beta*pe + pc) / (beta + 1)
+
+	beta = beta * pe / pc
+	if switchRate > 0 {
+		beta = ((1-switchRate)*beta + switchRate) / ((1 - switchRate) + switchRate*beta)
+	}
+	logBeta = math.Log(beta)
+	if logBeta > maxLogBeta {
+		logBeta = maxLogBeta
+	} else if logBeta < -maxLogBeta {
+		logBeta = -maxLogBeta
+	}
+	return pw, logBeta
+}
+
+// maxCount is the maximum number of zeros or ones a node counts.
+// When either count reaches maxCount, both counts are halved to prevent them from overflowing.
+// Halving the counts preserves the ratio between zeros and ones, and hence the predictions of the node,
+// while making the node slightly more adaptive to recent data.
+const maxCount = 1 << 31
+
 // krichevskyTrofimov updates the Krichevsky-Trofimov estimate of a node given a new observed bit.
 func krichevskyTrofimov(node *treeNode, bit int) {
-	a := float64(node.a)
-	b := float64(node.b)
+	node.lktp = ktLogProb(node.lktp, node.a, node.b, bit)
 	if bit == 0 {
-		node.lktp = node.lktp + math.Log(a+0.5) - math.Log(a+b+1)
 		node.a += 1
 	} else {
-		node.lktp = node.lktp + math.Log(b+0.5) - math.Log(a+b+1)
 		node.b += 1
 	}
+
+	if node.a >= maxCount || node.b >= maxCount {
+		node.a = (node.a + 1) / 2
+		node.b = (node.b + 1) / 2
+	}
+}
+
+// ktProb returns the conditional probability of bit given by the Krichevsky-Trofimov estimate,
+// given the number of zeros a and ones b.
+func ktProb(a, b uint32, bit int) float64 {
+	n := float64(a) + float64(b)
+	if bit == 0 {
+		return (float64(a) + 0.5) / (n + 1)
+	}
+	return (float64(b) + 0.5) / (n + 1)
+}
+
+// ktLogProb returns the log probability of the Krichevsky-Trofimov estimate after observing bit,
+// given the current log probability lktp, and the number of zeros a and ones b.
+func ktLogProb(lktp float64, a, b uint32, bit int) float64 {
+	n := uint64(a) + uint64(b)
+	if bit == 0 {
+		return lktp + logHalf(a) - logOne(n)
+	}
+	return lktp + logHalf(b) - logOne(n)
+}
+
+// logTableSize is the number of counts for which the logarithms in the Krichevsky-Trofimov estimate are precomputed.
+// Most nodes in a deep context tree are visited only a few times, so small counts dominate the computation.
+const logTableSize = 1 << 12
+
+var logHalfTable, logOneTable = logTables()
+
+func logTables() ([]float64, []float64) {
+	half := make([]float64, logTableSize)
+	one := make([]float64, logTableSize)
+	for i := range half {
+		half[i] = math.Log(float64(i) + 0.5)
+		one[i] = math.Log(float64(i) + 1)
+	}
+	return half, one
+}
+
+// logHalf returns log(n+0.5).
+func logHalf(n uint32) float64 {
+	if n < logTableSize {
+		return logHalfTable[n]
+	}
+	return math.Log(float64(n) + 0.5)
+}
+
+// logOne returns log(n+1).
+func logOne(n uint64) float64 {
+	if n < logTableSize {
+		return logOneTable[n]
+	}
+	return math.Log(float64(n) + 1)
 }
 
 // A CTW is a Context Tree Weighting based probabilistic model for binary data.
 // CTW implements the arithmetic coding Model interface.
 type CTW struct {
-	bits []int
-	root *treeNode
+	bits       []int
+	pool       *nodePool
+	root       uint32
+	switchRate float64
+	epsilon    float64
+
+	// prune reports whether the tree is pruned when it reaches the maximum number of nodes, instead of being frozen.
+	prune bool
+
+	// frozen reports whether the tree no longer learns, in which case observing a bit only shifts the context.
+	frozen bool
+
+	// known is the number of bits at the end of bits that have actually been observed, the rest being padding.
+	// Only the known bits are used as the context, so that a model with a short prior context predicts with shallower contexts until it has seen enough bits.
+	known int
+}
+
+// DefaultEpsilon is the default minimum probability of a bit predicted by the models in this package.
+// Keeping probabilities away from 0 and 1 ensures that arithmetic coders can always encode the unexpected bit, and that log losses remain finite.
+const DefaultEpsilon = 1e-9
+
+// clamp returns prob0 clamped to the interval [epsilon, 1-epsilon].
+func clamp(prob0, epsilon float64) float64 {
+	if prob0 < epsilon {
+		return epsilon
+	}
+	if prob0 > 1-epsilon {
+		return 1 - epsilon
+	}
+	return prob0
 }
 
 // NewCTW returns a new CTW whose context tree's depth is len(bits).
 // The prior context of the tree is given by bits.
+// To start with a prior context shorter than the depth, use NewCTWDepth.
 func NewCTW(bits []int) *CTW {
+	return NewCTWDepth(len(bits), bits)
+}
+
+// NewCTWDepth returns a new CTW whose context tree's depth is depth, and whose prior context is given by bits.
+// The prior context may be shorter than depth, or even empty, in which case the model starts predicting immediately with the contexts it has,
+// and uses deeper contexts as more bits are observed.
+func NewCTWDepth(depth int, bits []int) *CTW {
 	model := &CTW{
-		bits: bits,
-		root: &treeNode{},
+		bits:    make([]int, depth),
+		pool:    &nodePool{},
+		epsilon: DefaultEpsilon,
 	}
+	model.setContext(bits)
+	model.root = model.pool.get()
 	return model
 }
 
+// SetMaxNodes limits the number of nodes of the context tree to n, which bounds the memory of the model regardless of the length of the sequence.
+// Once the limit is reached, contexts that are not yet in the tree are predicted by their longest suffixes that are.
+// A limit of zero, which is the default, means no limit.
+// Since the limit changes the predictions, a decoder must use the same limit as the encoder.
+func (model *CTW) SetMaxNodes(n int) {
+	if n < 0 {
+		log.Fatalf("wrong max nodes %d", n)
+	}
+	model.pool.limit = n
+}
+
+// SetPrune sets whether the context tree is pruned when it reaches the maximum number of nodes set by SetMaxNodes.
+// Pruning releases the nodes that have been visited the fewest times, leaving the tree half full, so that the model keeps learning new contexts instead of being frozen.
+// Since pruning invalidates the snapshots of a CTWReverter, the two must not be combined.
+func (model *CTW) SetPrune(prune bool) {
+	model.prune = prune
+}
+
+// Freeze stops the context tree from learning, so that Observe only shifts the context.
+// Contexts that are not in the tree are predicted by their longest suffixes that are, as when the limit of SetMaxNodes is reached.
+// A frozen model must not be used with a CTWReverter.
+func (model *CTW) Freeze() {
+	model.frozen = true
+	model.pool.limit = model.pool.size()
+}
+
+// Nodes returns the number of nodes of the context tree.
+func (model *CTW) Nodes() int {
+	return model.pool.size()
+}
+
+// PeakNodes returns the largest number of nodes the context tree has had, which may exceed Nodes if nodes were released by a CTWReverter.
+func (model *CTW) PeakNodes() int {
+	return model.pool.peak
+}
+
+// Release releases the memory of the context tree.
+// This allows the memory to be reclaimed even if references to the model linger, for example in a CTWReverter.
+// The model must not be used after Release.
+func (model *CTW) Release() {
+	model.pool.release()
+	model.root = nilNode
+}
+
+// SetSwitchRate sets the rate at which each node of the context tree switches between its own KT estimate and the weighting of its children.
+// A rate of zero, which is the default, gives the plain Context Tree Weighting.
+// Small positive rates, for example 1/n where n is the length of the sequence, make the model more adaptive to non-stationary data.
+func (model *CTW) SetSwitchRate(rate float64) {
+	if rate < 0 || rate >= 0.5 {
+		log.Fatalf("wrong switch rate %f", rate)
+	}
+	model.switchRate = rate
+}
+
+// SetEpsilon sets the minimum probability of either bit returned by Prob0, which defaults to DefaultEpsilon.
+// A zero epsilon disables clamping, in which case Prob0 may return 0 or 1 after long runs of identical bits.
+func (model *CTW) SetEpsilon(epsilon float64) {
+	if epsilon < 0 || epsilon >= 0.5 {
+		log.Fatalf("wrong epsilon %f", epsilon)
+	}
+	model.epsilon = epsilon
+}
+
+// Depth returns the depth of the context tree.
+func (model *CTW) Depth() int {
+	return len(model.bits)
+}
+
+// Context returns a copy of the current context, which are the last Depth bits observed by the model.
+// The most recent bit is the last element.
+// If fewer than Depth bits have been observed, the context is shorter than Depth.
+func (model *CTW) Context() []int {
+	bits := make([]int, model.known)
+	copy(bits, model.context())
+	return bits
+}
+
+// context returns the bits of the current context that have been observed.
+func (model *CTW) context() []int {
+	return model.bits[len(model.bits)-model.known:]
+}
+
+// SetContext replaces the current context of the model with bits, leaving the learned context tree intact.
+// This is useful for reusing a trained model on a new sequence, for example after a gap in the data.
+// The length of bits should be the depth of the tree.
+func (model *CTW) SetContext(bits []int) {
+	if len(bits) != len(model.bits) {
+		log.Fatalf("wrong context length %d, expected %d", len(bits), len(model.bits))
+	}
+	model.setContext(bits)
+}
+
+// setContext replaces the current context with bits, which may be shorter than the depth of the tree.
+func (model *CTW) setContext(bits []int) {
+	if len(bits) > len(model.bits) {
+		log.Fatalf("context %d longer than depth %d", len(bits), len(model.bits))
+	}
+	for _, b := range bits {
+		if b != 0 && b != 1 {
+			log.Fatalf("wrong bit %d", b)
+		}
+	}
+	copy(model.bits[len(model.bits)-len(bits):], bits)
+	model.known = len(bits)
+}
+
+// NewSequence returns a CTW that shares the context tree of model, but has its own context whose prior is given by bits.
+// This allows a single tree to be trained on several independent sequences, each with its own sliding context,
+// so that the end of one sequence is never taken as the context of another.
+// Like NewCTWDepth, bits may be shorter than the depth of the tree.
+// The returned CTW and model must not be used concurrently, and releasing either of them releases the shared tree.
+func (model *CTW) NewSequence(bits []int) *CTW {
+	seq := *model
+	seq.bits = make([]int, len(model.bits))
+	seq.setContext(bits)
+	return &seq
+}
+
 // Prob0 returns the probability that the next bit be zero.
 func (model *CTW) Prob0() float64 {
-	before := model.root.LogProb
-	traversal := update(model.root, model.bits, 0)
-	after := model.root.LogProb
+	prob0 := predict(model.pool, model.root, model.context(), 0, 0, model.switchRate)
+	return clamp(prob0, model.epsilon)
+}
 
-	revert(traversal)
+// Prob1 returns the probability that the next bit be one.
+func (model *CTW) Prob1() float64 {
+	return 1 - model.Prob0()
+}
 
-	return math.Exp(after - before)
+// Entropy returns the entropy in bits of the prediction for the next bit.
+// A low entropy means the model is confident about the next bit.
+func (model *CTW) Entropy() float64 {
+	return ac.BinaryEntropy(model.Prob0())
 }
 
 // Observe updates the context tree, given that the sequence is followed by bit.
@@ -181,12 +486,30 @@ func (model *CTW) Observe(bit int) {
 }
 
 func (model *CTW) observe(bit int) []snapshot {
-	traversal := update(model.root, model.bits, bit)
+	if model.frozen {
+		model.shift(bit)
+		return nil
+	}
+	traversal := update(model.pool, model.root, model.context(), bit, model.switchRate)
+	if model.prune && !model.pool.available(len(model.bits)+1) {
+		model.pool.prune([]uint32{model.root}, model.pool.limit/2)
+	}
+	model.shift(bit)
+	return traversal
+}
+
+// shift shifts bit into the context.
+func (model *CTW) shift(bit int) {
+	if len(model.bits) == 0 {
+		return
+	}
 	for i := 1; i < len(model.bits); i++ {
 		model.bits[i-1] = model.bits[i]
 	}
 	model.bits[len(model.bits)-1] = bit
-	return traversal
+	if model.known < len(model.bits) {
+		model.known++
+	}
 }
 
 // A CTWReverter is a CTW model that allows reverting to its previous state.
@@ -194,6 +517,7 @@ func (model *CTW) observe(bit int) []snapshot {
 type CTWReverter struct {
 	model      *CTW
 	bits       []int
+	knowns     []int
 	traversals [][]snapshot
 }
 
@@ -209,13 +533,14 @@ func (cr *CTWReverter) Prob0() float64 {
 
 func (cr *CTWReverter) Observe(bit int) {
 	cr.bits = append(cr.bits, cr.model.bits[0])
+	cr.knowns = append(cr.knowns, cr.model.known)
 	cr.traversals = append(cr.traversals, cr.model.observe(bit))
 }
 
 func (cr *CTWReverter) Unobserve() {
 	// Revert the tree.
 	tvIdx := len(cr.traversals) - 1
-	revert(cr.traversals[tvIdx])
+	revert(cr.model.pool, cr.traversals[tvIdx])
 	cr.traversals = cr.traversals[:tvIdx]
 
 	// Revert the context bits.
@@ -225,4 +550,6 @@ func (cr *CTWReverter) Unobserve() {
 	btIdx := len(cr.bits) - 1
 	cr.model.bits[0] = cr.bits[btIdx]
 	cr.bits = cr.bits[:btIdx]
+	cr.model.known = cr.knowns[btIdx]
+	cr.knowns = cr.knowns[:btIdx]
 }
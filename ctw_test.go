@@ -9,6 +9,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/fumin/ctw/ac"
 	"github.com/fumin/ctw/ac/witten"
 )
 
@@ -16,25 +17,26 @@ import (
 // http://cs.anu.edu.au/courses/COMP4620/2013/slides-ctw.pdf
 func TestSunehag(t *testing.T) {
 	t.Parallel()
-	root := &treeNode{}
+	pool := &nodePool{}
+	root := pool.get()
 	depth := 3
 	bits := []int{1, 1, 0}
 
 	source := []int{0, 1, 0, 0, 1, 1, 0}
 	for _, b := range source {
-		update(root, bits[len(bits)-depth:], b)
+		update(pool, root, bits[len(bits)-depth:], b, 0)
 		bits = append(bits, b)
 	}
-	if math.Abs(root.LogProb-math.Log(7.0/2048)) > 1e-8 {
-		t.Errorf("%f", root.LogProb)
+	if math.Abs(pool.nodes[root].LogProb-math.Log(7.0/2048)) > 1e-8 {
+		t.Errorf("%f", pool.nodes[root].LogProb)
 	}
 
 	b := 0
-	update(root, bits[len(bits)-depth:], b)
+	update(pool, root, bits[len(bits)-depth:], b, 0)
 	bits = append(bits, b)
-	if math.Abs(root.LogProb-math.Log(153.0/65536)) > 1e-8 {
-		log.Printf("%f", root.LogProb)
-		t.Errorf("%f", root.LogProb)
+	if math.Abs(pool.nodes[root].LogProb-math.Log(153.0/65536)) > 1e-8 {
+		log.Printf("%f", pool.nodes[root].LogProb)
+		t.Errorf("%f", pool.nodes[root].LogProb)
 	}
 }
 
@@ -42,36 +44,38 @@ func TestSunehag(t *testing.T) {
 // Complexity Reduction of the Context-Tree Weighting Algorithm: A Study for KPN Research, Technical University of Eindhoven, EIDMA Report RS.97.01
 func TestEIDMA(t *testing.T) {
 	t.Parallel()
-	root := &treeNode{}
+	pool := &nodePool{}
+	root := pool.get()
 	depth := 3
 	bits := []int{0, 1, 0}
 
 	source := []int{0, 1, 1, 0, 1, 0, 0}
 	for _, b := range source {
-		update(root, bits[len(bits)-depth:], b)
+		update(pool, root, bits[len(bits)-depth:], b, 0)
 		bits = append(bits, b)
 	}
-	if math.Abs(root.LogProb-math.Log(95.0/32768)) > 1e-8 {
-		t.Errorf("%f", root.LogProb)
+	if math.Abs(pool.nodes[root].LogProb-math.Log(95.0/32768)) > 1e-8 {
+		t.Errorf("%f", pool.nodes[root].LogProb)
 	}
 }
 
 // TestRevert tests that revert reverts the state of the tree to its original one.
 func TestRevert(t *testing.T) {
 	t.Parallel()
-	root := &treeNode{}
+	pool := &nodePool{}
+	root := pool.get()
 	depth := 3
 	bits := []int{0, 1, 0}
 
 	source := []int{0, 1, 1, 0, 1, 0, 0}
 	for _, b := range source {
-		traversal := update(root, bits[len(bits)-depth:], b)
-		seqP := root.LogProb
+		traversal := update(pool, root, bits[len(bits)-depth:], b, 0)
+		seqP := pool.nodes[root].LogProb
 
-		revert(traversal)
+		revert(pool, traversal)
 
-		update(root, bits[len(bits)-depth:], b)
-		seqPAfter := root.LogProb
+		update(pool, root, bits[len(bits)-depth:], b, 0)
+		seqPAfter := pool.nodes[root].LogProb
 
 		if seqP != seqPAfter {
 			t.Errorf("%f %f", seqP, seqPAfter)
@@ -81,6 +85,80 @@ func TestRevert(t *testing.T) {
 	}
 }
 
+// TestPredict tests that predict gives the same probability as actually updating the tree, also when the pool is full.
+func TestPredict(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		switchRate float64
+		limit      int
+	}{{0, 0}, {0.01, 0}, {0, 20}, {0.01, 20}} {
+		switchRate := tc.switchRate
+		pool := &nodePool{limit: tc.limit}
+		root := pool.get()
+		depth := 8
+		bits := make([]int, depth)
+
+		source := []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1, 1, 1, 0, 1, 0, 1, 1, 1, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0}
+		for _, b := range source {
+			for _, bit := range []int{0, 1} {
+				predicted := pool.nodes[root].LogProb + math.Log(predict(pool, root, bits[len(bits)-depth:], 0, bit, switchRate))
+				traversal := update(pool, root, bits[len(bits)-depth:], bit, switchRate)
+				if predicted != pool.nodes[root].LogProb {
+					t.Errorf("%f %f", predicted, pool.nodes[root].LogProb)
+				}
+				revert(pool, traversal)
+			}
+
+			update(pool, root, bits[len(bits)-depth:], b, switchRate)
+			bits = append(bits, b)
+		}
+		if tc.limit > 0 && pool.size() != tc.limit {
+			t.Errorf("%d %d", pool.size(), tc.limit)
+		}
+	}
+}
+
+// TestRescale tests that counts are halved before they overflow, and that predictions are kept intact.
+func TestRescale(t *testing.T) {
+	t.Parallel()
+	pool := &nodePool{}
+	root := pool.get()
+	pool.nodes[root].a = maxCount - 1
+	pool.nodes[root].b = maxCount / 3
+	bits := []int{}
+
+	prob0 := predict(pool, root, bits, 0, 0, 0)
+	update(pool, root, bits, 0, 0)
+	if pool.nodes[root].a != maxCount/2 || pool.nodes[root].b != (maxCount/3+1)/2 {
+		t.Fatalf("%d %d", pool.nodes[root].a, pool.nodes[root].b)
+	}
+	rescaled := predict(pool, root, bits, 0, 0, 0)
+	if math.Abs(prob0-rescaled) > 1e-6 {
+		t.Fatalf("%f %f", prob0, rescaled)
+	}
+
+	// Predictions should still agree with actual updates after rescaling.
+	for _, b := range []int{1, 0, 1, 1} {
+		predicted := pool.nodes[root].LogProb + math.Log(predict(pool, root, bits, 0, b, 0))
+		update(pool, root, bits, b, 0)
+		if predicted != pool.nodes[root].LogProb {
+			t.Fatalf("%f %f", predicted, pool.nodes[root].LogProb)
+		}
+	}
+}
+
+func TestLogTables(t *testing.T) {
+	t.Parallel()
+	for _, n := range []uint32{0, 1, 2, logTableSize - 1, logTableSize, logTableSize + 1, maxCount} {
+		if l := logHalf(n); l != math.Log(float64(n)+0.5) {
+			t.Errorf("%d %f", n, l)
+		}
+		if l := logOne(uint64(n)); l != math.Log(float64(n)+1) {
+			t.Errorf("%d %f", n, l)
+		}
+	}
+}
+
 func TestCTWReverter(t *testing.T) {
 	t.Parallel()
 	model := NewCTW(make([]int, 48))
@@ -115,6 +193,165 @@ func TestCTWReverter(t *testing.T) {
 	}
 }
 
+func TestContext(t *testing.T) {
+	t.Parallel()
+	model := NewCTW([]int{0, 1, 0})
+	if model.Depth() != 3 {
+		t.Fatalf("%d", model.Depth())
+	}
+	for _, b := range []int{1, 1, 0, 1} {
+		model.Observe(b)
+	}
+	ctx := model.Context()
+	expected := []int{1, 0, 1}
+	for i := range expected {
+		if ctx[i] != expected[i] {
+			t.Fatalf("%v %v", ctx, expected)
+		}
+	}
+
+	// Modifying the returned context should not affect the model.
+	ctx[0] = 0
+	if model.Context()[0] != 1 {
+		t.Fatalf("%v", model.Context())
+	}
+}
+
+func TestSetContext(t *testing.T) {
+	t.Parallel()
+	model := NewCTW(make([]int, 4))
+	for _, b := range []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1} {
+		model.Observe(b)
+	}
+	ctx := model.Context()
+	prob0 := model.Prob0()
+
+	model.SetContext([]int{0, 0, 0, 0})
+	if p := model.Prob0(); p == prob0 {
+		t.Fatalf("%f %f", p, prob0)
+	}
+
+	// Switching back to the original context should give the original prediction,
+	// since the context tree is left untouched.
+	model.SetContext(ctx)
+	if p := model.Prob0(); p != prob0 {
+		t.Fatalf("%f %f", p, prob0)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	t.Parallel()
+	model := NewCTW(make([]int, 4))
+	if model.Prob1() != 0.5 || model.Entropy() != 1 {
+		t.Fatalf("%f %f", model.Prob1(), model.Entropy())
+	}
+	for i := 0; i < 64; i++ {
+		model.Observe(1)
+	}
+	if model.Prob1() < 0.9 || model.Entropy() > 0.5 {
+		t.Fatalf("%f %f", model.Prob1(), model.Entropy())
+	}
+}
+
+func TestEpsilon(t *testing.T) {
+	t.Parallel()
+	model := NewCTW(make([]int, 2))
+	model.SetEpsilon(0.01)
+	for i := 0; i < 1000; i++ {
+		model.Observe(0)
+	}
+	if p := model.Prob0(); p != 0.99 {
+		t.Fatalf("%f", p)
+	}
+
+	model = NewCTW(make([]int, 2))
+	for i := 0; i < 1000; i++ {
+		model.Observe(1)
+	}
+	if p := model.Prob0(); p < DefaultEpsilon || p >= 0.01 {
+		t.Fatalf("%g", p)
+	}
+}
+
+func TestShortContext(t *testing.T) {
+	t.Parallel()
+	x := []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1, 1, 1, 0, 1}
+
+	// A model without prior context predicts the first bits with the contexts it has seen so far.
+	model := NewCTWDepth(4, nil)
+	shallow := NewCTW(nil)
+	if len(model.Context()) != 0 || model.Depth() != 4 {
+		t.Fatalf("%v %d", model.Context(), model.Depth())
+	}
+	for i, b := range x {
+		if i == 0 && model.Prob0() != shallow.Prob0() {
+			t.Fatalf("%f %f", model.Prob0(), shallow.Prob0())
+		}
+		model.Observe(b)
+		shallow.Observe(b)
+	}
+	if ctx := model.Context(); len(ctx) != 4 || ctx[2] != 0 || ctx[3] != 1 {
+		t.Fatalf("%v", ctx)
+	}
+
+	// Reverting a model restores the length of its context.
+	model = NewCTWDepth(4, []int{1})
+	prob0 := model.Prob0()
+	cr := NewCTWReverter(model)
+	for _, b := range x {
+		cr.Observe(b)
+	}
+	for range x {
+		cr.Unobserve()
+	}
+	if ctx := model.Context(); len(ctx) != 1 || ctx[0] != 1 || model.Prob0() != prob0 {
+		t.Fatalf("%v %f %f", ctx, model.Prob0(), prob0)
+	}
+}
+
+func TestNewSequence(t *testing.T) {
+	t.Parallel()
+	x := []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1, 1, 1, 0, 1}
+	y := []int{0, 0, 0, 1, 0, 0, 1, 0, 0, 0, 1}
+
+	// Interleaving the sequences gives the same tree as training on them one after another,
+	// since the contexts of one sequence never include bits from the other.
+	xPrior, yPrior := []int{0, 0, 0, 0}, []int{1, 1, 1, 1}
+	interleaved := NewCTWDepth(4, nil)
+	xs, ys := interleaved.NewSequence(xPrior), interleaved.NewSequence(yPrior)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		if i < len(x) {
+			xs.Observe(x[i])
+		}
+		if i < len(y) {
+			ys.Observe(y[i])
+		}
+	}
+
+	serial := NewCTW(append([]int{}, xPrior...))
+	for _, b := range x {
+		serial.Observe(b)
+	}
+	serial = serial.NewSequence(yPrior)
+	for _, b := range y {
+		serial.Observe(b)
+	}
+
+	lp, slp := interleaved.pool.nodes[interleaved.root].LogProb, serial.pool.nodes[serial.root].LogProb
+	if math.Abs(lp-slp) > 1e-9 {
+		t.Fatalf("%f %f", lp, slp)
+	}
+	if interleaved.pool.size() != serial.pool.size() {
+		t.Fatalf("%d %d", interleaved.pool.size(), serial.pool.size())
+	}
+	if ctx := xs.Context(); ctx[3] != x[len(x)-1] {
+		t.Fatalf("%v", ctx)
+	}
+	if len(interleaved.Context()) != 0 {
+		t.Fatalf("%v", interleaved.Context())
+	}
+}
+
 func TestEncode(t *testing.T) {
 	t.Parallel()
 	// Prepare data
@@ -123,12 +360,7 @@ func TestEncode(t *testing.T) {
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
-	x := []int{}
-	for _, bt := range contents {
-		for i := uint(0); i < 8; i++ {
-			x = append(x, int(bt)&(1<<i)>>i)
-		}
-	}
+	x := ac.Bits(contents)
 
 	// Encode
 	src := make(chan int)
@@ -190,6 +422,24 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+func BenchmarkProb0(b *testing.B) {
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	model := NewCTW(make([]int, 48))
+	for _, bt := range contents {
+		for i := uint(0); i < 8; i++ {
+			model.Observe(int(bt) & (1 << i) >> i)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		model.Prob0()
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
@@ -0,0 +1,60 @@
+package ctw
+
+import (
+	"math"
+	"sync"
+
+	"github.com/fumin/ctw/ac"
+)
+
+// DepthCandidates are the depths among which ChooseDepth and WithAutoDepth choose.
+var DepthCandidates = []int{8, 16, 24, 32, 48, 64}
+
+// DepthSampleSize is the number of bytes at the start of the data that WithAutoDepth samples to choose the depth.
+const DepthSampleSize = 1 << 15
+
+// ChooseDepth returns the depth among DepthCandidates whose model, configured by opts, gives the shortest code length of sample.
+// The code lengths are estimated from the probabilities predicted by the models, without actually coding sample, and the candidates are evaluated concurrently.
+// If several depths give the same code length, the first of them in DepthCandidates is chosen.
+func ChooseDepth(sample []byte, opts ...Option) int {
+	o := newOptions(opts)
+	bits := ac.Bits(sample)
+	lengths := make([]float64, len(DepthCandidates))
+	var wg sync.WaitGroup
+	for i, depth := range DepthCandidates {
+		wg.Add(1)
+		go func(i, depth int) {
+			defer wg.Done()
+			h := o.header(int64(len(sample)))
+			h.Depth = depth
+			model := newModel(h, o.prime)
+			defer model.Release()
+			lengths[i] = codeLength(model, bits)
+		}(i, depth)
+	}
+	wg.Wait()
+
+	best := 0
+	for i := range lengths {
+		if lengths[i] < lengths[best] {
+			best = i
+		}
+	}
+	return DepthCandidates[best]
+}
+
+// codeLength returns the number of bits that coding bits with model would take, ignoring the overhead of the arithmetic coder.
+// Model observes bits, and is hence updated by codeLength.
+func codeLength(model ac.Model, bits []int) float64 {
+	var length float64
+	for _, bit := range bits {
+		prob0 := model.Prob0()
+		if bit == 0 {
+			length -= math.Log2(prob0)
+		} else {
+			length -= math.Log2(1 - prob0)
+		}
+		model.Observe(bit)
+	}
+	return length
+}
@@ -0,0 +1,49 @@
+package ctw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestAutoDepth(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	depth := ChooseDepth(gettys)
+	if depth < 16 {
+		t.Fatalf("%d", depth)
+	}
+
+	// Both CompressWith and a Writer record the chosen depth in the header.
+	compressed := bytes.NewBuffer(nil)
+	if _, err := CompressWith(compressed, bytes.NewReader(gettys), int64(len(gettys)), WithAutoDepth()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	framed := bytes.NewBuffer(nil)
+	z := NewWriter(framed, WithAutoDepth())
+	if _, err := z.Write(gettys); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, buf := range []*bytes.Buffer{compressed, framed} {
+		h, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if h.Depth != depth {
+			t.Fatalf("%d %d", h.Depth, depth)
+		}
+		decompressed := bytes.NewBuffer(nil)
+		if err := Decompress(decompressed, buf, nil); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(decompressed.Bytes(), gettys) {
+			t.Fatalf("%s", decompressed.Bytes())
+		}
+	}
+}
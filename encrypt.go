@@ -0,0 +1,242 @@
+package ctw
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// encryptMagic is the magic number at the start of an encrypted stream.
+	encryptMagic = "CTWCRYPT"
+
+	// saltSize is the size of the random salt of the key derivation.
+	saltSize = 16
+
+	// encryptHeaderSize is the size of the magic number, the salt, and the number of iterations of the key derivation.
+	encryptHeaderSize = len(encryptMagic) + saltSize + 4
+
+	// chunkSize is the maximum number of plaintext bytes sealed in a chunk.
+	chunkSize = 1 << 16
+
+	// DefaultIterations is the number of iterations of PBKDF2 with which NewEncryptWriter derives the key from the passphrase.
+	DefaultIterations = 600000
+
+	// maxIterations bounds the number of iterations accepted by NewDecryptReader, so that a forged header cannot stall it.
+	maxIterations = 1 << 24
+)
+
+// ErrDecrypt is returned when an encrypted stream cannot be authenticated, because the passphrase is wrong or the stream was tampered with or truncated.
+var ErrDecrypt = fmt.Errorf("wrong passphrase or corrupt encrypted stream")
+
+// An EncryptWriter is an io.WriteCloser that encrypts the bytes written to it with AES-256-GCM, as produced by Compress or a Writer, so that they can be stored safely.
+// The key is derived from a passphrase by PBKDF2 with SHA-256 and a random salt.
+//
+// The format is the magic number "CTWCRYPT", the salt, and the number of iterations of PBKDF2 as a big endian uint32, followed by a sequence of chunks,
+// each of which is a byte that is one for the final chunk and zero otherwise, the size of the sealed chunk as a big endian uint32, and the sealed chunk.
+// Each chunk seals up to 64KiB of data, with a nonce made of its index and its final byte, and the header as additional data,
+// so that reordering, truncating, or altering the chunks is detected.
+type EncryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint64
+	closed bool
+	err    error
+}
+
+// NewEncryptWriter returns an EncryptWriter writing the encrypted data to w, with a key derived from passphrase in DefaultIterations iterations.
+// It is the caller's responsibility to call Close on the EncryptWriter when done.
+func NewEncryptWriter(w io.Writer, passphrase string) (*EncryptWriter, error) {
+	return newEncryptWriter(w, passphrase, DefaultIterations)
+}
+
+func newEncryptWriter(w io.Writer, passphrase string, iterations int) (*EncryptWriter, error) {
+	header := make([]byte, encryptHeaderSize)
+	copy(header, encryptMagic)
+	salt := header[len(encryptMagic) : len(encryptMagic)+saltSize]
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(header[len(encryptMagic)+saltSize:], uint32(iterations))
+	aead, err := newAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &EncryptWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+// Write encrypts p, buffering the bytes of the current chunk.
+func (e *EncryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	if e.closed {
+		return 0, fmt.Errorf("write to closed EncryptWriter")
+	}
+	var n int
+	for len(p) > 0 {
+		m := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		n += m
+		p = p[m:]
+		if len(e.buf) == cap(e.buf) {
+			if e.err = e.writeChunk(false); e.err != nil {
+				return n, e.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the final chunk, which holds the buffered bytes.
+// It does not close the underlying io.Writer.
+func (e *EncryptWriter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	e.err = e.writeChunk(true)
+	return e.err
+}
+
+// writeChunk seals the buffered bytes as a chunk and writes it to the underlying io.Writer.
+func (e *EncryptWriter) writeChunk(final bool) error {
+	flag := chunkFlag(final)
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.index, flag), e.buf, e.header)
+	e.index++
+	e.buf = e.buf[:0]
+
+	header := make([]byte, 1+4)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err := e.w.Write(header); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// A decryptReader is an io.Reader that decrypts the stream written by an EncryptWriter.
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint64
+	final  bool
+}
+
+// NewDecryptReader returns an io.Reader decrypting the stream written by an EncryptWriter, which is read from r, with a key derived from passphrase.
+// Read returns ErrDecrypt if a chunk cannot be authenticated, and never returns data that was not authenticated.
+func NewDecryptReader(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, encryptHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(header) {
+		return nil, fmt.Errorf("not an encrypted ctw stream")
+	}
+	iterations := binary.BigEndian.Uint32(header[len(encryptMagic)+saltSize:])
+	if iterations == 0 || iterations > maxIterations {
+		return nil, fmt.Errorf("iterations %d out of range [1, %d]", iterations, maxIterations)
+	}
+	aead, err := newAEAD(passphrase, header[len(encryptMagic):len(encryptMagic)+saltSize], int(iterations))
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead, header: header}, nil
+}
+
+// IsEncrypted reports whether p starts with the magic number of a stream written by an EncryptWriter.
+func IsEncrypted(p []byte) bool {
+	return bytes.HasPrefix(p, []byte(encryptMagic))
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.final {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// readChunk reads and opens the next chunk.
+func (d *decryptReader) readChunk() error {
+	header := make([]byte, 1+4)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	flag := header[0]
+	size := binary.BigEndian.Uint32(header[1:])
+	if flag > 1 || size > uint32(chunkSize+d.aead.Overhead()) {
+		return ErrDecrypt
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	buf, err := d.aead.Open(sealed[:0], chunkNonce(d.aead, d.index, flag), sealed, d.header)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.index++
+	d.buf = buf
+	d.final = flag == 1
+	return nil
+}
+
+// newAEAD returns the AES-256-GCM cipher keyed by passphrase.
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkFlag returns the flag byte of a chunk, which is one for the final chunk.
+func chunkFlag(final bool) byte {
+	if final {
+		return 1
+	}
+	return 0
+}
+
+// chunkNonce returns the nonce of the chunk of the given index and flag.
+// Since every stream has a key of its own, derived with a random salt, the nonces need only be unique within a stream.
+func chunkNonce(aead cipher.AEAD, index uint64, flag byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, index)
+	nonce[len(nonce)-1] = flag
+	return nonce
+}
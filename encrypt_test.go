@@ -0,0 +1,62 @@
+package ctw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	t.Parallel()
+	// Few iterations keep the test fast.
+	const iterations = 1000
+	data := bytes.Repeat([]byte("four score and seven years ago "), 5000)
+	buf := bytes.NewBuffer(nil)
+	e, err := newEncryptWriter(buf, "lincoln", iterations)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := e.Write(data); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	encrypted := buf.Bytes()
+	if !IsEncrypted(encrypted) || bytes.Contains(encrypted, []byte("four score")) {
+		t.Fatalf("%q", encrypted[:64])
+	}
+
+	r, err := NewDecryptReader(bytes.NewReader(encrypted), "lincoln")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	decrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatalf("%d %d", len(decrypted), len(data))
+	}
+
+	// A wrong passphrase, a tampered chunk, and a stream truncated at a chunk boundary are all rejected.
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)/2] ^= 1
+	truncated := encrypted[:encryptHeaderSize+1+4+chunkSize+16]
+	for i, tc := range []struct {
+		stream     []byte
+		passphrase string
+	}{
+		{encrypted, "douglas"},
+		{tampered, "lincoln"},
+		{truncated, "lincoln"},
+	} {
+		r, err := NewDecryptReader(bytes.NewReader(tc.stream), tc.passphrase)
+		if err != nil {
+			t.Fatalf("%d %v", i, err)
+		}
+		if _, err := ioutil.ReadAll(r); err == nil {
+			t.Fatalf("%d expected error", i)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ctw
+
+import (
+	"log"
+	"math"
+)
+
+// An Ensemble is a Bayesian mixture of CTW models of different depths.
+// Each model is weighted by its posterior probability, which is proportional to the probability it assigns to the observed sequence.
+// This frees users from choosing a single depth, as the ensemble quickly concentrates on the depths that best explain the data.
+// Ensemble implements the arithmetic coding Model interface.
+type Ensemble struct {
+	models []*CTW
+	logW   []float64 // log posterior weight of each model
+}
+
+// NewEnsemble returns a new Ensemble of CTW models whose depths are given by depths, with a uniform prior over the depths.
+// The prior context is given by bits, whose length should be at least the maximum depth.
+// Each model takes the last bits of the prior context as its own context.
+func NewEnsemble(depths []int, bits []int) *Ensemble {
+	e := &Ensemble{}
+	for _, d := range depths {
+		if d > len(bits) {
+			log.Fatalf("depth %d larger than prior context %d", d, len(bits))
+		}
+		context := make([]int, d)
+		copy(context, bits[len(bits)-d:])
+		e.models = append(e.models, NewCTW(context))
+		e.logW = append(e.logW, -math.Log(float64(len(depths))))
+	}
+	return e
+}
+
+// Prob0 returns the probability that the next bit be zero.
+func (e *Ensemble) Prob0() float64 {
+	var prob0 float64
+	for i, model := range e.models {
+		prob0 += math.Exp(e.logW[i]) * model.Prob0()
+	}
+	return prob0
+}
+
+// Observe updates the models and their weights, given that the sequence is followed by bit.
+func (e *Ensemble) Observe(bit int) {
+	// The probability a model assigns to bit is the change of the probability of the whole sequence at its root.
+	total := math.Inf(-1)
+	for i, model := range e.models {
+		before := model.pool.nodes[model.root].LogProb
+		model.Observe(bit)
+		e.logW[i] += model.pool.nodes[model.root].LogProb - before
+		total = logaddexp(total, e.logW[i])
+	}
+
+	// Normalize the weights to keep them from underflowing.
+	for i := range e.logW {
+		e.logW[i] -= total
+	}
+}
+
+// Weights returns the posterior weights of the models, in the same order as the depths passed to NewEnsemble.
+func (e *Ensemble) Weights() []float64 {
+	weights := make([]float64, len(e.logW))
+	for i, lw := range e.logW {
+		weights[i] = math.Exp(lw)
+	}
+	return weights
+}
+
+// Best returns the model with the largest posterior weight.
+func (e *Ensemble) Best() *CTW {
+	best := 0
+	for i := range e.logW {
+		if e.logW[i] > e.logW[best] {
+			best = i
+		}
+	}
+	return e.models[best]
+}
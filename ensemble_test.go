@@ -0,0 +1,50 @@
+package ctw
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEnsemble(t *testing.T) {
+	t.Parallel()
+	// A periodic sequence that can only be predicted with contexts longer than 2 bits.
+	pattern := []int{1, 1, 0, 1, 0, 0, 0}
+	ensemble := NewEnsemble([]int{1, 2, 8}, make([]int, 8))
+	for i := 0; i < 200; i++ {
+		ensemble.Observe(pattern[i%len(pattern)])
+	}
+
+	weights := ensemble.Weights()
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	if math.Abs(sum-1) > 1e-8 {
+		t.Fatalf("%f", sum)
+	}
+	if weights[2] < 0.99 {
+		t.Fatalf("%+v", weights)
+	}
+	if ensemble.Best().Depth() != 8 {
+		t.Fatalf("%d", ensemble.Best().Depth())
+	}
+
+	// The next bit of the pattern should be predicted with high confidence.
+	if next := pattern[200%len(pattern)]; next != 0 || ensemble.Prob0() < 0.9 {
+		t.Fatalf("%d %f", next, ensemble.Prob0())
+	}
+}
+
+func TestEnsembleSingle(t *testing.T) {
+	t.Parallel()
+	// An ensemble of a single model should behave exactly like the model itself.
+	ensemble := NewEnsemble([]int{4}, make([]int, 4))
+	model := NewCTW(make([]int, 4))
+	for _, b := range []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1, 1, 1, 0, 1, 0, 1} {
+		if math.Abs(ensemble.Prob0()-model.Prob0()) > 1e-12 {
+			t.Fatalf("%f %f", ensemble.Prob0(), model.Prob0())
+		}
+		ensemble.Observe(b)
+		model.Observe(b)
+	}
+}
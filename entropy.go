@@ -0,0 +1,46 @@
+package ctw
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/fumin/ctw/ac"
+)
+
+// CodeLength returns the number of bits that compressing the data read from r with opts would take, and the number of bytes read.
+// The code length is estimated from the probabilities predicted by the model, as in ChooseDepth, which is much cheaper than compressing the data,
+// and it ignores the Header and the overhead of the arithmetic coder, which are a few bytes.
+// The data need not fit in memory, and the depth is chosen from its first DepthSampleSize bytes if it is chosen automatically.
+// Two-pass compression is not supported, since its code length includes the summary of the model.
+func CodeLength(r io.Reader, opts ...Option) (float64, int64, error) {
+	o := newOptions(opts)
+	if err := o.check(); err != nil {
+		return 0, 0, err
+	}
+	if o.twoPass > 0 {
+		return 0, 0, fmt.Errorf("code length of two-pass compression")
+	}
+
+	var model treeModel
+	var length float64
+	var n int64
+	buf := make([]byte, DepthSampleSize)
+	for {
+		m, err := io.ReadFull(r, buf)
+		if m > 0 {
+			if model == nil {
+				o.chooseDepth(buf[:m])
+				model = newModel(o.header(framedSize), o.prime)
+				defer model.Release()
+			}
+			length += codeLength(model, ac.Bits(buf[:m]))
+			n += int64(m)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return length, n, nil
+		}
+		if err != nil {
+			return length, n, err
+		}
+	}
+}
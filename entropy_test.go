@@ -0,0 +1,45 @@
+package ctw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+func TestCodeLength(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, opts := range [][]Option{
+		{WithDepth(48)},
+		{WithModel(ByteModel), WithDepth(16)},
+		{WithAutoDepth()},
+	} {
+		length, n, err := CodeLength(bytes.NewReader(gettys), opts...)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if n != int64(len(gettys)) {
+			t.Fatalf("%d", n)
+		}
+		// The estimate should be within a few bytes of the encoded bits.
+		stats, err := CompressWith(ioutil.Discard, bytes.NewReader(gettys), int64(len(gettys)), opts...)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if math.Abs(length-float64(stats.EncodedBits)) > 64 {
+			t.Fatalf("%f %d", length, stats.EncodedBits)
+		}
+	}
+
+	length, n, err := CodeLength(bytes.NewReader(nil))
+	if err != nil || length != 0 || n != 0 {
+		t.Fatalf("%f %d %v", length, n, err)
+	}
+	if _, _, err := CodeLength(bytes.NewReader(gettys), WithTwoPass(1)); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,56 @@
+package ctw
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// A JSONNode is the JSON representation of a node of the context tree, as written by WriteJSON.
+type JSONNode struct {
+	// Zeros and Ones are the number of times a zero and a one were observed after the suffix of the node.
+	Zeros int `json:"zeros"`
+	Ones  int `json:"ones"`
+
+	// KTWeight is the posterior weight of the Krichevsky-Trofimov estimate of the node in its weighting, as in NodeInfo.
+	KTWeight float64 `json:"kt_weight"`
+
+	// LogProb is the log probability of the observed sequence given the suffix of the node.
+	LogProb float64 `json:"log_prob"`
+
+	// Zero and One are the children whose suffixes extend that of the node with a zero and a one further in the past.
+	// They are omitted if they have not been observed, or if they are deeper than the depth limit of WriteJSON.
+	Zero *JSONNode `json:"zero,omitempty"`
+	One  *JSONNode `json:"one,omitempty"`
+}
+
+// WriteJSON writes the context tree in indented JSON to w, for analysis and for diffing models between training runs.
+// Only nodes up to maxDepth are written, since deep trees are large and their deep nodes seldom interesting.
+// A negative maxDepth writes the whole tree.
+func (model *CTW) WriteJSON(w io.Writer, maxDepth int) error {
+	if maxDepth < 0 || maxDepth > len(model.bits) {
+		maxDepth = len(model.bits)
+	}
+	root := jsonNode(model.pool, model.root, 0, len(model.bits), maxDepth)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(root)
+}
+
+// jsonNode returns the JSON representation of the subtree rooted at node, which is at depth d of a tree of the given depth.
+func jsonNode(pool *nodePool, node uint32, d, depth, maxDepth int) *JSONNode {
+	if node == nilNode {
+		return nil
+	}
+	n := pool.nodes[node]
+	jn := &JSONNode{Zeros: int(n.a), Ones: int(n.b), KTWeight: 1, LogProb: n.LogProb}
+	if d < depth {
+		beta := math.Exp(n.logBeta)
+		jn.KTWeight = beta / (1 + beta)
+	}
+	if d < maxDepth {
+		jn.Zero = jsonNode(pool, n.right, d+1, depth, maxDepth)
+		jn.One = jsonNode(pool, n.left, d+1, depth, maxDepth)
+	}
+	return jn
+}
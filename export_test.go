@@ -0,0 +1,40 @@
+package ctw
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	t.Parallel()
+	model := NewCTW(make([]int, 4))
+	x := []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1, 1, 1, 0, 1}
+	for _, b := range x {
+		model.Observe(b)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := model.WriteJSON(buf, 2); err != nil {
+		t.Fatalf("%v", err)
+	}
+	root := &JSONNode{}
+	if err := json.Unmarshal(buf.Bytes(), root); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if root.Zeros+root.Ones != len(x) || root.LogProb != model.pool.nodes[model.root].LogProb {
+		t.Fatalf("%+v", root)
+	}
+	if root.Zero.Zeros+root.Zero.Ones+root.One.Zeros+root.One.Ones != len(x) {
+		t.Fatalf("%+v %+v", root.Zero, root.One)
+	}
+
+	// Nodes deeper than the depth limit are not written.
+	depth := 0
+	for n := root; n != nil; n = n.Zero {
+		depth++
+	}
+	if depth != 3 {
+		t.Fatalf("%d", depth)
+	}
+}
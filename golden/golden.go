@@ -0,0 +1,155 @@
+// Package golden checks the compatibility of compressed streams against a fixed set of test vectors.
+// Each vector is an input, a model configuration, and the expected output of Compress, so that changes to the coders or models that alter the encoded streams are detected.
+//
+// The vectors are listed in the file vectors.json of a directory, which also holds the inputs, and the expected output of each vector in the file named after it with the extension ".ctw".
+// Vectors of an earlier format version are frozen: their outputs, written by the release of that version, are never regenerated, but checked to still decompress to their inputs.
+package golden
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/fumin/ctw"
+)
+
+// A Vector is a test vector.
+type Vector struct {
+	// Name is the name of the vector, which is also the name of its expected output without the extension.
+	Name string `json:"name"`
+
+	// Input is the name of the input file.
+	Input string `json:"input"`
+
+	// Coder is the name of the coder, as accepted by ctw.NewCoder.
+	Coder string `json:"coder"`
+
+	// Depth is the depth of the Context Tree Weighting model.
+	Depth int `json:"depth"`
+
+	// Model is the kind of the Context Tree Weighting model, as accepted by ctw.ParseModel, or the bit model if empty.
+	Model string `json:"model,omitempty"`
+
+	// Version, if not zero, is the format version of the frozen expected output of the vector, which is earlier than ctw.FormatVersion.
+	Version int `json:"version,omitempty"`
+}
+
+// ReadVectors reads the vectors listed in the vectors.json of dir.
+func ReadVectors(dir string) ([]Vector, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "vectors.json"))
+	if err != nil {
+		return nil, err
+	}
+	var vectors []Vector
+	if err := json.Unmarshal(b, &vectors); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+// Generate compresses the input of v in dir, and checks that the result decompresses back to the input.
+func (v Vector) Generate(dir string) ([]byte, error) {
+	coder, err := ctw.NewCoder(v.Coder)
+	if err != nil {
+		return nil, err
+	}
+	model := ctw.BitModel
+	if v.Model != "" {
+		if model, err = ctw.ParseModel(v.Model); err != nil {
+			return nil, err
+		}
+	}
+	original, err := ioutil.ReadFile(filepath.Join(dir, v.Input))
+	if err != nil {
+		return nil, err
+	}
+	compressed := bytes.NewBuffer(nil)
+	if _, err := ctw.CompressWith(compressed, bytes.NewReader(original), int64(len(original)), ctw.WithDepth(v.Depth), ctw.WithModel(model), ctw.WithCoder(coder)); err != nil {
+		return nil, err
+	}
+	output := append([]byte{}, compressed.Bytes()...)
+
+	decompressed := bytes.NewBuffer(nil)
+	if err := ctw.Decompress(decompressed, compressed, coder); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(decompressed.Bytes(), original) {
+		return nil, fmt.Errorf("%s: decompressed %d bytes differ from the original %d bytes", v.Name, decompressed.Len(), len(original))
+	}
+	return output, nil
+}
+
+// Check decompresses the expected output of the frozen vector v in dir, and checks that it is of the format version of v, and that it decompresses to the input.
+func (v Vector) Check(dir string) error {
+	coder, err := ctw.NewCoder(v.Coder)
+	if err != nil {
+		return err
+	}
+	original, err := ioutil.ReadFile(filepath.Join(dir, v.Input))
+	if err != nil {
+		return err
+	}
+	expected, err := ioutil.ReadFile(filepath.Join(dir, v.Name+".ctw"))
+	if err != nil {
+		return err
+	}
+	h, err := ctw.ReadHeader(bytes.NewReader(expected))
+	if err != nil {
+		return err
+	}
+	if int(h.Version) != v.Version || h.Depth != v.Depth {
+		return fmt.Errorf("%s: header %+v, expected version %d and depth %d", v.Name, h, v.Version, v.Depth)
+	}
+	decompressed := bytes.NewBuffer(nil)
+	if err := ctw.Decompress(decompressed, bytes.NewReader(expected), coder); err != nil {
+		return fmt.Errorf("%s: %v", v.Name, err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), original) {
+		return fmt.Errorf("%s: decompressed %d bytes differ from the original %d bytes", v.Name, decompressed.Len(), len(original))
+	}
+	return nil
+}
+
+// Verify regenerates the output of each vector in dir, and returns an error if any of them differs from its expected output.
+// Frozen vectors are checked instead, by Check.
+// If update is true, the expected outputs are overwritten with the regenerated ones instead, except those of frozen vectors.
+func Verify(dir string, update bool) error {
+	vectors, err := ReadVectors(dir)
+	if err != nil {
+		return err
+	}
+	var mismatches []string
+	for _, v := range vectors {
+		if v.Version != 0 {
+			if err := v.Check(dir); err != nil {
+				return err
+			}
+			continue
+		}
+		output, err := v.Generate(dir)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, v.Name+".ctw")
+		if update {
+			if err := ioutil.WriteFile(name, output, 0644); err != nil {
+				return err
+			}
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(output, expected) {
+			mismatches = append(mismatches, v.Name)
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("outputs differ from the expected ones for %v", mismatches)
+	}
+	return nil
+}
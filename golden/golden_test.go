@@ -0,0 +1,11 @@
+package golden
+
+import (
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	if err := Verify("../testdata/golden", false); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package ctw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// headerMagic is the magic number at the start of the streams written by Compress.
+const headerMagic = "CTW\x00"
+
+// FormatVersion is the version of the format of the streams written by Compress.
+// Streams of version 1, whose header holds only the depth and the original size, and which are of the bit model, of an unlimited context tree, without a checksum, and not delimited, can still be decompressed.
+const FormatVersion = 2
+
+// ErrHeader is returned when reading a stream that does not start with a valid Header.
+var ErrHeader = fmt.Errorf("not a ctw compressed stream")
+
+// A Header is the header of a stream written by Compress, which describes how to decompress the stream.
+type Header struct {
+	// Version is the version of the format of the stream.
+	Version uint8
+
+	// Depth is the depth of the Context Tree Weighting model.
+	Depth int
+
+	// Model is the kind of the Context Tree Weighting model.
+	Model ModelKind
+
+	// Coder is the arithmetic coder of the stream, or UnknownCoder if the stream does not record it.
+	Coder CoderKind
+
+	// MaxNodes is the maximum number of nodes of the context tree, or zero for no limit.
+	MaxNodes int
+
+	// Prune reports whether the context tree is pruned when it reaches MaxNodes, instead of being frozen.
+	Prune bool
+
+	// TwoPass reports whether the data was coded with a frozen model trained on the whole data, whose summary follows the header.
+	TwoPass bool
+
+	// Primed reports whether the model was trained on a prime before coding the data, in which case the same prime is needed to decompress the stream.
+	Primed bool
+
+	// PrimeChecksum is the CRC-32 checksum of the prime, if the model was primed.
+	PrimeChecksum uint32
+
+	// Size is the number of bytes of the original data, or -1 for a stream written by a Writer, whose data is coded in frames.
+	Size int64
+
+	// Metadata reports whether the header records the Name, ModTime, and Mode of the original file.
+	Metadata bool
+
+	// Name is the base name of the original file.
+	Name string
+
+	// ModTime is the modification time of the original file, in whole seconds.
+	ModTime time.Time
+
+	// Mode holds the permission bits of the original file.
+	Mode os.FileMode
+}
+
+// headerSize is the size of the magic number, the version, the depth, the model, the coder, the maximum number of nodes, the flags, the checksum of the prime, and the original size.
+// If the header records the metadata of the original file, it is followed by the length of the name as a big endian uint16, the name,
+// the modification time in seconds since the Unix epoch as a big endian int64, and the permission bits as a big endian uint32.
+const headerSize = len(headerMagic) + 1 + 2 + 1 + 1 + 4 + 1 + 4 + 8
+
+// The flags of a header.
+const (
+	flagPrimed = 1 << iota
+	flagPrune
+	flagTwoPass
+	flagMetadata
+)
+
+// write writes the header to w.
+func (h Header) write(w io.Writer) error {
+	if h.Depth < 0 || h.Depth > 0xFFFF {
+		return fmt.Errorf("depth %d out of range [0, %d]", h.Depth, 0xFFFF)
+	}
+	if h.MaxNodes < 0 || h.MaxNodes > 0xFFFFFFFF {
+		return fmt.Errorf("max nodes %d out of range [0, %d]", h.MaxNodes, uint32(0xFFFFFFFF))
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, headerSize))
+	buf.WriteString(headerMagic)
+	buf.WriteByte(h.Version)
+	binary.Write(buf, binary.BigEndian, uint16(h.Depth))
+	buf.WriteByte(byte(h.Model))
+	buf.WriteByte(byte(h.Coder))
+	binary.Write(buf, binary.BigEndian, uint32(h.MaxNodes))
+	var flags byte
+	if h.Primed {
+		flags |= flagPrimed
+	}
+	if h.Prune {
+		flags |= flagPrune
+	}
+	if h.TwoPass {
+		flags |= flagTwoPass
+	}
+	if h.Metadata {
+		flags |= flagMetadata
+	}
+	buf.WriteByte(flags)
+	binary.Write(buf, binary.BigEndian, h.PrimeChecksum)
+	binary.Write(buf, binary.BigEndian, h.Size)
+	if h.Metadata {
+		if len(h.Name) > 0xFFFF {
+			return fmt.Errorf("name of length %d longer than %d", len(h.Name), 0xFFFF)
+		}
+		binary.Write(buf, binary.BigEndian, uint16(len(h.Name)))
+		buf.WriteString(h.Name)
+		binary.Write(buf, binary.BigEndian, h.ModTime.Unix())
+		binary.Write(buf, binary.BigEndian, uint32(h.Mode.Perm()))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadHeader reads the header of a stream written by Compress.
+// It returns an error if r does not start with a header, or if the header is of an unsupported version.
+func ReadHeader(r io.Reader) (Header, error) {
+	b := make([]byte, len(headerMagic)+1)
+	if err := readHeaderBytes(r, b); err != nil {
+		return Header{}, err
+	}
+	if string(b[:len(headerMagic)]) != headerMagic {
+		return Header{}, ErrHeader
+	}
+	h := Header{Version: b[len(headerMagic)]}
+	switch h.Version {
+	case 1:
+		b = make([]byte, 2+8)
+		if err := readHeaderBytes(r, b); err != nil {
+			return h, err
+		}
+		h.Depth = int(binary.BigEndian.Uint16(b))
+		h.Size = int64(binary.BigEndian.Uint64(b[2:]))
+	case FormatVersion:
+		b = make([]byte, headerSize-len(b))
+		if err := readHeaderBytes(r, b); err != nil {
+			return h, err
+		}
+		h.Depth = int(binary.BigEndian.Uint16(b))
+		h.Model = ModelKind(b[2])
+		h.Coder = CoderKind(b[3])
+		h.MaxNodes = int(binary.BigEndian.Uint32(b[4:]))
+		flags := b[8]
+		if flags&^(flagPrimed|flagPrune|flagTwoPass|flagMetadata) != 0 {
+			return h, fmt.Errorf("unknown flags %#x", flags)
+		}
+		h.Primed = flags&flagPrimed != 0
+		h.Prune = flags&flagPrune != 0
+		h.TwoPass = flags&flagTwoPass != 0
+		h.Metadata = flags&flagMetadata != 0
+		h.PrimeChecksum = binary.BigEndian.Uint32(b[9:])
+		h.Size = int64(binary.BigEndian.Uint64(b[13:]))
+		if h.Metadata {
+			if err := h.readMetadata(r); err != nil {
+				return h, err
+			}
+		}
+		if h.Model != BitModel && h.Model != ByteModel {
+			return h, fmt.Errorf("unknown model %d", h.Model)
+		}
+		if h.Model == ByteModel && h.Depth == 0 {
+			return h, fmt.Errorf("byte model of depth zero")
+		}
+		if h.Coder > GolombCoder {
+			return h, fmt.Errorf("unknown coder %d", h.Coder)
+		}
+	default:
+		return h, fmt.Errorf("unsupported format version %d", h.Version)
+	}
+	if h.Size < 0 && !(h.Version == FormatVersion && h.Size == framedSize) {
+		return h, fmt.Errorf("negative original size %d", h.Size)
+	}
+	return h, nil
+}
+
+// readMetadata reads the metadata of the original file, which follows the fixed part of the header.
+func (h *Header) readMetadata(r io.Reader) error {
+	b := make([]byte, 2)
+	if err := readHeaderBytes(r, b); err != nil {
+		return err
+	}
+	b = make([]byte, int(binary.BigEndian.Uint16(b))+8+4)
+	if err := readHeaderBytes(r, b); err != nil {
+		return err
+	}
+	n := len(b) - 8 - 4
+	h.Name = string(b[:n])
+	h.ModTime = time.Unix(int64(binary.BigEndian.Uint64(b[n:])), 0)
+	h.Mode = os.FileMode(binary.BigEndian.Uint32(b[n+8:])).Perm()
+	return nil
+}
+
+// checkPrime returns an error if prime is not the prime of the stream.
+func (h Header) checkPrime(prime []byte) error {
+	if !h.Primed {
+		if prime != nil {
+			return fmt.Errorf("prime given for a stream that is not primed")
+		}
+		return nil
+	}
+	if prime == nil {
+		return fmt.Errorf("stream is primed, but no prime is given")
+	}
+	if crc32.ChecksumIEEE(prime) != h.PrimeChecksum {
+		return fmt.Errorf("prime checksum mismatch")
+	}
+	return nil
+}
+
+// readHeaderBytes reads len(b) bytes of a header into b.
+func readHeaderBytes(r io.Reader, b []byte) error {
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated header")
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package ctw
+
+import (
+	"math"
+)
+
+// A NodeInfo describes a node of the context tree, and the prediction it makes for the next bit.
+type NodeInfo struct {
+	// Suffix is the suffix of the context the node represents, with the most recent bit last.
+	// The root represents the empty suffix.
+	Suffix []int
+
+	// Zeros and Ones are the number of times a zero and a one were observed after the suffix.
+	Zeros int
+	Ones  int
+
+	// KTProb0 is the probability that the next bit be zero, according to the Krichevsky-Trofimov estimate of the node alone.
+	KTProb0 float64
+
+	// Prob0 is the probability that the next bit be zero, according to the weighting of the node and its descendants on the context path.
+	// The Prob0 of the root is the prediction of the model.
+	Prob0 float64
+
+	// KTWeight is the posterior weight of the Krichevsky-Trofimov estimate of the node in its weighting.
+	// The remaining weight is given to the predictions of its descendants.
+	// A weight close to one means that the suffix of the node suffices to predict the next bit, and longer contexts are unnecessary.
+	KTWeight float64
+
+	// LogProb is the log probability of the observed sequence given the suffix.
+	LogProb float64
+}
+
+// ContextPath returns the nodes of the context tree on the path given by the current context, starting from the root.
+// It is the chain of suffixes of increasing length used to predict the next bit, and explains how the model arrives at its prediction.
+// The path ends early if the current context has not been fully observed before.
+func (model *CTW) ContextPath() []NodeInfo {
+	path := []NodeInfo{}
+	ctx := model.context()
+	node := model.root
+	for d := 0; node != nilNode; d++ {
+		n := model.pool.nodes[node]
+		info := NodeInfo{}
+		info.Suffix = append([]int{}, ctx[len(ctx)-d:]...)
+		info.Zeros = int(n.a)
+		info.Ones = int(n.b)
+		info.KTProb0 = ktProb(n.a, n.b, 0)
+		info.Prob0 = predict(model.pool, node, ctx, d, 0, model.switchRate)
+		info.KTWeight = 1
+		if d < len(ctx) {
+			beta := math.Exp(n.logBeta)
+			info.KTWeight = beta / (1 + beta)
+		}
+		info.LogProb = n.LogProb
+		path = append(path, info)
+
+		if d == len(ctx) {
+			break
+		}
+		if ctx[len(ctx)-1-d] == 0 {
+			node = n.right
+		} else {
+			node = n.left
+		}
+	}
+	return path
+}
@@ -0,0 +1,44 @@
+package ctw
+
+import (
+	"testing"
+)
+
+func TestContextPath(t *testing.T) {
+	t.Parallel()
+	model := NewCTW(make([]int, 4))
+	x := []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1, 1, 1, 0, 1}
+	for _, b := range x {
+		model.Observe(b)
+	}
+
+	path := model.ContextPath()
+	if len(path) != 5 {
+		t.Fatalf("%d", len(path))
+	}
+	if path[0].Zeros+path[0].Ones != len(x) || len(path[0].Suffix) != 0 {
+		t.Fatalf("%+v", path[0])
+	}
+	if path[0].Prob0 != model.Prob0() {
+		t.Fatalf("%f %f", path[0].Prob0, model.Prob0())
+	}
+
+	ctx := model.Context()
+	for d, info := range path {
+		if len(info.Suffix) != d {
+			t.Fatalf("%d %+v", d, info)
+		}
+		for i := range info.Suffix {
+			if info.Suffix[i] != ctx[len(ctx)-d+i] {
+				t.Fatalf("%d %+v %v", d, info, ctx)
+			}
+		}
+		// Deeper nodes are visited less often.
+		if d > 0 && info.Zeros+info.Ones > path[d-1].Zeros+path[d-1].Ones {
+			t.Fatalf("%d %+v %+v", d, info, path[d-1])
+		}
+	}
+	if leaf := path[len(path)-1]; leaf.Prob0 != leaf.KTProb0 || leaf.KTWeight != 1 {
+		t.Fatalf("%+v", leaf)
+	}
+}
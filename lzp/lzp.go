@@ -0,0 +1,174 @@
+// Package lzp implements a binary probabilistic model based on LZP, the Lempel-Ziv variant that predicts the next byte from the last occurrence of the current context.
+// The model operates on bytes decomposed into bits from the least significant bit to the most significant one, and implements the arithmetic coding Model interface.
+//
+// Reference:
+// C. Bloom, LZP: a new data compression algorithm, Proceedings of Data Compression Conference 1996.
+package lzp
+
+const (
+	// hashBits is the number of bits of the hash table indexed by contexts.
+	hashBits = 20
+
+	// windowBits is the number of bits of the size of the history, which is a ring buffer of the last bytes.
+	windowBits = 22
+
+	// maxMatch is the match length beyond which the confidence in the predicted byte is no longer tracked separately.
+	maxMatch = 32
+
+	// maxCount is the count at which a counter is halved, so that it adapts to changing statistics.
+	maxCount = 255
+)
+
+// A counter estimates the probability of zero from the number of zeros and ones it has seen.
+type counter struct {
+	n0 uint32
+	n1 uint32
+}
+
+func (c *counter) prob0() float64 {
+	return (float64(c.n0) + 0.5) / (float64(c.n0+c.n1) + 1)
+}
+
+func (c *counter) observe(bit int) {
+	if bit == 0 {
+		c.n0++
+	} else {
+		c.n1++
+	}
+	if c.n0+c.n1 > maxCount {
+		c.n0 /= 2
+		c.n1 /= 2
+	}
+}
+
+// A Model predicts the next byte to be the one following the last occurrence of the current context, which is the last order bytes.
+// The confidence in the predicted byte is learned as a function of the current match length, that is the number of consecutive correct predictions.
+// When there is no predicted byte or when the bits of the current byte deviate from it, the Model falls back to an order-0 bitwise model.
+type Model struct {
+	order int
+	// history holds the last bytes in a ring buffer, whose size is a power of two.
+	// Positions in history are masked by mask, so that matches older than the size of history predict whatever byte has overwritten them.
+	history []byte
+	mask    int
+	// n is the number of bytes observed.
+	n     int64
+	table []int32
+
+	// match is the position in history of the predicted byte, or -1 if there is none.
+	match    int
+	matchLen int
+
+	// pos is the position within the current byte of the next bit.
+	pos uint
+	// partial holds the bits of the current byte that have been observed.
+	partial int
+
+	// hit holds the probabilities that the predicted bit is correct, indexed by the match length.
+	hit [maxMatch + 1]counter
+	// literal holds the probabilities of the order-0 fallback, indexed by the position and the bits observed in the current byte.
+	literal [256]counter
+}
+
+// NewModel returns a new Model whose contexts are the last order bytes.
+func NewModel(order int) *Model {
+	return newModel(order, windowBits)
+}
+
+// newModel returns a new Model whose contexts are the last order bytes, and whose history holds the last 1<<window bytes.
+func newModel(order int, window uint) *Model {
+	model := &Model{}
+	model.order = order
+	model.history = make([]byte, 1<<window)
+	model.mask = len(model.history) - 1
+	model.table = make([]int32, 1<<hashBits)
+	for i := range model.table {
+		model.table[i] = -1
+	}
+	model.match = -1
+	return model
+}
+
+// predicted returns the bit predicted by the match, and whether the prediction is valid.
+func (model *Model) predicted() (int, bool) {
+	if model.match < 0 {
+		return 0, false
+	}
+	c := int(model.history[model.match])
+	mask := (1 << model.pos) - 1
+	if c&mask != model.partial {
+		return 0, false
+	}
+	return (c >> model.pos) & 1, true
+}
+
+// counter returns the counter for the next bit, and the bit whose probability the counter estimates as its zero.
+func (model *Model) counter() (*counter, int) {
+	bit, ok := model.predicted()
+	if !ok {
+		return &model.literal[(1<<model.pos)|model.partial], 0
+	}
+	ml := model.matchLen
+	if ml > maxMatch {
+		ml = maxMatch
+	}
+	return &model.hit[ml], bit
+}
+
+// Prob0 returns the probability that the next bit be zero.
+func (model *Model) Prob0() float64 {
+	c, zero := model.counter()
+	p := c.prob0()
+	if zero == 1 {
+		return 1 - p
+	}
+	return p
+}
+
+// Observe updates the model, given that the sequence is followed by bit.
+func (model *Model) Observe(bit int) {
+	c, zero := model.counter()
+	c.observe(bit ^ zero)
+
+	model.partial |= bit << model.pos
+	model.pos++
+	if model.pos < 8 {
+		return
+	}
+	model.observeByte(byte(model.partial))
+	model.pos = 0
+	model.partial = 0
+}
+
+func (model *Model) observeByte(c byte) {
+	if model.match >= 0 && model.history[model.match] == c {
+		model.matchLen++
+	} else {
+		model.matchLen = 0
+	}
+	model.history[int(model.n)&model.mask] = c
+	model.n++
+
+	model.match = -1
+	if model.n < int64(model.order) {
+		return
+	}
+	h := model.hash()
+	if prev := model.table[h]; prev >= 0 {
+		model.match = int(prev)
+	}
+	model.table[h] = int32(int(model.n) & model.mask)
+	if model.match < 0 {
+		model.matchLen = 0
+	}
+}
+
+// hash returns the hash of the last order bytes.
+func (model *Model) hash() uint32 {
+	// FNV-1a
+	var h uint32 = 2166136261
+	for i := model.n - int64(model.order); i < model.n; i++ {
+		h ^= uint32(model.history[int(i)&model.mask])
+		h *= 16777619
+	}
+	return h >> (32 - hashBits)
+}
@@ -0,0 +1,145 @@
+package lzp
+
+import (
+	"io/ioutil"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/ac/witten"
+)
+
+// TestRepetition tests that the model predicts well data that repeats itself.
+func TestRepetition(t *testing.T) {
+	contents, err := ioutil.ReadFile("../gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	x := ac.Bits(append(contents, contents...))
+
+	model := NewModel(8)
+	var firstHalf, secondHalf float64
+	for i, b := range x {
+		prob0 := model.Prob0()
+		if prob0 <= 0 || prob0 >= 1 {
+			t.Fatalf("%d %f", i, prob0)
+		}
+		p := prob0
+		if b == 1 {
+			p = 1 - prob0
+		}
+		if i < len(x)/2 {
+			firstHalf -= math.Log2(p)
+		} else {
+			secondHalf -= math.Log2(p)
+		}
+		model.Observe(b)
+	}
+	t.Logf("code length first half: %f, second half: %f", firstHalf, secondHalf)
+	if secondHalf > firstHalf/4 {
+		t.Fatalf("%f %f", firstHalf, secondHalf)
+	}
+}
+
+// TestWrap tests that the model keeps predicting after its history wraps around.
+func TestWrap(t *testing.T) {
+	contents, err := ioutil.ReadFile("../gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	phrase := contents[:200]
+	var data []byte
+	for len(data) < 8<<10 {
+		data = append(data, phrase...)
+	}
+
+	// With a history of 1 KiB, the phrase repeats within the history, so that the model predicts as one whose history never wraps.
+	small, large := newModel(8, 10), NewModel(8)
+	for i, b := range ac.Bits(data) {
+		if p, q := small.Prob0(), large.Prob0(); p != q {
+			t.Fatalf("%d %f %f", i, p, q)
+		}
+		small.Observe(b)
+		large.Observe(b)
+	}
+	if small.n != int64(len(data)) || small.n <= int64(len(small.history)) {
+		t.Fatalf("%d %d", small.n, len(small.history))
+	}
+
+	// Matches older than the history predict the bytes that have overwritten them, but the probabilities remain valid.
+	model := newModel(8, 8)
+	for i, b := range ac.Bits(append(contents, contents...)) {
+		if p := model.Prob0(); p <= 0 || p >= 1 {
+			t.Fatalf("%d %f", i, p)
+		}
+		model.Observe(b)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	contents, err := ioutil.ReadFile("../gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	x := ac.Bits(contents)
+
+	// Encode
+	src := make(chan int)
+	go func() {
+		for _, b := range x {
+			src <- b
+		}
+		close(src)
+	}()
+
+	encoded := []int{}
+	dst := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for b := range dst {
+			encoded = append(encoded, b)
+		}
+	}()
+
+	witten.Encode(dst, src, NewModel(4))
+	wg.Wait()
+	t.Logf("encoded bits: %d, original bits: %d", len(encoded), len(x))
+
+	// Decode
+	dsrc := make(chan int)
+	go func() {
+		for i := range encoded {
+			dsrc <- encoded[i]
+		}
+		close(dsrc)
+	}()
+
+	decoded := []int{}
+	ddst := make(chan int)
+	wg = sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for b := range ddst {
+			decoded = append(decoded, b)
+		}
+	}()
+
+	if err := witten.Decode(ddst, dsrc, NewModel(4), int64(len(x))); err != nil {
+		t.Fatalf("%v", err)
+	}
+	wg.Wait()
+
+	// Check that the decoded result is correct.
+	if len(x) != len(decoded) {
+		t.Fatalf("%d != %d", len(x), len(decoded))
+	}
+	for i, b := range x {
+		if decoded[i] != b {
+			t.Errorf("%d: %d != %d", i, b, decoded[i])
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package markov implements an adaptive order-N Markov model on binary data.
+// It serves as a baseline against which the gains of Context Tree Weighting can be measured:
+// a Markov model predicts with the full context of a fixed order, whereas CTW weights over all context lengths up to its depth.
+package markov
+
+import (
+	"log"
+)
+
+// A Model is an adaptive Markov model which predicts the next bit given the last Order bits.
+// The probability of a bit given a context is the Krichevsky-Trofimov estimate of the counts of zeros and ones seen in the context.
+// Model implements the arithmetic coding Model interface.
+type Model struct {
+	// context holds the last order bits, the most recent one being the least significant bit.
+	context uint64
+	order   uint
+	counts  map[uint64]*[2]uint32
+}
+
+// NewModel returns a new Model of order len(bits), which should be at most 64.
+// The prior context of the model is given by bits, with the most recent bit being the last element.
+func NewModel(bits []int) *Model {
+	if len(bits) > 64 {
+		log.Fatalf("order %d larger than 64", len(bits))
+	}
+	model := &Model{}
+	model.order = uint(len(bits))
+	model.counts = make(map[uint64]*[2]uint32)
+	for _, b := range bits {
+		model.shift(b)
+	}
+	return model
+}
+
+// Order returns the order of the model.
+func (model *Model) Order() int {
+	return int(model.order)
+}
+
+// Prob0 returns the probability that the next bit be zero.
+func (model *Model) Prob0() float64 {
+	c, ok := model.counts[model.context]
+	if !ok {
+		return 0.5
+	}
+	return (float64(c[0]) + 0.5) / (float64(c[0]) + float64(c[1]) + 1)
+}
+
+// Observe updates the model, given that the sequence is followed by bit.
+func (model *Model) Observe(bit int) {
+	if bit != 0 && bit != 1 {
+		log.Fatalf("wrong bit %d", bit)
+	}
+	c, ok := model.counts[model.context]
+	if !ok {
+		c = &[2]uint32{}
+		model.counts[model.context] = c
+	}
+	c[bit]++
+	model.shift(bit)
+}
+
+// shift appends bit to the context.
+func (model *Model) shift(bit int) {
+	if model.order == 0 {
+		return
+	}
+	model.context = (model.context << 1) | uint64(bit)
+	if model.order < 64 {
+		model.context &= (1 << model.order) - 1
+	}
+}
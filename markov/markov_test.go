@@ -0,0 +1,37 @@
+package markov
+
+import (
+	"math"
+	"testing"
+)
+
+func TestModel(t *testing.T) {
+	model := NewModel([]int{0, 1})
+	if model.Prob0() != 0.5 {
+		t.Fatalf("%f", model.Prob0())
+	}
+
+	// The context 01 is followed by 1, so it becomes 11, which is followed by 0.
+	model.Observe(1)
+	model.Observe(0)
+	model.Observe(1)
+	// The current context is 01, which was followed by a single one.
+	if p := model.Prob0(); math.Abs(p-0.25) > 1e-12 {
+		t.Fatalf("%f", p)
+	}
+	model.Observe(1)
+	// The current context is 11, which was followed by a single zero.
+	if p := model.Prob0(); math.Abs(p-0.75) > 1e-12 {
+		t.Fatalf("%f", p)
+	}
+}
+
+func TestOrder0(t *testing.T) {
+	model := NewModel(nil)
+	for _, b := range []int{0, 0, 1} {
+		model.Observe(b)
+	}
+	if p := model.Prob0(); math.Abs(p-2.5/4) > 1e-12 {
+		t.Fatalf("%f", p)
+	}
+}
@@ -0,0 +1,84 @@
+package ctw
+
+import (
+	"log"
+	"math"
+)
+
+// Merge adds the counts of the context tree of other to that of model, as if model had also observed the data other had observed.
+// This allows models to be trained on different partitions of the data in parallel, and then combined into one.
+//
+// Since both the Krichevsky-Trofimov estimates and their weightings depend only on the counts of zeros and ones at each node,
+// the merged model is the same as a model that observed both sequences, except for the contexts straddling the boundary between them.
+// The only exception is when counts are large enough to have been rescaled, in which case the merge is approximate.
+// Merge is exact only for models with a zero switch rate.
+//
+// The context of model is left unchanged, and other is not modified.
+// Both models should have the same depth.
+func (model *CTW) Merge(other *CTW) {
+	if len(model.bits) != len(other.bits) {
+		log.Fatalf("merging models of different depths %d %d", len(model.bits), len(other.bits))
+	}
+	merge(model.pool, model.root, other.pool, other.root)
+}
+
+// merge adds the counts of the subtree rooted at src in srcPool to the subtree rooted at dst in pool, and recomputes the probabilities of the affected nodes.
+func merge(pool *nodePool, dst uint32, srcPool *nodePool, src uint32) {
+	s := srcPool.nodes[src]
+
+	// Merge the children first, since the probabilities of a node depend on those of its children.
+	if s.left != nilNode {
+		if pool.nodes[dst].left == nilNode {
+			child := pool.get()
+			pool.nodes[dst].left = child
+		}
+		merge(pool, pool.nodes[dst].left, srcPool, s.left)
+	}
+	if s.right != nilNode {
+		if pool.nodes[dst].right == nilNode {
+			child := pool.get()
+			pool.nodes[dst].right = child
+		}
+		merge(pool, pool.nodes[dst].right, srcPool, s.right)
+	}
+
+	node := &pool.nodes[dst]
+	a := uint64(node.a) + uint64(s.a)
+	b := uint64(node.b) + uint64(s.b)
+	for a >= maxCount || b >= maxCount {
+		a = (a + 1) / 2
+		b = (b + 1) / 2
+	}
+	node.a = uint32(a)
+	node.b = uint32(b)
+	node.lktp = ktLogProbCounts(node.a, node.b)
+
+	if node.left == nilNode && node.right == nilNode {
+		node.LogProb = node.lktp
+		return
+	}
+	var children float64
+	if node.left != nilNode {
+		children += pool.nodes[node.left].LogProb
+	}
+	if node.right != nilNode {
+		children += pool.nodes[node.right].LogProb
+	}
+	w := 0.5
+	node.LogProb = logaddexp(math.Log(w)+node.lktp, math.Log(1-w)+children)
+	node.logBeta = node.lktp - children
+	if node.logBeta > maxLogBeta {
+		node.logBeta = maxLogBeta
+	} else if node.logBeta < -maxLogBeta {
+		node.logBeta = -maxLogBeta
+	}
+}
+
+// ktLogProbCounts returns the log probability the Krichevsky-Trofimov estimate assigns to any sequence with a zeros and b ones.
+func ktLogProbCounts(a, b uint32) float64 {
+	la, _ := math.Lgamma(float64(a) + 0.5)
+	lb, _ := math.Lgamma(float64(b) + 0.5)
+	lh, _ := math.Lgamma(0.5)
+	ln, _ := math.Lgamma(float64(a) + float64(b) + 1)
+	return la + lb - 2*lh - ln
+}
@@ -0,0 +1,49 @@
+package ctw
+
+import (
+	"math"
+	"testing"
+)
+
+// TestMerge tests that merging two models gives the same model as training a single model on both sequences.
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	x := []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1, 1, 1, 0, 1, 0, 1, 1, 1, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0}
+	y := []int{0, 1, 0, 0, 1, 1, 1, 0, 1, 0, 1, 1, 0, 0, 0, 1, 0, 1, 1, 0}
+	xPrior := []int{0, 1, 1, 0}
+	yPrior := []int{1, 0, 0, 1}
+
+	mx := NewCTW(append([]int{}, xPrior...))
+	for _, b := range x {
+		mx.Observe(b)
+	}
+	my := NewCTW(append([]int{}, yPrior...))
+	for _, b := range y {
+		my.Observe(b)
+	}
+	mx.Merge(my)
+
+	// Train a single model on x, and then on y with the prior context of y.
+	single := NewCTW(append([]int{}, xPrior...))
+	for _, b := range x {
+		single.Observe(b)
+	}
+	ctx := single.Context()
+	single.SetContext(yPrior)
+	for _, b := range y {
+		single.Observe(b)
+	}
+	single.SetContext(ctx)
+
+	root := single.pool.nodes[single.root]
+	merged := mx.pool.nodes[mx.root]
+	if merged.a != root.a || merged.b != root.b {
+		t.Fatalf("%+v %+v", merged, root)
+	}
+	if math.Abs(merged.LogProb-root.LogProb) > 1e-9 {
+		t.Fatalf("%f %f", merged.LogProb, root.LogProb)
+	}
+	if math.Abs(mx.Prob0()-single.Prob0()) > 1e-9 {
+		t.Fatalf("%f %f", mx.Prob0(), single.Prob0())
+	}
+}
@@ -0,0 +1,252 @@
+package ctw
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"unsafe"
+
+	"github.com/fumin/ctw/ac"
+)
+
+// DefaultDepth is the depth of the Context Tree Weighting model unless WithDepth is given.
+const DefaultDepth = 48
+
+// DefaultMaxNodes is the maximum number of nodes of the context tree unless WithMaxNodes is given.
+// It bounds the memory needed to compress and decompress to a few hundred megabytes, no matter how large the data.
+const DefaultMaxNodes = 1 << 22
+
+// A ModelKind is a kind of Context Tree Weighting model used to compress data.
+type ModelKind uint8
+
+const (
+	// BitModel is a single context tree over the bits of the data, as given by NewCTW.
+	// It suits binary data, whose structure need not follow byte boundaries.
+	BitModel ModelKind = 0
+
+	// ByteModel decomposes each byte into 8 bits, each predicted by a context tree of its own bit position, as given by NewByteCTW.
+	// It suits text, whose bits mean different things at different positions of a byte.
+	ByteModel ModelKind = 1
+)
+
+// ParseModel returns the ModelKind of the given name, which is either "bit" or "byte".
+func ParseModel(name string) (ModelKind, error) {
+	switch name {
+	case "bit":
+		return BitModel, nil
+	case "byte":
+		return ByteModel, nil
+	}
+	return 0, fmt.Errorf("unknown model %q", name)
+}
+
+func (k ModelKind) String() string {
+	switch k {
+	case BitModel:
+		return "bit"
+	case ByteModel:
+		return "byte"
+	}
+	return fmt.Sprintf("ModelKind(%d)", uint8(k))
+}
+
+// A treeModel is a model whose memory is in context trees.
+type treeModel interface {
+	ac.Model
+	PeakNodes() int
+	Release()
+	writeState(sw *stateWriter)
+}
+
+// newModel returns a new model as described by h, which is trained on prime.
+func newModel(h Header, prime []byte) treeModel {
+	var model treeModel
+	if h.Model == ByteModel {
+		m := NewByteCTW(make([]int, h.Depth))
+		m.SetMaxNodes(h.MaxNodes)
+		m.SetPrune(h.Prune)
+		model = m
+	} else {
+		m := NewCTW(make([]int, h.Depth))
+		m.SetMaxNodes(h.MaxNodes)
+		m.SetPrune(h.Prune)
+		model = m
+	}
+	for _, bit := range ac.Bits(prime) {
+		model.Observe(bit)
+	}
+	return model
+}
+
+// header returns the header of a stream of size bytes compressed with the options.
+func (o options) header(size int64) Header {
+	h := Header{Version: FormatVersion, Depth: o.depth, Model: o.model, MaxNodes: o.maxNodes, Prune: o.prune, TwoPass: o.twoPass > 0, Size: size}
+	h.Coder = coderKind(o.coder)
+	if o.info != nil {
+		h.Metadata = true
+		h.Name = o.info.Name()
+		h.ModTime = o.info.ModTime()
+		h.Mode = o.info.Mode().Perm()
+	}
+	if o.prime != nil {
+		h.Primed = true
+		h.PrimeChecksum = crc32.ChecksumIEEE(o.prime)
+	}
+	return h
+}
+
+// options are the options of compressing and decompressing.
+type options struct {
+	depth    int
+	model    ModelKind
+	maxNodes int
+	prune    bool
+	auto     bool
+	twoPass  int
+	info     os.FileInfo
+	coder    ac.Coder
+	prime    []byte
+
+	// explicitCoder reports whether the coder was given by WithCoder, rather than defaulting to the witten coder.
+	explicitCoder bool
+}
+
+// An Option configures CompressWith, a Writer, or a Reader.
+type Option func(*options)
+
+// WithDepth sets the depth of the Context Tree Weighting model, which defaults to DefaultDepth.
+func WithDepth(depth int) Option {
+	return func(o *options) { o.depth = depth }
+}
+
+// WithAutoDepth sets the depth of the Context Tree Weighting model to that chosen by ChooseDepth from the first DepthSampleSize bytes of the data,
+// overriding WithDepth.
+// A Writer samples its first frame, which is shorter if the Writer is flushed early.
+// Since the depth is recorded in the Header, decompressing needs no such option.
+func WithAutoDepth() Option {
+	return func(o *options) { o.auto = true }
+}
+
+// WithModel sets the kind of the Context Tree Weighting model, which defaults to BitModel.
+func WithModel(model ModelKind) Option {
+	return func(o *options) { o.model = model }
+}
+
+// WithMaxNodes sets the maximum number of nodes of the context tree, which defaults to DefaultMaxNodes.
+func WithMaxNodes(n int) Option {
+	return func(o *options) { o.maxNodes = n }
+}
+
+// WithPrune sets whether the context tree is pruned when it reaches the maximum number of nodes, instead of being frozen, as described in CTW.SetPrune.
+// Pruning costs time, but lets the model keep adapting to long data.
+func WithPrune(prune bool) Option {
+	return func(o *options) { o.prune = prune }
+}
+
+// MaxNodesForMemory returns the maximum number of nodes of a context tree that uses at most about mem bytes,
+// including the transient copy made while the tree grows.
+func MaxNodesForMemory(mem int64) int {
+	nodes := mem * 2 / 3 / int64(unsafe.Sizeof(treeNode{}))
+	if nodes < 1 {
+		return 1
+	}
+	if nodes > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return int(nodes)
+}
+
+// WithTwoPass makes CompressWith compress in two passes, first training the model over the whole data, and then coding the data with the model frozen.
+// The summary of the model written by WriteSummary with minCount is stored in the stream, so that a larger minCount trades the accuracy of the model for a smaller summary.
+// Since the model need not learn as it codes, this suits data compressed repeatedly, or data too uniform for online adaptation to pay off.
+// Since the data is also compressed online, and the smaller stream is written, two-pass compression never compresses worse, but takes more than twice as long.
+// Two-pass compression holds the whole data in memory, supports only the bit model without a prime, and is not supported by a Writer.
+func WithTwoPass(minCount int) Option {
+	return func(o *options) { o.twoPass = minCount }
+}
+
+// WithFileInfo records the base name, modification time, and permission bits of the original file described by info in the Header, as gzip does.
+func WithFileInfo(info os.FileInfo) Option {
+	return func(o *options) { o.info = info }
+}
+
+// WithPrime sets the prime, on which the model is trained before coding the data.
+// Since no code is emitted for the prime, a prime resembling the data makes short data compress much better, as the model does not start cold.
+// A stream compressed with a prime can only be decompressed with the same prime.
+func WithPrime(prime []byte) Option {
+	return func(o *options) { o.prime = prime }
+}
+
+// WithCoder sets the arithmetic coder, which defaults to the witten coder.
+// When decompressing, the coder defaults to the one recorded in the Header, and a coder other than the recorded one is an error.
+func WithCoder(coder ac.Coder) Option {
+	return func(o *options) { o.coder = coder }
+}
+
+func newOptions(opts []Option) options {
+	o := options{depth: DefaultDepth, maxNodes: DefaultMaxNodes}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.explicitCoder = o.coder != nil
+	if o.coder == nil {
+		o.coder = defaultCoder
+	}
+	return o
+}
+
+// decoder returns the coder decoding the stream described by h, which is the coder recorded in h if any, or else the coder of the options.
+// It returns an error if a coder given by WithCoder is not the one recorded in h.
+func (o options) decoder(h Header) (ac.Coder, error) {
+	if h.Coder == UnknownCoder {
+		return o.coder, nil
+	}
+	if o.explicitCoder {
+		if k := coderKind(o.coder); k != h.Coder {
+			return nil, fmt.Errorf("stream coded by the %v coder, but the %v coder is given", h.Coder, k)
+		}
+		return o.coder, nil
+	}
+	return NewCoder(h.Coder.String())
+}
+
+// chooseDepth sets the depth to that chosen by ChooseDepth from sample, if the depth is chosen automatically.
+func (o *options) chooseDepth(sample []byte) {
+	if !o.auto {
+		return
+	}
+	if len(sample) > DepthSampleSize {
+		sample = sample[:DepthSampleSize]
+	}
+	o.depth = ChooseDepth(sample, WithModel(o.model), WithMaxNodes(o.maxNodes), WithPrune(o.prune), WithPrime(o.prime))
+}
+
+// check returns an error if the options are invalid.
+func (o options) check() error {
+	if o.depth < 0 {
+		return fmt.Errorf("negative depth %d", o.depth)
+	}
+	if o.maxNodes < 0 {
+		return fmt.Errorf("negative max nodes %d", o.maxNodes)
+	}
+	if o.prune && o.maxNodes == 0 {
+		return fmt.Errorf("pruning without a maximum number of nodes")
+	}
+	if o.twoPass < 0 {
+		return fmt.Errorf("negative two-pass min count %d", o.twoPass)
+	}
+	if o.twoPass > 0 && (o.model != BitModel || o.prime != nil) {
+		return fmt.Errorf("two-pass compression with a %v model or a prime", o.model)
+	}
+	switch o.model {
+	case BitModel:
+	case ByteModel:
+		if o.depth == 0 && !o.auto {
+			return fmt.Errorf("byte model of depth zero")
+		}
+	default:
+		return fmt.Errorf("unknown model %d", o.model)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Package order0 implements an adaptive order-0 model of bytes, which predicts each byte by the frequencies of the bytes seen so far.
+// It is the classic baseline for arithmetic coders, and a sensible fallback model for data that has little context to exploit.
+// The model operates on bytes decomposed into bits from the least significant bit to the most significant one, and implements the arithmetic coding Model interface.
+package order0
+
+import (
+	"log"
+)
+
+const (
+	// increment is the amount by which the frequency of a byte is increased when it is observed.
+	// Increments larger than the initial frequency of one let the model move away from the uniform prior quickly.
+	increment = 32
+
+	// DefaultLimit is the default total frequency at which all frequencies are halved.
+	DefaultLimit = 1 << 16
+)
+
+// A Model predicts the next byte with probabilities proportional to the frequencies of the bytes seen so far.
+// When the total frequency exceeds its limit, all frequencies are halved, so that the model adapts to changing statistics and recent bytes weigh more than old ones.
+type Model struct {
+	freq  [256]uint32
+	total uint32
+	limit uint32
+
+	// tree holds the total frequency of the bytes that agree with each internal node of the binary decomposition of a byte, indexed by the next bit.
+	// The node of the bits partial of a byte at position pos is numbered (1<<pos)+partial-1.
+	tree [255][2]uint32
+
+	// pos is the position within the current byte of the next bit.
+	pos uint
+	// partial holds the bits of the current byte that have been observed.
+	partial int
+}
+
+// NewModel returns a new Model which halves its frequencies whenever their total exceeds limit.
+// If limit is zero, DefaultLimit is used.
+func NewModel(limit int) *Model {
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	if limit < 256*increment {
+		log.Fatalf("limit %d smaller than %d", limit, 256*increment)
+	}
+	model := &Model{limit: uint32(limit)}
+	for c := range model.freq {
+		model.freq[c] = 1
+	}
+	model.rebuild()
+	return model
+}
+
+// rebuild recomputes the tree and the total from the frequencies.
+func (model *Model) rebuild() {
+	model.tree = [255][2]uint32{}
+	model.total = 0
+	for c, f := range model.freq {
+		model.add(byte(c), f)
+	}
+}
+
+// add adds f to the frequency of the byte c in the tree.
+func (model *Model) add(c byte, f uint32) {
+	for pos := uint(0); pos < 8; pos++ {
+		partial := int(c) & ((1 << pos) - 1)
+		node := (1 << pos) + partial - 1
+		model.tree[node][(c>>pos)&1] += f
+	}
+	model.total += f
+}
+
+// Prob0 returns the probability that the next bit be zero.
+func (model *Model) Prob0() float64 {
+	n := model.tree[(1<<model.pos)+model.partial-1]
+	return float64(n[0]) / float64(n[0]+n[1])
+}
+
+// Observe updates the model, given that the sequence is followed by bit.
+func (model *Model) Observe(bit int) {
+	if bit != 0 && bit != 1 {
+		log.Fatalf("wrong bit %d", bit)
+	}
+	model.partial |= bit << model.pos
+	model.pos++
+	if model.pos < 8 {
+		return
+	}
+
+	// A byte is complete.
+	c := byte(model.partial)
+	model.pos = 0
+	model.partial = 0
+	model.freq[c] += increment
+	model.add(c, increment)
+	if model.total > model.limit {
+		for i := range model.freq {
+			model.freq[i] = (model.freq[i] + 1) / 2
+		}
+		model.rebuild()
+	}
+}
+
+// ObserveByte updates the model, given that the sequence is followed by the byte c.
+// ObserveByte should only be called at byte boundaries, that is when all bits of the previous byte have been observed.
+func (model *Model) ObserveByte(c byte) {
+	if model.pos != 0 {
+		log.Fatalf("ObserveByte at bit position %d", model.pos)
+	}
+	for i := uint(0); i < 8; i++ {
+		model.Observe(int(c>>i) & 1)
+	}
+}
+
+// Prob returns the probability of the byte c at the next byte boundary.
+func (model *Model) Prob(c byte) float64 {
+	return float64(model.freq[c]) / float64(model.total)
+}
@@ -0,0 +1,80 @@
+package order0
+
+import (
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/ac/witten"
+)
+
+func TestModel(t *testing.T) {
+	model := NewModel(0)
+	if p := model.Prob0(); p != 0.5 {
+		t.Fatalf("%f", p)
+	}
+
+	model.ObserveByte('a')
+	// The probability of a byte should be the product of the probabilities of its bits.
+	prob := 1.0
+	for i := uint(0); i < 8; i++ {
+		bit := int('a'>>i) & 1
+		prob0 := model.Prob0()
+		if bit == 0 {
+			prob *= prob0
+		} else {
+			prob *= 1 - prob0
+		}
+		model.Observe(bit)
+	}
+	expected := float64(1+increment) / float64(256+increment)
+	if math.Abs(prob-expected) > 1e-12 {
+		t.Fatalf("%f %f", prob, expected)
+	}
+	if p := model.Prob('a'); math.Abs(p-float64(1+2*increment)/float64(256+2*increment)) > 1e-12 {
+		t.Fatalf("%f", p)
+	}
+}
+
+func TestHalving(t *testing.T) {
+	const limit = 256 * increment
+	model := NewModel(limit)
+	for i := 0; i < 1000; i++ {
+		model.ObserveByte(byte(i % 3))
+		if model.total > limit {
+			t.Fatalf("%d %d", i, model.total)
+		}
+	}
+
+	// After the statistics change, the model should adapt to the new byte.
+	for i := 0; i < 200; i++ {
+		model.ObserveByte('z')
+	}
+	if p := model.Prob('z'); p < 0.5 {
+		t.Fatalf("%f", p)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	contents, err := ioutil.ReadFile("../gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	encoded := witten.EncodeBytes(contents, NewModel(0))
+	t.Logf("encoded bytes: %d, original bytes: %d", len(encoded), len(contents))
+	// English text takes roughly four and a half bits per character under an order-0 model.
+	if len(encoded) > len(contents)*5/8 {
+		t.Fatalf("%d %d", len(encoded), len(contents))
+	}
+
+	decoded, err := witten.DecodeBytes(encoded, NewModel(0), len(contents))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(decoded) != string(contents) {
+		t.Fatalf("%s", decoded)
+	}
+}
+
+var _ ac.Model = &Model{}
@@ -0,0 +1,107 @@
+package ctw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// payloadChunkSize is the maximum number of encoded bytes in a chunk of the payload of a stream of known size.
+const payloadChunkSize = 1 << 16
+
+// A payloadWriter writes the encoded bytes of a stream of known size in chunks, each preceded by its size as a big endian uint32, and ends them with a chunk of size zero.
+// This delimits the stream, whose end could otherwise not be found without decoding it, since decoders read ahead of the bits they need.
+type payloadWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newPayloadWriter(w io.Writer) *payloadWriter {
+	return &payloadWriter{w: w, buf: make([]byte, 0, payloadChunkSize)}
+}
+
+func (pw *payloadWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		m := copy(pw.buf[len(pw.buf):cap(pw.buf)], p)
+		pw.buf = pw.buf[:len(pw.buf)+m]
+		n += m
+		p = p[m:]
+		if len(pw.buf) == cap(pw.buf) {
+			if err := pw.writeChunk(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the buffered bytes and the final chunk of size zero.
+// It does not close the underlying io.Writer.
+func (pw *payloadWriter) Close() error {
+	if len(pw.buf) > 0 {
+		if err := pw.writeChunk(); err != nil {
+			return err
+		}
+	}
+	return pw.writeChunk()
+}
+
+// writeChunk writes the buffered bytes as a chunk.
+func (pw *payloadWriter) writeChunk() error {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(pw.buf)))
+	if _, err := pw.w.Write(size); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(pw.buf)
+	pw.buf = pw.buf[:0]
+	return err
+}
+
+// A payloadReader reads the encoded bytes written by a payloadWriter, returning io.EOF after the final chunk.
+type payloadReader struct {
+	r    io.Reader
+	left uint32
+	done bool
+}
+
+func (pr *payloadReader) Read(p []byte) (int, error) {
+	for pr.left == 0 {
+		if pr.done {
+			return 0, io.EOF
+		}
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(pr.r, size); err != nil {
+			if err == io.EOF {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		pr.left = binary.BigEndian.Uint32(size)
+		if pr.left > payloadChunkSize {
+			return 0, fmt.Errorf("chunk of %d bytes larger than %d", pr.left, payloadChunkSize)
+		}
+		pr.done = pr.left == 0
+	}
+	if uint32(len(p)) > pr.left {
+		p = p[:pr.left]
+	}
+	n, err := pr.r.Read(p)
+	pr.left -= uint32(n)
+	if err == io.EOF {
+		// The end of the chunk is not the end of the payload, which the final chunk marks.
+		err = nil
+		if pr.left > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+// skip skips the encoded bytes that were not read, up to the end of the final chunk.
+func (pr *payloadReader) skip() error {
+	_, err := io.Copy(ioutil.Discard, pr)
+	return err
+}
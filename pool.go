@@ -0,0 +1,116 @@
+package ctw
+
+// nilNode is the index of a non-existent node.
+// The first node of every pool is reserved, so that the zero value of a child index means the child does not exist.
+const nilNode uint32 = 0
+
+// A nodePool stores the treeNodes of context trees in a single contiguous slice, and recycles nodes that are no longer part of a tree.
+// Nodes are referred to by their indices in the slice.
+// Since treeNodes contain no pointers, the garbage collector need not scan the pool no matter how large the trees grow,
+// and recycling nodes avoids growing the pool when the same nodes are repeatedly added and reverted, as in the rollouts of a CTWReverter.
+type nodePool struct {
+	nodes []treeNode
+	free  []uint32
+
+	// limit is the maximum number of nodes in use, or zero for no limit.
+	limit int
+
+	// peak is the largest number of nodes that have been in use at once.
+	peak int
+}
+
+// get returns the index of a zeroed treeNode.
+// Since get may grow the pool, pointers to nodes in the pool are invalidated after calling get.
+func (p *nodePool) get() uint32 {
+	node := p.alloc()
+	if size := p.size(); size > p.peak {
+		p.peak = size
+	}
+	return node
+}
+
+func (p *nodePool) alloc() uint32 {
+	if n := len(p.free); n > 0 {
+		node := p.free[n-1]
+		p.free = p.free[:n-1]
+		p.nodes[node] = treeNode{}
+		return node
+	}
+
+	if len(p.nodes) == 0 {
+		p.nodes = append(p.nodes, treeNode{})
+	}
+	// Grow a limited pool no further than its limit, so that the memory of the pool is bounded by the limit.
+	if p.limit > 0 && len(p.nodes) == cap(p.nodes) && 2*cap(p.nodes) > p.limit+1 {
+		nodes := make([]treeNode, len(p.nodes), p.limit+1)
+		copy(nodes, p.nodes)
+		p.nodes = nodes
+	}
+	p.nodes = append(p.nodes, treeNode{})
+	return uint32(len(p.nodes) - 1)
+}
+
+// put recycles node, which should no longer be referenced by any tree.
+func (p *nodePool) put(node uint32) {
+	p.free = append(p.free, node)
+}
+
+// size returns the number of nodes in use.
+func (p *nodePool) size() int {
+	if len(p.nodes) == 0 {
+		return 0
+	}
+	return len(p.nodes) - 1 - len(p.free)
+}
+
+// available reports whether n more nodes can be allocated without exceeding the limit of the pool.
+func (p *nodePool) available(n int) bool {
+	return p.limit == 0 || p.size()+n <= p.limit
+}
+
+// prune releases the nodes of the trees at roots that have been visited the fewest times, until at most target nodes are in use.
+// Since a node is visited at least as often as its descendants, the nodes are pruned in passes that release every node visited fewer than a threshold number of times,
+// doubling the threshold in each pass.
+// The roots themselves are never released.
+func (p *nodePool) prune(roots []uint32, target int) {
+	for threshold := uint64(2); p.size() > target && threshold <= 2*maxCount; threshold *= 2 {
+		for _, root := range roots {
+			p.pruneChildren(root, threshold)
+		}
+	}
+}
+
+// pruneChildren releases the descendants of node that have been visited fewer than threshold times.
+func (p *nodePool) pruneChildren(node uint32, threshold uint64) {
+	for _, child := range []*uint32{&p.nodes[node].left, &p.nodes[node].right} {
+		if *child == nilNode {
+			continue
+		}
+		c := &p.nodes[*child]
+		if uint64(c.a)+uint64(c.b) < threshold {
+			p.putTree(*child)
+			*child = nilNode
+			continue
+		}
+		p.pruneChildren(*child, threshold)
+	}
+}
+
+// putTree recycles node and all of its descendants.
+func (p *nodePool) putTree(node uint32) {
+	n := p.nodes[node]
+	if n.left != nilNode {
+		p.putTree(n.left)
+	}
+	if n.right != nilNode {
+		p.putTree(n.right)
+	}
+	p.put(node)
+}
+
+// release releases the memory of the pool.
+// All nodes allocated by the pool must no longer be used after release.
+func (p *nodePool) release() {
+	p.nodes = nil
+	p.free = nil
+}
@@ -0,0 +1,89 @@
+package ctw
+
+import (
+	"math"
+	"testing"
+)
+
+// TestNodePoolRecycle tests that nodes released by revert are reused by subsequent updates.
+func TestNodePoolRecycle(t *testing.T) {
+	t.Parallel()
+	model := NewCTW(make([]int, 16))
+	for _, b := range []int{1, 1, 0, 1, 0, 0, 1, 1, 0, 1} {
+		model.Observe(b)
+	}
+	size := model.pool.size()
+
+	reverter := NewCTWReverter(model)
+	for i := 0; i < 100; i++ {
+		for _, b := range []int{0, 0, 1, 0, 1, 1, 1, 0} {
+			reverter.Observe(b)
+		}
+		for j := 0; j < 8; j++ {
+			reverter.Unobserve()
+		}
+	}
+
+	// Reverting should release all nodes added by the rollouts, and the pool should only grow in the first rollout.
+	if model.pool.size() != size {
+		t.Fatalf("%d %d", model.pool.size(), size)
+	}
+	allocated := len(model.pool.nodes)
+	for _, b := range []int{0, 0, 1, 0, 1, 1, 1, 0} {
+		reverter.Observe(b)
+	}
+	if len(model.pool.nodes) != allocated {
+		t.Fatalf("%d %d", len(model.pool.nodes), allocated)
+	}
+	if model.pool.size() == size {
+		t.Fatalf("%d %d", model.pool.size(), size)
+	}
+
+	// The peak counts the nodes of the rollouts, even though they are released.
+	for j := 0; j < 8; j++ {
+		reverter.Unobserve()
+	}
+	if model.Nodes() != size || model.PeakNodes() <= size {
+		t.Fatalf("%d %d %d", model.Nodes(), model.PeakNodes(), size)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	t.Parallel()
+	model := NewCTW(make([]int, 48))
+	for i := 0; i < 4096; i++ {
+		model.Observe(i % 3 % 2)
+	}
+	model.Release()
+	if model.pool.nodes != nil || model.root != nilNode {
+		t.Fatalf("%d %d", len(model.pool.nodes), model.root)
+	}
+}
+
+// TestPrune tests that pruning keeps the tree within its limit and its capacity, and that predictions remain consistent with updates.
+func TestPrune(t *testing.T) {
+	t.Parallel()
+	const maxNodes = 500
+	model := NewCTW(make([]int, 48))
+	model.SetMaxNodes(maxNodes)
+	model.SetPrune(true)
+	for i := 0; i < 20000; i++ {
+		bit := (i*i/7 + i/3) % 5 % 2
+		prob0 := model.Prob0()
+		before := model.pool.nodes[model.root].LogProb
+		model.Observe(bit)
+		p := prob0
+		if bit == 1 {
+			p = 1 - prob0
+		}
+		if got := model.pool.nodes[model.root].LogProb - before; math.Abs(got-math.Log(p)) > 1e-9 {
+			t.Fatalf("%d %f %f", i, got, math.Log(p))
+		}
+		if model.Nodes() > maxNodes {
+			t.Fatalf("%d %d", i, model.Nodes())
+		}
+	}
+	if cap(model.pool.nodes) > maxNodes+1 {
+		t.Fatalf("%d", cap(model.pool.nodes))
+	}
+}
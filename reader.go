@@ -0,0 +1,45 @@
+package ctw
+
+import (
+	"io"
+)
+
+// A Reader is an io.Reader that decompresses a stream written by Compress or a Writer, in the same way as gzip.Reader.
+// The data is decoded in a separate goroutine as it is read, so that memory does not grow with the size of the data.
+// As with Decompress, the data of concatenated streams is read one stream after another.
+type Reader struct {
+	// Header is the header of the first stream.
+	Header Header
+
+	pr *io.PipeReader
+}
+
+// NewReader returns a Reader decompressing the stream read from r.
+// Of the options, only WithCoder and WithPrime apply, since the rest are read from the Header of the stream.
+// NewReader returns ErrHeader if r does not start with a valid Header.
+// It is the caller's responsibility to call Close on the Reader when done.
+func NewReader(r io.Reader, opts ...Option) (*Reader, error) {
+	o := newOptions(opts)
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(decompressStreams(pw, r, h, o))
+	}()
+	return &Reader{Header: h, pr: pr}, nil
+}
+
+// Read reads up to len(p) decompressed bytes into p.
+// At the end of the data, Read returns io.EOF, and if the stream is corrupt, Read returns the error of decoding it.
+func (z *Reader) Read(p []byte) (int, error) {
+	return z.pr.Read(p)
+}
+
+// Close stops the decoding, after which Read returns an error.
+// It does not close the underlying io.Reader.
+func (z *Reader) Close() error {
+	return z.pr.Close()
+}
@@ -0,0 +1,84 @@
+package ctw
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	compressed := bytes.NewBuffer(nil)
+	if err := Compress(compressed, bytes.NewReader(gettys), int64(len(gettys)), 16, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	written := bytes.NewBuffer(nil)
+	z := NewWriter(written, WithDepth(16))
+	if _, err := z.Write(gettys); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, stream := range [][]byte{compressed.Bytes(), written.Bytes()} {
+		zr, err := NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if zr.Header.Depth != 16 {
+			t.Fatalf("%+v", zr.Header)
+		}
+		decompressed, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := zr.Close(); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(decompressed, gettys) {
+			t.Fatalf("%s", decompressed)
+		}
+
+		// A truncated stream should give an error rather than a silently short result.
+		zr, err = NewReader(bytes.NewReader(stream[:len(stream)/2]))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := ioutil.ReadAll(zr); err == nil {
+			t.Fatalf("expected error")
+		}
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("not compressed at all"))); err != ErrHeader {
+		t.Fatalf("%v", err)
+	}
+}
+
+// TestReaderClose tests that closing a Reader before reading all of the data stops the decoding.
+func TestReaderClose(t *testing.T) {
+	t.Parallel()
+	compressed := bytes.NewBuffer(nil)
+	if err := CompressFile(compressed, "gettysburg.txt", 16, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	zr, err := NewReader(compressed)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := io.ReadFull(zr, make([]byte, 10)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := zr.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := zr.Read(make([]byte, 10)); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,227 @@
+package ctw
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// treeNodeSize is the size of a treeNode in the state of a model.
+const treeNodeSize = 8 + 4 + 4 + 8 + 8 + 4 + 4
+
+// A stateWriter writes the state of a model, remembering the first error.
+type stateWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (sw *stateWriter) put(v interface{}) {
+	if sw.err == nil {
+		sw.err = binary.Write(sw.w, binary.BigEndian, v)
+	}
+}
+
+func (sw *stateWriter) putBits(bits []int) {
+	sw.put(uint32(len(bits)))
+	for _, b := range bits {
+		sw.put(uint8(b))
+	}
+}
+
+// A stateReader reads the state of a model written by a stateWriter, remembering the first error.
+type stateReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (sr *stateReader) get(v interface{}) {
+	if sr.err == nil {
+		sr.err = binary.Read(sr.r, binary.BigEndian, v)
+	}
+}
+
+func (sr *stateReader) getBits() []int {
+	var n uint32
+	sr.get(&n)
+	if sr.err == nil && n > maxSummaryDepth {
+		sr.err = fmt.Errorf("depth %d larger than %d", n, maxSummaryDepth)
+	}
+	if sr.err != nil {
+		return nil
+	}
+	bits := make([]int, n)
+	for i := range bits {
+		var b uint8
+		sr.get(&b)
+		if sr.err == nil && b > 1 {
+			sr.err = fmt.Errorf("wrong bit %d", b)
+		}
+		bits[i] = int(b)
+	}
+	return bits
+}
+
+// writeState writes the nodes of the pool, and its recycled nodes, limit, and peak.
+func (p *nodePool) writeState(sw *stateWriter) {
+	sw.put(uint64(len(p.nodes)))
+	b := make([]byte, treeNodeSize)
+	for _, n := range p.nodes {
+		binary.BigEndian.PutUint64(b, math.Float64bits(n.LogProb))
+		binary.BigEndian.PutUint32(b[8:], n.a)
+		binary.BigEndian.PutUint32(b[12:], n.b)
+		binary.BigEndian.PutUint64(b[16:], math.Float64bits(n.lktp))
+		binary.BigEndian.PutUint64(b[24:], math.Float64bits(n.logBeta))
+		binary.BigEndian.PutUint32(b[32:], n.left)
+		binary.BigEndian.PutUint32(b[36:], n.right)
+		if sw.err == nil {
+			_, sw.err = sw.w.Write(b)
+		}
+	}
+	sw.put(uint64(len(p.free)))
+	sw.put(p.free)
+	sw.put(uint64(p.limit))
+	sw.put(uint64(p.peak))
+}
+
+// readState reads the pool written by writeState.
+func (p *nodePool) readState(sr *stateReader) {
+	var n uint64
+	sr.get(&n)
+	if sr.err == nil && n > math.MaxUint32 {
+		sr.err = fmt.Errorf("%d nodes", n)
+	}
+	if sr.err != nil {
+		return
+	}
+	p.nodes = make([]treeNode, n)
+	b := make([]byte, treeNodeSize)
+	for i := range p.nodes {
+		if _, err := io.ReadFull(sr.r, b); err != nil {
+			sr.err = err
+			return
+		}
+		node := treeNode{
+			LogProb: math.Float64frombits(binary.BigEndian.Uint64(b)),
+			a:       binary.BigEndian.Uint32(b[8:]),
+			b:       binary.BigEndian.Uint32(b[12:]),
+			lktp:    math.Float64frombits(binary.BigEndian.Uint64(b[16:])),
+			logBeta: math.Float64frombits(binary.BigEndian.Uint64(b[24:])),
+			left:    binary.BigEndian.Uint32(b[32:]),
+			right:   binary.BigEndian.Uint32(b[36:]),
+		}
+		if uint64(node.left) >= n || uint64(node.right) >= n {
+			sr.err = fmt.Errorf("child of node %d out of range", i)
+			return
+		}
+		p.nodes[i] = node
+	}
+
+	var free, limit, peak uint64
+	sr.get(&free)
+	if sr.err == nil && free > n {
+		sr.err = fmt.Errorf("%d free nodes of %d", free, n)
+	}
+	if sr.err != nil {
+		return
+	}
+	p.free = make([]uint32, free)
+	sr.get(p.free)
+	for _, node := range p.free {
+		if sr.err == nil && (node == nilNode || uint64(node) >= n) {
+			sr.err = fmt.Errorf("free node %d out of range", node)
+		}
+	}
+	sr.get(&limit)
+	sr.get(&peak)
+	p.limit, p.peak = int(limit), int(peak)
+}
+
+// checkNode returns an error if node is not a node of the pool.
+func (p *nodePool) checkNode(node uint32) error {
+	if node == nilNode || int(node) >= len(p.nodes) {
+		return fmt.Errorf("node %d out of range", node)
+	}
+	return nil
+}
+
+// writeState writes the state of the model, from which readCTWState restores it.
+func (model *CTW) writeState(sw *stateWriter) {
+	sw.putBits(model.bits)
+	sw.put(uint32(model.known))
+	sw.put(model.root)
+	sw.put(model.switchRate)
+	sw.put(model.epsilon)
+	sw.put(model.prune)
+	sw.put(model.frozen)
+	model.pool.writeState(sw)
+}
+
+// readCTWState reads the state of a CTW written by writeState.
+func readCTWState(sr *stateReader) (*CTW, error) {
+	model := &CTW{pool: &nodePool{}}
+	model.bits = sr.getBits()
+	var known uint32
+	sr.get(&known)
+	sr.get(&model.root)
+	sr.get(&model.switchRate)
+	sr.get(&model.epsilon)
+	sr.get(&model.prune)
+	sr.get(&model.frozen)
+	model.pool.readState(sr)
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if int(known) > len(model.bits) {
+		return nil, fmt.Errorf("known context %d longer than depth %d", known, len(model.bits))
+	}
+	model.known = int(known)
+	if err := model.pool.checkNode(model.root); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// writeState writes the state of the model, from which readByteCTWState restores it.
+func (model *ByteCTW) writeState(sw *stateWriter) {
+	sw.putBits(model.bits)
+	sw.put(model.roots)
+	sw.put(uint8(model.pos))
+	sw.put(uint8(model.partial))
+	sw.put(model.prune)
+	model.pool.writeState(sw)
+}
+
+// readByteCTWState reads the state of a ByteCTW written by writeState.
+func readByteCTWState(sr *stateReader) (*ByteCTW, error) {
+	model := &ByteCTW{pool: &nodePool{}}
+	model.bits = sr.getBits()
+	var pos, partial uint8
+	sr.get(&model.roots)
+	sr.get(&pos)
+	sr.get(&partial)
+	sr.get(&model.prune)
+	model.pool.readState(sr)
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if pos >= 8 {
+		return nil, fmt.Errorf("bit position %d", pos)
+	}
+	model.pos, model.partial = uint(pos), int(partial)
+	for _, root := range model.roots {
+		if err := model.pool.checkNode(root); err != nil {
+			return nil, err
+		}
+	}
+	return model, nil
+}
+
+// readModelState reads the state of a model of the given kind.
+func readModelState(sr *stateReader, kind ModelKind) (treeModel, error) {
+	if kind == ByteModel {
+		return readByteCTWState(sr)
+	}
+	return readCTWState(sr)
+}
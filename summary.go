@@ -0,0 +1,604 @@
+package ctw
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/bits"
+
+	"github.com/fumin/ctw/ac"
+	"github.com/fumin/ctw/ac/witten"
+)
+
+// maxSummaryDepth is the maximum depth of a summarized model, which is that of a Header.
+const maxSummaryDepth = 1<<16 - 1
+
+// WriteSummary writes a compact summary of the context tree to w, from which ReadSummary reconstructs the model.
+// The children of a node are kept only if predicting with their counts instead of those of the node saves more than describing their counts costs,
+// and if each of them that is not empty was visited at least minCount times, so that a larger minCount gives a smaller summary of a coarser model.
+// The root is always kept.
+//
+// The format is the depth of the tree and the number of bytes of the coded nodes as uvarints, followed by the coded nodes,
+// which are arithmetic coded in preorder by the adaptive models of a summaryModel.
+// The counts of the root are coded as Elias gamma codes, and each node is coded as whether its children are kept, followed by the counts of the children if they are.
+// The counts of the child extending the suffix with a zero are coded given the counts of its parent, which bound them,
+// and the counts of the other child are coded as whether they are the remainder of those of the parent, as they are unless the tree has been pruned or its counts rescaled.
+func (model *CTW) WriteSummary(w io.Writer, minCount int) error {
+	s := &summarizer{pool: model.pool, depth: len(model.bits), minCount: uint64(minCount)}
+	s.split = make([]bool, len(model.pool.nodes))
+	s.prune(model.root, 0)
+
+	coded := bytes.NewBuffer(nil)
+	bw := ac.NewBitWriter(coded)
+	sm := newSummaryModel()
+	enc := witten.NewEncoder(bw, sm)
+	n := model.pool.nodes[model.root]
+	sm.encodeGamma(enc, summaryRoot, uint64(n.a))
+	sm.encodeGamma(enc, summaryRoot, uint64(n.b))
+	s.encode(enc, sm, model.root)
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writeUvarint(buf, uint64(len(model.bits)))
+	writeUvarint(buf, uint64(coded.Len()))
+	buf.Write(coded.Bytes())
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// A summarizer decides which nodes of a context tree a summary keeps, and encodes them.
+type summarizer struct {
+	pool     *nodePool
+	depth    int
+	minCount uint64
+
+	// split reports, for each node, whether its children are kept.
+	split []bool
+}
+
+// prune decides which nodes of the subtree rooted at node, which is at depth d, are kept, and returns the estimated number of bits of coding the data of the subtree with the kept nodes,
+// including the description of the kept nodes.
+func (s *summarizer) prune(node uint32, d int) float64 {
+	n := s.pool.nodes[node]
+	leaf := frozenCost(uint64(n.a), uint64(n.b))
+	if d == s.depth || (n.left == nilNode && n.right == nilNode) {
+		return leaf
+	}
+	var zero, one [2]uint64
+	if n.right != nilNode {
+		zero = [2]uint64{uint64(s.pool.nodes[n.right].a), uint64(s.pool.nodes[n.right].b)}
+	}
+	if n.left != nilNode {
+		one = [2]uint64{uint64(s.pool.nodes[n.left].a), uint64(s.pool.nodes[n.left].b)}
+	}
+	for _, c := range [][2]uint64{zero, one} {
+		if total := c[0] + c[1]; total > 0 && total < s.minCount {
+			return leaf
+		}
+	}
+	// The counts of the children exceed those of the node only if the node has been rescaled, in which case the children are not kept.
+	if zero[0] > uint64(n.a) || zero[1] > uint64(n.b) || one[0] > uint64(n.a)-zero[0] || one[1] > uint64(n.b)-zero[1] {
+		return leaf
+	}
+
+	// One bit for the flags, and the description of the counts of the child extending the suffix with a zero, given those of the node.
+	split := 1 + splitCost(zero[0], uint64(n.a)) + splitCost(zero[1], uint64(n.b))
+	if n.right != nilNode {
+		split += s.prune(n.right, d+1)
+	}
+	if n.left != nilNode {
+		split += s.prune(n.left, d+1)
+	}
+	if split >= leaf {
+		return leaf
+	}
+	s.split[node] = true
+	return split
+}
+
+// frozenCost returns the number of bits of coding a zeros and b ones with the Krichevsky-Trofimov estimate of their final counts, as a frozen model does.
+func frozenCost(a, b uint64) float64 {
+	n := float64(a+b) + 1
+	var cost float64
+	if a > 0 {
+		cost += float64(a) * math.Log2(n/(float64(a)+0.5))
+	}
+	if b > 0 {
+		cost += float64(b) * math.Log2(n/(float64(b)+0.5))
+	}
+	return cost
+}
+
+// splitCost returns the number of bits of describing that x of the n observations of a node are those of one of its children,
+// under the beta-binomial distribution of the Krichevsky-Trofimov prior, which is what the adaptive coding of a summary approaches.
+func splitCost(x, n uint64) float64 {
+	lbeta := func(p, q float64) float64 {
+		lp, _ := math.Lgamma(p)
+		lq, _ := math.Lgamma(q)
+		lpq, _ := math.Lgamma(p + q)
+		return lp + lq - lpq
+	}
+	ln, _ := math.Lgamma(float64(n) + 1)
+	lx, _ := math.Lgamma(float64(x) + 1)
+	lnx, _ := math.Lgamma(float64(n-x) + 1)
+	return -(ln - lx - lnx + lbeta(float64(x)+0.5, float64(n-x)+0.5) - lbeta(0.5, 0.5)) / math.Ln2
+}
+
+// encode encodes the subtree rooted at node.
+func (s *summarizer) encode(enc *witten.Encoder, sm *summaryModel, node uint32) {
+	n := s.pool.nodes[node]
+	sm.encodeSplit(enc, uint64(n.a)+uint64(n.b), s.split[node])
+	if !s.split[node] {
+		return
+	}
+	var zero, one [2]uint64
+	if n.right != nilNode {
+		zero = [2]uint64{uint64(s.pool.nodes[n.right].a), uint64(s.pool.nodes[n.right].b)}
+	}
+	if n.left != nilNode {
+		one = [2]uint64{uint64(s.pool.nodes[n.left].a), uint64(s.pool.nodes[n.left].b)}
+	}
+	sm.encodeBounded(enc, summaryChild, zero[0], uint64(n.a))
+	sm.encodeBounded(enc, summaryChild, zero[1], uint64(n.b))
+	rest := [2]uint64{uint64(n.a) - zero[0], uint64(n.b) - zero[1]}
+	remainder := one == rest
+	sm.encodeRemainder(enc, remainder)
+	if !remainder {
+		sm.encodeBounded(enc, summaryRest, one[0], rest[0])
+		sm.encodeBounded(enc, summaryRest, one[1], rest[1])
+	}
+	if zero[0]+zero[1] > 0 {
+		s.encode(enc, sm, n.right)
+	}
+	if one[0]+one[1] > 0 {
+		s.encode(enc, sm, n.left)
+	}
+}
+
+func writeUvarint(buf *bytes.Buffer, x uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutUvarint(b, x)])
+}
+
+// ReadSummary returns the model summarized by WriteSummary, whose context is empty.
+// The probabilities of the model are recomputed from its counts, as in Merge.
+// Unless r is an io.ByteReader, ReadSummary may read beyond the end of the summary.
+func ReadSummary(r io.Reader) (*CTW, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		b := bufio.NewReader(r)
+		br, r = b, b
+	}
+	depth, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if depth > maxSummaryDepth {
+		return nil, fmt.Errorf("summary depth %d larger than %d", depth, maxSummaryDepth)
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if size > math.MaxUint32 {
+		return nil, fmt.Errorf("summary of %d bytes too large", size)
+	}
+	// The buffer grows as the coded bytes are read, so that a corrupt size cannot allocate more than the bytes present.
+	coded := bytes.NewBuffer(nil)
+	if _, err := io.CopyN(coded, r, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	// Read the counts into a tree of its own, and merge them into an empty model to compute the probabilities.
+	sm := newSummaryModel()
+	dec := witten.NewDecoder(ac.NewBitReader(coded), sm)
+	var counts [2]uint64
+	for i := range counts {
+		if counts[i], err = sm.decodeGamma(dec, summaryRoot); err != nil {
+			return nil, err
+		}
+	}
+	pool := &nodePool{}
+	root := pool.get()
+	if err := readSummaryNode(dec, sm, pool, root, counts, 0, int(depth)); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		return nil, fmt.Errorf("trailing bits after summary")
+	}
+	model := NewCTWDepth(int(depth), nil)
+	merge(model.pool, model.root, pool, root)
+	return model, nil
+}
+
+// readSummaryNode reads the summary of the subtree rooted at node, whose counts are given, and which is at depth d of a tree of the given depth.
+func readSummaryNode(dec *witten.Decoder, sm *summaryModel, pool *nodePool, node uint32, counts [2]uint64, d, depth int) error {
+	for _, c := range counts {
+		if c >= maxCount {
+			return fmt.Errorf("count %d not less than %d", c, maxCount)
+		}
+	}
+	pool.nodes[node].a = uint32(counts[0])
+	pool.nodes[node].b = uint32(counts[1])
+	split, err := sm.decodeSplit(dec, counts[0]+counts[1])
+	if err != nil {
+		return err
+	}
+	if !split {
+		return nil
+	}
+	if d == depth {
+		return fmt.Errorf("summary deeper than %d", depth)
+	}
+	var zero, one [2]uint64
+	for i := range zero {
+		if zero[i], err = sm.decodeBounded(dec, summaryChild, counts[i]); err != nil {
+			return err
+		}
+	}
+	remainder, err := sm.decodeRemainder(dec)
+	if err != nil {
+		return err
+	}
+	for i := range one {
+		one[i] = counts[i] - zero[i]
+		if !remainder {
+			if one[i], err = sm.decodeBounded(dec, summaryRest, one[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if zero[0]+zero[1] > 0 {
+		child := pool.get()
+		pool.nodes[node].right = child
+		if err := readSummaryNode(dec, sm, pool, child, zero, d+1, depth); err != nil {
+			return err
+		}
+	}
+	if one[0]+one[1] > 0 {
+		child := pool.get()
+		pool.nodes[node].left = child
+		if err := readSummaryNode(dec, sm, pool, child, one, d+1, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The kinds of counts in a summary, which are coded with models of their own.
+const (
+	// summaryRoot are the counts of the root, which are unbounded.
+	summaryRoot = iota
+	// summaryChild are the counts of the child extending the suffix with a zero, which are bounded by those of its parent.
+	summaryChild
+	// summaryRest are the counts of the other child, when they are not the remainder of those of its parent.
+	summaryRest
+	summaryKinds
+)
+
+// summaryMagnitudes is the number of magnitudes of the bounds of counts, which are their bit lengths.
+const summaryMagnitudes = 33
+
+// summaryUnary is the maximum length of the unary part of the Elias gamma code of a count.
+const summaryUnary = 64
+
+// A summaryModel is the adaptive model of the bits of a summary.
+// Each bit is predicted by the counter of its context, which the summary selects before coding the bit.
+type summaryModel struct {
+	counters []summaryCounter
+
+	// ctx is the index of the counter of the next bit, or -1 for a bit that is equally likely zero or one.
+	ctx int
+}
+
+// The offsets of the contexts of a summaryModel.
+const (
+	// ctxSplit is whether the children of a node are kept, given the magnitude of the count of the node.
+	ctxSplit = 0
+	// ctxRemainder is whether the counts of a child are the remainder of those of its parent.
+	ctxRemainder = ctxSplit + summaryMagnitudes
+	// ctxSide is whether a bounded count is more than half its bound, given its kind and the magnitude of its bound.
+	ctxSide = ctxRemainder + 1
+	// ctxUnary is the unary part of the Elias gamma code of a count, given its kind, the magnitude of its bound, and the position in the unary part.
+	ctxUnary = ctxSide + summaryKinds*summaryMagnitudes
+	// ctxMantissa is the leading bit of the mantissa of the Elias gamma code of a count, given its kind and the length of the code.
+	ctxMantissa = ctxUnary + summaryKinds*summaryMagnitudes*summaryUnary
+	// summaryContexts is the number of contexts.
+	summaryContexts = ctxMantissa + summaryKinds*summaryUnary
+)
+
+func newSummaryModel() *summaryModel {
+	return &summaryModel{counters: make([]summaryCounter, summaryContexts)}
+}
+
+// A summaryCounter estimates the probability of zero from the number of zeros and ones it has seen.
+type summaryCounter struct {
+	n0 uint32
+	n1 uint32
+}
+
+func (model *summaryModel) Prob0() float64 {
+	if model.ctx < 0 {
+		return 0.5
+	}
+	c := model.counters[model.ctx]
+	return (float64(c.n0) + 0.4) / (float64(c.n0+c.n1) + 0.8)
+}
+
+func (model *summaryModel) Observe(bit int) {
+	if model.ctx < 0 {
+		return
+	}
+	c := &model.counters[model.ctx]
+	if bit == 0 {
+		c.n0++
+	} else {
+		c.n1++
+	}
+	if c.n0+c.n1 > 1024 {
+		c.n0 = (c.n0 + 1) / 2
+		c.n1 = (c.n1 + 1) / 2
+	}
+}
+
+// magnitude returns the bit length of x, which is less than summaryMagnitudes for the counts of a tree.
+func magnitude(x uint64) int {
+	m := bits.Len64(x)
+	if m >= summaryMagnitudes {
+		m = summaryMagnitudes - 1
+	}
+	return m
+}
+
+func (model *summaryModel) encodeSplit(enc *witten.Encoder, count uint64, split bool) {
+	model.ctx = ctxSplit + magnitude(count)
+	enc.Encode(boolBit(split))
+}
+
+func (model *summaryModel) decodeSplit(dec *witten.Decoder, count uint64) (bool, error) {
+	model.ctx = ctxSplit + magnitude(count)
+	bit, err := dec.Decode()
+	return bit == 1, err
+}
+
+func (model *summaryModel) encodeRemainder(enc *witten.Encoder, remainder bool) {
+	model.ctx = ctxRemainder
+	enc.Encode(boolBit(remainder))
+}
+
+func (model *summaryModel) decodeRemainder(dec *witten.Decoder) (bool, error) {
+	model.ctx = ctxRemainder
+	bit, err := dec.Decode()
+	return bit == 1, err
+}
+
+// encodeBounded encodes the count x of the given kind, which is at most bound.
+// The count is coded as whether it is more than half of bound, followed by its distance to the nearer of 0 and bound, as an Elias gamma code.
+func (model *summaryModel) encodeBounded(enc *witten.Encoder, kind int, x, bound uint64) {
+	if bound == 0 {
+		return
+	}
+	m := magnitude(bound)
+	model.ctx = ctxSide + kind*summaryMagnitudes + m
+	d := x
+	if x > bound/2 {
+		enc.Encode(1)
+		d = bound - x
+	} else {
+		enc.Encode(0)
+	}
+	model.encodeCode(enc, kind, m, d, bound/2)
+}
+
+func (model *summaryModel) decodeBounded(dec *witten.Decoder, kind int, bound uint64) (uint64, error) {
+	if bound == 0 {
+		return 0, nil
+	}
+	m := magnitude(bound)
+	model.ctx = ctxSide + kind*summaryMagnitudes + m
+	side, err := dec.Decode()
+	if err != nil {
+		return 0, err
+	}
+	d, err := model.decodeCode(dec, kind, m, bound/2)
+	if err != nil {
+		return 0, err
+	}
+	if side == 1 {
+		if d >= bound-bound/2 {
+			return 0, fmt.Errorf("count %d beyond half of %d", d, bound)
+		}
+		return bound - d, nil
+	}
+	return d, nil
+}
+
+// encodeGamma encodes the unbounded count x of the given kind as an Elias gamma code.
+func (model *summaryModel) encodeGamma(enc *witten.Encoder, kind int, x uint64) {
+	model.encodeCode(enc, kind, 0, x, math.MaxUint64-1)
+}
+
+func (model *summaryModel) decodeGamma(dec *witten.Decoder, kind int) (uint64, error) {
+	return model.decodeCode(dec, kind, 0, math.MaxUint64-1)
+}
+
+// encodeCode encodes x, which is at most max, as the Elias gamma code of x+1, whose unary part is omitted if it is as long as that of max+1 allows.
+// The unary part is coded given the kind, the magnitude m, and the position in it, and the leading bit of the mantissa given the kind and the length of the code.
+func (model *summaryModel) encodeCode(enc *witten.Encoder, kind, m int, x, max uint64) {
+	k, maxK := bits.Len64(x+1), bits.Len64(max+1)
+	for i := 1; i < k; i++ {
+		model.ctx = ctxUnary + (kind*summaryMagnitudes+m)*summaryUnary + i - 1
+		enc.Encode(1)
+	}
+	if k < maxK {
+		model.ctx = ctxUnary + (kind*summaryMagnitudes+m)*summaryUnary + k - 1
+		enc.Encode(0)
+	}
+	for i := k - 2; i >= 0; i-- {
+		model.ctx = -1
+		if i == k-2 {
+			model.ctx = ctxMantissa + kind*summaryUnary + k - 1
+		}
+		enc.Encode(int((x+1)>>uint(i)) & 1)
+	}
+}
+
+func (model *summaryModel) decodeCode(dec *witten.Decoder, kind, m int, max uint64) (uint64, error) {
+	k, maxK := 1, bits.Len64(max+1)
+	for k < maxK {
+		model.ctx = ctxUnary + (kind*summaryMagnitudes+m)*summaryUnary + k - 1
+		bit, err := dec.Decode()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		k++
+	}
+	x := uint64(1)
+	for i := k - 2; i >= 0; i-- {
+		model.ctx = -1
+		if i == k-2 {
+			model.ctx = ctxMantissa + kind*summaryUnary + k - 1
+		}
+		bit, err := dec.Decode()
+		if err != nil {
+			return 0, err
+		}
+		x = x<<1 | uint64(bit)
+	}
+	if x-1 > max {
+		return 0, fmt.Errorf("count %d larger than %d", x-1, max)
+	}
+	return x - 1, nil
+}
+
+func boolBit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compressTwoPass compresses data as CompressWith does with WithTwoPass, unless compressing it online, as without WithTwoPass, gives a stream no larger, in which case the online stream is written instead.
+// The summary of the model is written by writeTwoPass after the Header, and the data is coded with the frozen model it returns.
+func (o options) compressTwoPass(w io.Writer, data []byte) (Stats, error) {
+	size := int64(len(data))
+	online := o
+	online.twoPass = 0
+	onlineBuf := bytes.NewBuffer(nil)
+	onlineStats, err := online.compress(onlineBuf, bytes.NewReader(data), size, newModel(online.header(size), online.prime))
+	if err != nil {
+		return onlineStats, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := o.header(size).write(buf); err != nil {
+		return Stats{}, err
+	}
+	model, err := o.writeTwoPass(buf, data)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats, err := o.encode(buf, bytes.NewReader(data), size, model)
+	if err != nil {
+		return stats, err
+	}
+	if buf.Len() >= onlineBuf.Len() {
+		buf, stats = onlineBuf, onlineStats
+	}
+	_, err = w.Write(buf.Bytes())
+	return stats, err
+}
+
+// writeTwoPass trains a model on data, and writes its summary to w, preceded by the size of the summary as a big endian uint32.
+// It returns the frozen model reconstructed from the summary, which is the model the decoder reads by readTwoPass.
+func (o options) writeTwoPass(w io.Writer, data []byte) (treeModel, error) {
+	model := newModel(o.header(int64(len(data))), nil).(*CTW)
+	for i := 0; i < len(data); i += 4096 {
+		end := i + 4096
+		if end > len(data) {
+			end = len(data)
+		}
+		for _, bit := range ac.Bits(data[i:end]) {
+			model.Observe(bit)
+		}
+	}
+	summary := bytes.NewBuffer(nil)
+	err := model.WriteSummary(summary, o.twoPass)
+	model.Release()
+	if err != nil {
+		return nil, err
+	}
+	if int64(summary.Len()) > math.MaxUint32 {
+		return nil, fmt.Errorf("summary of %d bytes too large", summary.Len())
+	}
+
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(summary.Len()))
+	if _, err := w.Write(size); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(summary.Bytes()); err != nil {
+		return nil, err
+	}
+	frozen, err := ReadSummary(summary)
+	if err != nil {
+		return nil, err
+	}
+	frozen.Freeze()
+	trustLeaves(frozen.pool, frozen.root)
+	return frozen, nil
+}
+
+// trustLeaves makes the nodes of the subtree rooted at node predict with the deepest node on the context path, instead of weighting the nodes on the path.
+// Since a summary keeps the children of a node only if they predict better than the node, a frozen model reconstructed from it predicts best with its leaves.
+func trustLeaves(pool *nodePool, node uint32) {
+	n := &pool.nodes[node]
+	if n.left == nilNode && n.right == nilNode {
+		return
+	}
+	n.logBeta = -maxLogBeta
+	for _, child := range []uint32{n.left, n.right} {
+		if child != nilNode {
+			trustLeaves(pool, child)
+		}
+	}
+}
+
+// readTwoPass reads the summary written by writeTwoPass, and returns the frozen model it summarizes.
+func readTwoPass(r io.Reader) (treeModel, error) {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return nil, err
+	}
+	n := int64(binary.BigEndian.Uint32(size))
+	summary, err := ioutil.ReadAll(io.LimitReader(r, n))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(summary)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	br := bytes.NewReader(summary)
+	model, err := ReadSummary(br)
+	if err != nil {
+		return nil, err
+	}
+	if br.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after summary", br.Len())
+	}
+	model.Freeze()
+	trustLeaves(model.pool, model.root)
+	return model, nil
+}
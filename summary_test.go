@@ -0,0 +1,82 @@
+package ctw
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/fumin/ctw/ac"
+)
+
+func TestSummary(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	const depth = 16
+	model := NewCTW(make([]int, depth))
+	for _, bit := range ac.Bits(gettys) {
+		model.Observe(bit)
+	}
+
+	// The summary keeps only the nodes that pay for themselves, and reconstructs them exactly, so that summarizing the reconstructed model gives the same summary.
+	buf := bytes.NewBuffer(nil)
+	if err := model.WriteSummary(buf, 0); err != nil {
+		t.Fatalf("%v", err)
+	}
+	size := buf.Len()
+	if size > len(gettys)/2 {
+		t.Fatalf("%d %d", size, len(gettys))
+	}
+	full, err := ReadSummary(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if full.Nodes() >= model.Nodes() {
+		t.Fatalf("%d %d", full.Nodes(), model.Nodes())
+	}
+	root, fullRoot := model.pool.nodes[model.root], full.pool.nodes[full.root]
+	if root.a != fullRoot.a || root.b != fullRoot.b {
+		t.Fatalf("%+v %+v", root, fullRoot)
+	}
+	again := bytes.NewBuffer(nil)
+	if err := full.WriteSummary(again, 0); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Fatalf("%d %d", again.Len(), buf.Len())
+	}
+
+	// A coarser summary is smaller, and freezing the model keeps its tree intact.
+	coarse := bytes.NewBuffer(nil)
+	if err := model.WriteSummary(coarse, 8); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if coarse.Len() >= size {
+		t.Fatalf("%d %d", coarse.Len(), size)
+	}
+	frozen, err := ReadSummary(bytes.NewReader(coarse.Bytes()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	frozen.Freeze()
+	nodes := frozen.Nodes()
+	for _, bit := range ac.Bits(gettys[:100]) {
+		frozen.Prob0()
+		frozen.Observe(bit)
+	}
+	if frozen.Nodes() != nodes || len(frozen.Context()) != depth {
+		t.Fatalf("%d %d %d", frozen.Nodes(), nodes, len(frozen.Context()))
+	}
+
+	if _, err := ReadSummary(bytes.NewReader(coarse.Bytes()[:coarse.Len()/2])); err == nil {
+		t.Fatalf("expected error")
+	}
+	// A corrupt size larger than the bytes present is an error, rather than an allocation of that size.
+	corrupt := append([]byte{byte(depth), 0xFF, 0xFF, 0xFF, 0xFF, 0x0F}, coarse.Bytes()[2:]...)
+	if _, err := ReadSummary(bytes.NewReader(corrupt)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package ctw
+
+import (
+	"log"
+	"sort"
+)
+
+// A Decomposition is a binary tree whose leaves are the 256 byte values.
+// It decomposes the prediction of a byte into a sequence of binary decisions, one for each internal node on the path from the root to the byte.
+type Decomposition struct {
+	// nodes holds the internal nodes of the tree, with the root at index 0.
+	// A non-negative child is the index of an internal node, whereas a negative child c is the leaf of the byte ^c.
+	nodes [][2]int
+
+	// paths holds the binary decisions leading to each byte.
+	paths [256][]decision
+}
+
+// A decision is a step on the path from the root of a Decomposition to a byte.
+type decision struct {
+	node int
+	bit  int
+}
+
+// BalancedDecomposition returns the decomposition that decides the bits of a byte from the least significant one to the most significant one.
+// Under this decomposition, the binary decisions of a byte are exactly its bits, in the same order as Compress reads them.
+func BalancedDecomposition() *Decomposition {
+	d := &Decomposition{}
+	// The internal node for the bits partial of a byte at position pos is numbered (1<<pos)+partial-1.
+	for pos := uint(0); pos < 8; pos++ {
+		for partial := 0; partial < (1 << pos); partial++ {
+			var children [2]int
+			for bit := 0; bit < 2; bit++ {
+				next := partial | (bit << pos)
+				if pos == 7 {
+					children[bit] = ^next
+				} else {
+					children[bit] = (1 << (pos + 1)) + next - 1
+				}
+			}
+			d.nodes = append(d.nodes, children)
+		}
+	}
+	d.setPaths()
+	return d
+}
+
+// HuffmanDecomposition returns the decomposition given by the Huffman code of the byte frequencies counts.
+// Frequent bytes have short paths, which reduces both the number of binary decisions and the number of context trees that need to learn them.
+// This is the decomposition recommended by Volf for text.
+func HuffmanDecomposition(counts [256]int) *Decomposition {
+	type subtree struct {
+		count int
+		node  int // the child value of the subtree, as in Decomposition.nodes
+	}
+	trees := make([]subtree, 0, 256)
+	for c := range counts {
+		trees = append(trees, subtree{count: counts[c], node: ^c})
+	}
+
+	// Merge the two least frequent subtrees until a single tree is left.
+	// Internal nodes are created from the leaves up, so we number them in reverse and fix the numbering afterwards.
+	d := &Decomposition{}
+	for len(trees) > 1 {
+		sort.SliceStable(trees, func(i, j int) bool { return trees[i].count < trees[j].count })
+		merged := subtree{count: trees[0].count + trees[1].count, node: len(d.nodes)}
+		d.nodes = append(d.nodes, [2]int{trees[0].node, trees[1].node})
+		trees = append([]subtree{merged}, trees[2:]...)
+	}
+	last := len(d.nodes) - 1
+	for i, j := 0, last; i < j; i, j = i+1, j-1 {
+		d.nodes[i], d.nodes[j] = d.nodes[j], d.nodes[i]
+	}
+	for i := range d.nodes {
+		for bit := range d.nodes[i] {
+			if d.nodes[i][bit] >= 0 {
+				d.nodes[i][bit] = last - d.nodes[i][bit]
+			}
+		}
+	}
+
+	d.setPaths()
+	return d
+}
+
+// setPaths computes the path to each byte.
+func (d *Decomposition) setPaths() {
+	var walk func(node int, path []decision)
+	walk = func(node int, path []decision) {
+		for bit, child := range d.nodes[node] {
+			p := append(append([]decision{}, path...), decision{node: node, bit: bit})
+			if child < 0 {
+				d.paths[^child] = p
+			} else {
+				walk(child, p)
+			}
+		}
+	}
+	walk(0, nil)
+}
+
+// Path returns the binary decisions that lead to c.
+// These are the bits a VolfCTW expects to Observe for the byte c.
+func (d *Decomposition) Path(c byte) []int {
+	path := make([]int, 0, len(d.paths[c]))
+	for _, dc := range d.paths[c] {
+		path = append(path, dc.bit)
+	}
+	return path
+}
+
+// A VolfCTW is the decomposed Context Tree Weighting model for bytes proposed by Volf.
+// A byte is predicted by a sequence of binary decisions given by a Decomposition, each of which is predicted by its own context tree.
+// The context of all decisions of a byte are the bits of the bytes preceding it, so that each context tree is a CTW over byte-aligned contexts.
+//
+// VolfCTW implements the arithmetic coding Model interface, where the bits it expects are the binary decisions of each byte as given by Decomposition.Path.
+//
+// Reference:
+// P.A.J. Volf, Weighting Techniques in Data Compression: Theory and Algorithms, Ph.D. thesis, Technical University of Eindhoven, 2002.
+type VolfCTW struct {
+	bits   []int
+	decomp *Decomposition
+	pool   *nodePool
+	roots  []uint32
+
+	epsilon float64
+
+	// node is the internal node of the decomposition which makes the next decision.
+	node int
+}
+
+// NewVolfCTW returns a new VolfCTW whose context trees' depth are len(bits).
+// The prior context of the trees is given by bits, and should preferably be a multiple of 8 to cover whole bytes.
+// If decomp is nil, the BalancedDecomposition is used.
+func NewVolfCTW(bits []int, decomp *Decomposition) *VolfCTW {
+	if decomp == nil {
+		decomp = BalancedDecomposition()
+	}
+	model := &VolfCTW{bits: bits, decomp: decomp, pool: &nodePool{}, epsilon: DefaultEpsilon}
+	model.roots = make([]uint32, len(decomp.nodes))
+	return model
+}
+
+// Release releases the memory of the context trees, so that it can be reused by other models.
+// The model must not be used after Release.
+func (model *VolfCTW) Release() {
+	model.pool.release()
+	model.roots = nil
+}
+
+// SetEpsilon sets the minimum probability of either binary decision returned by Prob0, as CTW.SetEpsilon does for a single tree.
+func (model *VolfCTW) SetEpsilon(epsilon float64) {
+	if epsilon < 0 || epsilon >= 0.5 {
+		log.Fatalf("wrong epsilon %f", epsilon)
+	}
+	model.epsilon = epsilon
+}
+
+// Decomposition returns the decomposition of the model.
+func (model *VolfCTW) Decomposition() *Decomposition {
+	return model.decomp
+}
+
+// Prob0 returns the probability that the next binary decision be zero.
+func (model *VolfCTW) Prob0() float64 {
+	prob0 := predict(model.pool, model.roots[model.node], model.bits, 0, 0, 0)
+	return clamp(prob0, model.epsilon)
+}
+
+// Observe updates the model, given that the next binary decision is bit.
+func (model *VolfCTW) Observe(bit int) {
+	if bit != 0 && bit != 1 {
+		log.Fatalf("wrong bit %d", bit)
+	}
+	if model.roots[model.node] == nilNode {
+		model.roots[model.node] = model.pool.get()
+	}
+	update(model.pool, model.roots[model.node], model.bits, bit, 0)
+
+	child := model.decomp.nodes[model.node][bit]
+	if child >= 0 {
+		model.node = child
+		return
+	}
+
+	// A byte is complete, shift it into the context.
+	c := ^child
+	for i := uint(0); i < 8; i++ {
+		for j := 1; j < len(model.bits); j++ {
+			model.bits[j-1] = model.bits[j]
+		}
+		if len(model.bits) > 0 {
+			model.bits[len(model.bits)-1] = (c & (1 << i)) >> i
+		}
+	}
+	model.node = 0
+}
+
+// ObserveByte updates the model, given that the sequence is followed by the byte c.
+// ObserveByte should only be called at byte boundaries, that is when all decisions of the previous byte have been observed.
+func (model *VolfCTW) ObserveByte(c byte) {
+	if model.node != 0 {
+		log.Fatalf("ObserveByte at decomposition node %d", model.node)
+	}
+	for _, dc := range model.decomp.paths[c] {
+		model.Observe(dc.bit)
+	}
+}
@@ -0,0 +1,122 @@
+package ctw
+
+import (
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+func TestBalancedDecomposition(t *testing.T) {
+	t.Parallel()
+	d := BalancedDecomposition()
+	if len(d.nodes) != 255 {
+		t.Fatalf("%d", len(d.nodes))
+	}
+	for c := 0; c < 256; c++ {
+		path := d.Path(byte(c))
+		if len(path) != 8 {
+			t.Fatalf("%d %v", c, path)
+		}
+		for i, b := range path {
+			if b != (c&(1<<uint(i)))>>uint(i) {
+				t.Fatalf("%d %v", c, path)
+			}
+		}
+	}
+}
+
+func TestHuffmanDecomposition(t *testing.T) {
+	t.Parallel()
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var counts [256]int
+	for _, c := range contents {
+		counts[c]++
+	}
+	d := HuffmanDecomposition(counts)
+	if len(d.nodes) != 255 {
+		t.Fatalf("%d", len(d.nodes))
+	}
+
+	// Following the path of each byte from the root should lead to the byte itself.
+	for c := 0; c < 256; c++ {
+		node := 0
+		path := d.Path(byte(c))
+		for i, b := range path {
+			child := d.nodes[node][b]
+			if i == len(path)-1 {
+				if child != ^c {
+					t.Fatalf("%d %v", c, path)
+				}
+			} else {
+				node = child
+			}
+		}
+	}
+
+	// Frequent bytes should have shorter paths.
+	if len(d.Path(' ')) >= len(d.Path('z')) {
+		t.Fatalf("%v %v", d.Path(' '), d.Path('z'))
+	}
+}
+
+// TestVolfCTW tests that the probabilities of the decisions of each byte sum to one,
+// and that the Huffman decomposition predicts text better than the flat bit model.
+func TestVolfCTW(t *testing.T) {
+	t.Parallel()
+	contents, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var counts [256]int
+	for _, c := range contents {
+		counts[c]++
+	}
+	volf := NewVolfCTW(make([]int, 48), HuffmanDecomposition(counts))
+	var volfLen float64
+	for _, c := range contents {
+		for _, b := range volf.Decomposition().Path(c) {
+			prob0 := volf.Prob0()
+			if b == 0 {
+				volfLen -= math.Log2(prob0)
+			} else {
+				volfLen -= math.Log2(1 - prob0)
+			}
+			volf.Observe(b)
+		}
+	}
+
+	flat := NewCTW(make([]int, 48))
+	var flatLen float64
+	for _, c := range contents {
+		for i := uint(0); i < 8; i++ {
+			b := (int(c) & (1 << i)) >> i
+			prob0 := flat.Prob0()
+			if b == 0 {
+				flatLen -= math.Log2(prob0)
+			} else {
+				flatLen -= math.Log2(1 - prob0)
+			}
+			flat.Observe(b)
+		}
+	}
+
+	t.Logf("volf: %f bits, flat: %f bits", volfLen, flatLen)
+	if volfLen >= flatLen {
+		t.Fatalf("%f %f", volfLen, flatLen)
+	}
+}
+
+func TestVolfEpsilon(t *testing.T) {
+	t.Parallel()
+	model := NewVolfCTW(make([]int, 8), nil)
+	model.SetEpsilon(0.01)
+	for i := 0; i < 1000; i++ {
+		model.ObserveByte(0)
+	}
+	if p := model.Prob0(); p != 0.99 {
+		t.Fatalf("%f", p)
+	}
+}
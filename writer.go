@@ -0,0 +1,237 @@
+package ctw
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/fumin/ctw/ac"
+)
+
+const (
+	// frameSize is the number of bytes of the original data that a Writer buffers before coding them as a frame.
+	frameSize = 1 << 20
+
+	// frameHeaderSize is the size of the original and encoded sizes of a frame.
+	frameHeaderSize = 4 + 4
+
+	// framedSize is the size recorded in the Header of a framed stream, whose size is not known in advance.
+	framedSize = -1
+
+	// storedFlag marks the encoded size of a frame or a block whose bytes are stored uncoded, because coding would not have made them smaller.
+	storedFlag = 1 << 31
+)
+
+// A Writer is an io.WriteCloser that compresses the bytes written to it, in the same way as gzip.Writer.
+// Since the size of the data is not known in advance, the data is coded in frames of up to a megabyte each, which share a single model.
+// The result can be decompressed by Decompress, and costs only a few bytes per frame more than that of Compress.
+//
+// The format is a Header whose Size is -1, followed by a sequence of frames,
+// each of which is the original and encoded sizes as big endian uint32s and the encoded bytes, ending with a frame of original size zero,
+// and finally the big endian CRC-32 checksum of the data.
+// A frame that coding would not make smaller, such as one of already compressed data, is stored uncoded instead, which is marked by the highest bit of its encoded size.
+// The model still learns from stored frames, as the decoder replays their bits.
+type Writer struct {
+	w     *countingWriter
+	opts  options
+	model treeModel
+
+	buf         []byte
+	crc         uint32
+	stats       Stats
+	encoded     *bytes.Buffer
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+// NewWriter returns a Writer writing the compressed data to w.
+// It is the caller's responsibility to call Close on the Writer when done.
+// Invalid options are reported by the first Write or Close.
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	o := newOptions(opts)
+	z := &Writer{w: &countingWriter{w: w}, opts: o, buf: make([]byte, 0, frameSize), encoded: bytes.NewBuffer(nil)}
+	if z.err = o.check(); z.err == nil && o.twoPass > 0 {
+		z.err = fmt.Errorf("two-pass compression by a Writer")
+	}
+	return z
+}
+
+// Write compresses p, buffering the bytes of the current frame.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, fmt.Errorf("write to closed Writer")
+	}
+	var n int
+	for len(p) > 0 {
+		m := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.crc = crc32.Update(z.crc, crc32.IEEETable, p[:m])
+		z.buf = z.buf[:len(z.buf)+m]
+		n += m
+		p = p[m:]
+		if len(z.buf) == cap(z.buf) {
+			if z.err = z.writeFrame(z.buf); z.err != nil {
+				return n, z.err
+			}
+			z.buf = z.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// Flush codes the buffered bytes as a frame and writes it to the underlying io.Writer.
+// Flush is useful when the compressed data must be delivered promptly, as in network protocols, but flushing often hurts compression.
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed || len(z.buf) == 0 {
+		return nil
+	}
+	if z.err = z.writeFrame(z.buf); z.err != nil {
+		return z.err
+	}
+	z.buf = z.buf[:0]
+	return nil
+}
+
+// Close flushes the buffered bytes, and writes the final frame and the checksum of the data.
+// It does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	if z.err = z.Flush(); z.err != nil {
+		return z.err
+	}
+	z.closed = true
+	if z.err = z.writeFrame(nil); z.err != nil {
+		return z.err
+	}
+	if z.err = writeChecksum(z.w, z.crc); z.err != nil {
+		return z.err
+	}
+	z.model.Release()
+	return nil
+}
+
+// Stats returns the statistics of the frames compressed so far.
+func (z *Writer) Stats() Stats {
+	return z.stats
+}
+
+// writeFrame codes p as a frame, writing the header of the stream first if necessary.
+// An empty p gives the final frame.
+func (z *Writer) writeFrame(p []byte) error {
+	if err := z.start(p); err != nil {
+		return err
+	}
+
+	z.encoded.Reset()
+	if len(p) > 0 {
+		bw := ac.NewBitWriter(z.encoded)
+		stats, err := z.opts.coder.EncodeStats(context.Background(), bw, ac.NewBitReader(bytes.NewReader(p)), z.model)
+		z.stats.add(stats, z.model.PeakNodes())
+		if err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	payload, size := z.encoded.Bytes(), uint32(z.encoded.Len())
+	if len(p) > 0 && len(payload) >= len(p) {
+		payload, size = p, uint32(len(p))|storedFlag
+	}
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(p)))
+	binary.BigEndian.PutUint32(header[4:], size)
+	if _, err := z.w.Write(header); err != nil {
+		return err
+	}
+	_, err := z.w.Write(payload)
+	return err
+}
+
+// start writes the header of the stream and creates the model, unless they already have been.
+// The model is created with the first frame p, whose bytes choose the depth if it is chosen automatically.
+func (z *Writer) start(p []byte) error {
+	if z.wroteHeader {
+		return nil
+	}
+	z.opts.chooseDepth(p)
+	if err := z.opts.header(framedSize).write(z.w); err != nil {
+		return err
+	}
+	z.model = newModel(z.opts.header(framedSize), z.opts.prime)
+	z.wroteHeader = true
+	return nil
+}
+
+// decodeFrame decodes the next frame read from r with coder and model, which persists across frames, and writes the decoded bytes to w.
+// It returns io.EOF after decoding the final frame.
+func decodeFrame(w io.Writer, r io.Reader, coder ac.Coder, model ac.Model) error {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	n := binary.BigEndian.Uint32(header)
+	size := binary.BigEndian.Uint32(header[4:])
+	if n == 0 {
+		if size != 0 {
+			return fmt.Errorf("final frame with encoded size %d", size)
+		}
+		return io.EOF
+	}
+	if n > frameSize {
+		return fmt.Errorf("frame of %d bytes larger than %d", n, frameSize)
+	}
+	if size&storedFlag != 0 {
+		if size&^storedFlag != n {
+			return fmt.Errorf("stored frame of %d bytes with size %d", n, size&^storedFlag)
+		}
+		return readStoredFrame(w, r, n, model)
+	}
+
+	payload := io.LimitReader(r, int64(size))
+	bw := ac.NewBitWriter(w)
+	if _, err := coder.DecodeStats(context.Background(), bw, ac.NewBitReader(payload), model, int64(n)*8); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	// Skip the bits of the coder that were not read.
+	_, err := io.Copy(ioutil.Discard, payload)
+	return err
+}
+
+// readStoredFrame reads the n bytes of a stored frame from r and writes them to w, and updates model as if it had decoded them.
+func readStoredFrame(w io.Writer, r io.Reader, n uint32, model ac.Model) error {
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	for _, bit := range ac.Bits(p) {
+		model.Observe(bit)
+	}
+	_, err := w.Write(p)
+	return err
+}
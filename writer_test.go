@@ -0,0 +1,122 @@
+package ctw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/fumin/ctw/ac/mq"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Data spanning several frames, which are made small to keep the test fast.
+	const testFrameSize = 1000
+	large := make([]byte, 2*testFrameSize+testFrameSize/3)
+	rng := rand.New(rand.NewSource(0))
+	for i := range large {
+		large[i] = byte('a' + rng.Intn(4))
+	}
+
+	for _, data := range [][]byte{nil, gettys, large} {
+		buf := bytes.NewBuffer(nil)
+		z := NewWriter(buf, WithDepth(16), WithCoder(mq.Coder{}))
+		z.buf = make([]byte, 0, testFrameSize)
+		// Write in uneven pieces, flushing once in the middle.
+		for i, p := 0, data; len(p) > 0; i++ {
+			n := 1 + rng.Intn(3*testFrameSize/2)
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := z.Write(p[:n]); err != nil {
+				t.Fatalf("%v", err)
+			}
+			p = p[n:]
+			if i == 1 {
+				if err := z.Flush(); err != nil {
+					t.Fatalf("%v", err)
+				}
+			}
+		}
+		if err := z.Close(); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := z.Write([]byte{0}); err == nil {
+			t.Fatalf("expected error")
+		}
+
+		decompressed := bytes.NewBuffer(nil)
+		if err := Decompress(decompressed, buf, mq.Coder{}); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(decompressed.Bytes(), data) {
+			t.Fatalf("%d %d", decompressed.Len(), len(data))
+		}
+	}
+}
+
+// TestWriterSize tests that a Writer compresses nearly as well as Compress.
+func TestWriterSize(t *testing.T) {
+	t.Parallel()
+	gettys, err := ioutil.ReadFile("gettysburg.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	compressed := bytes.NewBuffer(nil)
+	if err := Compress(compressed, bytes.NewReader(gettys), int64(len(gettys)), DefaultDepth, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	written := bytes.NewBuffer(nil)
+	z := NewWriter(written)
+	if _, err := z.Write(gettys); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if written.Len() > compressed.Len()+2*frameHeaderSize {
+		t.Fatalf("%d %d", written.Len(), compressed.Len())
+	}
+}
+
+// TestWriterStored tests that frames of incompressible data are stored uncoded, and that the model still learns from them.
+func TestWriterStored(t *testing.T) {
+	t.Parallel()
+	const testFrameSize = 1000
+	random := make([]byte, testFrameSize)
+	rand.New(rand.NewSource(0)).Read(random)
+	// The random frame is stored, and the following repetition of it is coded, since the model has learned it.
+	data := append(append([]byte{}, random...), random...)
+
+	buf := bytes.NewBuffer(nil)
+	z := NewWriter(buf, WithDepth(16))
+	z.buf = make([]byte, 0, testFrameSize)
+	if _, err := z.Write(data); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	frames := buf.Bytes()[headerSize:]
+	if size := binary.BigEndian.Uint32(frames[4:]); size != testFrameSize|storedFlag {
+		t.Fatalf("%#x", size)
+	}
+	frames = frames[frameHeaderSize+testFrameSize:]
+	if size := binary.BigEndian.Uint32(frames[4:]); size&storedFlag != 0 {
+		t.Fatalf("%#x", size)
+	}
+
+	decompressed := bytes.NewBuffer(nil)
+	if err := Decompress(decompressed, buf, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), data) {
+		t.Fatalf("%d %d", decompressed.Len(), len(data))
+	}
+}